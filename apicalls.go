@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"sync"
+)
+
+// apiCallKinds are the kinds of API requests counted per challenge: zone
+// reads, zone updates, the reads of --verify-zone-updates, and requests
+// the API client sent again after a failed attempt.
+var apiCallKinds = []string{"read", "update", "verification", "retry"}
+
+// apiCallCounter counts the Sakura Cloud API requests sent on behalf of one
+// challenge, or of one zone update shared by several, by kind. Counts are
+// fractional, as the requests of a zone update are shared among its edits.
+type apiCallCounter struct {
+	mu    sync.Mutex
+	calls map[string]float64
+	// retrying is set by checkRetry when the API client is about to send a
+	// request again.
+	retrying bool
+}
+
+type apiCallCounterKey struct{}
+
+type apiCallKindKey struct{}
+
+// withAPICallCounter returns a context that makes accountingTransport count
+// the API requests sent with it in the returned counter, instead of the
+// counter of ctx, if any.
+func withAPICallCounter(ctx context.Context) (context.Context, *apiCallCounter) {
+	c := &apiCallCounter{calls: map[string]float64{}}
+	return context.WithValue(ctx, apiCallCounterKey{}, c), c
+}
+
+// apiCallCounterFrom returns the counter of ctx, or nil.
+func apiCallCounterFrom(ctx context.Context) *apiCallCounter {
+	c, _ := ctx.Value(apiCallCounterKey{}).(*apiCallCounter)
+	return c
+}
+
+// withAPICallKind returns a context that makes the requests sent with it
+// count as kind rather than by their method.
+func withAPICallKind(ctx context.Context, kind string) context.Context {
+	return context.WithValue(ctx, apiCallKindKey{}, kind)
+}
+
+// countAPICall counts req in the counter of its context, if any.
+func countAPICall(req *http.Request) {
+	c := apiCallCounterFrom(req.Context())
+	if c == nil {
+		return
+	}
+	kind, ok := req.Context().Value(apiCallKindKey{}).(string)
+	if !ok {
+		kind = "update"
+		if req.Method == http.MethodGet {
+			kind = "read"
+		}
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.retrying {
+		kind, c.retrying = "retry", false
+	}
+	c.calls[kind]++
+}
+
+// retry marks the next request counted as a retry.
+func (c *apiCallCounter) retry() {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.retrying = true
+}
+
+// add adds share of the requests of other to c.
+func (c *apiCallCounter) add(other *apiCallCounter, share float64) {
+	if c == nil || other == nil {
+		return
+	}
+	other.mu.Lock()
+	calls := make(map[string]float64, len(other.calls))
+	for kind, n := range other.calls {
+		calls[kind] = n * share
+	}
+	other.mu.Unlock()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for kind, n := range calls {
+		c.calls[kind] += n
+	}
+}
+
+// observe records the requests of a challenge of operation, present or
+// cleanup, in apiCallsPerChallenge, by kind and in total.
+func (c *apiCallCounter) observe(operation string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	total := 0.0
+	for _, kind := range apiCallKinds {
+		apiCallsPerChallenge.WithLabelValues(operation, kind).Observe(c.calls[kind])
+		total += c.calls[kind]
+	}
+	apiCallsPerChallenge.WithLabelValues(operation, "total").Observe(total)
+}
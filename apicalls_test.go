@@ -0,0 +1,53 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAPICallCounter(t *testing.T) {
+	transport := &accountingTransport{credential: "test", next: roundTripFunc(func(*http.Request) (*http.Response, error) {
+		return &http.Response{StatusCode: http.StatusOK}, nil
+	})}
+	send := func(ctx context.Context, method string) {
+		req, err := http.NewRequestWithContext(ctx, method, "https://secure.sakura.ad.jp/", nil)
+		require.NoError(t, err)
+		_, err = transport.RoundTrip(req)
+		require.NoError(t, err)
+	}
+
+	ctx, calls := withAPICallCounter(context.Background())
+	send(ctx, http.MethodGet)
+	send(ctx, http.MethodPut)
+	retry, err := checkRetry(ctx, &http.Response{StatusCode: http.StatusLocked}, nil)
+	require.NoError(t, err)
+	require.True(t, retry)
+	send(ctx, http.MethodPut)
+	send(withAPICallKind(ctx, "verification"), http.MethodGet)
+	send(context.Background(), http.MethodGet)
+	assert.Equal(t, map[string]float64{"read": 1, "update": 1, "retry": 1, "verification": 1}, calls.calls)
+
+	_, other := withAPICallCounter(context.Background())
+	other.add(calls, 0.5)
+	assert.Equal(t, map[string]float64{"read": .5, "update": .5, "retry": .5, "verification": .5}, other.calls, "shared by two edits")
+	var none *apiCallCounter
+	none.add(calls, 1)
+	none.retry()
+
+	histogram := func(kind string) *dto.Histogram {
+		m := &dto.Metric{}
+		require.NoError(t, apiCallsPerChallenge.WithLabelValues("present", kind).(prometheus.Metric).Write(m))
+		return m.GetHistogram()
+	}
+	before := histogram("total")
+	other.observe("present")
+	after := histogram("total")
+	assert.Equal(t, before.GetSampleCount()+1, after.GetSampleCount())
+	assert.InDelta(t, before.GetSampleSum()+2, after.GetSampleSum(), 1e-9)
+}
@@ -0,0 +1,231 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/hashicorp/go-retryablehttp"
+	client "github.com/sacloud/api-client-go"
+	"github.com/sacloud/iaas-api-go"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"k8s.io/klog/v2"
+)
+
+// newAPICaller builds the Sakura Cloud API caller for one API key. Every
+// caller gets its own http.Client: the api-client-go factory decorates the
+// transport of the client it is given, and sharing http.DefaultClient would
+// stack another decorator on every challenge. API errors are returned as
+// describedAPIErrors.
+func newAPICaller(accessToken, accessTokenSecret string) iaas.APICaller {
+	var transport http.RoundTripper = http.DefaultTransport
+	if apiDebugLogging {
+		transport = &debugTransport{next: transport}
+	}
+	transport = &breakerTransport{breaker: apiBreaker, next: transport}
+	return &describingAPICaller{next: iaas.NewClientWithOptions(&client.Options{
+		AccessToken:       accessToken,
+		AccessTokenSecret: accessTokenSecret,
+		CheckRetryFunc:    checkRetry,
+		HttpClient: &http.Client{
+			Transport: &maintenanceTransport{
+				window: apiMaintenance,
+				next: &accountingTransport{
+					credential: credentialHash(accessToken),
+					next:       transport,
+				},
+			},
+		},
+	})}
+}
+
+// credentialHash returns a short, non-reversible identifier of an API key
+// that is safe to use as a metric label.
+func credentialHash(accessToken string) string {
+	sum := sha256.Sum256([]byte(accessToken))
+	return hex.EncodeToString(sum[:])[:12]
+}
+
+// accountingTransport counts every HTTP request sent to the Sakura Cloud API,
+// including retries, per credential and per challenge, records the size of
+// request bodies and the latency, and traces each request.
+type accountingTransport struct {
+	credential string
+	next       http.RoundTripper
+}
+
+func (t *accountingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.ContentLength > 0 {
+		apiRequestBodyBytes.WithLabelValues(req.Method).Observe(float64(req.ContentLength))
+	}
+	if size, ok := req.Context().Value(requestBodySizeKey{}).(*atomic.Int64); ok {
+		size.Store(req.ContentLength)
+	}
+	_, span := tracer.Start(req.Context(), "sakuracloud."+req.Method, trace.WithAttributes(
+		attribute.String("http.method", req.Method),
+		attribute.String("http.url", req.URL.Redacted()),
+	))
+	start := time.Now()
+	resp, err := t.next.RoundTrip(req)
+	code := "error"
+	if err == nil {
+		code = strconv.Itoa(resp.StatusCode)
+		span.SetAttributes(attribute.Int("http.status_code", resp.StatusCode))
+	}
+	took := time.Since(start)
+	apiRequestsTotal.WithLabelValues(t.credential, req.Method, code).Inc()
+	countAPICall(req)
+	apiRequestDuration.WithLabelValues(req.Method, code).Observe(took.Seconds())
+	logAPIf("Sakura Cloud API %s %s: %s in %s", req.Method, req.URL.Path, code, took.Round(time.Millisecond))
+	endSpan(span, err)
+	return resp, err
+}
+
+type requestBodySizeKey struct{}
+
+// withRequestBodySize returns a context that makes accountingTransport store
+// the body size of the API requests sent with it in the returned value, so
+// that callers learn the size of the body the SDK serialized.
+func withRequestBodySize(ctx context.Context) (context.Context, *atomic.Int64) {
+	size := &atomic.Int64{}
+	return context.WithValue(ctx, requestBodySizeKey{}, size), size
+}
+
+// retryStatusCodes are the responses the API client retries, by the reason
+// they are counted under. They default to iaas-api-go's defaults (423 while
+// the resource is locked by another operation, 503) and 429, and are set
+// from --api-retry-status-codes.
+var retryStatusCodes = map[int]string{
+	http.StatusLocked:             "conflict",
+	http.StatusServiceUnavailable: "5xx",
+	http.StatusTooManyRequests:    "rate_limit",
+}
+
+// setRetryStatusCodes makes the API client retry the responses with codes,
+// and no others. Transport errors are retried either way.
+func setRetryStatusCodes(codes []int) error {
+	reasons := map[int]string{}
+	for _, code := range codes {
+		switch {
+		case code == http.StatusTooManyRequests:
+			reasons[code] = "rate_limit"
+		case code == http.StatusLocked:
+			reasons[code] = "conflict"
+		case code >= 500 && code <= 599:
+			reasons[code] = "5xx"
+		case code >= 400 && code <= 499:
+			reasons[code] = "4xx"
+		default:
+			return fmt.Errorf("--api-retry-status-codes: %d is not a 4xx or 5xx status code", code)
+		}
+	}
+	retryStatusCodes = reasons
+	return nil
+}
+
+// checkRetry is the retry policy of the API client. It counts every retry
+// in apiRetriesTotal by reason.
+func checkRetry(ctx context.Context, resp *http.Response, err error) (bool, error) {
+	if ctx.Err() != nil {
+		return false, ctx.Err()
+	}
+	retry, err := shouldRetry(ctx, resp, err)
+	if retry {
+		apiRetriesTotal.WithLabelValues(retryReason(resp, err)).Inc()
+		apiCallCounterFrom(ctx).retry()
+	}
+	return retry, err
+}
+
+func shouldRetry(ctx context.Context, resp *http.Response, err error) (bool, error) {
+	var maintenance *maintenanceError
+	if errors.Is(err, errCircuitOpen) || errors.As(err, &maintenance) {
+		return false, err
+	}
+	if err == nil && resp.StatusCode == http.StatusServiceUnavailable {
+		// A 503 opened the maintenance window, if enabled; retrying would
+		// only be rejected by it.
+		if err := apiMaintenance.check(); err != nil {
+			return false, err
+		}
+	}
+	if err != nil {
+		return retryablehttp.DefaultRetryPolicy(ctx, resp, err)
+	}
+	if resp.StatusCode == 0 {
+		return true, nil
+	}
+	_, ok := retryStatusCodes[resp.StatusCode]
+	return ok, nil
+}
+
+// retryReason classifies a retried response or error as rate_limit,
+// conflict, 4xx, timeout, 5xx or, for other transport errors, network.
+func retryReason(resp *http.Response, err error) string {
+	if err != nil {
+		var netErr net.Error
+		if errors.Is(err, context.DeadlineExceeded) || (errors.As(err, &netErr) && netErr.Timeout()) {
+			return "timeout"
+		}
+		return "network"
+	}
+	if reason, ok := retryStatusCodes[resp.StatusCode]; ok {
+		return reason
+	}
+	if resp.StatusCode >= 500 {
+		return "5xx"
+	}
+	return "network"
+}
+
+// apiDebugLogging makes newAPICaller log every API request and response,
+// see debugTransport. It is set from --api-debug-logging.
+var apiDebugLogging bool
+
+// rdataPattern matches record values in API request and response bodies.
+var rdataPattern = regexp.MustCompile(`"RData"\s*:\s*"(?:[^"\\]|\\.)*"`)
+
+// debugTransport logs API requests and responses through klog for
+// diagnosing errors returned by the API. Credentials and record values are
+// redacted.
+type debugTransport struct {
+	next http.RoundTripper
+}
+
+func (t *debugTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if dump, err := httputil.DumpRequestOut(req, true); err == nil {
+		klog.Infof("Sakura Cloud API request:\n%s", redactDump(dump))
+	}
+	resp, err := t.next.RoundTrip(req)
+	if err != nil {
+		klog.Infof("Sakura Cloud API request to %s failed: %v", req.URL.Redacted(), err)
+		return resp, err
+	}
+	if dump, err := httputil.DumpResponse(resp, true); err == nil {
+		klog.Infof("Sakura Cloud API response:\n%s", redactDump(dump))
+	}
+	return resp, nil
+}
+
+// redactDump removes the Authorization header and record values from an
+// HTTP dump.
+func redactDump(dump []byte) string {
+	lines := strings.Split(string(dump), "\r\n")
+	for i, l := range lines {
+		if name, _, ok := strings.Cut(l, ":"); ok && strings.EqualFold(name, "Authorization") {
+			lines[i] = name + ": [redacted]"
+		}
+	}
+	return rdataPattern.ReplaceAllString(strings.Join(lines, "\n"), `"RData":"[redacted]"`)
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/sacloud/iaas-api-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRedactDump(t *testing.T) {
+	dump := "PUT /cloud/1.1/commonserviceitem/1 HTTP/1.1\r\n" +
+		"Host: secure.sakura.ad.jp\r\n" +
+		"Authorization: Basic dG9rZW46c2VjcmV0\r\n" +
+		"\r\n" +
+		`{"Settings":{"DNS":{"ResourceRecordSets":[{"Name":"_acme-challenge","Type":"TXT","RData":"key \"quoted\"","TTL":60}]}}}`
+
+	redacted := redactDump([]byte(dump))
+	assert.NotContains(t, redacted, "dG9rZW46c2VjcmV0")
+	assert.NotContains(t, redacted, "quoted")
+	assert.Contains(t, redacted, "Authorization: [redacted]")
+	assert.Contains(t, redacted, `"Name":"_acme-challenge","Type":"TXT","RData":"[redacted]","TTL":60`)
+}
+
+func TestRetryReason(t *testing.T) {
+	response := func(code int) *http.Response { return &http.Response{StatusCode: code} }
+
+	assert.Equal(t, "rate_limit", retryReason(response(http.StatusTooManyRequests), nil))
+	assert.Equal(t, "conflict", retryReason(response(http.StatusLocked), nil))
+	assert.Equal(t, "5xx", retryReason(response(http.StatusServiceUnavailable), nil))
+	assert.Equal(t, "5xx", retryReason(response(http.StatusBadGateway), nil))
+	assert.Equal(t, "timeout", retryReason(nil, context.DeadlineExceeded))
+	assert.Equal(t, "timeout", retryReason(nil, &url.Error{Op: "Get", Err: &net.DNSError{IsTimeout: true}}))
+	assert.Equal(t, "network", retryReason(nil, errors.New("connection reset by peer")))
+}
+
+func TestShouldRetry(t *testing.T) {
+	for code, want := range map[int]bool{
+		http.StatusOK:                  false,
+		http.StatusNotFound:            false,
+		http.StatusConflict:            false,
+		http.StatusLocked:              true,
+		http.StatusTooManyRequests:     true,
+		http.StatusInternalServerError: false,
+		http.StatusServiceUnavailable:  true,
+	} {
+		retry, err := shouldRetry(context.Background(), &http.Response{StatusCode: code}, nil)
+		assert.NoError(t, err)
+		assert.Equal(t, want, retry, "status %d", code)
+	}
+
+	retry, err := shouldRetry(context.Background(), nil, &maintenanceError{until: time.Now()})
+	assert.False(t, retry, "requests held back for maintenance are not retried")
+	assert.Error(t, err)
+}
+
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+func TestRequestBodySize(t *testing.T) {
+	transport := &accountingTransport{credential: "test", next: roundTripFunc(func(*http.Request) (*http.Response, error) {
+		return &http.Response{StatusCode: http.StatusOK}, nil
+	})}
+	send := func(ctx context.Context, body string) {
+		req, err := http.NewRequestWithContext(ctx, http.MethodPut, "https://secure.sakura.ad.jp/", strings.NewReader(body))
+		require.NoError(t, err)
+		_, err = transport.RoundTrip(req)
+		require.NoError(t, err)
+	}
+
+	bodies := sampleCount(t, apiRequestBodyBytes.WithLabelValues(http.MethodPut))
+	ctx, size := withRequestBodySize(context.Background())
+	send(ctx, `{"CommonServiceItem":{}}`)
+	assert.Equal(t, int64(24), size.Load())
+
+	send(context.Background(), `{}`)
+	assert.Equal(t, int64(24), size.Load(), "requests without the context are not recorded")
+	assert.Equal(t, bodies+2, sampleCount(t, apiRequestBodyBytes.WithLabelValues(http.MethodPut)), "every body is observed")
+}
+
+func TestSetRetryStatusCodes(t *testing.T) {
+	defaults := retryStatusCodes
+	t.Cleanup(func() { retryStatusCodes = defaults })
+
+	require.NoError(t, setRetryStatusCodes([]int{http.StatusTooManyRequests, http.StatusBadGateway, http.StatusConflict}))
+	for code, want := range map[int]bool{
+		http.StatusTooManyRequests:     true,
+		http.StatusBadGateway:          true,
+		http.StatusConflict:            true,
+		http.StatusLocked:              false,
+		http.StatusInternalServerError: false,
+	} {
+		retry, err := shouldRetry(context.Background(), &http.Response{StatusCode: code}, nil)
+		assert.NoError(t, err)
+		assert.Equal(t, want, retry, "status %d", code)
+	}
+	assert.Equal(t, "4xx", retryReason(&http.Response{StatusCode: http.StatusConflict}, nil))
+	assert.Equal(t, "5xx", retryReason(&http.Response{StatusCode: http.StatusBadGateway}, nil))
+	assert.Equal(t, "rate_limit", retryReason(&http.Response{StatusCode: http.StatusTooManyRequests}, nil))
+
+	assert.ErrorContains(t, setRetryStatusCodes([]int{200}), "200 is not a 4xx or 5xx status code")
+}
+
+func TestAPIRequestsPerCredential(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusNotFound)
+		_, _ = w.Write([]byte(`{"is_fatal":true,"status":"404 Not Found","error_code":"not_found","error_msg":"the resource is not found"}`))
+	}))
+	defer srv.Close()
+	root := iaas.SakuraCloudAPIRoot
+	iaas.SakuraCloudAPIRoot = srv.URL
+	defer func() { iaas.SakuraCloudAPIRoot = root }()
+
+	requests := func(token string) float64 {
+		return testutil.ToFloat64(apiRequestsTotal.WithLabelValues(credentialHash(token), http.MethodGet, "404"))
+	}
+	a, b := requests("token-a"), requests("token-b")
+	read := func(token string) {
+		_, err := iaas.NewDNSOp(newAPICaller(token, "secret")).Read(context.Background(), 1)
+		require.True(t, iaas.IsNotFoundError(err), "%v", err)
+	}
+	read("token-a")
+	read("token-a")
+	read("token-b")
+	assert.Equal(t, a+2, requests("token-a"))
+	assert.Equal(t, b+1, requests("token-b"))
+	assert.NotEqual(t, credentialHash("token-a"), credentialHash("token-b"))
+}
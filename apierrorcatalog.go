@@ -0,0 +1,123 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/sacloud/iaas-api-go"
+)
+
+// apiErrorHint explains an error response of the Sakura Cloud API: what
+// went wrong and what to do about it.
+type apiErrorHint struct {
+	// status matches the HTTP status of the response, codePrefix the start
+	// of its error_code. Zero and empty match any.
+	status     int
+	codePrefix string
+
+	summary string
+	action  string
+}
+
+// apiErrorCatalog lists the API errors challenges commonly fail with. The
+// first matching entry describes an error.
+var apiErrorCatalog = []apiErrorHint{
+	{
+		codePrefix: "limit_",
+		summary:    "a resource limit of the Sakura Cloud account was reached",
+		action:     "delete unused resources of the account or ask Sakura Cloud support to raise the limit",
+	},
+	{
+		status:     http.StatusConflict,
+		codePrefix: "still_creating",
+		summary:    "the DNS zone is still being created",
+		action:     "wait, cert-manager retries once it is ready",
+	},
+	{
+		status:  http.StatusUnauthorized,
+		summary: "the API key was rejected",
+		action:  "check that accessToken and accessTokenSecret in the Secret referenced by the Issuer belong to an existing API key",
+	},
+	{
+		status:  http.StatusForbidden,
+		summary: "the API key is not allowed to change the DNS zone",
+		action:  "give the API key an access level that allows changing resources, in the project of the zone",
+	},
+	{
+		status:  http.StatusNotFound,
+		summary: "the DNS zone does not exist or belongs to another project",
+		action:  "check zoneID in the Issuer config against the zone in the control panel, and that the API key belongs to the project of the zone",
+	},
+	{
+		status:  http.StatusTooManyRequests,
+		summary: "too many requests were sent to the Sakura Cloud API",
+		action:  "wait, cert-manager retries; if it keeps happening, spread the updates with --zone-batch-window or --zone-workers",
+	},
+	{
+		status:  http.StatusServiceUnavailable,
+		summary: "the Sakura Cloud API is unavailable, usually for maintenance",
+		action:  "wait for the maintenance to end, cert-manager retries meanwhile",
+	},
+}
+
+// lookupAPIErrorHint returns the entry of apiErrorCatalog describing err.
+func lookupAPIErrorHint(err iaas.APIError) (apiErrorHint, bool) {
+	for _, h := range apiErrorCatalog {
+		if h.status != 0 && h.status != err.ResponseCode() {
+			continue
+		}
+		if h.codePrefix != "" && !strings.HasPrefix(err.Code(), h.codePrefix) {
+			continue
+		}
+		return h, true
+	}
+	return apiErrorHint{}, false
+}
+
+// describedAPIError is an iaas.APIError whose message says what the error
+// means and how to fix it, followed by the status, error code, message and
+// serial of the response, instead of the Go syntax dump of the response the
+// SDK prints. The challenge events show this message.
+type describedAPIError struct {
+	iaas.APIError
+}
+
+func (e *describedAPIError) Error() string {
+	var b strings.Builder
+	b.WriteString("Sakura Cloud API: ")
+	if h, ok := lookupAPIErrorHint(e.APIError); ok {
+		fmt.Fprintf(&b, "%s; %s. ", h.summary, h.action)
+	}
+	fmt.Fprintf(&b, "The API responded %d %s", e.ResponseCode(), http.StatusText(e.ResponseCode()))
+	if code := e.Code(); code != "" {
+		fmt.Fprintf(&b, " (%s)", code)
+	}
+	if msg := strings.TrimSpace(e.Message()); msg != "" {
+		fmt.Fprintf(&b, ": %s", msg)
+	}
+	if serial := e.Serial(); serial != "" {
+		fmt.Fprintf(&b, " [serial %s]", serial)
+	}
+	return b.String()
+}
+
+func (e *describedAPIError) Unwrap() error {
+	return e.APIError
+}
+
+// describingAPICaller replaces the API errors of the caller it wraps with
+// describedAPIErrors. They still implement iaas.APIError, so checks of the
+// response code keep working.
+type describingAPICaller struct {
+	next iaas.APICaller
+}
+
+func (c *describingAPICaller) Do(ctx context.Context, method, uri string, body interface{}) ([]byte, error) {
+	data, err := c.next.Do(ctx, method, uri, body)
+	if apiErr, ok := err.(iaas.APIError); ok {
+		err = &describedAPIError{APIError: apiErr}
+	}
+	return data, err
+}
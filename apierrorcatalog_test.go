@@ -0,0 +1,79 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/sacloud/iaas-api-go"
+	"github.com/stretchr/testify/assert"
+)
+
+type fakeAPICaller struct {
+	err error
+}
+
+func (c *fakeAPICaller) Do(context.Context, string, string, interface{}) ([]byte, error) {
+	return nil, c.err
+}
+
+func TestDescribedAPIError(t *testing.T) {
+	for name, tc := range map[string]struct {
+		code int
+		resp *iaas.APIErrorResponse
+		want string
+	}{
+		"unauthorized": {
+			code: http.StatusUnauthorized,
+			resp: &iaas.APIErrorResponse{ErrorCode: "unauthorized", ErrorMessage: "認証に失敗しました。", Serial: "abc"},
+			want: "Sakura Cloud API: the API key was rejected; check that accessToken and accessTokenSecret in the Secret referenced by the Issuer belong to an existing API key. " +
+				"The API responded 401 Unauthorized (unauthorized): 認証に失敗しました。 [serial abc]",
+		},
+		"over limit": {
+			code: http.StatusConflict,
+			resp: &iaas.APIErrorResponse{ErrorCode: "limit_count_in_account"},
+			want: "Sakura Cloud API: a resource limit of the Sakura Cloud account was reached; delete unused resources of the account or ask Sakura Cloud support to raise the limit. " +
+				"The API responded 409 Conflict (limit_count_in_account)",
+		},
+		"still creating": {
+			code: http.StatusConflict,
+			resp: &iaas.APIErrorResponse{ErrorCode: "still_creating"},
+			want: "Sakura Cloud API: the DNS zone is still being created; wait, cert-manager retries once it is ready. The API responded 409 Conflict (still_creating)",
+		},
+		"other conflict": {
+			code: http.StatusConflict,
+			resp: &iaas.APIErrorResponse{ErrorCode: "conflict", ErrorMessage: "競合しました。"},
+			want: "Sakura Cloud API: The API responded 409 Conflict (conflict): 競合しました。",
+		},
+		"no body": {
+			code: http.StatusServiceUnavailable,
+			want: "Sakura Cloud API: the Sakura Cloud API is unavailable, usually for maintenance; wait for the maintenance to end, cert-manager retries meanwhile. " +
+				"The API responded 503 Service Unavailable",
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			caller := &describingAPICaller{next: &fakeAPICaller{err: iaas.NewAPIError(http.MethodGet, nil, tc.code, tc.resp)}}
+			_, err := caller.Do(context.Background(), http.MethodGet, "/", nil)
+			assert.EqualError(t, err, tc.want)
+
+			var apiErr iaas.APIError
+			assert.ErrorAs(t, err, &apiErr)
+			assert.Equal(t, tc.code, apiErr.ResponseCode())
+		})
+	}
+
+	t.Run("not found", func(t *testing.T) {
+		caller := &describingAPICaller{next: &fakeAPICaller{err: iaas.NewAPIError(http.MethodGet, nil, http.StatusNotFound, nil)}}
+		_, err := caller.Do(context.Background(), http.MethodGet, "/", nil)
+		assert.True(t, iaas.IsNotFoundError(err))
+		assert.True(t, isNotFoundError(zoneNotFoundError(1, err)))
+	})
+
+	t.Run("transport error", func(t *testing.T) {
+		want := errors.New("connection refused")
+		caller := &describingAPICaller{next: &fakeAPICaller{err: want}}
+		_, err := caller.Do(context.Background(), http.MethodGet, "/", nil)
+		assert.Same(t, want, err)
+	})
+}
@@ -0,0 +1,237 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sacloud/iaas-api-go"
+)
+
+// auditLogFormats are the values of --audit-log-format.
+var auditLogFormats = []string{"jsonl", "cef"}
+
+// auditRecord is a line of the audit log: a zone write of the solver, made
+// or attempted.
+type auditRecord struct {
+	Time   time.Time `json:"time"`
+	ZoneID int64     `json:"zoneID"`
+	Zone   string    `json:"zone,omitempty"`
+	// FQDN is the challenge record; prune records have none.
+	FQDN      string      `json:"fqdn,omitempty"`
+	Operation string      `json:"operation"`
+	Actor     changeActor `json:"actor"`
+	// Result is success or failure, with the error in Error.
+	Result string `json:"result"`
+	Error  string `json:"error,omitempty"`
+}
+
+// auditLog writes an auditRecord for every record the solver writes to a
+// zone or fails to, as JSON Lines or CEF for SIEM systems, to a file that
+// is rotated by size or to stdout. Records are written synchronously, so
+// none is lost to a queue; write errors are only counted and logged. A nil
+// log writes nothing.
+type auditLog struct {
+	format   string
+	instance string
+	version  string
+	now      func() time.Time
+
+	mu  sync.Mutex
+	out io.Writer
+}
+
+// newAuditLog returns a log writing to path, stdout if path is "-", or nil
+// if path is empty. maxSize is the size in bytes after which the file is
+// rotated, keeping maxBackups of the old files as path.1, path.2 and so on.
+func newAuditLog(path, format string, maxSize int64, maxBackups int) (*auditLog, error) {
+	if path == "" {
+		return nil, nil
+	}
+	if !slices.Contains(auditLogFormats, format) {
+		return nil, fmt.Errorf("unknown --audit-log-format %q, use one of %s", format, strings.Join(auditLogFormats, ", "))
+	}
+	var out io.Writer = os.Stdout
+	if path != "-" {
+		f, err := openRotatingFile(path, maxSize, maxBackups)
+		if err != nil {
+			return nil, fmt.Errorf("--audit-log-path: %w", err)
+		}
+		out = f
+	}
+	instance, _ := os.Hostname()
+	version := buildVersion()["module"]
+	if version == "" {
+		version = "unknown"
+	}
+	return &auditLog{format: format, instance: instance, version: version, now: time.Now, out: out}, nil
+}
+
+// record writes a record for every edit of zone zoneID that was written or
+// failed. zone is nil if the zone could not be read.
+func (l *auditLog) record(zoneID int64, zone *iaas.DNS, edits []*zoneEdit) {
+	if l == nil {
+		return
+	}
+	now := l.now()
+	for _, e := range edits {
+		if !e.changed && e.err == nil {
+			continue
+		}
+		actor := e.actor
+		actor.Component, actor.Instance = "cert-manager-webhook-sakuracloud", l.instance
+		r := auditRecord{Time: now, ZoneID: zoneID, FQDN: e.op.fqdn, Operation: e.operation(), Actor: actor, Result: "success"}
+		if zone != nil {
+			r.Zone = zone.Name
+		}
+		if e.err != nil {
+			r.Result, r.Error = "failure", e.err.Error()
+		}
+		l.write(r)
+	}
+}
+
+func (l *auditLog) write(r auditRecord) {
+	var line []byte
+	if l.format == "cef" {
+		line = []byte(l.cef(r))
+	} else {
+		var err error
+		if line, err = json.Marshal(r); err != nil {
+			auditRecordsTotal.WithLabelValues("failed").Inc()
+			sampledLog.Errorf("encoding the audit record for %s in zone %d: %v", r.FQDN, r.ZoneID, err)
+			return
+		}
+	}
+	line = append(line, '\n')
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if _, err := l.out.Write(line); err != nil {
+		auditRecordsTotal.WithLabelValues("failed").Inc()
+		sampledLog.Errorf("writing the audit record for %s in zone %d: %v", r.FQDN, r.ZoneID, err)
+		return
+	}
+	auditRecordsTotal.WithLabelValues("written").Inc()
+}
+
+// cef formats r as an ArcSight Common Event Format line. Failures are
+// reported with a higher severity.
+func (l *auditLog) cef(r auditRecord) string {
+	severity := "3"
+	if r.Result == "failure" {
+		severity = "7"
+	}
+	header := []string{
+		"CEF:0", "ophum", "cert-manager-webhook-sakuracloud", cefHeader(l.version),
+		"dns-" + r.Operation, cefHeader("DNS challenge record " + r.Operation), severity,
+	}
+	// The custom fields cn1 and cs1-cs4 are named by their label fields.
+	ext := []struct{ key, label, value string }{
+		{"rt", "", strconv.FormatInt(r.Time.UnixMilli(), 10)},
+		{"dvchost", "", r.Actor.Instance},
+		{"act", "", r.Operation},
+		{"outcome", "", r.Result},
+		{"cn1", "zoneID", strconv.FormatInt(r.ZoneID, 10)},
+		{"cs1", "zone", r.Zone},
+		{"cs2", "fqdn", r.FQDN},
+		{"cs3", "namespace", r.Actor.Namespace},
+		{"cs4", "challengeUID", r.Actor.ChallengeUID},
+		{"msg", "", r.Error},
+	}
+	var fields []string
+	for _, f := range ext {
+		if f.value == "" {
+			continue
+		}
+		if f.label != "" {
+			fields = append(fields, f.key+"Label="+f.label)
+		}
+		fields = append(fields, f.key+"="+cefExtension(f.value))
+	}
+	return strings.Join(header, "|") + "|" + strings.Join(fields, " ")
+}
+
+var (
+	cefHeaderEscaper    = strings.NewReplacer(`\`, `\\`, `|`, `\|`, "\n", " ", "\r", " ")
+	cefExtensionEscaper = strings.NewReplacer(`\`, `\\`, `=`, `\=`, "\n", `\n`, "\r", `\r`)
+)
+
+func cefHeader(s string) string    { return cefHeaderEscaper.Replace(s) }
+func cefExtension(s string) string { return cefExtensionEscaper.Replace(s) }
+
+// rotatingFile is a file that is renamed to path.1 once a write would grow
+// it beyond maxSize, shifting the older files up to path.<maxBackups>.
+type rotatingFile struct {
+	path       string
+	maxSize    int64
+	maxBackups int
+
+	f    *os.File
+	size int64
+}
+
+func openRotatingFile(path string, maxSize int64, maxBackups int) (*rotatingFile, error) {
+	r := &rotatingFile{path: path, maxSize: maxSize, maxBackups: maxBackups}
+	if err := r.open(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (r *rotatingFile) open() error {
+	f, err := os.OpenFile(r.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o600)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	r.f, r.size = f, info.Size()
+	return nil
+}
+
+// Write writes p, rotating the file first if needed. The caller serializes
+// the writes.
+func (r *rotatingFile) Write(p []byte) (int, error) {
+	if r.maxSize > 0 && r.size > 0 && r.size+int64(len(p)) > r.maxSize {
+		if err := r.rotate(); err != nil {
+			return 0, err
+		}
+	}
+	if r.f == nil {
+		if err := r.open(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := r.f.Write(p)
+	r.size += int64(n)
+	return n, err
+}
+
+func (r *rotatingFile) rotate() error {
+	if r.f != nil {
+		if err := r.f.Close(); err != nil {
+			return err
+		}
+		r.f = nil
+	}
+	if r.maxBackups <= 0 {
+		return os.Remove(r.path)
+	}
+	for i := r.maxBackups - 1; i >= 1; i-- {
+		err := os.Rename(r.path+"."+strconv.Itoa(i), r.path+"."+strconv.Itoa(i+1))
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return os.Rename(r.path, r.path+".1")
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/sacloud/iaas-api-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAuditLog(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	zone := &iaas.DNS{ID: 1, Name: "example.com"}
+	actor := changeActor{Namespace: "default", ChallengeUID: "uid"}
+	edits := []*zoneEdit{
+		{op: zoneOp{fqdn: "_acme-challenge.a.example.com."}, actor: actor, changed: true},
+		{op: zoneOp{fqdn: "_acme-challenge.b.example.com."}, actor: actor},
+		{op: zoneOp{fqdn: "_acme-challenge.c.example.com.", cleanup: true}, actor: actor, err: errors.New("409 Conflict\nretry")},
+	}
+
+	t.Run("jsonl", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "audit.log")
+		l, err := newAuditLog(path, "jsonl", 0, 0)
+		require.NoError(t, err)
+		l.instance, l.now = "webhook-0", func() time.Time { return now }
+		written := auditRecordsTotal.WithLabelValues("written")
+		before := testutil.ToFloat64(written)
+		l.record(1, zone, edits)
+		assert.Equal(t, before+2, testutil.ToFloat64(written))
+
+		data, err := os.ReadFile(path)
+		require.NoError(t, err)
+		lines := strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+		require.Len(t, lines, 2)
+		var r auditRecord
+		require.NoError(t, json.Unmarshal([]byte(lines[0]), &r))
+		assert.Equal(t, auditRecord{
+			Time:      now,
+			ZoneID:    1,
+			Zone:      "example.com",
+			FQDN:      "_acme-challenge.a.example.com.",
+			Operation: "present",
+			Actor:     changeActor{Component: "cert-manager-webhook-sakuracloud", Instance: "webhook-0", Namespace: "default", ChallengeUID: "uid"},
+			Result:    "success",
+		}, r)
+		require.NoError(t, json.Unmarshal([]byte(lines[1]), &r))
+		assert.Equal(t, "cleanup", r.Operation)
+		assert.Equal(t, "failure", r.Result)
+		assert.Equal(t, "409 Conflict\nretry", r.Error)
+	})
+
+	t.Run("cef", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "audit.log")
+		l, err := newAuditLog(path, "cef", 0, 0)
+		require.NoError(t, err)
+		l.instance, l.version, l.now = "webhook-0", "v1.2|3", func() time.Time { return now }
+		l.record(1, nil, edits)
+
+		data, err := os.ReadFile(path)
+		require.NoError(t, err)
+		assert.Equal(t,
+			`CEF:0|ophum|cert-manager-webhook-sakuracloud|v1.2\|3|dns-present|DNS challenge record present|3|`+
+				`rt=1704067200000 dvchost=webhook-0 act=present outcome=success cn1Label=zoneID cn1=1 `+
+				`cs2Label=fqdn cs2=_acme-challenge.a.example.com. cs3Label=namespace cs3=default cs4Label=challengeUID cs4=uid`+"\n"+
+				`CEF:0|ophum|cert-manager-webhook-sakuracloud|v1.2\|3|dns-cleanup|DNS challenge record cleanup|7|`+
+				`rt=1704067200000 dvchost=webhook-0 act=cleanup outcome=failure cn1Label=zoneID cn1=1 `+
+				`cs2Label=fqdn cs2=_acme-challenge.c.example.com. cs3Label=namespace cs3=default cs4Label=challengeUID cs4=uid `+
+				`msg=409 Conflict\nretry`+"\n",
+			string(data))
+	})
+}
+
+func TestNewAuditLog(t *testing.T) {
+	l, err := newAuditLog("", "jsonl", 0, 0)
+	require.NoError(t, err)
+	assert.Nil(t, l)
+	l.record(1, &iaas.DNS{}, []*zoneEdit{{changed: true}})
+
+	_, err = newAuditLog("-", "syslog", 0, 0)
+	assert.ErrorContains(t, err, "unknown --audit-log-format")
+	_, err = newAuditLog(filepath.Join(t.TempDir(), "missing", "audit.log"), "jsonl", 0, 0)
+	assert.ErrorContains(t, err, "--audit-log-path")
+}
+
+func TestRotatingFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	f, err := openRotatingFile(path, 10, 2)
+	require.NoError(t, err)
+	for _, line := range []string{"first\n", "second\n", "third\n", "fourth\n"} {
+		_, err := f.Write([]byte(line))
+		require.NoError(t, err)
+	}
+
+	for name, want := range map[string]string{"": "fourth\n", ".1": "third\n", ".2": "second\n"} {
+		data, err := os.ReadFile(path + name)
+		require.NoError(t, err)
+		assert.Equal(t, want, string(data), name)
+	}
+	assert.NoFileExists(t, path+".3")
+
+	// An existing file counts towards the size.
+	f, err = openRotatingFile(path, 10, 2)
+	require.NoError(t, err)
+	_, err = f.Write([]byte("fifth\n"))
+	require.NoError(t, err)
+	data, err := os.ReadFile(path + ".1")
+	require.NoError(t, err)
+	assert.Equal(t, "fourth\n", string(data))
+}
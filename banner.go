@@ -0,0 +1,180 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"runtime/debug"
+	"sync"
+
+	"github.com/cert-manager/cert-manager/pkg/acme/webhook"
+	"k8s.io/klog/v2"
+)
+
+// logStartupBanner logs a single entry summarizing the configuration the
+// webhook starts with, so that support can reconstruct the environment from
+// the first lines of the logs.
+func logStartupBanner(groupName string, groups []groupConfig, hooks []webhook.Solver, opts *solverOptions) {
+	kv := startupBanner(groupName, groups, hooks, opts)
+	effectiveConfig.set(kv)
+	klog.InfoS("starting the webhook", kv...)
+}
+
+// effectiveConfig is the startup banner of the running webhook, served on
+// /debug/config for support bundles.
+var effectiveConfig = &bannerConfig{}
+
+type bannerConfig struct {
+	mu     sync.Mutex
+	values map[string]any
+}
+
+func (c *bannerConfig) set(kv []any) {
+	values := map[string]any{}
+	for i := 0; i+1 < len(kv); i += 2 {
+		values[fmt.Sprint(kv[i])] = kv[i+1]
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.values = values
+}
+
+// get returns the banner, or only the version before the webhook started.
+func (c *bannerConfig) get() map[string]any {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.values == nil {
+		return map[string]any{"version": buildVersion()}
+	}
+	return c.values
+}
+
+// startupBanner returns the key/value pairs of the startup banner. It holds
+// no credentials: API keys are only referenced by the Issuers, files are
+// logged by path and URLs without their user info and query.
+func startupBanner(groupName string, groups []groupConfig, hooks []webhook.Solver, opts *solverOptions) []any {
+	groupNames := []string{groupName}
+	for _, g := range groups {
+		groupNames = append(groupNames, g.GroupName)
+	}
+	var solverNames []string
+	for _, h := range hooks {
+		solverNames = append(solverNames, h.Name())
+	}
+
+	secretsCluster := "local"
+	if opts.SecretsKubeconfig != "" {
+		secretsCluster = opts.SecretsKubeconfig
+	}
+
+	return []any{
+		"version", buildVersion(),
+		"groupNames", groupNames,
+		"solverNames", solverNames,
+		"features", enabledFeatures(opts),
+		"credentials", map[string]any{
+			"providers":               opts.CredentialProviders,
+			"secretsCluster":          secretsCluster,
+			"secretsNamespace":        opts.SecretsNamespace,
+			"allowedSecretNamespaces": opts.AllowedSecretNamespaces,
+		},
+		"zones", map[string]any{
+			"prune":      opts.PruneZones,
+			"readiness":  opts.ReadinessZones,
+			"metrics":    opts.MetricsZones,
+			"metricsMax": opts.MetricsMaxZones,
+		},
+		"rateLimits", map[string]any{
+			"zoneWorkers":            opts.ZoneWorkers,
+			"zoneBatchWindow":        opts.ZoneBatchWindow.String(),
+			"presentCacheTTL":        opts.PresentCacheTTL.String(),
+			"zoneMetadataCacheTTL":   opts.ZoneMetadataCacheTTL.String(),
+			"dailyUpdateBudget":      opts.DailyUpdateBudget,
+			"circuitBreakerFailures": opts.CircuitBreakerFailures,
+			"circuitBreakerCooldown": opts.CircuitBreakerCooldown.String(),
+			"maintenanceBackoff":     opts.MaintenanceBackoff.String(),
+			"handlerTimeout":         opts.HandlerTimeout.String(),
+			"apiRetryStatusCodes":    opts.APIRetryStatusCodes,
+		},
+		"propagation", map[string]any{
+			"checker":     opts.PropagationChecker,
+			"timeout":     opts.PropagationCheckTimeout.String(),
+			"nameservers": opts.PropagationNameservers,
+			"resolvers":   opts.PropagationResolvers,
+			"dohURL":      sanitizeURL(opts.PropagationDoHURL),
+		},
+		"ttl", map[string]any{
+			"default": opts.DefaultTTL,
+			"min":     opts.MinTTL,
+			"max":     opts.MaxTTL,
+		},
+	}
+}
+
+// enabledFeatures lists the optional behaviors opts turn on, by the name of
+// the flag that turns them on.
+func enabledFeatures(opts *solverOptions) []string {
+	features := []string{}
+	for _, f := range []struct {
+		name    string
+		enabled bool
+	}{
+		{"leader-elect", opts.LeaderElect},
+		{"registry-configmap", opts.RegistryConfigMap != ""},
+		{"registry-store=" + opts.RegistryStore, opts.registryEnabled() && opts.RegistryStore != "configmap"},
+		{"registry-gc-interval", opts.registryEnabled() && opts.RegistryGCInterval > 0},
+		{"cleanup-on-shutdown", opts.CleanupOnShutdown},
+		{"prune-age", opts.PruneAge > 0},
+		{"async-propagation-check", opts.AsyncPropagationCheck},
+		{"verify-zone-updates", opts.VerifyZoneUpdates},
+		{"refresh-after-write", opts.RefreshAfterWrite > 0},
+		{"record-engine=v1", opts.RecordEngine == "v1"},
+		{"config-shape=cert-manager", opts.ConfigShape == "cert-manager"},
+		{"snapshot-dir", opts.SnapshotDir != ""},
+		{"groups-config", opts.GroupsConfig != ""},
+		{"grpc-bind-address", opts.GRPCBindAddress != ""},
+		{"debug-bind-address", opts.DebugBindAddress != ""},
+		{"drain-bind-address", opts.DrainBindAddress != ""},
+		{"change-event-url", opts.ChangeEventURL != ""},
+		{"audit-log-path", opts.AuditLogPath != ""},
+		{"notification-group-id", opts.NotificationGroupID != ""},
+		{"heartbeat-namespace", opts.HeartbeatNamespace != ""},
+		{"issuer-check-interval", opts.IssuerCheckInterval > 0},
+		{"external-dns-owner-id", opts.ExternalDNSOwnerID != ""},
+		{"inject-failure-rate", opts.InjectFailureRate > 0},
+		{"api-debug-logging", opts.APIDebugLogging},
+	} {
+		if f.enabled {
+			features = append(features, f.name)
+		}
+	}
+	return features
+}
+
+// sanitizeURL returns rawURL without user info and query, which may hold
+// credentials.
+func sanitizeURL(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "<invalid URL>"
+	}
+	u.User, u.RawQuery, u.Fragment = nil, "", ""
+	return u.String()
+}
+
+// buildVersion returns the module version, VCS revision and Go version the
+// binary was built with, as far as the build recorded them.
+func buildVersion() map[string]string {
+	version := map[string]string{}
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return version
+	}
+	version["module"], version["go"] = info.Main.Version, info.GoVersion
+	for _, s := range info.Settings {
+		switch s.Key {
+		case "vcs.revision", "vcs.time", "vcs.modified":
+			version[s.Key] = s.Value
+		}
+	}
+	return version
+}
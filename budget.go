@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/sacloud/iaas-api-go"
+	"github.com/sacloud/iaas-api-go/types"
+	"k8s.io/klog/v2"
+)
+
+// errUpdateBudgetExhausted is the error of CleanUps deferred because their
+// API key used up its --daily-update-budget.
+var errUpdateBudgetExhausted = errors.New("the API key used up its --daily-update-budget of zone updates, the cleanup is deferred until the next day (UTC)")
+
+// updateBudget counts the zone updates of each API key per UTC day, so the
+// webhook leaves the rest of the API quota to other tooling sharing the
+// keys. Presents are made over the budget, but CleanUps wait for the next
+// day; see deferCleanups.
+//
+// The counts are kept in memory by each replica: every replica has the
+// whole budget, and a restarted replica starts the day over. The budget
+// bounds the updates of a replica, so with several replicas it has to be
+// divided by their number.
+type updateBudget struct {
+	limit int
+	now   func() time.Time
+
+	mu   sync.Mutex
+	day  string
+	used map[string]int
+}
+
+// newUpdateBudget returns a budget of limit updates per API key and day, or
+// nil if limit is zero or less.
+func newUpdateBudget(limit int) *updateBudget {
+	if limit <= 0 {
+		return nil
+	}
+	return &updateBudget{limit: limit, now: time.Now, used: map[string]int{}}
+}
+
+// rollover starts a new day once the UTC date changed. b.mu must be held.
+func (b *updateBudget) rollover() {
+	day := b.now().UTC().Format(time.DateOnly)
+	if day == b.day {
+		return
+	}
+	b.day = day
+	for credential := range b.used {
+		updateBudgetRemaining.WithLabelValues(credential).Set(float64(b.limit))
+	}
+	clear(b.used)
+}
+
+// spend counts a zone update with credential.
+func (b *updateBudget) spend(credential string) {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.rollover()
+	b.used[credential]++
+	used := b.used[credential]
+	updateBudgetRemaining.WithLabelValues(credential).Set(float64(max(b.limit-used, 0)))
+	if used == b.limit {
+		klog.Warningf("API key %s used up its --daily-update-budget of %d zone updates, cleanups are deferred until the next day (UTC)", credential, b.limit)
+	}
+}
+
+// exhausted reports whether credential used up its budget for the day.
+func (b *updateBudget) exhausted(credential string) bool {
+	if b == nil {
+		return false
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.rollover()
+	return b.used[credential] >= b.limit
+}
+
+// budgetedZoneAPI counts the zone updates made with one API key against its
+// budget.
+type budgetedZoneAPI struct {
+	zoneAPI
+	credential string
+	budget     *updateBudget
+}
+
+func (z *budgetedZoneAPI) UpdateSettings(ctx context.Context, id types.ID, param *iaas.DNSUpdateSettingsRequest) (*iaas.DNS, error) {
+	z.budget.spend(z.credential)
+	return z.zoneAPI.UpdateSettings(ctx, id, param)
+}
+
+// deferCleanups fails the changed edits with errUpdateBudgetExhausted and
+// reports true if all of them are CleanUps and the API key of client used up
+// its budget. An update that presents a record is never deferred.
+func deferCleanups(client zoneAPI, edits []*zoneEdit) bool {
+	z, ok := client.(*budgetedZoneAPI)
+	if !ok || !z.budget.exhausted(z.credential) {
+		return false
+	}
+	for _, e := range edits {
+		if e.changed && !e.op.cleanup {
+			return false
+		}
+	}
+	for _, e := range edits {
+		if e.changed {
+			e.changed, e.err = false, errUpdateBudgetExhausted
+			cleanupsDeferredTotal.Inc()
+		}
+	}
+	return true
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/sacloud/iaas-api-go"
+	"github.com/sacloud/iaas-api-go/types"
+	"github.com/stretchr/testify/assert"
+	"k8s.io/klog/v2"
+)
+
+func TestUpdateBudget(t *testing.T) {
+	now := time.Date(2024, 1, 1, 23, 0, 0, 0, time.UTC)
+	b := newUpdateBudget(2)
+	b.now = func() time.Time { return now }
+
+	buf := captureKlog(t, 0)
+	b.spend("a")
+	assert.False(t, b.exhausted("a"))
+	b.spend("a")
+	assert.True(t, b.exhausted("a"))
+	klog.Flush()
+	assert.Contains(t, buf.String(), "API key a used up its --daily-update-budget of 2 zone updates", "warned once the cleanups are deferred")
+	warned := buf.Len()
+	assert.False(t, b.exhausted("b"), "every API key has its own budget")
+	b.spend("a")
+	assert.True(t, b.exhausted("a"))
+	klog.Flush()
+	assert.Equal(t, warned, buf.Len(), "warned once")
+
+	now = now.Add(time.Hour)
+	assert.False(t, b.exhausted("a"), "the budget is renewed every UTC day")
+
+	assert.Nil(t, newUpdateBudget(0))
+	var unlimited *updateBudget
+	unlimited.spend("a")
+	assert.False(t, unlimited.exhausted("a"))
+}
+
+type countingZoneAPI struct {
+	zoneAPI
+	updates int
+}
+
+func (z *countingZoneAPI) UpdateSettings(context.Context, types.ID, *iaas.DNSUpdateSettingsRequest) (*iaas.DNS, error) {
+	z.updates++
+	return nil, nil
+}
+
+func TestDeferCleanups(t *testing.T) {
+	b := newUpdateBudget(1)
+	api := &countingZoneAPI{}
+	client := &budgetedZoneAPI{zoneAPI: api, credential: "a", budget: b}
+
+	cleanup := func() *zoneEdit { return &zoneEdit{op: zoneOp{cleanup: true}, changed: true} }
+	edits := []*zoneEdit{cleanup()}
+	assert.False(t, deferCleanups(client, edits), "within the budget")
+	_, _ = client.UpdateSettings(context.Background(), 1, nil)
+	assert.Equal(t, 1, api.updates)
+
+	assert.True(t, deferCleanups(client, edits))
+	assert.ErrorIs(t, edits[0].err, errUpdateBudgetExhausted)
+	assert.False(t, edits[0].changed)
+
+	unchanged := cleanup()
+	unchanged.changed = false
+	edits = []*zoneEdit{cleanup(), {op: zoneOp{}, changed: true}, unchanged}
+	assert.False(t, deferCleanups(client, edits), "updates presenting records are made over the budget")
+	assert.NoError(t, edits[0].err)
+
+	assert.False(t, deferCleanups(api, []*zoneEdit{cleanup()}), "clients without a budget")
+}
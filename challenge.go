@@ -0,0 +1,67 @@
+package main
+
+import (
+	"encoding/base64"
+	"fmt"
+	"strings"
+
+	"github.com/cert-manager/cert-manager/pkg/acme/webhook/apis/acme/v1alpha1"
+	"github.com/cert-manager/webhook-example/pkg/dnsname"
+)
+
+// invalidChallengeError reports a ChallengeRequest field that cannot be
+// turned into a DNS record.
+type invalidChallengeError struct {
+	field  string
+	value  string
+	reason string
+}
+
+func (e *invalidChallengeError) Error() string {
+	return fmt.Sprintf("invalid challenge request: %s %q %s", e.field, e.value, e.reason)
+}
+
+// validateChallenge checks the parts of ch the solver writes into the zone
+// before any API call is made.
+func validateChallenge(ch *v1alpha1.ChallengeRequest) error {
+	if ch.Key == "" {
+		return &invalidChallengeError{field: "key", value: ch.Key, reason: "is empty"}
+	}
+	if !strings.HasSuffix(ch.ResolvedFQDN, ".") {
+		return &invalidChallengeError{field: "resolvedFQDN", value: ch.ResolvedFQDN, reason: "is not fully qualified"}
+	}
+	if !strings.HasSuffix(ch.ResolvedZone, ".") {
+		return &invalidChallengeError{field: "resolvedZone", value: ch.ResolvedZone, reason: "is not fully qualified"}
+	}
+	// The FQDN may be the zone itself, e.g. a zone delegated for the
+	// challenge records only: the record is then written at the apex.
+	if _, err := dnsname.RelativeName(ch.ResolvedFQDN, ch.ResolvedZone); err != nil {
+		return &invalidChallengeError{field: "resolvedFQDN", value: ch.ResolvedFQDN, reason: "is not within resolvedZone " + ch.ResolvedZone}
+	}
+	return nil
+}
+
+// acmeKeyLength is the length of the key of a DNS-01 challenge: the SHA-256
+// digest of the key authorization in base64url without padding (RFC 8555,
+// section 8.4).
+const acmeKeyLength = 43
+
+// keyProblem returns why key does not look like the key of a DNS-01
+// challenge, or "" if it does. Such keys are still written, as other ACME
+// servers might use other keys, but they are usually corrupted somewhere
+// between the ACME server and the webhook, and the record then never
+// validates.
+func keyProblem(key string) string {
+	if len(key) != acmeKeyLength {
+		return fmt.Sprintf("is %d characters long instead of %d", len(key), acmeKeyLength)
+	}
+	for i, r := range key {
+		if !('a' <= r && r <= 'z' || 'A' <= r && r <= 'Z' || '0' <= r && r <= '9' || r == '-' || r == '_') {
+			return fmt.Sprintf("has %q at offset %d, which is not in the base64url alphabet", r, i)
+		}
+	}
+	if _, err := base64.RawURLEncoding.Strict().DecodeString(key); err != nil {
+		return "is not canonical base64url"
+	}
+	return ""
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/cert-manager/cert-manager/pkg/acme/webhook/apis/acme/v1alpha1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidateChallenge(t *testing.T) {
+	tests := []struct {
+		name      string
+		ch        v1alpha1.ChallengeRequest
+		wantField string
+	}{
+		{
+			name: "valid",
+			ch:   v1alpha1.ChallengeRequest{Key: "key", ResolvedFQDN: "_acme-challenge.example.com.", ResolvedZone: "example.com."},
+		},
+		{
+			name: "zone apex",
+			ch:   v1alpha1.ChallengeRequest{Key: "key", ResolvedFQDN: "_acme-challenge.example.com.", ResolvedZone: "_acme-challenge.example.com."},
+		},
+		{
+			name: "root zone",
+			ch:   v1alpha1.ChallengeRequest{Key: "key", ResolvedFQDN: "_acme-challenge.example.com.", ResolvedZone: "."},
+		},
+		{
+			name:      "empty key",
+			ch:        v1alpha1.ChallengeRequest{ResolvedFQDN: "_acme-challenge.example.com.", ResolvedZone: "example.com."},
+			wantField: "key",
+		},
+		{
+			name:      "relative fqdn",
+			ch:        v1alpha1.ChallengeRequest{Key: "key", ResolvedFQDN: "_acme-challenge.example.com", ResolvedZone: "example.com."},
+			wantField: "resolvedFQDN",
+		},
+		{
+			name:      "relative zone",
+			ch:        v1alpha1.ChallengeRequest{Key: "key", ResolvedFQDN: "_acme-challenge.example.com.", ResolvedZone: "example.com"},
+			wantField: "resolvedZone",
+		},
+		{
+			name:      "fqdn outside zone",
+			ch:        v1alpha1.ChallengeRequest{Key: "key", ResolvedFQDN: "_acme-challenge.badexample.com.", ResolvedZone: "example.com."},
+			wantField: "resolvedFQDN",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateChallenge(&tt.ch)
+			if tt.wantField == "" {
+				assert.NoError(t, err)
+				return
+			}
+			var invalid *invalidChallengeError
+			if assert.ErrorAs(t, err, &invalid) {
+				assert.Equal(t, tt.wantField, invalid.field)
+			}
+		})
+	}
+}
+
+func TestKeyProblem(t *testing.T) {
+	assert.Empty(t, keyProblem("LoqXcYV8q5ONbJQxbmR7SCTNo3tiAXDfowyjxAjEuX0"))
+	key, err := simulationKey()
+	require.NoError(t, err)
+	assert.Empty(t, keyProblem(key))
+	assert.Equal(t, "is 3 characters long instead of 43", keyProblem("key"))
+	assert.Equal(t, `has '+' at offset 0, which is not in the base64url alphabet`, keyProblem("+oqXcYV8q5ONbJQxbmR7SCTNo3tiAXDfowyjxAjEuX0"))
+	assert.Equal(t, "is not canonical base64url", keyProblem("LoqXcYV8q5ONbJQxbmR7SCTNo3tiAXDfowyjxAjEuX1"))
+}
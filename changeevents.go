@@ -0,0 +1,204 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/cert-manager/cert-manager/pkg/acme/webhook/apis/acme/v1alpha1"
+	"github.com/sacloud/iaas-api-go"
+	"k8s.io/apimachinery/pkg/util/wait"
+)
+
+// changeEvent is the JSON body posted to --change-event-url for every
+// record the solver wrote to a zone, as a change record for CMDB or ITSM
+// systems.
+type changeEvent struct {
+	Time   time.Time `json:"time"`
+	ZoneID int64     `json:"zoneID"`
+	Zone   string    `json:"zone"`
+	// FQDN is the challenge record; prune events have none, as one prune
+	// deletes all stale records of the zone.
+	FQDN string `json:"fqdn,omitempty"`
+	// Operation is present, cleanup or prune.
+	Operation string      `json:"operation"`
+	Actor     changeActor `json:"actor"`
+	// Result is always success: failed writes change nothing.
+	Result string `json:"result"`
+}
+
+// changeActor identifies who made a change: the webhook instance and, for
+// challenge records, the cert-manager challenge it was made for.
+type changeActor struct {
+	Component    string `json:"component"`
+	Instance     string `json:"instance"`
+	Namespace    string `json:"namespace,omitempty"`
+	ChallengeUID string `json:"challengeUID,omitempty"`
+}
+
+// challengeActor returns the actor of the edits made for ch.
+func challengeActor(ch *v1alpha1.ChallengeRequest) changeActor {
+	return changeActor{Namespace: ch.ResourceNamespace, ChallengeUID: string(ch.UID)}
+}
+
+// changeEventQueueSize is how many events wait for delivery before new ones
+// are dropped.
+const changeEventQueueSize = 1000
+
+// changeNotifier posts changeEvents to a webhook endpoint. Events are
+// queued and posted in the background, so a slow or unavailable endpoint
+// never delays a challenge; failed posts are retried a few times and then
+// only counted and logged. A nil notifier posts nothing.
+type changeNotifier struct {
+	url      string
+	token    string
+	instance string
+	client   *http.Client
+	retries  int
+	backoff  time.Duration
+	now      func() time.Time
+
+	events chan changeEvent
+}
+
+// newChangeNotifier returns a notifier posting to url with the bearer token
+// of tokenFile, if set, or nil if url is empty.
+func newChangeNotifier(url, tokenFile string) (*changeNotifier, error) {
+	if url == "" {
+		return nil, nil
+	}
+	if !strings.HasPrefix(url, "http://") && !strings.HasPrefix(url, "https://") {
+		return nil, fmt.Errorf("--change-event-url %q is not an http or https URL", url)
+	}
+	var token string
+	if tokenFile != "" {
+		data, err := os.ReadFile(tokenFile)
+		if err != nil {
+			return nil, fmt.Errorf("--change-event-token-file: %w", err)
+		}
+		if token = strings.TrimSpace(string(data)); token == "" {
+			return nil, errors.New("--change-event-token-file is empty")
+		}
+	}
+	instance, _ := os.Hostname()
+	return &changeNotifier{
+		url:      url,
+		token:    token,
+		instance: instance,
+		client:   &http.Client{Timeout: 10 * time.Second},
+		retries:  3,
+		backoff:  time.Second,
+		now:      time.Now,
+		events:   make(chan changeEvent, changeEventQueueSize),
+	}, nil
+}
+
+// len returns the number of events waiting for delivery.
+func (n *changeNotifier) len() int {
+	if n == nil {
+		return 0
+	}
+	return len(n.events)
+}
+
+// notify queues an event for every edit of zone that was written.
+func (n *changeNotifier) notify(zone *iaas.DNS, edits []*zoneEdit) {
+	if n == nil {
+		return
+	}
+	now := n.now()
+	for _, e := range edits {
+		if !e.changed || e.err != nil {
+			continue
+		}
+		actor := e.actor
+		actor.Component, actor.Instance = "cert-manager-webhook-sakuracloud", n.instance
+		event := changeEvent{
+			Time:      now,
+			ZoneID:    zone.ID.Int64(),
+			Zone:      zone.Name,
+			FQDN:      e.op.fqdn,
+			Operation: e.operation(),
+			Actor:     actor,
+			Result:    "success",
+		}
+		select {
+		case n.events <- event:
+		default:
+			changeEventsTotal.WithLabelValues("dropped").Inc()
+			sampledLog.Errorf("dropping the change event for %s in zone %s: %d events are waiting for %s", event.FQDN, zone.Name, changeEventQueueSize, sanitizeURL(n.url))
+		}
+	}
+}
+
+// run posts the queued events until stopCh is closed, and the ones still
+// queued once more on the way out.
+func (n *changeNotifier) run(stopCh <-chan struct{}) {
+	ctx := wait.ContextForChannel(stopCh)
+	for {
+		select {
+		case <-stopCh:
+			for {
+				select {
+				case event := <-n.events:
+					n.deliver(context.Background(), event, 1)
+				default:
+					return
+				}
+			}
+		case event := <-n.events:
+			n.deliver(ctx, event, n.retries)
+		}
+	}
+}
+
+// deliver posts event, trying up to attempts times.
+func (n *changeNotifier) deliver(ctx context.Context, event changeEvent, attempts int) {
+	var err error
+	for i := 0; i < attempts; i++ {
+		if i > 0 {
+			select {
+			case <-ctx.Done():
+			case <-time.After(n.backoff << (i - 1)):
+			}
+		}
+		if err = n.post(ctx, event); err == nil {
+			changeEventsTotal.WithLabelValues("sent").Inc()
+			return
+		}
+	}
+	changeEventsTotal.WithLabelValues("failed").Inc()
+	sampledLog.Errorf("posting the change event for %s in zone %s: %v", event.FQDN, event.Zone, err)
+}
+
+func (n *changeNotifier) post(ctx context.Context, event changeEvent) error {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, n.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if n.token != "" {
+		req.Header.Set("Authorization", "Bearer "+n.token)
+	}
+	resp, err := n.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("%s responded %s: %s", sanitizeURL(n.url), resp.Status, msg)
+	}
+	return nil
+}
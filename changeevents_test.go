@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/sacloud/iaas-api-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestChangeNotifier(t *testing.T) {
+	received := make(chan changeEvent, 10)
+	var status int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "Bearer token", r.Header.Get("Authorization"))
+		assert.Equal(t, "application/json", r.Header.Get("Content-Type"))
+		var event changeEvent
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&event))
+		if status != 0 {
+			w.WriteHeader(status)
+			return
+		}
+		received <- event
+	}))
+	defer srv.Close()
+
+	tokenFile := filepath.Join(t.TempDir(), "token")
+	require.NoError(t, os.WriteFile(tokenFile, []byte("token\n"), 0o600))
+	n, err := newChangeNotifier(srv.URL, tokenFile)
+	require.NoError(t, err)
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	n.instance, n.now, n.backoff = "webhook-0", func() time.Time { return now }, time.Millisecond
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+	go n.run(stopCh)
+
+	zone := &iaas.DNS{ID: 1, Name: "example.com"}
+	actor := changeActor{Namespace: "default", ChallengeUID: "uid"}
+	n.notify(zone, []*zoneEdit{
+		{op: zoneOp{fqdn: "_acme-challenge.a.example.com."}, actor: actor, changed: true},
+		{op: zoneOp{fqdn: "_acme-challenge.b.example.com."}, actor: actor},
+		{op: zoneOp{fqdn: "_acme-challenge.c.example.com."}, actor: actor, err: errors.New("409 Conflict")},
+		{op: zoneOp{fqdn: "_acme-challenge.a.example.com.", cleanup: true}, actor: actor, changed: true},
+		{changed: true},
+	})
+
+	var events []changeEvent
+	for len(events) < 3 {
+		select {
+		case event := <-received:
+			events = append(events, event)
+		case <-time.After(5 * time.Second):
+			t.Fatalf("received %d of 3 events", len(events))
+		}
+	}
+	assert.Equal(t, changeEvent{
+		Time:      now,
+		ZoneID:    1,
+		Zone:      "example.com",
+		FQDN:      "_acme-challenge.a.example.com.",
+		Operation: "present",
+		Actor:     changeActor{Component: "cert-manager-webhook-sakuracloud", Instance: "webhook-0", Namespace: "default", ChallengeUID: "uid"},
+		Result:    "success",
+	}, events[0])
+	assert.Equal(t, "cleanup", events[1].Operation)
+	assert.Equal(t, "prune", events[2].Operation)
+	assert.Empty(t, events[2].FQDN)
+	assert.Empty(t, events[2].Actor.ChallengeUID)
+
+	t.Run("failed", func(t *testing.T) {
+		status = http.StatusServiceUnavailable
+		failed := changeEventsTotal.WithLabelValues("failed")
+		before := testutil.ToFloat64(failed)
+		n.deliver(context.Background(), events[0], 3)
+		assert.Equal(t, before+1, testutil.ToFloat64(failed))
+	})
+}
+
+func TestNewChangeNotifier(t *testing.T) {
+	n, err := newChangeNotifier("", "")
+	require.NoError(t, err)
+	assert.Nil(t, n)
+	n.notify(&iaas.DNS{}, []*zoneEdit{{changed: true}})
+
+	_, err = newChangeNotifier("cmdb.example.com/events", "")
+	assert.ErrorContains(t, err, "not an http or https URL")
+	_, err = newChangeNotifier("https://cmdb.example.com/events", filepath.Join(t.TempDir(), "missing"))
+	assert.ErrorContains(t, err, "--change-event-token-file")
+}
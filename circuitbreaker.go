@@ -0,0 +1,107 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// errCircuitOpen is returned for API requests while the circuit breaker is
+// open.
+var errCircuitOpen = errors.New("Sakura Cloud API circuit breaker is open")
+
+// apiBreaker guards the path from this replica to the Sakura Cloud API. It
+// is configured from --circuit-breaker-failures and
+// --circuit-breaker-cooldown; with a zero threshold it never opens.
+var apiBreaker = &circuitBreaker{now: time.Now}
+
+// circuitBreaker opens after threshold consecutive failed requests and
+// rejects requests until cooldown has passed. Then one request is let
+// through; it closes the breaker if it succeeds and reopens it otherwise.
+type circuitBreaker struct {
+	threshold int
+	cooldown  time.Duration
+	now       func() time.Time
+	// onOpen, if set, is called when the breaker opens after having been
+	// closed.
+	onOpen func()
+
+	mu        sync.Mutex
+	failures  int
+	openUntil time.Time
+	probing   bool
+}
+
+// allow reports whether a request may be sent.
+func (b *circuitBreaker) allow() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.threshold <= 0 || b.failures < b.threshold {
+		return true
+	}
+	if b.probing || b.now().Before(b.openUntil) {
+		return false
+	}
+	b.probing = true
+	return true
+}
+
+// record reports the outcome of a request let through by allow.
+func (b *circuitBreaker) record(failed bool) {
+	b.mu.Lock()
+	b.probing = false
+	if !failed {
+		b.failures = 0
+		b.mu.Unlock()
+		return
+	}
+	b.failures++
+	if b.threshold > 0 && b.failures >= b.threshold {
+		b.openUntil = b.now().Add(b.cooldown)
+	}
+	opened := b.threshold > 0 && b.failures == b.threshold
+	b.mu.Unlock()
+	if opened && b.onOpen != nil {
+		b.onOpen()
+	}
+}
+
+// open reports whether requests are currently rejected.
+func (b *circuitBreaker) open() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.threshold > 0 && b.failures >= b.threshold && b.now().Before(b.openUntil)
+}
+
+// breakerTransport sends requests through apiBreaker. Transport errors and
+// 5xx responses count as failures; any other response shows the API is
+// reachable.
+type breakerTransport struct {
+	breaker *circuitBreaker
+	next    http.RoundTripper
+}
+
+func (t *breakerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if !t.breaker.allow() {
+		return nil, errCircuitOpen
+	}
+	resp, err := t.next.RoundTrip(req)
+	t.breaker.record(err != nil || resp.StatusCode >= 500)
+	return resp, err
+}
+
+// circuitBreakerCheck reports the replica as not ready while the breaker
+// is open, so that challenges go to replicas that can reach the API.
+func circuitBreakerCheck(b *circuitBreaker) readinessCheck {
+	return readinessCheck{
+		name: "sakuracloud-api",
+		check: func() error {
+			if b.open() {
+				return fmt.Errorf("circuit breaker open after %d consecutive failed API requests", b.threshold)
+			}
+			return nil
+		},
+	}
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCircuitBreaker(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	opened := 0
+	b := &circuitBreaker{threshold: 2, cooldown: time.Minute, now: func() time.Time { return now }, onOpen: func() { opened++ }}
+	check := circuitBreakerCheck(b)
+
+	assert.True(t, b.allow())
+	b.record(true)
+	assert.True(t, b.allow(), "stays closed below the threshold")
+	b.record(true)
+	assert.False(t, b.allow(), "opens at the threshold")
+	assert.Error(t, check.check())
+
+	now = now.Add(time.Minute)
+	assert.NoError(t, check.check(), "ready again once the cooldown passed")
+	assert.True(t, b.allow(), "lets one request through after the cooldown")
+	assert.False(t, b.allow(), "but only one")
+	b.record(true)
+	assert.False(t, b.allow(), "a failed probe reopens the breaker")
+	assert.Error(t, check.check())
+
+	now = now.Add(time.Minute)
+	assert.True(t, b.allow())
+	b.record(false)
+	assert.True(t, b.allow(), "a successful probe closes the breaker")
+	assert.NoError(t, check.check())
+	assert.Equal(t, 1, opened, "onOpen is not called again when a probe reopens the breaker")
+}
+
+func TestCircuitBreakerDisabled(t *testing.T) {
+	b := &circuitBreaker{now: time.Now}
+	for i := 0; i < 10; i++ {
+		b.record(true)
+	}
+	assert.True(t, b.allow())
+	assert.False(t, b.open())
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/klog/v2"
+)
+
+// maxCleanupRetryBackoff caps the delay between the attempts of a failed
+// cleanup.
+const maxCleanupRetryBackoff = time.Hour
+
+// retryCleanups retries the cleanups that failed, every interval until
+// stopCh is closed. cert-manager eventually stops calling CleanUp for a
+// challenge, so records whose cleanup failed during an API outage would
+// otherwise stay in the zone for good. The records of commands like
+// `simulate` that expired are deleted as well. With leader election only
+// the leader retries.
+func (c *sakuraCloudDNSProviderSolver) retryCleanups(interval time.Duration, stopCh <-chan struct{}) {
+	wait.Until(func() {
+		if c.leader != nil && !c.leader.IsLeader() {
+			return
+		}
+		c.retryDueCleanups(time.Now(), interval)
+	}, interval, stopCh)
+}
+
+// retryDueCleanups retries the cleanups that are due at now. The delay
+// after a failed attempt starts at interval and doubles with every attempt,
+// up to maxCleanupRetryBackoff, so that a record that cannot be deleted,
+// e.g. with a revoked API key, does not cost an API call every interval.
+// After --cleanup-max-attempts attempts the cleanup is given up.
+func (c *sakuraCloudDNSProviderSolver) retryDueCleanups(now time.Time, interval time.Duration) {
+	entries, err := c.registry.list()
+	if err != nil {
+		sampledLog.Errorf("listing registry entries: %v", err)
+		return
+	}
+	// unavailable are the zones found unavailable this round; the
+	// cleanups of their other records cannot succeed either.
+	unavailable := map[int64]bool{}
+	for _, e := range entries {
+		expired := e.expired(now)
+		if !e.CleanupPending && !expired || unavailable[e.ZoneID] {
+			continue
+		}
+		if e.CleanupPending && !c.cleanupRetryDue(e, now, interval) {
+			continue
+		}
+		if err := c.cleanUp(context.Background(), e.challengeRequest(), e.KeyDigest); err != nil {
+			var zoneErr *zoneUnavailableError
+			unavailable[e.ZoneID] = errors.As(err, &zoneErr)
+			if max := c.opts.CleanupMaxAttempts; max > 0 && e.CleanupAttempts+1 >= max {
+				klog.Errorf("giving up the cleanup of %s in zone %d after %d failed attempts, delete the record by hand: %v", e.ResolvedFQDN, e.ZoneID, e.CleanupAttempts+1, err)
+				continue
+			}
+			sampledLog.Warningf("retrying cleanup of %s in zone %d failed: %v", e.ResolvedFQDN, e.ZoneID, err)
+			continue
+		}
+		if expired && !e.CleanupPending {
+			klog.Infof("cleaned up %s in zone %d left behind by the %s command", e.ResolvedFQDN, e.ZoneID, e.Tool)
+			continue
+		}
+		klog.Infof("cleaned up %s in zone %d after %d failed attempts", e.ResolvedFQDN, e.ZoneID, e.CleanupAttempts)
+	}
+}
+
+// cleanupRetryDue reports whether the failed cleanup of e is to be retried
+// at now.
+func (c *sakuraCloudDNSProviderSolver) cleanupRetryDue(e *registryEntry, now time.Time, interval time.Duration) bool {
+	if max := c.opts.CleanupMaxAttempts; max > 0 && e.CleanupAttempts >= max {
+		return false
+	}
+	if e.LastCleanupAttemptAt == nil || e.CleanupAttempts <= 1 {
+		return true
+	}
+	backoff := maxCleanupRetryBackoff
+	if shift := e.CleanupAttempts - 2; shift < 16 {
+		backoff = min(interval<<shift, maxCleanupRetryBackoff)
+	}
+	return !now.Before(e.LastCleanupAttemptAt.Add(backoff))
+}
+
+// cleanUpOnShutdown is called once the webhook has stopped serving, with
+// --cleanup-on-shutdown. It deletes the records of failed cleanups and of
+// challenges presented more than maxAge ago, so a decommissioned cluster
+// leaves no challenge records behind. Records of challenges still in flight
+// are kept for the replica taking over. With --leader-elect it deletes
+// nothing: the lease is released by now and the next leader retries the
+// failed cleanups.
+func (c *sakuraCloudDNSProviderSolver) cleanUpOnShutdown(maxAge time.Duration) {
+	if c.registry == nil {
+		return
+	}
+	if c.leader != nil {
+		klog.Infof("--cleanup-on-shutdown has no effect with --leader-elect, leaving the registry to the next leader")
+		return
+	}
+	entries, err := c.registry.list()
+	if err != nil {
+		klog.Errorf("listing registry entries on shutdown: %v", err)
+		return
+	}
+	now := time.Now()
+	for _, e := range entries {
+		if !e.abandoned(now, maxAge) {
+			continue
+		}
+		if err := c.cleanUp(context.Background(), e.challengeRequest(), e.KeyDigest); err != nil {
+			klog.Warningf("cleaning up %s in zone %d on shutdown failed: %v", e.ResolvedFQDN, e.ZoneID, err)
+			continue
+		}
+		klog.Infof("cleaned up %s in zone %d on shutdown", e.ResolvedFQDN, e.ZoneID)
+	}
+}
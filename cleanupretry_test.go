@@ -0,0 +1,114 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	sctesting "github.com/cert-manager/webhook-example/pkg/testing"
+	"github.com/sacloud/iaas-api-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+// newRetrySolver returns a solver with a registry holding the failed
+// cleanup of harnessChallenge(0), after one failed attempt.
+func newRetrySolver(t *testing.T, zones *sctesting.ZoneClient) *sakuraCloudDNSProviderSolver {
+	c := newHarness(t, zones)
+	c.registry = &ownershipRegistry{store: &memoryStore{}}
+	require.NoError(t, c.Present(harnessChallenge(0)))
+	zones.Conflict(1, 1)
+	require.Error(t, c.CleanUp(harnessChallenge(0)))
+	return c
+}
+
+// cleanupAttempts returns the failed attempts of the only registry entry,
+// or -1 once it is removed.
+func cleanupAttempts(t *testing.T, c *sakuraCloudDNSProviderSolver) int {
+	entries, err := c.registry.list()
+	require.NoError(t, err)
+	if len(entries) == 0 {
+		return -1
+	}
+	require.Len(t, entries, 1)
+	assert.True(t, entries[0].CleanupPending)
+	return entries[0].CleanupAttempts
+}
+
+func TestRetryCleanupsBackoff(t *testing.T) {
+	zones := sctesting.NewZoneClient(&iaas.DNS{ID: 1, Name: "example.com"})
+	c := newRetrySolver(t, zones)
+	require.Equal(t, 1, cleanupAttempts(t, c))
+	start := time.Now()
+
+	// The first retry is due right away and fails again.
+	zones.Conflict(1, 2)
+	c.retryDueCleanups(start, time.Minute)
+	require.Equal(t, 2, cleanupAttempts(t, c))
+
+	// The next one waits an interval after the last attempt, the one after
+	// two. The attempts are recorded at the time of the test, around start.
+	updates := zones.Updates(1)
+	c.retryDueCleanups(start.Add(30*time.Second), time.Minute)
+	assert.Equal(t, updates, zones.Updates(1), "not due yet")
+	c.retryDueCleanups(start.Add(time.Minute+time.Second), time.Minute)
+	require.Equal(t, 3, cleanupAttempts(t, c))
+
+	updates = zones.Updates(1)
+	c.retryDueCleanups(start.Add(time.Minute+30*time.Second), time.Minute)
+	assert.Equal(t, updates, zones.Updates(1), "not due yet")
+	c.retryDueCleanups(start.Add(2*time.Minute+time.Second), time.Minute)
+	assert.Equal(t, -1, cleanupAttempts(t, c), "cleaned up")
+	assert.Empty(t, zones.Zone(1).Records)
+}
+
+func TestRetryCleanupsGivesUp(t *testing.T) {
+	zones := sctesting.NewZoneClient(&iaas.DNS{ID: 1, Name: "example.com"})
+	c := newRetrySolver(t, zones)
+	c.opts.CleanupMaxAttempts = 2
+
+	zones.Conflict(1, 1)
+	c.retryDueCleanups(time.Now(), time.Minute)
+	require.Equal(t, 2, cleanupAttempts(t, c))
+
+	// The cleanup is given up: the record stays in the zone and in the
+	// registry, for --cleanup-on-shutdown.
+	updates := zones.Updates(1)
+	c.retryDueCleanups(time.Now().Add(24*time.Hour), time.Minute)
+	assert.Equal(t, updates, zones.Updates(1))
+	assert.Equal(t, 2, cleanupAttempts(t, c))
+	assert.Len(t, zones.Zone(1).Records, 1)
+
+	c.opts.CleanupMaxAttempts = 0
+	c.retryDueCleanups(time.Now().Add(24*time.Hour), time.Minute)
+	assert.Equal(t, -1, cleanupAttempts(t, c), "retried without a cap")
+	assert.Empty(t, zones.Zone(1).Records)
+}
+
+func TestCleanUpOnShutdown(t *testing.T) {
+	zones := sctesting.NewZoneClient(&iaas.DNS{ID: 1, Name: "example.com"})
+	c := newRetrySolver(t, zones)
+	require.NoError(t, c.Present(harnessChallenge(1)))
+	require.Len(t, zones.Zone(1).Records, 2)
+
+	// With leader election the next leader retries the failed cleanup.
+	le, _, err := newLeaderElector(fake.NewSimpleClientset(), "webhook-0", "cert-manager", "webhook")
+	require.NoError(t, err)
+	c.leader = le
+	c.cleanUpOnShutdown(time.Hour)
+	assert.Len(t, zones.Zone(1).Records, 2)
+
+	// The record of the challenge in flight is kept.
+	c.leader = nil
+	c.cleanUpOnShutdown(time.Hour)
+	require.Len(t, zones.Zone(1).Records, 1)
+	assert.Equal(t, "_acme-challenge.host1", zones.Zone(1).Records[0].Name)
+	entries, err := c.registry.list()
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	assert.Equal(t, "_acme-challenge.host1.example.com.", entries[0].ResolvedFQDN)
+
+	// Unless it is older than maxAge.
+	c.cleanUpOnShutdown(0)
+	assert.Empty(t, zones.Zone(1).Records)
+}
@@ -0,0 +1,343 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/cert-manager/cert-manager/pkg/acme/webhook/apis/acme/v1alpha1"
+	cmmeta "github.com/cert-manager/cert-manager/pkg/apis/meta/v1"
+	"github.com/sacloud/iaas-api-go"
+	extapi "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/yaml"
+)
+
+// sakuraCloudDNSProviderConfig is a structure that is used to decode into when
+// solving a DNS01 challenge.
+// This information is provided by cert-manager, and may be a reference to
+// additional configuration that's needed to solve the challenge for this
+// particular certificate or issuer.
+// This typically includes references to Secret resources containing DNS
+// provider credentials, in cases where a 'multi-tenant' DNS solver is being
+// created.
+// If you do *not* require per-issuer or per-certificate configuration to be
+// provided to your webhook, you can skip decoding altogether in favour of
+// using CLI flags or similar to provide configuration.
+// You should not include sensitive information here. If credentials need to
+// be used by your provider here, you should reference a Kubernetes Secret
+// resource and fetch these credentials using a Kubernetes clientset.
+type sakuraCloudDNSProviderConfig struct {
+	// Change the two fields below according to the format of the configuration
+	// to be decoded.
+	// These fields will be set by users in the
+	// `issuer.spec.acme.dns01.providers.webhook.config` field.
+
+	//Email           string `json:"email"`
+	//APIKeySecretRef v1alpha1.SecretKeySelector `json:"apiKeySecretRef"`
+	ZoneID               int64                    `json:"zoneID"`
+	AccessTokenRef       cmmeta.SecretKeySelector `json:"accessTokenRef"`
+	AccessTokenSecretRef cmmeta.SecretKeySelector `json:"accessTokenSecretRef"`
+
+	// ZoneName optionally states the name of the zone selected by ZoneID.
+	// Challenges fail instead of writing to the zone if they disagree.
+	ZoneName string `json:"zoneName,omitempty"`
+
+	// SecondaryAccessTokenRef and SecondaryAccessTokenSecretRef optionally
+	// reference a second API key. It is tried when the primary key is
+	// rejected by the API, so keys can be rotated without failed challenges.
+	SecondaryAccessTokenRef       *cmmeta.SecretKeySelector `json:"secondaryAccessTokenRef,omitempty"`
+	SecondaryAccessTokenSecretRef *cmmeta.SecretKeySelector `json:"secondaryAccessTokenSecretRef,omitempty"`
+
+	// MirrorZone optionally selects a second zone, e.g. a copy of the zone in
+	// another Sakura Cloud account while DNS is being migrated there.
+	// Challenge records are written to and deleted from both zones.
+	MirrorZone *mirrorZoneConfig `json:"mirrorZone,omitempty"`
+
+	// TTL of the challenge record. Defaults to --default-ttl.
+	TTL *int `json:"ttl,omitempty"`
+
+	// PropagationCheckTimeout overrides --propagation-check-timeout for the
+	// Issuer.
+	PropagationCheckTimeout *metav1.Duration `json:"propagationCheckTimeout,omitempty"`
+
+	// PropagationChecker overrides --propagation-checker for the Issuer.
+	PropagationChecker string `json:"propagationChecker,omitempty"`
+
+	// TXTConflictPolicy overrides --txt-conflict-policy for the Issuer.
+	TXTConflictPolicy string `json:"txtConflictPolicy,omitempty"`
+
+	// ConfigRef points at a ConfigMap key holding the whole config, in JSON
+	// or YAML, so that many Issuers can share it. It must be the only field
+	// when set.
+	ConfigRef *configMapKeySelector `json:"configRef,omitempty"`
+}
+
+// mirrorZoneConfig selects the mirror zone of a config. The API keys of the
+// config are used unless it references its own.
+type mirrorZoneConfig struct {
+	ZoneID               int64                     `json:"zoneID"`
+	ZoneName             string                    `json:"zoneName,omitempty"`
+	AccessTokenRef       *cmmeta.SecretKeySelector `json:"accessTokenRef,omitempty"`
+	AccessTokenSecretRef *cmmeta.SecretKeySelector `json:"accessTokenSecretRef,omitempty"`
+}
+
+// configMapKeySelector references a key of a ConfigMap in the namespace of
+// the challenge.
+type configMapKeySelector struct {
+	Name string `json:"name"`
+	Key  string `json:"key"`
+}
+
+// legacyConfigFields maps deprecated or alternative spellings of config fields
+// to their canonical name. Issuers written against older releases or other
+// forks keep working, but a deprecation warning is logged for every use.
+var legacyConfigFields = map[string]string{
+	"zoneId":            "zoneID",
+	"zone_id":           "zoneID",
+	"apiTokenRef":       "accessTokenRef",
+	"apiTokenSecretRef": "accessTokenSecretRef",
+	"apiSecretRef":      "accessTokenSecretRef",
+}
+
+// configShapes are the values of --config-shape.
+var configShapes = []string{"native", "cert-manager"}
+
+// certManagerConfigFields maps the fields of the cert-manager config shape
+// to their canonical name. It follows the conventions of the DNS01
+// providers built into cert-manager, which other Sakura Cloud webhooks
+// copied: a credential is referenced by <credential>SecretRef, and the zone
+// is the hosted zone. apiTokenSecretRef names the token there but the
+// secret in the legacy spellings of the native shape, so the shape is chosen
+// per API group with --config-shape rather than guessed from the fields.
+var certManagerConfigFields = map[string]string{
+	"hostedZoneID":       "zoneID",
+	"hostedZoneName":     "zoneName",
+	"apiTokenSecretRef":  "accessTokenRef",
+	"apiSecretSecretRef": "accessTokenSecretRef",
+}
+
+// convertCertManagerConfig rewrites the fields of the cert-manager config
+// shape in the raw solver config to their canonical name. Unlike the legacy
+// spellings they are not deprecated, but a config setting both a field and
+// its canonical name is rejected.
+func convertCertManagerConfig(raw []byte) ([]byte, error) {
+	fields := map[string]json.RawMessage{}
+	if err := json.Unmarshal(raw, &fields); err != nil {
+		return nil, err
+	}
+	converted := false
+	for name, canonical := range certManagerConfigFields {
+		value, ok := fields[name]
+		if !ok {
+			continue
+		}
+		if _, ok := fields[canonical]; ok {
+			return nil, fmt.Errorf("config fields %q and %q are both set", name, canonical)
+		}
+		delete(fields, name)
+		fields[canonical] = value
+		converted = true
+	}
+	if !converted {
+		return raw, nil
+	}
+	return json.Marshal(fields)
+}
+
+// convertLegacyConfig rewrites deprecated field names in the raw solver config
+// to their canonical spelling. It returns the converted document together
+// with the deprecated fields that were found.
+// When both the canonical and a deprecated spelling are present, the
+// canonical field wins and the deprecated one is dropped.
+func convertLegacyConfig(raw []byte) ([]byte, []deprecation, error) {
+	fields := map[string]json.RawMessage{}
+	if err := json.Unmarshal(raw, &fields); err != nil {
+		return nil, nil, err
+	}
+
+	legacy := make([]string, 0, len(legacyConfigFields))
+	for name := range legacyConfigFields {
+		legacy = append(legacy, name)
+	}
+	sort.Strings(legacy)
+
+	var warnings []deprecation
+	for _, name := range legacy {
+		value, ok := fields[name]
+		if !ok {
+			continue
+		}
+		delete(fields, name)
+
+		canonical := legacyConfigFields[name]
+		if _, ok := fields[canonical]; ok {
+			warnings = append(warnings, deprecation{name, fmt.Sprintf("config field %q is deprecated and ignored because %q is also set", name, canonical)})
+			continue
+		}
+		fields[canonical] = value
+		warnings = append(warnings, deprecation{name, fmt.Sprintf("config field %q is deprecated, use %q instead", name, canonical)})
+	}
+	if len(warnings) == 0 {
+		return raw, nil, nil
+	}
+
+	converted, err := json.Marshal(fields)
+	if err != nil {
+		return nil, nil, err
+	}
+	return converted, warnings, nil
+}
+
+// loadConfig is a small helper function that decodes JSON configuration into
+// the typed config struct. shape is the --config-shape the config is
+// written in.
+func loadConfig(cfgJSON *extapi.JSON, shape string) (sakuraCloudDNSProviderConfig, error) {
+	cfg := sakuraCloudDNSProviderConfig{}
+	// handle the 'base case' where no configuration has been provided
+	if cfgJSON == nil {
+		return cfg, nil
+	}
+	raw := cfgJSON.Raw
+	if shape == "cert-manager" {
+		var err error
+		if raw, err = convertCertManagerConfig(raw); err != nil {
+			return cfg, fmt.Errorf("error decoding solver config: %v", err)
+		}
+	}
+	raw, warnings, err := convertLegacyConfig(raw)
+	if err != nil {
+		return cfg, fmt.Errorf("error decoding solver config: %v", err)
+	}
+	for _, w := range warnings {
+		deprecations.use(w)
+	}
+	if err := json.Unmarshal(raw, &cfg); err != nil {
+		return cfg, fmt.Errorf("error decoding solver config: %v", err)
+	}
+
+	return cfg, nil
+}
+
+// resolveConfig loads the solver config of ch and fills in the defaults
+// given by the flags, see applyDefaults.
+func (c *sakuraCloudDNSProviderSolver) resolveConfig(ctx context.Context, ch *v1alpha1.ChallengeRequest) (sakuraCloudDNSProviderConfig, error) {
+	cfg, err := c.followConfigRef(ctx, ch)
+	if err != nil {
+		return cfg, err
+	}
+	if err := cfg.validate(); err != nil {
+		return cfg, fmt.Errorf("invalid solver config: %w", err)
+	}
+	c.opts.applyDefaults(&cfg)
+	return cfg, nil
+}
+
+// validate rejects configs that leave it unclear which zone or credentials
+// to use, rather than silently preferring one of the settings.
+func (cfg *sakuraCloudDNSProviderConfig) validate() error {
+	if cfg.ZoneID == 0 && cfg.ZoneName != "" {
+		return errors.New("zoneName does not select a zone on its own, zoneID is required")
+	}
+	if (cfg.SecondaryAccessTokenRef == nil) != (cfg.SecondaryAccessTokenSecretRef == nil) {
+		return errors.New("secondaryAccessTokenRef and secondaryAccessTokenSecretRef must be set together")
+	}
+	if m := cfg.MirrorZone; m != nil {
+		if m.ZoneID == 0 {
+			return errors.New("mirrorZone requires zoneID")
+		}
+		if m.ZoneID == cfg.ZoneID {
+			return errors.New("mirrorZone selects the zone of zoneID again")
+		}
+		if (m.AccessTokenRef == nil) != (m.AccessTokenSecretRef == nil) {
+			return errors.New("mirrorZone.accessTokenRef and mirrorZone.accessTokenSecretRef must be set together")
+		}
+	}
+	if cfg.TTL != nil && *cfg.TTL <= 0 {
+		return fmt.Errorf("ttl must be positive, got %d", *cfg.TTL)
+	}
+	if cfg.PropagationChecker != "" && !slices.Contains(propagationCheckers, cfg.PropagationChecker) {
+		return fmt.Errorf("unknown propagationChecker %q, use one of %s", cfg.PropagationChecker, strings.Join(propagationCheckers, ", "))
+	}
+	if cfg.TXTConflictPolicy != "" && !slices.Contains(txtConflictPolicies, cfg.TXTConflictPolicy) {
+		return fmt.Errorf("unknown txtConflictPolicy %q, use one of %s", cfg.TXTConflictPolicy, strings.Join(txtConflictPolicies, ", "))
+	}
+	return nil
+}
+
+// mirror returns the config for the mirror zone, or nil if there is none.
+// Everything but the zone and, if the mirror references its own, the API
+// keys is the same as in cfg.
+func (cfg *sakuraCloudDNSProviderConfig) mirror() *sakuraCloudDNSProviderConfig {
+	m := cfg.MirrorZone
+	if m == nil {
+		return nil
+	}
+	mirror := *cfg
+	mirror.MirrorZone = nil
+	mirror.ZoneID, mirror.ZoneName = m.ZoneID, m.ZoneName
+	if m.AccessTokenRef != nil && m.AccessTokenSecretRef != nil {
+		mirror.AccessTokenRef, mirror.AccessTokenSecretRef = *m.AccessTokenRef, *m.AccessTokenSecretRef
+		mirror.SecondaryAccessTokenRef, mirror.SecondaryAccessTokenSecretRef = nil, nil
+	}
+	return &mirror
+}
+
+// checkZone returns an ambiguousZoneError if zone, read by ZoneID, is not
+// the zone named by ZoneName.
+func (cfg *sakuraCloudDNSProviderConfig) checkZone(zone *iaas.DNS) error {
+	if cfg.ZoneName == "" {
+		return nil
+	}
+	if !strings.EqualFold(strings.TrimSuffix(cfg.ZoneName, "."), strings.TrimSuffix(zone.Name, ".")) {
+		return &ambiguousZoneError{zoneID: cfg.ZoneID, zoneName: cfg.ZoneName, actual: zone.Name}
+	}
+	return nil
+}
+
+// followConfigRef loads the solver config of ch, reading it from the
+// ConfigMap referenced by configRef if the Issuer config is just a
+// reference.
+func (c *sakuraCloudDNSProviderSolver) followConfigRef(ctx context.Context, ch *v1alpha1.ChallengeRequest) (sakuraCloudDNSProviderConfig, error) {
+	cfg, err := loadConfig(ch.Config, c.opts.ConfigShape)
+	if err != nil || cfg.ConfigRef == nil {
+		return cfg, err
+	}
+	ref := cfg.ConfigRef
+
+	fields := map[string]json.RawMessage{}
+	if err := json.Unmarshal(ch.Config.Raw, &fields); err != nil {
+		return cfg, fmt.Errorf("error decoding solver config: %v", err)
+	}
+	if len(fields) > 1 {
+		return cfg, errors.New("configRef must be the only field of the solver config")
+	}
+	if ref.Name == "" || ref.Key == "" {
+		return cfg, errors.New("configRef requires name and key")
+	}
+
+	cm, err := c.client.CoreV1().ConfigMaps(ch.ResourceNamespace).Get(ctx, ref.Name, metav1.GetOptions{})
+	if err != nil {
+		return cfg, fmt.Errorf("reading configRef ConfigMap %s/%s: %w", ch.ResourceNamespace, ref.Name, err)
+	}
+	data, ok := cm.Data[ref.Key]
+	if !ok {
+		return cfg, fmt.Errorf("key %q not found in ConfigMap %s/%s", ref.Key, ch.ResourceNamespace, ref.Name)
+	}
+	raw, err := yaml.YAMLToJSON([]byte(data))
+	if err != nil {
+		return cfg, fmt.Errorf("error decoding solver config from ConfigMap %s/%s: %v", ch.ResourceNamespace, ref.Name, err)
+	}
+	cfg, err = loadConfig(&extapi.JSON{Raw: raw}, c.opts.ConfigShape)
+	if err != nil {
+		return cfg, fmt.Errorf("ConfigMap %s/%s: %w", ch.ResourceNamespace, ref.Name, err)
+	}
+	if cfg.ConfigRef != nil {
+		return cfg, fmt.Errorf("ConfigMap %s/%s: configRef cannot be nested", ch.ResourceNamespace, ref.Name)
+	}
+	return cfg, nil
+}
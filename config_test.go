@@ -0,0 +1,238 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	"github.com/cert-manager/cert-manager/pkg/acme/webhook/apis/acme/v1alpha1"
+	cmmeta "github.com/cert-manager/cert-manager/pkg/apis/meta/v1"
+	sctesting "github.com/cert-manager/webhook-example/pkg/testing"
+	"github.com/sacloud/iaas-api-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	extapi "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestLoadConfigLegacyFields(t *testing.T) {
+	tests := []struct {
+		name       string
+		raw        string
+		wantZoneID int64
+		wantToken  string
+		wantSecret string
+	}{
+		{
+			name:       "canonical",
+			raw:        `{"zoneID": 1, "accessTokenRef": {"name": "a", "key": "token"}, "accessTokenSecretRef": {"name": "a", "key": "secret"}}`,
+			wantZoneID: 1,
+			wantToken:  "token",
+			wantSecret: "secret",
+		},
+		{
+			name:       "legacy spellings",
+			raw:        `{"zone_id": 2, "apiTokenRef": {"name": "a", "key": "token"}, "apiTokenSecretRef": {"name": "a", "key": "secret"}}`,
+			wantZoneID: 2,
+			wantToken:  "token",
+			wantSecret: "secret",
+		},
+		{
+			name:       "canonical wins over legacy",
+			raw:        `{"zoneID": 3, "zoneId": 4, "apiSecretRef": {"name": "a", "key": "old"}, "accessTokenSecretRef": {"name": "a", "key": "new"}}`,
+			wantZoneID: 3,
+			wantSecret: "new",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg, err := loadConfig(&extapi.JSON{Raw: []byte(tt.raw)}, "native")
+			require.NoError(t, err)
+			assert.Equal(t, tt.wantZoneID, cfg.ZoneID)
+			assert.Equal(t, tt.wantToken, cfg.AccessTokenRef.Key)
+			assert.Equal(t, tt.wantSecret, cfg.AccessTokenSecretRef.Key)
+		})
+	}
+}
+
+func TestLoadConfigCertManagerShape(t *testing.T) {
+	raw := []byte(`{"hostedZoneID": 1, "hostedZoneName": "example.com", "apiTokenSecretRef": {"name": "a", "key": "token"}, "apiSecretSecretRef": {"name": "a", "key": "secret"}}`)
+	cfg, err := loadConfig(&extapi.JSON{Raw: raw}, "cert-manager")
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), cfg.ZoneID)
+	assert.Equal(t, "example.com", cfg.ZoneName)
+	assert.Equal(t, "token", cfg.AccessTokenRef.Key)
+	assert.Equal(t, "secret", cfg.AccessTokenSecretRef.Key)
+
+	// In the native shape apiTokenSecretRef is a legacy spelling of
+	// accessTokenSecretRef.
+	cfg, err = loadConfig(&extapi.JSON{Raw: raw}, "native")
+	require.NoError(t, err)
+	assert.Equal(t, int64(0), cfg.ZoneID)
+	assert.Equal(t, "token", cfg.AccessTokenSecretRef.Key)
+
+	// The canonical names still work, but not together with their
+	// cert-manager spelling.
+	cfg, err = loadConfig(&extapi.JSON{Raw: []byte(`{"zoneID": 2, "accessTokenRef": {"name": "a", "key": "token"}}`)}, "cert-manager")
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), cfg.ZoneID)
+	assert.Equal(t, "token", cfg.AccessTokenRef.Key)
+	_, err = loadConfig(&extapi.JSON{Raw: []byte(`{"zoneID": 2, "hostedZoneID": 3}`)}, "cert-manager")
+	assert.ErrorContains(t, err, `"hostedZoneID" and "zoneID" are both set`)
+}
+
+func TestConvertLegacyConfigWarnings(t *testing.T) {
+	raw := []byte(`{"zoneID": 1}`)
+	converted, warnings, err := convertLegacyConfig(raw)
+	require.NoError(t, err)
+	assert.Empty(t, warnings)
+	assert.Equal(t, raw, converted)
+
+	_, warnings, err = convertLegacyConfig([]byte(`{"zoneId": 1, "apiTokenRef": {}}`))
+	require.NoError(t, err)
+	assert.Len(t, warnings, 2)
+}
+
+func TestResolveConfigRef(t *testing.T) {
+	cm := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "team-a", Name: "sakuracloud"},
+		Data: map[string]string{
+			"config.yaml": "zoneID: 5\naccessTokenRef:\n  name: creds\n  key: token\n",
+			"nested":      `{"configRef": {"name": "sakuracloud", "key": "config.yaml"}}`,
+		},
+	}
+	c := &sakuraCloudDNSProviderSolver{client: fake.NewSimpleClientset(cm), opts: newSolverOptions()}
+	challenge := func(raw string) *v1alpha1.ChallengeRequest {
+		return &v1alpha1.ChallengeRequest{ResourceNamespace: "team-a", Config: &extapi.JSON{Raw: []byte(raw)}}
+	}
+
+	cfg, err := c.resolveConfig(context.Background(), challenge(`{"configRef": {"name": "sakuracloud", "key": "config.yaml"}}`))
+	require.NoError(t, err)
+	assert.Equal(t, int64(5), cfg.ZoneID)
+	assert.Equal(t, "creds", cfg.AccessTokenRef.Name)
+
+	cfg, err = c.resolveConfig(context.Background(), challenge(`{"zoneID": 7}`))
+	require.NoError(t, err)
+	assert.Equal(t, int64(7), cfg.ZoneID, "configs without configRef are used as is")
+
+	_, err = c.resolveConfig(context.Background(), challenge(`{"zoneID": 7, "configRef": {"name": "sakuracloud", "key": "config.yaml"}}`))
+	assert.ErrorContains(t, err, "only field")
+
+	_, err = c.resolveConfig(context.Background(), challenge(`{"configRef": {"name": "sakuracloud", "key": "missing"}}`))
+	assert.ErrorContains(t, err, "not found")
+
+	_, err = c.resolveConfig(context.Background(), challenge(`{"configRef": {"name": "sakuracloud", "key": "nested"}}`))
+	assert.ErrorContains(t, err, "nested")
+}
+
+func TestConfigValidate(t *testing.T) {
+	ref := &cmmeta.SecretKeySelector{Key: "token"}
+	ttl := 0
+	tests := []struct {
+		name    string
+		cfg     sakuraCloudDNSProviderConfig
+		wantErr string
+	}{
+		{name: "zoneID only", cfg: sakuraCloudDNSProviderConfig{ZoneID: 1}},
+		{name: "zoneID and zoneName", cfg: sakuraCloudDNSProviderConfig{ZoneID: 1, ZoneName: "example.com"}},
+		{name: "zoneName only", cfg: sakuraCloudDNSProviderConfig{ZoneName: "example.com"}, wantErr: "zoneID is required"},
+		{name: "half a secondary key", cfg: sakuraCloudDNSProviderConfig{ZoneID: 1, SecondaryAccessTokenRef: ref}, wantErr: "set together"},
+		{name: "zero ttl", cfg: sakuraCloudDNSProviderConfig{ZoneID: 1, TTL: &ttl}, wantErr: "ttl"},
+		{name: "mirror zone", cfg: sakuraCloudDNSProviderConfig{ZoneID: 1, MirrorZone: &mirrorZoneConfig{ZoneID: 2}}},
+		{name: "mirror zone without zoneID", cfg: sakuraCloudDNSProviderConfig{ZoneID: 1, MirrorZone: &mirrorZoneConfig{}}, wantErr: "mirrorZone requires zoneID"},
+		{name: "mirror of itself", cfg: sakuraCloudDNSProviderConfig{ZoneID: 1, MirrorZone: &mirrorZoneConfig{ZoneID: 1}}, wantErr: "zone of zoneID again"},
+		{name: "half a mirror key", cfg: sakuraCloudDNSProviderConfig{ZoneID: 1, MirrorZone: &mirrorZoneConfig{ZoneID: 2, AccessTokenRef: ref}}, wantErr: "set together"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.cfg.validate()
+			if tt.wantErr == "" {
+				assert.NoError(t, err)
+			} else {
+				assert.ErrorContains(t, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestConfigMirror(t *testing.T) {
+	primary := cmmeta.SecretKeySelector{LocalObjectReference: cmmeta.LocalObjectReference{Name: "old-account"}, Key: "token"}
+	secondary := &cmmeta.SecretKeySelector{LocalObjectReference: cmmeta.LocalObjectReference{Name: "old-account-next"}, Key: "token"}
+	other := &cmmeta.SecretKeySelector{LocalObjectReference: cmmeta.LocalObjectReference{Name: "new-account"}, Key: "token"}
+	cfg := &sakuraCloudDNSProviderConfig{
+		ZoneID:                        1,
+		ZoneName:                      "example.com",
+		AccessTokenRef:                primary,
+		AccessTokenSecretRef:          primary,
+		SecondaryAccessTokenRef:       secondary,
+		SecondaryAccessTokenSecretRef: secondary,
+	}
+	assert.Nil(t, cfg.mirror())
+
+	cfg.MirrorZone = &mirrorZoneConfig{ZoneID: 2, ZoneName: "example.com"}
+	mirror := cfg.mirror()
+	assert.Equal(t, int64(2), mirror.ZoneID)
+	assert.Nil(t, mirror.MirrorZone)
+	assert.Equal(t, primary, mirror.AccessTokenRef, "the API keys of the config are used by default")
+	assert.Equal(t, secondary, mirror.SecondaryAccessTokenRef)
+
+	cfg.MirrorZone.AccessTokenRef, cfg.MirrorZone.AccessTokenSecretRef = other, other
+	mirror = cfg.mirror()
+	assert.Equal(t, *other, mirror.AccessTokenRef)
+	assert.Nil(t, mirror.SecondaryAccessTokenRef, "the secondary key belongs to the other account")
+	assert.Equal(t, int64(1), cfg.ZoneID, "cfg is left alone")
+}
+
+func TestConfigCheckZone(t *testing.T) {
+	zone := &iaas.DNS{ID: 1, Name: "example.com"}
+
+	assert.NoError(t, (&sakuraCloudDNSProviderConfig{ZoneID: 1}).checkZone(zone))
+	assert.NoError(t, (&sakuraCloudDNSProviderConfig{ZoneID: 1, ZoneName: "Example.com."}).checkZone(zone))
+
+	err := (&sakuraCloudDNSProviderConfig{ZoneID: 1, ZoneName: "example.org"}).checkZone(zone)
+	var ambiguous *ambiguousZoneError
+	if assert.ErrorAs(t, err, &ambiguous) {
+		assert.Contains(t, err.Error(), "ambiguous zone selection")
+	}
+}
+
+func TestMirrorZone(t *testing.T) {
+	zones := sctesting.NewZoneClient(&iaas.DNS{ID: 1, Name: "example.com"}, &iaas.DNS{ID: 2, Name: "example.com"})
+	c := newHarness(t, zones)
+	challenge := func(i int) *v1alpha1.ChallengeRequest {
+		ch := harnessChallenge(i)
+		ch.Config.Raw = []byte(`{"zoneID": 1, "mirrorZone": {"zoneID": 2},
+			"accessTokenRef": {"name": "creds", "key": "accessToken"},
+			"accessTokenSecretRef": {"name": "creds", "key": "accessTokenSecret"}}`)
+		return ch
+	}
+
+	require.NoError(t, c.Present(challenge(0)))
+	assert.Equal(t, zones.Zone(1).Records, zones.Zone(2).Records, "the mirror zone gets the record")
+	require.Len(t, zones.Zone(2).Records, 1)
+	require.NoError(t, c.CleanUp(challenge(0)))
+	assert.Empty(t, zones.Zone(1).Records)
+	assert.Empty(t, zones.Zone(2).Records)
+
+	// A failed write of the mirror zone fails the challenge, keeping the
+	// record of the zone; cert-manager retries.
+	zones.Conflict(2, 1)
+	err := c.Present(challenge(1))
+	assert.ErrorContains(t, err, "presenting _acme-challenge.host1.example.com. in mirror zone 2")
+	assert.ErrorContains(t, err, "423")
+	assert.Len(t, zones.Zone(1).Records, 1)
+	assert.Empty(t, zones.Zone(2).Records)
+	require.NoError(t, c.Present(challenge(1)))
+	assert.Len(t, zones.Zone(1).Records, 1)
+	assert.Equal(t, zones.Zone(1).Records, zones.Zone(2).Records)
+
+	zones.Conflict(2, 1)
+	err = c.CleanUp(challenge(1))
+	assert.ErrorContains(t, err, "cleaning up _acme-challenge.host1.example.com. in mirror zone 2")
+	assert.Empty(t, zones.Zone(1).Records, "the record of the zone is deleted")
+	assert.Len(t, zones.Zone(2).Records, 1)
+	require.NoError(t, c.CleanUp(challenge(1)))
+	assert.Empty(t, zones.Zone(2).Records)
+}
@@ -0,0 +1,236 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// apiKey is an access token and its secret, as found by a
+// credentialProvider.
+type apiKey struct {
+	token, secret string
+	// source and location tell where the key was found, for
+	// /debug/credentials.
+	source, location string
+}
+
+// errNoAPIKey is returned by a credentialProvider that has no key for a
+// credential, so that the next provider is asked.
+var errNoAPIKey = errors.New("no API key")
+
+// credentialProvider is a source of API keys. New sources only have to
+// implement it and be added to credentialProviders.
+type credentialProvider interface {
+	// apiKey returns the key of cred for a challenge in namespace ns, or
+	// errNoAPIKey.
+	apiKey(ctx context.Context, cred credential, ns string) (apiKey, error)
+	// installationKey reports whether the provider serves the same key of
+	// the installation to every namespace, so that it is only asked for
+	// the namespaces of --installation-key-namespaces.
+	installationKey() bool
+}
+
+// credentialProviders are the values of --credential-providers.
+var credentialProviders = []string{"secret", "env", "file", "vault"}
+
+// credentialProvider returns the provider called name, as configured by the
+// flags.
+func (c *sakuraCloudDNSProviderSolver) credentialProvider(name string) (credentialProvider, error) {
+	switch name {
+	case "secret":
+		return secretRefProvider{c}, nil
+	case "env":
+		return envProvider{}, nil
+	case "file":
+		return fileProvider{dir: c.opts.CredentialsDir}, nil
+	case "vault":
+		return &vaultProvider{
+			addr:      strings.TrimSuffix(c.opts.VaultAddr, "/"),
+			path:      c.opts.VaultPath,
+			tokenFile: c.opts.VaultTokenFile,
+			client:    &http.Client{Timeout: 10 * time.Second},
+		}, nil
+	}
+	return nil, fmt.Errorf("unknown credential provider %q, use one of %s", name, strings.Join(credentialProviders, ", "))
+}
+
+// resolveAPIKey returns the key of cred for a challenge in namespace ns.
+// The namespace policy is checked before any provider is asked: ns must be
+// in --allowed-secret-namespaces, and the providers of the key of the
+// installation are only asked for the namespaces of
+// --installation-key-namespaces.
+func (c *sakuraCloudDNSProviderSolver) resolveAPIKey(ctx context.Context, cred credential, ns string) (apiKey, error) {
+	if !c.opts.secretNamespaceAllowed(ns) {
+		return apiKey{}, fmt.Errorf("resolving the API key is denied: namespace %s is not in --allowed-secret-namespaces", ns)
+	}
+	return c.findAPIKey(ctx, cred, ns, c.opts.installationKeyAllowed(ns))
+}
+
+// findAPIKey asks the providers of --credential-providers in order for the
+// key of cred, and returns the first one found. The providers of the key of
+// the installation are skipped unless installationKey is set. Errors other
+// than errNoAPIKey end the search, so that a Secret that cannot be read does
+// not silently fall back to the keys of the installation.
+func (c *sakuraCloudDNSProviderSolver) findAPIKey(ctx context.Context, cred credential, ns string, installationKey bool) (apiKey, error) {
+	names := c.opts.CredentialProviders
+	if len(names) == 0 {
+		names = []string{"secret"}
+	}
+	var skipped []string
+	for _, name := range names {
+		p, err := c.credentialProvider(name)
+		if err != nil {
+			return apiKey{}, err
+		}
+		if p.installationKey() && !installationKey {
+			skipped = append(skipped, name)
+			continue
+		}
+		key, err := p.apiKey(ctx, cred, ns)
+		if errors.Is(err, errNoAPIKey) {
+			continue
+		}
+		return key, err
+	}
+	if len(skipped) > 0 {
+		return apiKey{}, fmt.Errorf("none of the credential providers %s has an API key for the %s credential (the key of the installation from %s is not served to namespace %s, see --installation-key-namespaces)",
+			strings.Join(names, ", "), cred.name, strings.Join(skipped, ", "), ns)
+	}
+	return apiKey{}, fmt.Errorf("none of the credential providers %s has an API key for the %s credential", strings.Join(names, ", "), cred.name)
+}
+
+// secretRefProvider reads the Secrets referenced by the Issuer config. It
+// has no key for configs that reference none.
+type secretRefProvider struct {
+	c *sakuraCloudDNSProviderSolver
+}
+
+func (secretRefProvider) installationKey() bool { return false }
+
+func (p secretRefProvider) apiKey(ctx context.Context, cred credential, ns string) (apiKey, error) {
+	if cred.accessTokenRef.Name == "" && cred.accessTokenSecretRef.Name == "" {
+		return apiKey{}, errNoAPIKey
+	}
+	token, err := p.c.getSecretString(ctx, cred.accessTokenRef, ns)
+	if err != nil {
+		return apiKey{}, err
+	}
+	secret, err := p.c.getSecretString(ctx, cred.accessTokenSecretRef, ns)
+	if err != nil {
+		return apiKey{}, err
+	}
+	source := "secret"
+	if p.c.opts.SecretsKubeconfig != "" {
+		source = "secret of " + p.c.opts.SecretsKubeconfig
+	}
+	return apiKey{
+		token:  token,
+		secret: secret,
+		source: source,
+		location: fmt.Sprintf("%s/%s[%s,%s]", p.c.secretNamespace(ns), cred.accessTokenRef.Name,
+			cred.accessTokenRef.Key, cred.accessTokenSecretRef.Key),
+	}, nil
+}
+
+// envProvider reads the key of the installation from the environment
+// variables of the Sakura Cloud CLI and SDKs.
+type envProvider struct{}
+
+func (envProvider) installationKey() bool { return true }
+
+func (envProvider) apiKey(context.Context, credential, string) (apiKey, error) {
+	token, secret := os.Getenv("SAKURACLOUD_ACCESS_TOKEN"), os.Getenv("SAKURACLOUD_ACCESS_TOKEN_SECRET")
+	if token == "" || secret == "" {
+		return apiKey{}, errNoAPIKey
+	}
+	return apiKey{token: token, secret: secret, source: "env", location: "SAKURACLOUD_ACCESS_TOKEN,SAKURACLOUD_ACCESS_TOKEN_SECRET"}, nil
+}
+
+// fileProvider reads the key of the installation from the files accessToken
+// and accessTokenSecret in dir, e.g. a mounted Secret. They are read for
+// every challenge, so a rotated key is used as soon as it is mounted.
+type fileProvider struct {
+	dir string
+}
+
+func (fileProvider) installationKey() bool { return true }
+
+func (p fileProvider) apiKey(context.Context, credential, string) (apiKey, error) {
+	var values []string
+	for _, name := range []string{"accessToken", "accessTokenSecret"} {
+		b, err := os.ReadFile(filepath.Join(p.dir, name))
+		if errors.Is(err, os.ErrNotExist) {
+			return apiKey{}, errNoAPIKey
+		} else if err != nil {
+			return apiKey{}, fmt.Errorf("reading the API key from --credentials-dir: %w", err)
+		}
+		values = append(values, strings.TrimSpace(string(b)))
+	}
+	return apiKey{token: values[0], secret: values[1], source: "file", location: p.dir}, nil
+}
+
+// vaultProvider reads the key from the fields accessToken and
+// accessTokenSecret of a secret of the KV version 2 secrets engine of
+// HashiCorp Vault, with the token in tokenFile, e.g. written by the Vault
+// Agent. The path may contain {namespace}, replaced by the namespace of the
+// challenge, to keep a key per tenant.
+type vaultProvider struct {
+	addr, path, tokenFile string
+	client                *http.Client
+}
+
+// installationKey reports whether the path has no {namespace}, so that
+// every namespace reads the same secret.
+func (p *vaultProvider) installationKey() bool {
+	return !strings.Contains(p.path, "{namespace}")
+}
+
+func (p *vaultProvider) apiKey(ctx context.Context, _ credential, ns string) (apiKey, error) {
+	token, err := os.ReadFile(p.tokenFile)
+	if err != nil {
+		return apiKey{}, fmt.Errorf("reading --vault-token-file: %w", err)
+	}
+	path := strings.ReplaceAll(p.path, "{namespace}", ns)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, p.addr+"/v1/"+strings.TrimPrefix(path, "/"), nil)
+	if err != nil {
+		return apiKey{}, err
+	}
+	req.Header.Set("X-Vault-Token", strings.TrimSpace(string(token)))
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return apiKey{}, fmt.Errorf("reading %s from Vault: %w", path, err)
+	}
+	defer resp.Body.Close()
+	switch {
+	case resp.StatusCode == http.StatusNotFound:
+		return apiKey{}, errNoAPIKey
+	case resp.StatusCode != http.StatusOK:
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return apiKey{}, fmt.Errorf("reading %s from Vault: %s: %s", path, resp.Status, strings.TrimSpace(string(body)))
+	}
+
+	var secret struct {
+		Data struct {
+			Data struct {
+				AccessToken       string `json:"accessToken"`
+				AccessTokenSecret string `json:"accessTokenSecret"`
+			} `json:"data"`
+		} `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&secret); err != nil {
+		return apiKey{}, fmt.Errorf("decoding %s from Vault: %w", path, err)
+	}
+	data := secret.Data.Data
+	if data.AccessToken == "" || data.AccessTokenSecret == "" {
+		return apiKey{}, fmt.Errorf("%s in Vault has no accessToken and accessTokenSecret", path)
+	}
+	return apiKey{token: data.AccessToken, secret: data.AccessTokenSecret, source: "vault", location: path}, nil
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	cmmeta "github.com/cert-manager/cert-manager/pkg/apis/meta/v1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestResolveAPIKey(t *testing.T) {
+	client := fake.NewSimpleClientset(&corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "acme", Name: "creds"},
+		Data:       map[string][]byte{"accessToken": []byte("secret-token"), "accessTokenSecret": []byte("secret-secret")},
+	})
+	c := &sakuraCloudDNSProviderSolver{secretsClient: client, opts: newSolverOptions()}
+	c.opts.AllowedSecretNamespaces = []string{"*"}
+	referenced := credential{
+		name:                 "primary",
+		accessTokenRef:       &cmmeta.SecretKeySelector{LocalObjectReference: cmmeta.LocalObjectReference{Name: "creds"}, Key: "accessToken"},
+		accessTokenSecretRef: &cmmeta.SecretKeySelector{LocalObjectReference: cmmeta.LocalObjectReference{Name: "creds"}, Key: "accessTokenSecret"},
+	}
+	unreferenced := credential{name: "primary", accessTokenRef: &cmmeta.SecretKeySelector{}, accessTokenSecretRef: &cmmeta.SecretKeySelector{}}
+
+	key, err := c.resolveAPIKey(context.Background(), referenced, "acme")
+	require.NoError(t, err)
+	assert.Equal(t, apiKey{token: "secret-token", secret: "secret-secret", source: "secret", location: "acme/creds[accessToken,accessTokenSecret]"}, key)
+
+	_, err = c.resolveAPIKey(context.Background(), unreferenced, "acme")
+	assert.EqualError(t, err, "none of the credential providers secret has an API key for the primary credential")
+
+	t.Setenv("SAKURACLOUD_ACCESS_TOKEN", "env-token")
+	t.Setenv("SAKURACLOUD_ACCESS_TOKEN_SECRET", "env-secret")
+	c.opts.CredentialProviders = []string{"secret", "env"}
+	_, err = c.resolveAPIKey(context.Background(), unreferenced, "acme")
+	assert.EqualError(t, err, "none of the credential providers secret, env has an API key for the primary credential (the key of the installation from env is not served to namespace acme, see --installation-key-namespaces)")
+	c.opts.InstallationKeyNamespaces = []string{"ac*"}
+	key, err = c.resolveAPIKey(context.Background(), unreferenced, "acme")
+	require.NoError(t, err)
+	assert.Equal(t, "env-token", key.token, "configs without Secrets fall back to the environment")
+	key, err = c.resolveAPIKey(context.Background(), referenced, "acme")
+	require.NoError(t, err)
+	assert.Equal(t, "secret-token", key.token, "the Secrets of the config come first")
+
+	missing := referenced
+	missing.accessTokenRef = &cmmeta.SecretKeySelector{LocalObjectReference: cmmeta.LocalObjectReference{Name: "missing"}, Key: "accessToken"}
+	_, err = c.resolveAPIKey(context.Background(), missing, "acme")
+	assert.ErrorContains(t, err, `"missing" not found`, "a Secret that cannot be read does not fall back to the environment")
+
+	c.opts.AllowedSecretNamespaces = []string{"tenant"}
+	_, err = c.resolveAPIKey(context.Background(), unreferenced, "acme")
+	assert.EqualError(t, err, "resolving the API key is denied: namespace acme is not in --allowed-secret-namespaces", "the policy is checked before the environment is asked")
+
+	c.opts.SecretsNamespace = "acme"
+	c.opts.SecretsKubeconfig = "/etc/management/kubeconfig"
+	key, err = c.resolveAPIKey(context.Background(), referenced, "tenant")
+	require.NoError(t, err)
+	assert.Equal(t, "secret of /etc/management/kubeconfig", key.source)
+	assert.Equal(t, "acme/creds[accessToken,accessTokenSecret]", key.location)
+}
+
+func TestFileProvider(t *testing.T) {
+	dir := t.TempDir()
+	p := fileProvider{dir: dir}
+	_, err := p.apiKey(context.Background(), credential{}, "acme")
+	assert.ErrorIs(t, err, errNoAPIKey)
+
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "accessToken"), []byte("file-token\n"), 0o600))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "accessTokenSecret"), []byte("file-secret\n"), 0o600))
+	key, err := p.apiKey(context.Background(), credential{}, "acme")
+	require.NoError(t, err)
+	assert.Equal(t, apiKey{token: "file-token", secret: "file-secret", source: "file", location: dir}, key)
+}
+
+func TestVaultProvider(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Vault-Token") != "vault-token" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		switch r.URL.Path {
+		case "/v1/secret/data/sakuracloud/acme":
+			_, _ = w.Write([]byte(`{"data": {"data": {"accessToken": "vault-token-acme", "accessTokenSecret": "vault-secret-acme"}}}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer srv.Close()
+	tokenFile := filepath.Join(t.TempDir(), "token")
+	require.NoError(t, os.WriteFile(tokenFile, []byte("vault-token\n"), 0o600))
+
+	p := &vaultProvider{addr: srv.URL, path: "secret/data/sakuracloud/{namespace}", tokenFile: tokenFile, client: srv.Client()}
+	key, err := p.apiKey(context.Background(), credential{}, "acme")
+	require.NoError(t, err)
+	assert.Equal(t, apiKey{token: "vault-token-acme", secret: "vault-secret-acme", source: "vault", location: "secret/data/sakuracloud/acme"}, key)
+	assert.False(t, p.installationKey(), "a path with {namespace} keeps a key per namespace")
+	assert.True(t, (&vaultProvider{path: "secret/data/sakuracloud"}).installationKey())
+
+	_, err = p.apiKey(context.Background(), credential{}, "other")
+	assert.ErrorIs(t, err, errNoAPIKey, "namespaces without a secret are passed on")
+
+	require.NoError(t, os.WriteFile(tokenFile, []byte("revoked"), 0o600))
+	_, err = p.apiKey(context.Background(), credential{}, "acme")
+	assert.ErrorContains(t, err, "403 Forbidden")
+}
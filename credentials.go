@@ -0,0 +1,204 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/cert-manager/cert-manager/pkg/acme/webhook/apis/acme/v1alpha1"
+	cmmeta "github.com/cert-manager/cert-manager/pkg/apis/meta/v1"
+	"github.com/sacloud/iaas-api-go"
+	"github.com/sacloud/iaas-api-go/types"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	corev1 "k8s.io/api/core/v1"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/clientcmd"
+	"k8s.io/klog/v2"
+)
+
+// credential is one API key pair referenced by the solver config.
+type credential struct {
+	// name identifies the credential slot in logs and metrics.
+	name                 string
+	accessTokenRef       *cmmeta.SecretKeySelector
+	accessTokenSecretRef *cmmeta.SecretKeySelector
+}
+
+// credentials returns the configured API keys in the order they should be
+// tried.
+func (cfg *sakuraCloudDNSProviderConfig) credentials() []credential {
+	creds := []credential{{
+		name:                 "primary",
+		accessTokenRef:       &cfg.AccessTokenRef,
+		accessTokenSecretRef: &cfg.AccessTokenSecretRef,
+	}}
+	if cfg.SecondaryAccessTokenRef != nil && cfg.SecondaryAccessTokenSecretRef != nil {
+		creds = append(creds, credential{
+			name:                 "secondary",
+			accessTokenRef:       cfg.SecondaryAccessTokenRef,
+			accessTokenSecretRef: cfg.SecondaryAccessTokenSecretRef,
+		})
+	}
+	return creds
+}
+
+// zoneAPI is the subset of the DNS API the solver uses. The API has no
+// record-level operations, so a challenge still reads the whole zone and
+// writes back its record set; UpdateSettings at least leaves the zone's
+// description, tags and icon alone and skips the extra read a full Update
+// does. Should record-level endpoints appear, they belong here.
+type zoneAPI interface {
+	Read(ctx context.Context, id types.ID) (*iaas.DNS, error)
+	UpdateSettings(ctx context.Context, id types.ID, param *iaas.DNSUpdateSettingsRequest) (*iaas.DNS, error)
+}
+
+// newClient returns a client with the API key of cred, found by the
+// providers of --credential-providers, and the credentialStatus of the key.
+func (c *sakuraCloudDNSProviderSolver) newClient(ctx context.Context, cred credential, ch *v1alpha1.ChallengeRequest) (zoneAPI, credentialStatus, error) {
+	key, err := c.resolveAPIKey(ctx, cred, ch.ResourceNamespace)
+	if err != nil {
+		return nil, credentialStatus{}, err
+	}
+	client, status := c.clientForKey(key, cred)
+	return client, status, nil
+}
+
+// clientForKey returns a client with key, the API key of cred, and the
+// credentialStatus of the key.
+func (c *sakuraCloudDNSProviderSolver) clientForKey(key apiKey, cred credential) (zoneAPI, credentialStatus) {
+	status := credentialStatus{
+		Credential: credentialHash(key.token),
+		Slot:       cred.name,
+		Source:     key.source,
+		Secret:     key.location,
+	}
+	var client zoneAPI
+	if c.newZoneAPI != nil {
+		client = c.newZoneAPI(key.token, key.secret)
+	} else {
+		client = iaas.NewDNSOp(newAPICaller(key.token, key.secret))
+	}
+	if c.budget == nil {
+		return client, status
+	}
+	return &budgetedZoneAPI{zoneAPI: client, credential: status.Credential, budget: c.budget}, status
+}
+
+// readZone reads the configured zone with the first credential that the API
+// accepts and returns a client bound to that credential. Only authentication
+// failures fall through to the next credential; any other error is returned
+// as is. A zoneUnavailableError is returned for zones that cannot be
+// changed.
+func (c *sakuraCloudDNSProviderSolver) readZone(ctx context.Context, cfg *sakuraCloudDNSProviderConfig, ch *v1alpha1.ChallengeRequest) (zoneAPI, *iaas.DNS, error) {
+	var errs []error
+	for _, cred := range cfg.credentials() {
+		start := time.Now()
+		client, status, err := c.newClient(ctx, cred, ch)
+		observePhase("secret_fetch", start)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s credential: %w", cred.name, err))
+			continue
+		}
+		start = time.Now()
+		zone, err := client.Read(ctx, types.Int64ID(cfg.ZoneID))
+		observePhase("zone_read", start)
+		credentialStatuses.record(status, cfg.ZoneID, err)
+		if err != nil {
+			if isAuthError(err) {
+				sampledLog.Warningf("%s credential was rejected for zone %d: %v", cred.name, cfg.ZoneID, err)
+				errs = append(errs, fmt.Errorf("%s credential: %w", cred.name, err))
+				continue
+			}
+			return nil, nil, err
+		}
+		c.metadata.put(status.Credential, zone)
+		if err := cfg.checkZone(zone); err != nil {
+			return nil, nil, err
+		}
+		if err := checkZoneAvailable(zone); err != nil {
+			zoneUnavailable.WithLabelValues(zoneLabels.nameLabel(zone)).Set(1)
+			return nil, nil, err
+		}
+		zoneUnavailable.WithLabelValues(zoneLabels.nameLabel(zone)).Set(0)
+		credentialUsedTotal.WithLabelValues(cred.name).Inc()
+		zoneRecords.WithLabelValues(zoneLabels.nameLabel(zone)).Set(float64(len(zone.Records)))
+		c.checkDelegation(zone)
+		return client, zone, nil
+	}
+	return nil, nil, errors.Join(errs...)
+}
+
+// isAuthError reports whether err is an API response rejecting the API key.
+func isAuthError(err error) bool {
+	var apiErr iaas.APIError
+	if !errors.As(err, &apiErr) {
+		return false
+	}
+	code := apiErr.ResponseCode()
+	return code == http.StatusUnauthorized || code == http.StatusForbidden
+}
+
+// secretNamespace returns the namespace the credential Secrets of Issuers
+// in ns are read from.
+func (c *sakuraCloudDNSProviderSolver) secretNamespace(ns string) string {
+	if c.opts.SecretsNamespace != "" {
+		return c.opts.SecretsNamespace
+	}
+	return ns
+}
+
+// getSecretString reads the key of ref from the credential Secrets of
+// Issuers in ns, which are in --secrets-namespace when it is set. The
+// namespace policy has been checked on ns by resolveAPIKey.
+func (c *sakuraCloudDNSProviderSolver) getSecretString(ctx context.Context, ref *cmmeta.SecretKeySelector, ns string) (string, error) {
+	secret, err := c.getSecret(ctx, c.secretNamespace(ns), ref.Name)
+	if err != nil {
+		return "", err
+	}
+
+	if accessToken, ok := secret.Data[ref.Key]; ok {
+		return string(accessToken), nil
+	}
+	return "", errors.New("accessToken not found")
+}
+
+// getSecret reads a credential Secret, timing the request so that a slow
+// Kubernetes API server can be told apart from a slow Sakura Cloud API. With
+// --watch-namespaces it is read from the cache of the informers instead.
+func (c *sakuraCloudDNSProviderSolver) getSecret(ctx context.Context, ns, name string) (secret *corev1.Secret, err error) {
+	ctx, span := tracer.Start(ctx, "kubernetes.GetSecret", trace.WithAttributes(
+		attribute.String("namespace", ns),
+		attribute.String("name", name),
+	))
+	start := time.Now()
+	defer func() {
+		result := "success"
+		if err != nil {
+			result = "error"
+		}
+		secretFetchDuration.WithLabelValues(result).Observe(time.Since(start).Seconds())
+		endSpan(span, err)
+	}()
+	if c.secrets != nil {
+		return c.secrets.get(ns, name)
+	}
+	return c.secretsClient.CoreV1().Secrets(ns).Get(ctx, name, v1.GetOptions{})
+}
+
+// newSecretsClient returns the clientset credential Secrets are read with:
+// the local cluster, or the cluster referenced by --secrets-kubeconfig.
+func newSecretsClient(local kubernetes.Interface, kubeconfig string) (kubernetes.Interface, error) {
+	if kubeconfig == "" {
+		return local, nil
+	}
+	cfg, err := clientcmd.BuildConfigFromFlags("", kubeconfig)
+	if err != nil {
+		return nil, fmt.Errorf("loading --secrets-kubeconfig: %w", err)
+	}
+	klog.Infof("reading credential secrets from %s", cfg.Host)
+	return kubernetes.NewForConfig(cfg)
+}
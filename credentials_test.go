@@ -0,0 +1,188 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	cmmeta "github.com/cert-manager/cert-manager/pkg/apis/meta/v1"
+	sctesting "github.com/cert-manager/webhook-example/pkg/testing"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/sacloud/iaas-api-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestReadZoneCredentialFallback(t *testing.T) {
+	zones := sctesting.NewZoneClient(&iaas.DNS{ID: 1, Name: "example.com"})
+	secrets := sctesting.NewSecretStore()
+	require.NoError(t, secrets.SetAPIKey("acme", "creds", "revoked", "secret"))
+	require.NoError(t, secrets.SetAPIKey("acme", "rotated", "token", "secret"))
+	c := newHarnessSolver(zones, secrets)
+	// tokens are the tokens the clients were created with, in order.
+	var tokens []string
+	c.newZoneAPI = func(token, _ string) zoneAPI {
+		tokens = append(tokens, token)
+		if token != "token" {
+			return rejectedKeyZones{}
+		}
+		return zones
+	}
+	ch := harnessChallenge(0)
+	ch.Config.Raw = []byte(`{"zoneID": 1,
+		"accessTokenRef": {"name": "creds", "key": "accessToken"},
+		"accessTokenSecretRef": {"name": "creds", "key": "accessTokenSecret"},
+		"secondaryAccessTokenRef": {"name": "rotated", "key": "accessToken"},
+		"secondaryAccessTokenSecretRef": {"name": "rotated", "key": "accessTokenSecret"}}`)
+	cfg, err := c.resolveConfig(context.Background(), ch)
+	require.NoError(t, err)
+	used := func(slot string) float64 { return testutil.ToFloat64(credentialUsedTotal.WithLabelValues(slot)) }
+	primary, secondary := used("primary"), used("secondary")
+
+	// The primary key is rejected: the zone is read with the secondary one.
+	client, zone, err := c.readZone(context.Background(), &cfg, ch)
+	require.NoError(t, err)
+	assert.Equal(t, zones, client)
+	assert.Equal(t, "example.com", zone.Name)
+	assert.Equal(t, []string{"revoked", "token"}, tokens)
+	assert.Equal(t, primary, used("primary"))
+	assert.Equal(t, secondary+1, used("secondary"))
+
+	// Once the primary key is rotated, the secondary one is not used.
+	require.NoError(t, secrets.SetAPIKey("acme", "creds", "token", "secret"))
+	tokens = nil
+	_, _, err = c.readZone(context.Background(), &cfg, ch)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"token"}, tokens)
+	assert.Equal(t, primary+1, used("primary"))
+	assert.Equal(t, secondary+1, used("secondary"))
+
+	// Other errors than a rejected key do not fall through.
+	missing := cfg
+	missing.ZoneID = 2
+	tokens = nil
+	_, _, err = c.readZone(context.Background(), &missing, ch)
+	var apiErr iaas.APIError
+	require.ErrorAs(t, err, &apiErr)
+	assert.Equal(t, http.StatusNotFound, apiErr.ResponseCode())
+	assert.Equal(t, []string{"token"}, tokens)
+
+	// Both keys rejected.
+	require.NoError(t, secrets.SetAPIKey("acme", "creds", "revoked", "secret"))
+	require.NoError(t, secrets.SetAPIKey("acme", "rotated", "revoked", "secret"))
+	_, _, err = c.readZone(context.Background(), &cfg, ch)
+	assert.ErrorContains(t, err, "primary credential: ")
+	assert.ErrorContains(t, err, "secondary credential: ")
+	assert.Equal(t, primary+1, used("primary"))
+	assert.Equal(t, secondary+1, used("secondary"))
+}
+
+func TestSecretsNamespace(t *testing.T) {
+	zones := sctesting.NewZoneClient(&iaas.DNS{ID: 1, Name: "example.com"})
+	secrets := sctesting.NewSecretStore()
+	require.NoError(t, secrets.SetAPIKey("credentials", "creds", "token", "secret"))
+	c := newHarnessSolver(zones, secrets)
+	c.opts.SecretsNamespace = "credentials"
+	c.opts.AllowedSecretNamespaces = []string{"tenant-a"}
+
+	ch := harnessChallenge(1)
+	ch.ResourceNamespace = "tenant-a"
+	require.NoError(t, c.Present(ch))
+	assert.Len(t, zones.Zone(1).Records, 1, "the Secret is read from --secrets-namespace")
+
+	ch = harnessChallenge(2)
+	ch.ResourceNamespace = "tenant-b"
+	assert.EqualError(t, c.Present(ch), "primary credential: resolving the API key is denied: namespace tenant-b is not in --allowed-secret-namespaces")
+
+	c.opts.AllowedSecretNamespaces = []string{"credentials"}
+	ch = harnessChallenge(3)
+	ch.ResourceNamespace = "tenant-a"
+	assert.Error(t, c.Present(ch), "allowing --secrets-namespace does not allow every Issuer")
+	assert.Len(t, zones.Zone(1).Records, 1)
+}
+
+func TestNewSecretsClient(t *testing.T) {
+	local := fake.NewSimpleClientset()
+	client, err := newSecretsClient(local, "")
+	require.NoError(t, err)
+	assert.Same(t, local, client)
+
+	_, err = newSecretsClient(local, filepath.Join(t.TempDir(), "missing"))
+	assert.ErrorContains(t, err, "loading --secrets-kubeconfig")
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/v1/namespaces/credentials/secrets/creds" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"apiVersion": "v1", "kind": "Secret", "metadata": {"namespace": "credentials", "name": "creds"}, "data": {"accessToken": "cmVtb3Rl"}}`))
+	}))
+	defer srv.Close()
+	kubeconfig := filepath.Join(t.TempDir(), "kubeconfig")
+	require.NoError(t, os.WriteFile(kubeconfig, []byte(`apiVersion: v1
+kind: Config
+clusters:
+- name: management
+  cluster:
+    server: `+srv.URL+`
+contexts:
+- name: management
+  context:
+    cluster: management
+current-context: management
+`), 0o600))
+	client, err = newSecretsClient(local, kubeconfig)
+	require.NoError(t, err)
+
+	c := &sakuraCloudDNSProviderSolver{secretsClient: client, opts: newSolverOptions()}
+	c.opts.SecretsNamespace = "credentials"
+	value, err := c.getSecretString(context.Background(), &cmmeta.SecretKeySelector{LocalObjectReference: cmmeta.LocalObjectReference{Name: "creds"}, Key: "accessToken"}, "tenant-a")
+	require.NoError(t, err)
+	assert.Equal(t, "remote", value, "Secrets are read from the cluster of --secrets-kubeconfig")
+}
+
+func TestPresentUpdatesOnlyTheRecords(t *testing.T) {
+	var mu sync.Mutex
+	var requests []string
+	var update map[string]any
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		requests = append(requests, r.Method+" "+path.Base(r.URL.Path))
+		if r.Method == http.MethodPut {
+			require.NoError(t, json.NewDecoder(r.Body).Decode(&update))
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"CommonServiceItem": {"ID": "1", "Name": "example.com", "Description": "managed by terraform",
+			"Tags": ["prod"], "Availability": "available", "SettingsHash": "hash",
+			"Provider": {"Class": "dns"}, "Status": {"Zone": "example.com"},
+			"Settings": {"DNS": {"ResourceRecordSets": [{"Name": "www", "Type": "A", "RData": "192.0.2.1", "TTL": 300}]}}}}`))
+	}))
+	defer srv.Close()
+	root := iaas.SakuraCloudAPIRoot
+	iaas.SakuraCloudAPIRoot = srv.URL
+	defer func() { iaas.SakuraCloudAPIRoot = root }()
+
+	c := newHarness(t, nil)
+	c.newZoneAPI = nil
+	require.NoError(t, c.Present(harnessChallenge(0)))
+
+	// One read of the zone and one update of its records, which leaves the
+	// description and tags of the zone alone.
+	assert.Equal(t, []string{"GET 1", "PUT 1"}, requests)
+	item := update["CommonServiceItem"].(map[string]any)
+	assert.NotContains(t, item, "Description")
+	assert.NotContains(t, item, "Tags")
+	assert.NotContains(t, item, "Icon")
+	assert.Equal(t, "hash", item["SettingsHash"])
+	records := item["Settings"].(map[string]any)["DNS"].(map[string]any)["ResourceRecordSets"]
+	assert.Len(t, records, 2, "the record is added to the others")
+}
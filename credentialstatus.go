@@ -0,0 +1,103 @@
+package main
+
+import (
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// credentialStatus is what /debug/credentials reports about one API key, to
+// tell which key a zone is actually written with. The key itself is only
+// identified by its credentialHash.
+type credentialStatus struct {
+	// Credential is the credentialHash of the access token.
+	Credential string `json:"credential"`
+	// Slot is the credential of the Issuer config the key was read from:
+	// primary or secondary.
+	Slot string `json:"slot"`
+	// Source is the credential provider the key was read with: a Secret of
+	// the local cluster or of the cluster of --secrets-kubeconfig, the
+	// environment, --credentials-dir or Vault.
+	Source string `json:"source"`
+	// Secret names where the key was read from: for a Secret, the Secret
+	// and the keys of the access token and its secret, as
+	// namespace/name[tokenKey,secretKey].
+	Secret  string  `json:"secret"`
+	ZoneIDs []int64 `json:"zoneIDs"`
+
+	LastSuccess     *time.Time `json:"lastSuccess,omitempty"`
+	LastAuthFailure *time.Time `json:"lastAuthFailure,omitempty"`
+	LastAuthError   string     `json:"lastAuthError,omitempty"`
+}
+
+// credentialTracker records the use of the API keys of the solver.
+type credentialTracker struct {
+	now func() time.Time
+
+	mu       sync.Mutex
+	statuses map[credentialStatusKey]*credentialStatus
+}
+
+// credentialStatusKey identifies a credentialStatus. A rotated key gets a
+// status of its own, so the old key shows when it was last used.
+type credentialStatusKey struct {
+	credential, slot, source, secret string
+}
+
+var credentialStatuses = newCredentialTracker()
+
+func newCredentialTracker() *credentialTracker {
+	return &credentialTracker{now: time.Now, statuses: map[credentialStatusKey]*credentialStatus{}}
+}
+
+// record records a zone read of zoneID with the key of status: err is nil
+// after a successful read, and an error of isAuthError if the key was
+// rejected. Other errors say nothing about the key and are not recorded.
+func (t *credentialTracker) record(status credentialStatus, zoneID int64, err error) {
+	if err != nil && !isAuthError(err) {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	key := credentialStatusKey{status.Credential, status.Slot, status.Source, status.Secret}
+	s, ok := t.statuses[key]
+	if !ok {
+		s = &status
+		t.statuses[key] = s
+	}
+	if !slices.Contains(s.ZoneIDs, zoneID) {
+		s.ZoneIDs = append(s.ZoneIDs, zoneID)
+		slices.Sort(s.ZoneIDs)
+	}
+	now := t.now()
+	if err != nil {
+		s.LastAuthFailure, s.LastAuthError = &now, err.Error()
+		return
+	}
+	s.LastSuccess = &now
+}
+
+// len returns the number of tracked statuses.
+func (t *credentialTracker) len() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return len(t.statuses)
+}
+
+// list returns copies of the statuses, ordered by Secret, slot and
+// credential.
+func (t *credentialTracker) list() []credentialStatus {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	list := make([]credentialStatus, 0, len(t.statuses))
+	for _, s := range t.statuses {
+		c := *s
+		c.ZoneIDs = slices.Clone(s.ZoneIDs)
+		list = append(list, c)
+	}
+	slices.SortFunc(list, func(a, b credentialStatus) int {
+		return strings.Compare(a.Secret+"\t"+a.Slot+"\t"+a.Credential, b.Secret+"\t"+b.Slot+"\t"+b.Credential)
+	})
+	return list
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/sacloud/iaas-api-go"
+	"github.com/stretchr/testify/assert"
+)
+
+type authError struct{ iaas.APIError }
+
+func (authError) ResponseCode() int { return 401 }
+func (authError) Error() string     { return "401 Unauthorized" }
+
+func TestCredentialTracker(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	tr := newCredentialTracker()
+	tr.now = func() time.Time { return now }
+
+	status := credentialStatus{Credential: "0123456789ab", Slot: "primary", Source: "secret", Secret: "acme/creds[accessToken,accessTokenSecret]"}
+
+	tr.record(status, 2, nil)
+	tr.record(status, 1, errors.New("connection refused"))
+	now = now.Add(time.Minute)
+	tr.record(status, 1, authError{})
+
+	failedAt, succeededAt := now, now.Add(-time.Minute)
+	assert.Equal(t, []credentialStatus{{
+		Credential:      "0123456789ab",
+		Slot:            "primary",
+		Source:          "secret",
+		Secret:          "acme/creds[accessToken,accessTokenSecret]",
+		ZoneIDs:         []int64{1, 2},
+		LastSuccess:     &succeededAt,
+		LastAuthFailure: &failedAt,
+		LastAuthError:   "401 Unauthorized",
+	}}, tr.list())
+
+	rotated := status
+	rotated.Credential = "ba9876543210"
+	tr.record(rotated, 1, nil)
+	assert.Len(t, tr.list(), 2, "every key has a status of its own")
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// handlerContext returns the context a challenge is handled in, derived
+// from the one of its request, if any. The kube-apiserver gives up on
+// requests to the webhook after its request timeout (one minute by
+// default), while the webhook would keep working on them. With
+// --handler-timeout all work of a challenge, including API retries and the
+// propagation check, is aborted before that instead.
+func (c *sakuraCloudDNSProviderSolver) handlerContext(parent context.Context) (context.Context, context.CancelFunc) {
+	if c.opts.HandlerTimeout <= 0 {
+		return context.WithCancel(parent)
+	}
+	return context.WithTimeout(parent, c.opts.HandlerTimeout)
+}
+
+// deadlineError turns err into a retriable error if the handler deadline of
+// ctx has passed, so cert-manager retries the challenge.
+func (c *sakuraCloudDNSProviderSolver) deadlineError(ctx context.Context, err error) error {
+	if err == nil || !errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return err
+	}
+	var retriable *retriableError
+	if errors.As(err, &retriable) {
+		return err
+	}
+	return &retriableError{
+		reason: fmt.Sprintf("challenge was not handled within --handler-timeout %s", c.opts.HandlerTimeout),
+		err:    err,
+	}
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDeadlineError(t *testing.T) {
+	opts := newSolverOptions()
+	opts.HandlerTimeout = time.Millisecond
+	c := &sakuraCloudDNSProviderSolver{opts: opts}
+
+	ctx, cancel := c.handlerContext(context.Background())
+	defer cancel()
+	err := errors.New("boom")
+	assert.Same(t, err, c.deadlineError(ctx, err), "errors before the deadline are returned as is")
+
+	<-ctx.Done()
+	var retriable *retriableError
+	wrapped := c.deadlineError(ctx, err)
+	if assert.ErrorAs(t, wrapped, &retriable) {
+		assert.ErrorIs(t, wrapped, err)
+		assert.Contains(t, wrapped.Error(), "--handler-timeout")
+	}
+	assert.Nil(t, c.deadlineError(ctx, nil))
+
+	opts.HandlerTimeout = 0
+	ctx, cancel = c.handlerContext(context.Background())
+	defer cancel()
+	_, ok := ctx.Deadline()
+	assert.False(t, ok, "a zero timeout sets no deadline")
+	assert.NoError(t, context.Cause(ctx))
+}
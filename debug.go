@@ -0,0 +1,97 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// serveDebug serves the debugHandler on addr until stopCh is closed.
+func serveDebug(addr, token string, history *zoneHistory, stopCh <-chan struct{}) {
+	serveHTTP("debug endpoints", addr, debugHandler(token, history), stopCh)
+}
+
+// debugHandler serves the debug endpoints: /debug/config reports the
+// effectiveConfig, /debug/credentials credentialStatuses, /debug/groups
+// groupRegistrations and /debug/zones/history the edits in history, of all
+// zones or of the zone given by ?zone=<ID>. With a token, requests have to carry it as a bearer
+// token.
+func debugHandler(token string, history *zoneHistory) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/config", func(w http.ResponseWriter, _ *http.Request) {
+		writeDebugJSON(w, effectiveConfig.get())
+	})
+	mux.HandleFunc("/debug/credentials", func(w http.ResponseWriter, _ *http.Request) {
+		writeDebugJSON(w, credentialStatuses.list())
+	})
+	mux.HandleFunc("/debug/groups", func(w http.ResponseWriter, _ *http.Request) {
+		writeDebugJSON(w, groupRegistrations.list())
+	})
+	mux.HandleFunc("/debug/zones/history", func(w http.ResponseWriter, r *http.Request) {
+		zones := history.list()
+		if s := r.URL.Query().Get("zone"); s != "" {
+			id, err := strconv.ParseInt(s, 10, 64)
+			if err != nil {
+				http.Error(w, fmt.Sprintf("invalid zone %q", s), http.StatusBadRequest)
+				return
+			}
+			zones = map[int64][]zoneMutation{id: zones[id]}
+		}
+		writeDebugJSON(w, zones)
+	})
+	return requireBearerToken(token, mux)
+}
+
+// requireBearerToken rejects the requests to next that do not carry token
+// as a bearer token. An empty token lets every request through.
+func requireBearerToken(token string, next http.Handler) http.Handler {
+	if token == "" {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(got), []byte(token)) != 1 {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func writeDebugJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	_ = enc.Encode(v)
+}
+
+// debugToken reads the token of --debug-token-file. Without one, the debug
+// endpoints may only listen on a loopback address, which is reached with
+// `kubectl port-forward` and so guarded by RBAC.
+func debugToken(addr, tokenFile string) (string, error) {
+	if tokenFile == "" {
+		host, _, err := net.SplitHostPort(addr)
+		if err != nil {
+			return "", fmt.Errorf("--debug-bind-address: %w", err)
+		}
+		if ip := net.ParseIP(host); host != "localhost" && (ip == nil || !ip.IsLoopback()) {
+			return "", fmt.Errorf("--debug-bind-address %s is not a loopback address, --debug-token-file is required", addr)
+		}
+		return "", nil
+	}
+	data, err := os.ReadFile(tokenFile)
+	if err != nil {
+		return "", fmt.Errorf("--debug-token-file: %w", err)
+	}
+	token := strings.TrimSpace(string(data))
+	if token == "" {
+		return "", errors.New("--debug-token-file is empty")
+	}
+	return token, nil
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRequireBearerToken(t *testing.T) {
+	ok := http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {})
+	get := func(h http.Handler, auth string) int {
+		req := httptest.NewRequest(http.MethodGet, "/debug/credentials", nil)
+		if auth != "" {
+			req.Header.Set("Authorization", auth)
+		}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	h := requireBearerToken("token", ok)
+	assert.Equal(t, http.StatusOK, get(h, "Bearer token"))
+	assert.Equal(t, http.StatusUnauthorized, get(h, "Bearer other"))
+	assert.Equal(t, http.StatusUnauthorized, get(h, "token"))
+	assert.Equal(t, http.StatusUnauthorized, get(h, ""))
+	assert.Equal(t, http.StatusOK, get(requireBearerToken("", ok), ""))
+}
+
+func TestDebugToken(t *testing.T) {
+	token, err := debugToken("127.0.0.1:8082", "")
+	require.NoError(t, err)
+	assert.Empty(t, token)
+	_, err = debugToken("localhost:8082", "")
+	assert.NoError(t, err)
+	_, err = debugToken(":8082", "")
+	assert.ErrorContains(t, err, "--debug-token-file is required")
+
+	file := filepath.Join(t.TempDir(), "token")
+	require.NoError(t, os.WriteFile(file, []byte("secret\n"), 0o600))
+	token, err = debugToken(":8082", file)
+	require.NoError(t, err)
+	assert.Equal(t, "secret", token)
+}
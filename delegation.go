@@ -0,0 +1,135 @@
+package main
+
+import (
+	"context"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sacloud/iaas-api-go"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/scheme"
+	typedcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/klog/v2"
+)
+
+// delegationChecker compares the NS set a zone is publicly delegated to
+// with the nameservers Sakura Cloud assigned to it. When the delegation
+// misses one of them, records are created in a zone nobody queries and
+// validation never passes.
+type delegationChecker struct {
+	// recorder records a Warning event on pod when a zone is found to be
+	// misdelegated. It is nil outside of a cluster.
+	recorder record.EventRecorder
+	pod      *corev1.ObjectReference
+
+	mu         sync.Mutex
+	mismatched map[int64]bool
+}
+
+// newDelegationChecker returns a checker that records events on the webhook
+// Pod, if it can tell which Pod it runs in.
+func newDelegationChecker(client kubernetes.Interface, stopCh <-chan struct{}) *delegationChecker {
+	d := &delegationChecker{}
+	ns, err := namespaceOrOwn("")
+	if err != nil {
+		klog.V(2).Infof("not recording nameserver mismatch events: %v", err)
+		return d
+	}
+	name, err := os.Hostname()
+	if err != nil {
+		klog.V(2).Infof("not recording nameserver mismatch events: %v", err)
+		return d
+	}
+	d.pod = &corev1.ObjectReference{
+		APIVersion: "v1",
+		Kind:       "Pod",
+		Namespace:  ns,
+		Name:       name,
+		UID:        types.UID(os.Getenv("POD_UID")),
+	}
+
+	broadcaster := record.NewBroadcaster()
+	broadcaster.StartRecordingToSink(&typedcorev1.EventSinkImpl{Interface: client.CoreV1().Events(ns)})
+	d.recorder = broadcaster.NewRecorder(scheme.Scheme, corev1.EventSource{Component: "cert-manager-webhook-sakuracloud"})
+	go func() {
+		<-stopCh
+		broadcaster.Shutdown()
+	}()
+	return d
+}
+
+// check compares the delegation of zone with its assigned nameservers,
+// exports the result as a metric and warns when a zone becomes
+// misdelegated. A nil checker does nothing.
+func (d *delegationChecker) check(ctx context.Context, zone *iaas.DNS) {
+	if d == nil || len(zone.DNSNameServers) == 0 {
+		return
+	}
+	delegated, err := zoneNameservers.get(ctx, zone.Name)
+	if err != nil {
+		klog.V(4).Infof("not checking the delegation of zone %s: %v", zone.Name, err)
+		return
+	}
+	missing := missingNameservers(zone.DNSNameServers, delegated)
+	mismatched := len(missing) > 0
+
+	v := 0.0
+	if mismatched {
+		v = 1
+	}
+	zoneNameserverMismatch.WithLabelValues(zoneLabels.nameLabel(zone)).Set(v)
+
+	d.mu.Lock()
+	was := d.mismatched[zone.ID.Int64()]
+	if d.mismatched == nil {
+		d.mismatched = map[int64]bool{}
+	}
+	d.mismatched[zone.ID.Int64()] = mismatched
+	d.mu.Unlock()
+	if !mismatched || was {
+		return
+	}
+
+	sampledLog.Warningf("zone %s is delegated to %s, which misses its Sakura Cloud nameservers %s; challenges in it will not validate",
+		zone.Name, strings.Join(delegated, ", "), strings.Join(missing, ", "))
+	if d.recorder != nil {
+		d.recorder.Eventf(d.pod, corev1.EventTypeWarning, "NameserverMismatch",
+			"Zone %s (%d) is delegated to %s, which misses its Sakura Cloud nameservers %s",
+			zone.Name, zone.ID.Int64(), strings.Join(delegated, ", "), strings.Join(missing, ", "))
+	}
+}
+
+// checkDelegation runs the delegation check of zone in the background, so
+// that a slow resolver does not delay the challenge.
+func (c *sakuraCloudDNSProviderSolver) checkDelegation(zone *iaas.DNS) {
+	if c.delegation == nil {
+		return
+	}
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+		c.delegation.check(ctx, zone)
+	}()
+}
+
+// missingNameservers returns the assigned nameservers that are not in
+// delegated. Names are compared case-insensitively and without the final
+// dot.
+func missingNameservers(assigned, delegated []string) []string {
+	set := make(map[string]bool, len(delegated))
+	for _, ns := range delegated {
+		set[strings.ToLower(strings.TrimSuffix(ns, "."))] = true
+	}
+	var missing []string
+	for _, ns := range assigned {
+		if !set[strings.ToLower(strings.TrimSuffix(ns, "."))] {
+			missing = append(missing, ns)
+		}
+	}
+	return missing
+}
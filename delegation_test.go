@@ -0,0 +1,49 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/sacloud/iaas-api-go"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/tools/record"
+)
+
+func TestMissingNameservers(t *testing.T) {
+	assigned := []string{"ns1.gslb1.sakura.ne.jp", "ns2.gslb1.sakura.ne.jp"}
+	assert.Empty(t, missingNameservers(assigned, []string{"NS2.gslb1.sakura.ne.jp.", "ns1.gslb1.sakura.ne.jp", "ns.secondary.example."}))
+	assert.Equal(t, []string{"ns2.gslb1.sakura.ne.jp"}, missingNameservers(assigned, []string{"ns1.gslb1.sakura.ne.jp"}))
+}
+
+func TestDelegationChecker(t *testing.T) {
+	delegated := []string{"ns1.gslb1.sakura.ne.jp"}
+	saved := zoneNameservers
+	defer func() { zoneNameservers = saved }()
+	zoneNameservers = &nameserverCache{
+		now: time.Now,
+		lookup: func(ctx context.Context, zone string) ([]string, time.Duration, error) {
+			return delegated, 0, nil
+		},
+	}
+
+	recorder := record.NewFakeRecorder(10)
+	d := &delegationChecker{recorder: recorder, pod: &corev1.ObjectReference{Kind: "Pod", Name: "webhook"}}
+	zone := &iaas.DNS{ID: 1, Name: "delegation.example", DNSNameServers: []string{"ns1.gslb1.sakura.ne.jp", "ns2.gslb1.sakura.ne.jp"}}
+	gauge := zoneNameserverMismatch.WithLabelValues("delegation.example")
+
+	ctx := context.Background()
+	d.check(ctx, zone)
+	d.check(ctx, zone)
+	assert.Equal(t, 1.0, testutil.ToFloat64(gauge))
+	assert.Len(t, recorder.Events, 1, "a mismatch is reported once")
+	assert.Contains(t, <-recorder.Events, "NameserverMismatch")
+
+	delegated = append(delegated, "ns2.gslb1.sakura.ne.jp")
+	zoneNameservers.entries = nil
+	d.check(ctx, zone)
+	assert.Equal(t, 0.0, testutil.ToFloat64(gauge))
+	assert.Empty(t, recorder.Events)
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sync"
+
+	"github.com/spf13/pflag"
+	"k8s.io/klog/v2"
+)
+
+// deprecation is a use of a deprecated config field or flag.
+type deprecation struct {
+	// field names the config field, e.g. "zoneId", or the flag, e.g.
+	// "--some-flag". It is the field label of deprecated_config_usage_total.
+	field   string
+	message string
+}
+
+// deprecationWarner logs a warning the first time each deprecated field or
+// flag is used, so that Issuers using an old spelling do not flood the logs
+// with a warning per challenge. Every use is counted in
+// deprecated_config_usage_total, which tells when a migration is done.
+type deprecationWarner struct {
+	mu     sync.Mutex
+	warned map[string]bool
+}
+
+var deprecations = &deprecationWarner{warned: map[string]bool{}}
+
+// use records a use of d.
+func (w *deprecationWarner) use(d deprecation) {
+	deprecatedConfigUsageTotal.WithLabelValues(d.field).Inc()
+	w.mu.Lock()
+	first := !w.warned[d.field]
+	w.warned[d.field] = true
+	w.mu.Unlock()
+	if first {
+		klog.Warningf("%s (logged once, see the deprecated_config_usage_total metric for further uses)", d.message)
+	}
+}
+
+// warnDeprecatedFlags records the uses of the flags of fs marked with
+// MarkDeprecated. With env, flags set through their environment variable,
+// see applyEnv, count as well.
+func warnDeprecatedFlags(fs *pflag.FlagSet, env bool) {
+	fs.VisitAll(func(f *pflag.Flag) {
+		if f.Deprecated == "" {
+			return
+		}
+		if _, ok := os.LookupEnv(flagEnvName(f.Name)); !f.Changed && !(env && ok) {
+			return
+		}
+		deprecations.use(deprecation{
+			field:   "--" + f.Name,
+			message: fmt.Sprintf("flag --%s is deprecated, %s", f.Name, f.Deprecated),
+		})
+	})
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"io"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/spf13/pflag"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+)
+
+func TestDeprecationWarner(t *testing.T) {
+	w := &deprecationWarner{warned: map[string]bool{}}
+	counter := deprecatedConfigUsageTotal.WithLabelValues("test-field")
+	before := testutil.ToFloat64(counter)
+
+	w.use(deprecation{field: "test-field", message: "test-field is deprecated"})
+	w.use(deprecation{field: "test-field", message: "test-field is deprecated"})
+	assert.Equal(t, map[string]bool{"test-field": true}, w.warned)
+	assert.Equal(t, before+2, testutil.ToFloat64(counter), "every use is counted")
+}
+
+func TestWarnDeprecatedFlags(t *testing.T) {
+	newFlags := func() *pflag.FlagSet {
+		fs := pflag.NewFlagSet("test", pflag.ContinueOnError)
+		fs.SetOutput(io.Discard)
+		fs.String("old-flag", "", "")
+		fs.String("new-flag", "", "")
+		require.NoError(t, fs.MarkDeprecated("old-flag", "use --new-flag instead"))
+		return fs
+	}
+	counter := deprecatedConfigUsageTotal.WithLabelValues("--old-flag")
+	before := testutil.ToFloat64(counter)
+
+	fs := newFlags()
+	require.NoError(t, fs.Parse([]string{"--new-flag=x"}))
+	warnDeprecatedFlags(fs, true)
+	assert.Equal(t, before, testutil.ToFloat64(counter))
+
+	require.NoError(t, fs.Parse([]string{"--old-flag=x"}))
+	warnDeprecatedFlags(fs, true)
+	assert.Equal(t, before+1, testutil.ToFloat64(counter))
+
+	t.Setenv(flagEnvName("old-flag"), "x")
+	warnDeprecatedFlags(newFlags(), false)
+	assert.Equal(t, before+1, testutil.ToFloat64(counter))
+	warnDeprecatedFlags(newFlags(), true)
+	assert.Equal(t, before+2, testutil.ToFloat64(counter), "flags set through the environment count")
+}
+
+func TestLoadConfigCountsDeprecatedFields(t *testing.T) {
+	counter := deprecatedConfigUsageTotal.WithLabelValues("zoneId")
+	before := testutil.ToFloat64(counter)
+
+	cfg, err := loadConfig(&apiextensionsv1.JSON{Raw: []byte(`{"zoneId": 1}`)}, "native")
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), cfg.ZoneID)
+	assert.Equal(t, before+1, testutil.ToFloat64(counter))
+	assert.True(t, deprecations.warned["zoneId"])
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"k8s.io/klog/v2"
+)
+
+// drainer lets a replica leave without dropping ChallengeRequests. The
+// preStop hook of the pod starts draining it with the `drain` command: /readyz
+// fails, so that the endpoints of the Service drop the pod and the
+// aggregated API server sends new ChallengeRequests to the other replicas,
+// while the ones still reaching the pod meanwhile are served. The hook
+// returns once the challenges in flight are done, and only then is the
+// process sent SIGTERM.
+type drainer struct {
+	// delay is how long the pod keeps serving after it stopped being ready,
+	// for the endpoints to catch up; timeout bounds the whole drain.
+	delay   time.Duration
+	timeout time.Duration
+
+	mu       sync.Mutex
+	draining bool
+	inFlight int
+	idle     chan struct{}
+}
+
+func newDrainer(delay, timeout time.Duration) *drainer {
+	return &drainer{delay: delay, timeout: timeout}
+}
+
+// track counts a Present, a CleanUp or an asynchronous propagation check as
+// in flight until the returned function is called.
+func (d *drainer) track() func() {
+	if d == nil {
+		return func() {}
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.inFlight++
+	return func() {
+		d.mu.Lock()
+		defer d.mu.Unlock()
+		d.inFlight--
+		if d.inFlight == 0 && d.idle != nil {
+			close(d.idle)
+			d.idle = nil
+		}
+	}
+}
+
+// drain marks the replica draining and waits for the delay, then for the
+// challenges in flight, within the timeout. It returns how many are still
+// in flight.
+func (d *drainer) drain(ctx context.Context) int {
+	d.mu.Lock()
+	if !d.draining {
+		d.draining = true
+		drainingGauge.Set(1)
+		klog.Infof("draining: not ready, serving the ChallengeRequests still sent here for %s", d.delay)
+	}
+	d.mu.Unlock()
+
+	ctx, cancel := context.WithTimeout(ctx, d.timeout)
+	defer cancel()
+	select {
+	case <-time.After(d.delay):
+	case <-ctx.Done():
+	}
+	for {
+		d.mu.Lock()
+		n := d.inFlight
+		if n == 0 {
+			d.mu.Unlock()
+			klog.Infof("drained: no challenges in flight")
+			return 0
+		}
+		if d.idle == nil {
+			d.idle = make(chan struct{})
+		}
+		idle := d.idle
+		d.mu.Unlock()
+		select {
+		case <-idle:
+		case <-ctx.Done():
+			klog.Warningf("draining timed out with %d challenges in flight", n)
+			return n
+		}
+	}
+}
+
+// drainCheck reports the replica as not ready once it is draining.
+func drainCheck(d *drainer) readinessCheck {
+	return readinessCheck{
+		name: "draining",
+		check: func() error {
+			d.mu.Lock()
+			defer d.mu.Unlock()
+			if d.draining {
+				return errors.New("draining for shutdown")
+			}
+			return nil
+		},
+	}
+}
+
+// serveDrain serves the drainHandler on addr, a loopback address, as anyone
+// reaching it could take the replica out of service.
+func serveDrain(addr string, d *drainer, stopCh <-chan struct{}) error {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return fmt.Errorf("--drain-bind-address: %w", err)
+	}
+	if ip := net.ParseIP(host); host != "localhost" && (ip == nil || !ip.IsLoopback()) {
+		return fmt.Errorf("--drain-bind-address %s is not a loopback address", addr)
+	}
+	serveHTTP("drain endpoint", addr, drainHandler(d), stopCh)
+	return nil
+}
+
+// drainHandler serves POST /drain, which answers once the replica is
+// drained, with 503 if challenges were still in flight at the timeout.
+func drainHandler(d *drainer) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/drain", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "use POST", http.StatusMethodNotAllowed)
+			return
+		}
+		if n := d.drain(r.Context()); n > 0 {
+			http.Error(w, fmt.Sprintf("timed out after %s with %d challenges in flight", d.timeout, n), http.StatusServiceUnavailable)
+			return
+		}
+		fmt.Fprintln(w, "drained")
+	})
+	return mux
+}
+
+// runDrain implements the `drain` command, the preStop hook of the webhook
+// container: it drains the webhook through its drain endpoint.
+func runDrain(args []string) error {
+	return drain(args, os.Stdout)
+}
+
+func drain(args []string, out io.Writer) error {
+	fs := flag.NewFlagSet("drain", flag.ContinueOnError)
+	addr := fs.String("address", "127.0.0.1:8083", "address of the drain endpoint of the webhook (--drain-bind-address)")
+	timeout := fs.Duration("timeout", 2*time.Minute, "how long to wait for the webhook; set it above --drain-timeout of the webhook")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, "http://"+*addr+"/drain", nil)
+	if err != nil {
+		return err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	msg := strings.TrimSpace(string(body))
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s: %s", resp.Status, msg)
+	}
+	fmt.Fprintln(out, msg)
+	return nil
+}
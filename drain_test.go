@@ -0,0 +1,60 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDrainer(t *testing.T) {
+	d := newDrainer(10*time.Millisecond, time.Second)
+	check := drainCheck(d)
+	require.NoError(t, check.check())
+
+	done := d.track()
+	drained := make(chan int)
+	go func() { drained <- d.drain(context.Background()) }()
+	require.Eventually(t, func() bool { return check.check() != nil }, time.Second, time.Millisecond, "not ready once draining")
+	assert.Equal(t, 1.0, testutil.ToFloat64(drainingGauge))
+
+	late := d.track()
+	late()
+	select {
+	case <-drained:
+		t.Fatal("drained with a challenge in flight")
+	case <-time.After(50 * time.Millisecond):
+	}
+	done()
+	assert.Equal(t, 0, <-drained)
+
+	d = newDrainer(0, 10*time.Millisecond)
+	d.track()
+	assert.Equal(t, 1, d.drain(context.Background()), "timed out")
+
+	var none *drainer
+	none.track()()
+}
+
+func TestDrainCommand(t *testing.T) {
+	d := newDrainer(0, time.Second)
+	srv := httptest.NewServer(drainHandler(d))
+	defer srv.Close()
+	addr := strings.TrimPrefix(srv.URL, "http://")
+
+	var out bytes.Buffer
+	require.NoError(t, drain([]string{"--address=" + addr}, &out))
+	assert.Equal(t, "drained\n", out.String())
+
+	d = newDrainer(0, 10*time.Millisecond)
+	d.track()
+	srv.Config.Handler = drainHandler(d)
+	err := drain([]string{"--address=" + addr}, &out)
+	assert.ErrorContains(t, err, "503 Service Unavailable: timed out after 10ms with 1 challenges in flight")
+}
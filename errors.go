@@ -0,0 +1,79 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/sacloud/iaas-api-go"
+	"github.com/sacloud/iaas-api-go/types"
+)
+
+// retriableError marks failures that are expected to go away without user
+// intervention, e.g. a zone that is being recreated by IaC tooling.
+// cert-manager keeps retrying Present, so the message makes clear that
+// waiting is the expected remedy.
+type retriableError struct {
+	reason string
+	err    error
+}
+
+func (e *retriableError) Error() string {
+	return fmt.Sprintf("%s (will be retried): %v", e.reason, e.err)
+}
+
+func (e *retriableError) Unwrap() error {
+	return e.err
+}
+
+// isNotFoundError is like iaas.IsNotFoundError, but also matches wrapped API
+// errors.
+func isNotFoundError(err error) bool {
+	var apiErr iaas.APIError
+	return errors.As(err, &apiErr) && apiErr.ResponseCode() == http.StatusNotFound
+}
+
+// zoneNotFoundError wraps a NotFound API error for zoneID into a retriable
+// error: the zone may be deleted and recreated while a challenge is in
+// flight, and Present succeeds again once it exists.
+func zoneNotFoundError(zoneID int64, err error) error {
+	return &retriableError{
+		reason: fmt.Sprintf("DNS zone %d does not exist", zoneID),
+		err:    err,
+	}
+}
+
+// zoneUnavailableError is returned for a zone Sakura Cloud does not serve
+// changes to, e.g. while it is being transferred or after it was suspended.
+// Updates would only fail, or be retried while the zone is locked, so none
+// are sent.
+type zoneUnavailableError struct {
+	zoneID       int64
+	zoneName     string
+	availability types.EAvailability
+}
+
+func (e *zoneUnavailableError) Error() string {
+	return fmt.Sprintf("DNS zone %s (%d) is %s, not available: its records cannot be changed until Sakura Cloud makes it available again, check the zone in the control panel", e.zoneName, e.zoneID, e.availability)
+}
+
+// checkZoneAvailable returns a zoneUnavailableError if zone is not
+// available. Zones whose availability is unknown are assumed available.
+func checkZoneAvailable(zone *iaas.DNS) error {
+	if zone.Availability == types.Availabilities.Unknown || zone.Availability.IsAvailable() {
+		return nil
+	}
+	return &zoneUnavailableError{zoneID: zone.ID.Int64(), zoneName: zone.Name, availability: zone.Availability}
+}
+
+// ambiguousZoneError is returned when zoneID and zoneName of the config
+// select different zones.
+type ambiguousZoneError struct {
+	zoneID   int64
+	zoneName string
+	actual   string
+}
+
+func (e *ambiguousZoneError) Error() string {
+	return fmt.Sprintf("ambiguous zone selection: zoneID %d is zone %s, but zoneName is %s", e.zoneID, e.actual, e.zoneName)
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"errors"
+	"testing"
+
+	sctesting "github.com/cert-manager/webhook-example/pkg/testing"
+	"github.com/sacloud/iaas-api-go"
+	"github.com/sacloud/iaas-api-go/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCheckZoneAvailable(t *testing.T) {
+	zone := &iaas.DNS{ID: types.Int64ID(123), Name: "example.com"}
+	assert.NoError(t, checkZoneAvailable(zone), "unknown availability")
+	zone.Availability = types.Availabilities.Available
+	assert.NoError(t, checkZoneAvailable(zone))
+
+	zone.Availability = types.Availabilities.Transferring
+	err := checkZoneAvailable(zone)
+	var zoneErr *zoneUnavailableError
+	assert.True(t, errors.As(err, &zoneErr))
+	assert.ErrorContains(t, err, "DNS zone example.com (123) is transfering, not available")
+}
+
+func TestZoneDeletedMidChallenge(t *testing.T) {
+	zones := sctesting.NewZoneClient(&iaas.DNS{ID: 1, Name: "example.com"})
+	c := newHarness(t, zones)
+
+	// The zone is deleted between Present and CleanUp, and so is the record.
+	require.NoError(t, c.Present(harnessChallenge(0)))
+	zones.Delete(1)
+	assert.NoError(t, c.CleanUp(harnessChallenge(0)))
+
+	// Present fails with a retriable error until the zone is recreated.
+	err := c.Present(harnessChallenge(1))
+	var retriable *retriableError
+	require.ErrorAs(t, err, &retriable)
+	assert.ErrorContains(t, err, "DNS zone 1 does not exist (will be retried)")
+	zones.Put(&iaas.DNS{ID: 1, Name: "example.com"})
+	require.NoError(t, c.Present(harnessChallenge(1)))
+	assert.Len(t, zones.Zone(1).Records, 1)
+}
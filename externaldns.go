@@ -0,0 +1,67 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/sacloud/iaas-api-go"
+	"github.com/sacloud/iaas-api-go/types"
+)
+
+// externalDNSHeritage starts the value of the TXT records external-dns keeps
+// next to the records it owns, e.g.
+// "heritage=external-dns,external-dns/owner=default,external-dns/resource=ingress/default/web".
+// The solver never modifies or deletes such records, except its own ones
+// written with --external-dns-owner-id.
+const externalDNSHeritage = "heritage=external-dns,"
+
+// externalDNSResource is the resource named by the ownership records the
+// solver writes.
+const externalDNSResource = "cert-manager-webhook-sakuracloud"
+
+// isExternalDNSRecord reports whether r is an ownership record of
+// external-dns.
+func isExternalDNSRecord(r *iaas.DNSRecord) bool {
+	return r.Type == types.DNSRecordTypes.TXT && strings.HasPrefix(txtValue(r.RData), externalDNSHeritage)
+}
+
+// externalDNSOwnerValue returns the value of the ownership record the solver
+// writes for owner.
+func externalDNSOwnerValue(owner string) string {
+	return fmt.Sprintf("%sexternal-dns/owner=%s,external-dns/resource=%s", externalDNSHeritage, owner, externalDNSResource)
+}
+
+// addExternalDNSOwner adds the ownership record of owner next to the
+// challenge record entry, so external-dns instances with another owner ID
+// leave the challenge record alone. It reports whether zone was changed.
+func addExternalDNSOwner(zone *iaas.DNS, entry, owner string, ttl int) (bool, error) {
+	value := externalDNSOwnerValue(owner)
+	rdata, err := txtRData(value)
+	if err != nil {
+		return false, fmt.Errorf("invalid --external-dns-owner-id: %w", err)
+	}
+	for _, r := range zone.Records {
+		if r.Name == entry && isExternalDNSRecord(r) && txtValue(r.RData) == value {
+			return false, nil
+		}
+	}
+	zone.Records.Add(&iaas.DNSRecord{Name: entry, Type: types.DNSRecordTypes.TXT, RData: rdata, TTL: ttl})
+	return true, nil
+}
+
+// removeExternalDNSOwner deletes the ownership record of owner at entry once
+// no challenge record is left there. It reports whether zone was changed.
+func removeExternalDNSOwner(zone *iaas.DNS, entry, owner string) bool {
+	for _, r := range zone.Records {
+		if r.Name == entry && r.Type == types.DNSRecordTypes.TXT && !isExternalDNSRecord(r) {
+			return false
+		}
+	}
+	value := externalDNSOwnerValue(owner)
+	n := len(zone.Records)
+	zone.Records = slices.DeleteFunc(zone.Records, func(r *iaas.DNSRecord) bool {
+		return r.Name == entry && isExternalDNSRecord(r) && txtValue(r.RData) == value
+	})
+	return len(zone.Records) != n
+}
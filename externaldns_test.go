@@ -0,0 +1,42 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/sacloud/iaas-api-go"
+	"github.com/sacloud/iaas-api-go/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIsExternalDNSRecord(t *testing.T) {
+	txt := types.DNSRecordTypes.TXT
+	assert.True(t, isExternalDNSRecord(&iaas.DNSRecord{Type: txt, RData: "heritage=external-dns,external-dns/owner=default"}))
+	assert.True(t, isExternalDNSRecord(&iaas.DNSRecord{Type: txt, RData: `"heritage=external-dns,external-dns/owner=default,external-dns/resource=ingress/default/web"`}))
+	assert.False(t, isExternalDNSRecord(&iaas.DNSRecord{Type: txt, RData: "key"}))
+	assert.False(t, isExternalDNSRecord(&iaas.DNSRecord{Type: types.DNSRecordTypes.CNAME, RData: "heritage=external-dns,"}))
+}
+
+func TestExternalDNSOwner(t *testing.T) {
+	txt := types.DNSRecordTypes.TXT
+	foreign := &iaas.DNSRecord{Name: "_acme-challenge.www", Type: txt, RData: "heritage=external-dns,external-dns/owner=other"}
+	challenge := &iaas.DNSRecord{Name: "_acme-challenge.www", Type: txt, RData: "key"}
+	zone := &iaas.DNS{Records: iaas.DNSRecords{foreign, challenge}}
+
+	added, err := addExternalDNSOwner(zone, "_acme-challenge.www", "webhook", 60)
+	require.NoError(t, err)
+	assert.True(t, added)
+	require.Len(t, zone.Records, 3)
+	assert.Equal(t, "heritage=external-dns,external-dns/owner=webhook,external-dns/resource=cert-manager-webhook-sakuracloud", zone.Records[2].RData)
+	added, err = addExternalDNSOwner(zone, "_acme-challenge.www", "webhook", 60)
+	require.NoError(t, err)
+	assert.False(t, added, "the ownership record is only written once")
+
+	assert.False(t, removeExternalDNSOwner(zone, "_acme-challenge.www", "webhook"), "kept while a challenge record is left")
+	zone.Records = iaas.DNSRecords{foreign, zone.Records[2]}
+	assert.True(t, removeExternalDNSOwner(zone, "_acme-challenge.www", "webhook"))
+	assert.Equal(t, iaas.DNSRecords{foreign}, zone.Records, "ownership records of other owners are kept")
+
+	_, err = addExternalDNSOwner(zone, "_acme-challenge.www", "with space", 60)
+	assert.NoError(t, err, "values with spaces are quoted")
+}
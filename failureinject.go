@@ -0,0 +1,58 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/cert-manager/cert-manager/pkg/acme/webhook/apis/acme/v1alpha1"
+	"k8s.io/client-go/rest"
+)
+
+// errInjectedFailure is returned by Present calls failed on purpose by
+// --inject-failure-rate.
+var errInjectedFailure = errors.New("injected failure (--inject-failure-rate)")
+
+// stagingSolver is the sakuracloud-dns-solver-staging solver. It shares the
+// zones, credentials and state of the regular solver, but fails part of the
+// Present calls when --inject-failure-rate is set. Issuers pointing at it can
+// be used to rehearse alerting and cert-manager's retries without affecting
+// Issuers using the regular solver.
+type stagingSolver struct {
+	*sakuraCloudDNSProviderSolver
+}
+
+func (s *stagingSolver) Name() string {
+	return s.sakuraCloudDNSProviderSolver.Name() + "-staging"
+}
+
+func (s *stagingSolver) Present(ch *v1alpha1.ChallengeRequest) error {
+	return s.present(context.Background(), ch, true)
+}
+
+func (s *stagingSolver) presentContext(ctx context.Context, ch *v1alpha1.ChallengeRequest) error {
+	return s.present(ctx, ch, true)
+}
+
+// Initialize does nothing: the embedded solver is registered on its own and
+// initialized by the webhook server already.
+func (s *stagingSolver) Initialize(*rest.Config, <-chan struct{}) error {
+	return nil
+}
+
+// shutdown does nothing for the same reason.
+func (s *stagingSolver) shutdown() {}
+
+// validateFailureRate checks the --inject-failure-rate percentage.
+func validateFailureRate(rate float64) error {
+	if rate < 0 || rate > 100 {
+		return fmt.Errorf("--inject-failure-rate must be between 0 and 100, got %v", rate)
+	}
+	return nil
+}
+
+// injectFailure reports whether a call should fail for a failure rate in
+// percent, given a random number generator returning values in [0, 1).
+func injectFailure(rate float64, random func() float64) bool {
+	return rate > 0 && random()*100 < rate
+}
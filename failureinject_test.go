@@ -0,0 +1,31 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestInjectFailure(t *testing.T) {
+	fixed := func(v float64) func() float64 {
+		return func() float64 { return v }
+	}
+
+	assert.False(t, injectFailure(0, fixed(0)), "a zero rate never fails")
+	assert.True(t, injectFailure(100, fixed(0.999)), "a rate of 100 always fails")
+	assert.True(t, injectFailure(25, fixed(0.2)))
+	assert.False(t, injectFailure(25, fixed(0.25)))
+}
+
+func TestValidateFailureRate(t *testing.T) {
+	assert.NoError(t, validateFailureRate(0))
+	assert.NoError(t, validateFailureRate(12.5))
+	assert.NoError(t, validateFailureRate(100))
+	assert.Error(t, validateFailureRate(-1))
+	assert.Error(t, validateFailureRate(101))
+}
+
+func TestStagingSolverName(t *testing.T) {
+	s := &stagingSolver{&sakuraCloudDNSProviderSolver{}}
+	assert.Equal(t, "sakuracloud-dns-solver-staging", s.Name())
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"encoding/base64"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/template"
+
+	client "github.com/sacloud/api-client-go"
+)
+
+// genSecretTemplate renders the credentials Secret followed by an Issuer
+// snippet. The Issuer is commented out so the output can be piped straight
+// into `kubectl apply -f -` and the snippet copied afterwards.
+var genSecretTemplate = template.Must(template.New("gen-secret").Parse(`apiVersion: v1
+kind: Secret
+metadata:
+  name: {{ .SecretName }}
+  namespace: {{ .Namespace }}
+type: Opaque
+data:
+  accessToken: {{ .AccessToken }}
+  accessTokenSecret: {{ .AccessTokenSecret }}
+# Sample Issuer referencing the Secret above:
+#
+# apiVersion: cert-manager.io/v1
+# kind: Issuer
+# metadata:
+#   name: {{ .IssuerName }}
+#   namespace: {{ .Namespace }}
+# spec:
+#   acme:
+#     server: https://acme-staging-v02.api.letsencrypt.org/directory
+#     email: {{ .Email }}
+#     privateKeySecretRef:
+#       name: {{ .IssuerName }}-account-key
+#     solvers:
+#     - dns01:
+#         webhook:
+#           groupName: {{ .GroupName }}
+#           solverName: sakuracloud-dns-solver
+#           config:
+#             zoneID: {{ .ZoneID }}
+#             accessTokenRef:
+#               name: {{ .SecretName }}
+#               key: accessToken
+#             accessTokenSecretRef:
+#               name: {{ .SecretName }}
+#               key: accessTokenSecret
+`))
+
+type genSecretParams struct {
+	Namespace         string
+	SecretName        string
+	IssuerName        string
+	GroupName         string
+	Email             string
+	ZoneID            string
+	AccessToken       string
+	AccessTokenSecret string
+}
+
+// runGenSecret implements the `gen-secret` command. It reads the API key from
+// the usacloud profile or the SAKURACLOUD_ACCESS_TOKEN(_SECRET) environment
+// variables and prints a Secret manifest plus a sample Issuer.
+func runGenSecret(args []string) error {
+	return genSecret(args, os.Stdout)
+}
+
+func genSecret(args []string, out io.Writer) error {
+	fs := flag.NewFlagSet("gen-secret", flag.ContinueOnError)
+	profile := fs.String("profile", "", "usacloud profile name to read the API key from (defaults to the current profile)")
+	params := genSecretParams{}
+	fs.StringVar(&params.Namespace, "namespace", "default", "namespace of the generated Secret and Issuer")
+	fs.StringVar(&params.SecretName, "secret-name", "sakuracloud-dns-credentials", "name of the generated Secret")
+	fs.StringVar(&params.IssuerName, "issuer-name", "sakuracloud-issuer", "name of the sample Issuer")
+	fs.StringVar(&params.GroupName, "group-name", os.Getenv("GROUP_NAME"), "groupName this webhook is installed with, defaults to $GROUP_NAME")
+	fs.StringVar(&params.Email, "email", "user@example.com", "ACME account email used in the sample Issuer")
+	fs.StringVar(&params.ZoneID, "zone-id", "<さくらのクラウドのDNSゾーンID>", "DNS zone ID used in the sample Issuer")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if params.GroupName == "" {
+		return errors.New("--group-name is required")
+	}
+
+	accessToken, accessTokenSecret, err := profileAPIKey(*profile)
+	if err != nil {
+		return err
+	}
+	params.AccessToken = base64.StdEncoding.EncodeToString([]byte(accessToken))
+	params.AccessTokenSecret = base64.StdEncoding.EncodeToString([]byte(accessTokenSecret))
+
+	return genSecretTemplate.Execute(out, params)
+}
+
+// profileAPIKey reads the API key of the commands from the usacloud profile
+// or the SAKURACLOUD_ACCESS_TOKEN(_SECRET) environment variables.
+func profileAPIKey(profile string) (accessToken, accessTokenSecret string, err error) {
+	opts, err := client.DefaultOptionWithProfile(profile)
+	if err != nil {
+		return "", "", fmt.Errorf("loading sakuracloud profile: %w", err)
+	}
+	if opts.AccessToken == "" || opts.AccessTokenSecret == "" {
+		return "", "", errors.New("API key not found: set SAKURACLOUD_ACCESS_TOKEN and SAKURACLOUD_ACCESS_TOKEN_SECRET or configure a usacloud profile")
+	}
+	return strings.TrimSpace(opts.AccessToken), strings.TrimSpace(opts.AccessTokenSecret), nil
+}
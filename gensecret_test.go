@@ -0,0 +1,34 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGenSecret(t *testing.T) {
+	t.Setenv("SAKURACLOUD_PROFILE_DIR", t.TempDir())
+	t.Setenv("SAKURACLOUD_ACCESS_TOKEN", "token")
+	t.Setenv("SAKURACLOUD_ACCESS_TOKEN_SECRET", "secret")
+	t.Setenv("GROUP_NAME", "")
+
+	var out bytes.Buffer
+	require.NoError(t, genSecret([]string{"--namespace", "acme", "--group-name", "acme.example.com", "--zone-id", "113000000001"}, &out))
+	assert.Contains(t, out.String(), "  namespace: acme\n")
+	assert.Contains(t, out.String(), "  accessToken: dG9rZW4=\n")
+	assert.Contains(t, out.String(), "  accessTokenSecret: c2VjcmV0\n")
+	assert.Contains(t, out.String(), "#           groupName: acme.example.com\n")
+	assert.Contains(t, out.String(), "#             zoneID: 113000000001\n")
+
+	assert.EqualError(t, genSecret(nil, &out), "--group-name is required")
+
+	t.Setenv("GROUP_NAME", "acme.example.org")
+	out.Reset()
+	require.NoError(t, genSecret(nil, &out))
+	assert.Contains(t, out.String(), "#           groupName: acme.example.org\n")
+
+	t.Setenv("SAKURACLOUD_ACCESS_TOKEN", "")
+	assert.ErrorContains(t, genSecret(nil, &out), "API key not found")
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/klog/v2"
+)
+
+var apiServicesResource = schema.GroupVersionResource{Group: "apiregistration.k8s.io", Version: "v1", Resource: "apiservices"}
+
+// groupRegistration is how an API group is registered with the Kubernetes
+// API server, as served on /debug/groups.
+type groupRegistration struct {
+	Group string `json:"group"`
+	// Served is whether the webhook serves the group, as GROUP_NAME or
+	// from --groups-config.
+	Served     bool   `json:"served"`
+	APIService string `json:"apiService,omitempty"`
+	// Service is the namespace/name of the Service the APIService sends
+	// the requests of the group to.
+	Service   string `json:"service,omitempty"`
+	Available bool   `json:"available"`
+	Problem   string `json:"problem,omitempty"`
+}
+
+// groupRegistrations is the result of the last checkGroupRegistrations.
+var groupRegistrations = &groupRegistrationList{}
+
+type groupRegistrationList struct {
+	mu    sync.Mutex
+	items []groupRegistration
+}
+
+func (l *groupRegistrationList) set(items []groupRegistration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.items = items
+}
+
+func (l *groupRegistrationList) list() []groupRegistration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return slices.Clone(l.items)
+}
+
+// checkGroupRegistrations compares the groups the webhook serves, GROUP_NAME
+// first, with the APIServices registered in the cluster, and warns with a
+// remediation hint about every mismatch: cert-manager sends the challenges
+// of an Issuer to the APIService of its groupName, so when GROUP_NAME does
+// not match it every challenge fails, with nothing in the logs of the
+// webhook. APIServices of groups the webhook does not serve are reported if
+// they send to service, the namespace/name of the Service of the webhook,
+// and are not available, which is what the aggregator makes of an
+// APIService whose group the webhook behind it does not serve. Other
+// webhooks may run in the same namespace, so only the Service tells which
+// APIServices are meant for this one. An empty service skips those, and
+// the check of where the APIServices of the served groups send to. It never
+// fails, as the webhook may not be allowed to list APIServices.
+func checkGroupRegistrations(ctx context.Context, client dynamic.Interface, served []string, service string) []groupRegistration {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	list, err := client.Resource(apiServicesResource).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		klog.Warningf("could not check the APIServices of %s: %v", strings.Join(served, ", "), err)
+		return nil
+	}
+
+	registered := map[string]groupRegistration{}
+	for _, item := range list.Items {
+		group, _, _ := unstructured.NestedString(item.Object, "spec", "group")
+		version, _, _ := unstructured.NestedString(item.Object, "spec", "version")
+		if group == "" || version != "v1alpha1" {
+			continue
+		}
+		svcName, _, _ := unstructured.NestedString(item.Object, "spec", "service", "name")
+		svcNamespace, _, _ := unstructured.NestedString(item.Object, "spec", "service", "namespace")
+		if svcName == "" {
+			// Served by the Kubernetes API server itself.
+			continue
+		}
+		registered[group] = groupRegistration{
+			Group:      group,
+			APIService: item.GetName(),
+			Service:    svcNamespace + "/" + svcName,
+			Available:  apiServiceAvailable(&item),
+		}
+	}
+
+	// unserved are the unavailable APIServices of groups the webhook does
+	// not serve that send to its Service. If GROUP_NAME is not
+	// registered, the first of them is likely the group it was meant to
+	// be.
+	var unserved []groupRegistration
+	for group, r := range registered {
+		if service == "" || slices.Contains(served, group) || r.Available || r.Service != service {
+			continue
+		}
+		r.Problem = fmt.Sprintf("APIService %s sends group %s to Service %s of the webhook, which does not serve the group", r.APIService, group, r.Service)
+		unserved = append(unserved, r)
+	}
+	slices.SortFunc(unserved, func(a, b groupRegistration) int { return strings.Compare(a.Group, b.Group) })
+
+	var regs []groupRegistration
+	mentioned := false
+	for i, group := range served {
+		setting := "GROUP_NAME"
+		if i > 0 {
+			setting = "the groupName in --groups-config"
+		}
+		r, ok := registered[group]
+		r.Group, r.Served = group, true
+		switch {
+		case !ok && i == 0 && len(unserved) > 0:
+			r.Problem = fmt.Sprintf("no APIService registers group %s, but %s", group, unserved[0].Problem)
+			mentioned = true
+			klog.Warningf("%s is %s, but no APIService registers that group, and APIService %s sends group %s to Service %s instead: "+
+				"the webhook does not see the challenges of the Issuers with groupName %s. Set %s to %s, the group the APIService registers, or install the Helm chart again with groupName %s",
+				setting, group, unserved[0].APIService, unserved[0].Group, unserved[0].Service, unserved[0].Group, setting, unserved[0].Group, group)
+		case !ok:
+			r.Problem = fmt.Sprintf("no APIService registers group %s", group)
+			klog.Warningf("%s is %s, but no APIService registers that group, so cert-manager cannot send challenges to the webhook. "+
+				"Set %s to the groupName of the Helm chart, or install the webhook again with groupName %s", setting, group, setting, group)
+		case service != "" && r.Service != service:
+			r.Problem = fmt.Sprintf("APIService %s sends group %s to Service %s, not to Service %s of the webhook", r.APIService, group, r.Service, service)
+			klog.Warningf("APIService %s sends the challenges of group %s to Service %s, not to Service %s of the webhook; "+
+				"another installation claims the group. Give each installation its own %s", r.APIService, group, r.Service, service, setting)
+		default:
+			klog.V(2).Infof("APIService %s sends group %s to Service %s", r.APIService, group, r.Service)
+		}
+		regs = append(regs, r)
+	}
+	for i, r := range unserved {
+		if i == 0 && mentioned {
+			continue
+		}
+		klog.Warningf("%s: every challenge of the Issuers with groupName %s fails. Serve the group with --groups-config, or delete the APIService if it is left over from an earlier groupName", r.Problem, r.Group)
+	}
+	return append(regs, unserved...)
+}
+
+// apiServiceAvailable reports whether the Available condition of the
+// APIService is true.
+func apiServiceAvailable(apiService *unstructured.Unstructured) bool {
+	conditions, _, _ := unstructured.NestedSlice(apiService.Object, "status", "conditions")
+	for _, c := range conditions {
+		c, _ := c.(map[string]interface{})
+		if c["type"] == "Available" {
+			return c["status"] == "True"
+		}
+	}
+	return false
+}
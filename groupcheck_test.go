@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	"k8s.io/klog/v2"
+)
+
+func newAPIService(group, namespace, service string, available bool) *unstructured.Unstructured {
+	status := "False"
+	if available {
+		status = "True"
+	}
+	return &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "apiregistration.k8s.io/v1",
+		"kind":       "APIService",
+		"metadata":   map[string]interface{}{"name": "v1alpha1." + group},
+		"spec": map[string]interface{}{
+			"group":   group,
+			"version": "v1alpha1",
+			"service": map[string]interface{}{"name": service, "namespace": namespace},
+		},
+		"status": map[string]interface{}{
+			"conditions": []interface{}{map[string]interface{}{"type": "Available", "status": status}},
+		},
+	}}
+}
+
+func newAPIServiceClient(objects ...runtime.Object) *dynamicfake.FakeDynamicClient {
+	return dynamicfake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(),
+		map[schema.GroupVersionResource]string{apiServicesResource: "APIServiceList"}, objects...)
+}
+
+func TestCheckGroupRegistrations(t *testing.T) {
+	client := newAPIServiceClient(
+		newAPIService("acme.example.com", "cert-manager", "cert-manager-webhook-sakuracloud", true),
+		newAPIService("acme.other.example", "cert-manager", "cert-manager-webhook-sakuracloud", true),
+		// Another webhook in the same namespace, which is down.
+		newAPIService("acme.another.example", "cert-manager", "cert-manager-webhook-another", false),
+	)
+	regs := checkGroupRegistrations(context.Background(), client, []string{"acme.example.com"}, "cert-manager/cert-manager-webhook-sakuracloud")
+	require.Len(t, regs, 1, "available APIServices of other groups and those of other Services are other webhooks")
+	assert.Equal(t, groupRegistration{
+		Group:      "acme.example.com",
+		Served:     true,
+		APIService: "v1alpha1.acme.example.com",
+		Service:    "cert-manager/cert-manager-webhook-sakuracloud",
+		Available:  true,
+	}, regs[0])
+
+	regs = checkGroupRegistrations(context.Background(), client, []string{"acme.example.com"}, "tenant-a/cert-manager-webhook-sakuracloud")
+	require.Len(t, regs, 1)
+	assert.Contains(t, regs[0].Problem, "not to Service tenant-a/cert-manager-webhook-sakuracloud of the webhook")
+}
+
+func TestCheckGroupRegistrationsMismatch(t *testing.T) {
+	buf := captureKlog(t, 0)
+	client := newAPIServiceClient(newAPIService("acme.old.example", "cert-manager", "cert-manager-webhook-sakuracloud", false))
+	regs := checkGroupRegistrations(context.Background(), client, []string{"acme.example.com", "acme.extra.example"}, "cert-manager/cert-manager-webhook-sakuracloud")
+	klog.Flush()
+	require.Len(t, regs, 3)
+	assert.Equal(t, "no APIService registers group acme.example.com, but APIService v1alpha1.acme.old.example sends group acme.old.example to Service cert-manager/cert-manager-webhook-sakuracloud of the webhook, which does not serve the group", regs[0].Problem)
+	assert.Equal(t, "no APIService registers group acme.extra.example", regs[1].Problem)
+	assert.False(t, regs[2].Served)
+	assert.Contains(t, buf.String(), "Set GROUP_NAME to acme.old.example, the group the APIService registers, or install the Helm chart again with groupName acme.example.com")
+	assert.Contains(t, buf.String(), "Set the groupName in --groups-config to the groupName of the Helm chart")
+
+	regs = checkGroupRegistrations(context.Background(), client, []string{"acme.example.com"}, "")
+	require.Len(t, regs, 1, "APIServices of other groups are not attributed without a Service")
+	assert.Equal(t, "no APIService registers group acme.example.com", regs[0].Problem)
+}
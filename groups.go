@@ -0,0 +1,175 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/cert-manager/cert-manager/pkg/acme/webhook"
+	"github.com/cert-manager/cert-manager/pkg/acme/webhook/apiserver"
+	"github.com/cert-manager/cert-manager/pkg/acme/webhook/registry/challengepayload"
+	"github.com/spf13/pflag"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apiserver/pkg/registry/rest"
+	genericapiserver "k8s.io/apiserver/pkg/server"
+	restclient "k8s.io/client-go/rest"
+	"sigs.k8s.io/yaml"
+)
+
+// groupConfig is an extra API group served by the webhook, from the file
+// given with --groups-config.
+type groupConfig struct {
+	// GroupName is the API group Issuers reference in groupName.
+	GroupName string `json:"groupName"`
+	// Args are solver flags for the group, overriding those of the command
+	// line. Only groupScopedFlags may be given.
+	Args []string `json:"args,omitempty"`
+}
+
+// groupScopedFlags are the solver flags that can differ between API groups.
+// The other flags configure what the groups share: clients, caches,
+// background work and the metrics and health endpoints.
+var groupScopedFlags = []string{
+	"allowed-secret-namespaces",
+	"secrets-namespace",
+	"default-ttl",
+	"propagation-check-timeout",
+	"propagation-check-interval",
+	"propagation-nameservers",
+	"propagation-checker",
+	"propagation-resolvers",
+	"propagation-doh-url",
+	"async-propagation-check",
+	"handler-timeout",
+	"inject-failure-rate",
+	"verify-zone-updates",
+	"record-engine",
+	"config-shape",
+}
+
+// loadGroupsConfig reads the extra API groups from path, a YAML file with a
+// list of groups under "groups".
+func loadGroupsConfig(path string) ([]groupConfig, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var file struct {
+		Groups []groupConfig `json:"groups"`
+	}
+	if err := yaml.UnmarshalStrict(data, &file); err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", path, err)
+	}
+	seen := map[string]bool{}
+	for _, g := range file.Groups {
+		if g.GroupName == "" {
+			return nil, fmt.Errorf("%s: a group has no groupName", path)
+		}
+		if seen[g.GroupName] {
+			return nil, fmt.Errorf("%s: group %s is listed twice", path, g.GroupName)
+		}
+		seen[g.GroupName] = true
+	}
+	return file.Groups, nil
+}
+
+// forGroup returns a copy of o with the flags in args applied.
+func (o *solverOptions) forGroup(args []string) (*solverOptions, error) {
+	g := *o
+	fs := pflag.NewFlagSet("group", pflag.ContinueOnError)
+	g.addSolverFlags(fs)
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+	if fs.NArg() > 0 {
+		return nil, fmt.Errorf("unexpected arguments %s", strings.Join(fs.Args(), " "))
+	}
+	var err error
+	fs.Visit(func(f *pflag.Flag) {
+		if err == nil && !slices.Contains(groupScopedFlags, f.Name) {
+			err = fmt.Errorf("--%s cannot be set per group", f.Name)
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	warnDeprecatedFlags(fs, false)
+	if err := validateFailureRate(g.InjectFailureRate); err != nil {
+		return nil, err
+	}
+	if !slices.Contains(recordEngines, g.RecordEngine) {
+		return nil, fmt.Errorf("unknown --record-engine %q, use one of %s", g.RecordEngine, strings.Join(recordEngines, ", "))
+	}
+	if !slices.Contains(configShapes, g.ConfigShape) {
+		return nil, fmt.Errorf("unknown --config-shape %q, use one of %s", g.ConfigShape, strings.Join(configShapes, ", "))
+	}
+	if !slices.Contains(propagationCheckers, g.PropagationChecker) {
+		return nil, fmt.Errorf("unknown --propagation-checker %q, use one of %s", g.PropagationChecker, strings.Join(propagationCheckers, ", "))
+	}
+	return &g, nil
+}
+
+// groupSolver is the solver of an extra API group. It shares everything
+// but its options with the solver of the main group, which it copies once
+// that one is initialized.
+type groupSolver struct {
+	*sakuraCloudDNSProviderSolver
+	main *sakuraCloudDNSProviderSolver
+}
+
+func newGroupSolver(main *sakuraCloudDNSProviderSolver, opts *solverOptions) *groupSolver {
+	main.groups = append(main.groups, opts)
+	return &groupSolver{sakuraCloudDNSProviderSolver: &sakuraCloudDNSProviderSolver{opts: opts}, main: main}
+}
+
+// Initialize waits for the solver of the main group, as post-start hooks
+// run concurrently.
+func (g *groupSolver) Initialize(_ *restclient.Config, stopCh <-chan struct{}) error {
+	select {
+	case <-g.main.initialized:
+	case <-stopCh:
+		return nil
+	}
+	opts := g.opts
+	*g.sakuraCloudDNSProviderSolver = *g.main
+	g.opts = opts
+	return nil
+}
+
+// shutdown does nothing: the solver of the main group cleans up.
+func (g *groupSolver) shutdown() {}
+
+// installSolverGroup serves solvers under group as well. The server of
+// cert-manager only installs one group; this adds the others the same way
+// and initializes their solvers as post-start hooks.
+func installSolverGroup(s *genericapiserver.GenericAPIServer, group string, solvers ...webhook.Solver) error {
+	gv := schema.GroupVersion{Group: group, Version: "v1alpha1"}
+	storage := map[string]rest.Storage{}
+	for _, solver := range solvers {
+		storage[solver.Name()] = challengepayload.NewREST(solver)
+	}
+	info := genericapiserver.APIGroupInfo{
+		PrioritizedVersions:          []schema.GroupVersion{gv},
+		VersionedResourcesStorageMap: map[string]map[string]rest.Storage{gv.Version: storage},
+		OptionsExternalVersion:       &schema.GroupVersion{Version: gv.Version},
+		Scheme:                       apiserver.Scheme,
+		ParameterCodec:               metav1.ParameterCodec,
+		NegotiatedSerializer:         apiserver.Codecs,
+	}
+	if err := s.InstallAPIGroup(&info); err != nil {
+		return fmt.Errorf("installing API group %s: %w", group, err)
+	}
+
+	for _, solver := range solvers {
+		solver := solver
+		err := s.AddPostStartHook(fmt.Sprintf("solver-%s-%s-init", group, solver.Name()), func(ctx genericapiserver.PostStartHookContext) error {
+			return solver.Initialize(nil, ctx.StopCh)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
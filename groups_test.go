@@ -0,0 +1,68 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoadGroupsConfig(t *testing.T) {
+	write := func(t *testing.T, data string) string {
+		path := filepath.Join(t.TempDir(), "groups.yaml")
+		require.NoError(t, os.WriteFile(path, []byte(data), 0o600))
+		return path
+	}
+
+	groups, err := loadGroupsConfig(write(t, `
+groups:
+  - groupName: acme.team-a.example.com
+    args: [--default-ttl=120]
+  - groupName: acme.team-b.example.com
+`))
+	require.NoError(t, err)
+	assert.Equal(t, []groupConfig{
+		{GroupName: "acme.team-a.example.com", Args: []string{"--default-ttl=120"}},
+		{GroupName: "acme.team-b.example.com"},
+	}, groups)
+
+	_, err = loadGroupsConfig(write(t, "groups:\n  - groupName: a\n  - groupName: a\n"))
+	assert.ErrorContains(t, err, "listed twice")
+	_, err = loadGroupsConfig(write(t, "groups:\n  - args: [--default-ttl=1]\n"))
+	assert.ErrorContains(t, err, "no groupName")
+	_, err = loadGroupsConfig(write(t, "groups:\n  - groupName: a\n    flags: []\n"))
+	assert.Error(t, err, "unknown fields are rejected")
+}
+
+func TestSolverOptionsForGroup(t *testing.T) {
+	opts := newSolverOptions()
+	opts.AllowedSecretNamespaces = []string{"shared"}
+	opts.RegistryConfigMap = "registry"
+
+	g, err := opts.forGroup([]string{"--allowed-secret-namespaces=team-a", "--default-ttl=120"})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"team-a"}, g.AllowedSecretNamespaces)
+	assert.Equal(t, 120, g.DefaultTTL)
+	assert.Equal(t, "registry", g.RegistryConfigMap, "other options are inherited")
+	assert.Equal(t, []string{"shared"}, opts.AllowedSecretNamespaces, "the main options are unchanged")
+	assert.Equal(t, 60, opts.DefaultTTL)
+
+	_, err = opts.forGroup([]string{"--registry-configmap=other"})
+	assert.ErrorContains(t, err, "--registry-configmap cannot be set per group")
+	_, err = opts.forGroup([]string{"--propagation-checker=carrier-pigeon"})
+	assert.ErrorContains(t, err, "unknown --propagation-checker")
+	g, err = opts.forGroup([]string{"--record-engine=v1"})
+	require.NoError(t, err)
+	assert.Equal(t, "v1", g.RecordEngine)
+	_, err = opts.forGroup([]string{"--record-engine=v3"})
+	assert.ErrorContains(t, err, "unknown --record-engine")
+	g, err = opts.forGroup([]string{"--config-shape=cert-manager"})
+	require.NoError(t, err)
+	assert.Equal(t, "cert-manager", g.ConfigShape)
+	_, err = opts.forGroup([]string{"--config-shape=route53"})
+	assert.ErrorContains(t, err, "unknown --config-shape")
+	_, err = opts.forGroup([]string{"extra"})
+	assert.ErrorContains(t, err, "unexpected arguments")
+}
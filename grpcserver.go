@@ -0,0 +1,257 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/cert-manager/cert-manager/pkg/acme/webhook"
+	"github.com/cert-manager/cert-manager/pkg/acme/webhook/apis/acme/v1alpha1"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/emptypb"
+	"google.golang.org/protobuf/types/known/structpb"
+	"k8s.io/klog/v2"
+)
+
+// challengeService is the gRPC service of the solver, for ACME clients
+// outside of Kubernetes such as lego or acme.sh hooks. See
+// api/solver.proto; its requests are ChallengeRequests in the JSON form
+// cert-manager sends, carried in a google.protobuf.Struct so that the
+// service needs no generated code.
+type challengeService interface {
+	Present(ctx context.Context, req *structpb.Struct) (*emptypb.Empty, error)
+	CleanUp(ctx context.Context, req *structpb.Struct) (*emptypb.Empty, error)
+}
+
+const challengeServiceName = "sakuracloud.webhook.v1.Solver"
+
+var challengeServiceDesc = grpc.ServiceDesc{
+	ServiceName: challengeServiceName,
+	HandlerType: (*challengeService)(nil),
+	Methods: []grpc.MethodDesc{
+		{MethodName: "Present", Handler: challengeHandler("Present", challengeService.Present)},
+		{MethodName: "CleanUp", Handler: challengeHandler("CleanUp", challengeService.CleanUp)},
+	},
+	Metadata: "api/solver.proto",
+}
+
+// challengeHandler adapts method of challengeService to a grpc.MethodDesc
+// handler.
+func challengeHandler(name string, method func(challengeService, context.Context, *structpb.Struct) (*emptypb.Empty, error)) func(any, context.Context, func(any) error, grpc.UnaryServerInterceptor) (any, error) {
+	return func(srv any, ctx context.Context, dec func(any) error, interceptor grpc.UnaryServerInterceptor) (any, error) {
+		req := new(structpb.Struct)
+		if err := dec(req); err != nil {
+			return nil, err
+		}
+		if interceptor == nil {
+			return method(srv.(challengeService), ctx, req)
+		}
+		info := &grpc.UnaryServerInfo{Server: srv, FullMethod: "/" + challengeServiceName + "/" + name}
+		return interceptor(ctx, req, info, func(ctx context.Context, req any) (any, error) {
+			return method(srv.(challengeService), ctx, req.(*structpb.Struct))
+		})
+	}
+}
+
+// grpcSolver serves a webhook.Solver as challengeService to the clients
+// allowed by clients.
+type grpcSolver struct {
+	solver  webhook.Solver
+	clients grpcClients
+}
+
+// contextSolver is implemented by solvers that abort a challenge with the
+// context of its request, for clients that give up or go away.
+type contextSolver interface {
+	presentContext(ctx context.Context, ch *v1alpha1.ChallengeRequest) error
+	cleanUpContext(ctx context.Context, ch *v1alpha1.ChallengeRequest) error
+}
+
+func (s *grpcSolver) Present(ctx context.Context, req *structpb.Struct) (*emptypb.Empty, error) {
+	ch, err := s.challengeRequest(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	if cs, ok := s.solver.(contextSolver); ok {
+		return &emptypb.Empty{}, grpcError(cs.presentContext(ctx, ch))
+	}
+	return &emptypb.Empty{}, grpcError(s.solver.Present(ch))
+}
+
+func (s *grpcSolver) CleanUp(ctx context.Context, req *structpb.Struct) (*emptypb.Empty, error) {
+	ch, err := s.challengeRequest(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	if cs, ok := s.solver.(contextSolver); ok {
+		return &emptypb.Empty{}, grpcError(cs.cleanUpContext(ctx, ch))
+	}
+	return &emptypb.Empty{}, grpcError(s.solver.CleanUp(ch))
+}
+
+// challengeRequest decodes req and checks that the client of ctx may use
+// its resourceNamespace.
+func (s *grpcSolver) challengeRequest(ctx context.Context, req *structpb.Struct) (*v1alpha1.ChallengeRequest, error) {
+	ch, err := decodeChallengeRequest(req)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.clients.authorize(ctx, ch.ResourceNamespace); err != nil {
+		return nil, err
+	}
+	return ch, nil
+}
+
+// grpcClients maps the identities of the client certificates of the gRPC
+// service to the namespaces (or path.Match patterns) whose Secrets they may
+// use, from --grpc-client-namespaces. The client CA alone does not limit
+// them: like the namespace of an Issuer, the resourceNamespace of a request
+// selects the API keys it writes with.
+type grpcClients map[string][]string
+
+// parseGRPCClients parses entries of the form identity=namespace.
+func parseGRPCClients(entries []string) (grpcClients, error) {
+	clients := grpcClients{}
+	for _, e := range entries {
+		identity, ns, ok := strings.Cut(e, "=")
+		if !ok || identity == "" || ns == "" {
+			return nil, fmt.Errorf("--grpc-client-namespaces entry %q is not identity=namespace", e)
+		}
+		if _, err := path.Match(ns, ""); err != nil {
+			return nil, fmt.Errorf("--grpc-client-namespaces entry %q: %w", e, err)
+		}
+		clients[identity] = append(clients[identity], ns)
+	}
+	return clients, nil
+}
+
+// authorize checks that the client certificate of ctx has an identity, its
+// common name or a DNS or URI name, allowed to use namespace ns.
+func (c grpcClients) authorize(ctx context.Context, ns string) error {
+	identities := clientIdentities(ctx)
+	if len(identities) == 0 {
+		return status.Error(codes.Unauthenticated, "no verified client certificate")
+	}
+	for _, id := range identities {
+		for _, pattern := range c[id] {
+			if ok, _ := path.Match(pattern, ns); ok {
+				return nil
+			}
+		}
+	}
+	return status.Errorf(codes.PermissionDenied, "client %s may not use resourceNamespace %q, see --grpc-client-namespaces", identities[0], ns)
+}
+
+// clientIdentities returns the common name and the DNS and URI names of the
+// verified client certificate of ctx.
+func clientIdentities(ctx context.Context) []string {
+	p, ok := peer.FromContext(ctx)
+	if !ok {
+		return nil
+	}
+	info, ok := p.AuthInfo.(credentials.TLSInfo)
+	if !ok || len(info.State.VerifiedChains) == 0 || len(info.State.VerifiedChains[0]) == 0 {
+		return nil
+	}
+	cert := info.State.VerifiedChains[0][0]
+	var ids []string
+	if cert.Subject.CommonName != "" {
+		ids = append(ids, cert.Subject.CommonName)
+	}
+	ids = append(ids, cert.DNSNames...)
+	for _, u := range cert.URIs {
+		ids = append(ids, u.String())
+	}
+	return ids
+}
+
+// decodeChallengeRequest reads the ChallengeRequest carried by req.
+func decodeChallengeRequest(req *structpb.Struct) (*v1alpha1.ChallengeRequest, error) {
+	data, err := req.MarshalJSON()
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "encoding the challenge request: %v", err)
+	}
+	ch := new(v1alpha1.ChallengeRequest)
+	if err := json.Unmarshal(data, ch); err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "decoding the challenge request: %v", err)
+	}
+	return ch, nil
+}
+
+// grpcError maps an error of the solver to a gRPC status: retriable errors
+// become Unavailable, so clients know to try again later.
+func grpcError(err error) error {
+	if err == nil {
+		return nil
+	}
+	var retriable *retriableError
+	if errors.As(err, &retriable) {
+		return status.Error(codes.Unavailable, err.Error())
+	}
+	return status.Error(codes.Unknown, err.Error())
+}
+
+// newGRPCServer returns a gRPC server serving solver as challengeService to
+// clients.
+func newGRPCServer(solver webhook.Solver, clients grpcClients, opts ...grpc.ServerOption) *grpc.Server {
+	srv := grpc.NewServer(opts...)
+	srv.RegisterService(&challengeServiceDesc, &grpcSolver{solver: solver, clients: clients})
+	return srv
+}
+
+// grpcServerTLS loads the serving certificate and requires clients to
+// present a certificate signed by the CA in clientCAFile. The service writes
+// to zones with Secrets of the cluster, so it is never served without
+// client authentication.
+func grpcServerTLS(certFile, keyFile, clientCAFile string) (*tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("loading --grpc-tls-cert-file and --grpc-tls-key-file: %w", err)
+	}
+	ca, err := os.ReadFile(clientCAFile)
+	if err != nil {
+		return nil, fmt.Errorf("--grpc-client-ca-file: %w", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(ca) {
+		return nil, fmt.Errorf("--grpc-client-ca-file %s holds no PEM certificates", clientCAFile)
+	}
+	return &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		ClientCAs:    pool,
+		MinVersion:   tls.VersionTLS12,
+	}, nil
+}
+
+// serveGRPC serves solver over gRPC on addr to clients in the background
+// until stopCh is closed.
+func serveGRPC(addr string, tlsConfig *tls.Config, solver webhook.Solver, clients grpcClients, stopCh <-chan struct{}) error {
+	lis, err := net.Listen("tcp", addr)
+	if err != nil {
+		return fmt.Errorf("--grpc-bind-address: %w", err)
+	}
+	srv := newGRPCServer(solver, clients, grpc.Creds(credentials.NewTLS(tlsConfig)))
+	go func() {
+		klog.Infof("serving the gRPC solver on %s", addr)
+		if err := srv.Serve(lis); err != nil {
+			klog.Errorf("gRPC solver server failed: %v", err)
+		}
+	}()
+	go func() {
+		<-stopCh
+		srv.GracefulStop()
+	}()
+	return nil
+}
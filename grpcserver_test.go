@@ -0,0 +1,172 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/cert-manager/cert-manager/pkg/acme/webhook"
+	"github.com/cert-manager/cert-manager/pkg/acme/webhook/apis/acme/v1alpha1"
+	"github.com/cert-manager/webhook-example/pkg/fake"
+	"github.com/cert-manager/webhook-example/pkg/legoprovider"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+	"google.golang.org/protobuf/types/known/emptypb"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+// dialGRPCSolver serves solver over an in-memory connection, as if the
+// client presented a certificate for identity, and returns a client
+// connection to it.
+func dialGRPCSolver(t *testing.T, solver webhook.Solver, clients grpcClients, identity string) *grpc.ClientConn {
+	lis := bufconn.Listen(1 << 16)
+	var opts []grpc.ServerOption
+	if identity != "" {
+		cert := &x509.Certificate{Subject: pkix.Name{CommonName: identity}}
+		opts = append(opts, grpc.UnaryInterceptor(func(ctx context.Context, req any, _ *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+			p, _ := peer.FromContext(ctx)
+			p.AuthInfo = credentials.TLSInfo{State: tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{cert}}}}
+			return handler(peer.NewContext(ctx, p), req)
+		}))
+	}
+	srv := newGRPCServer(solver, clients, opts...)
+	go func() { _ = srv.Serve(lis) }()
+	t.Cleanup(srv.Stop)
+
+	conn, err := grpc.DialContext(context.Background(), "bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return lis.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+func TestGRPCSolver(t *testing.T) {
+	solver := fake.New("", "example.com")
+	conn := dialGRPCSolver(t, solver, grpcClients{"client": {"*"}}, "client")
+
+	call := func(method string, fields map[string]any) error {
+		req, err := structpb.NewStruct(fields)
+		require.NoError(t, err)
+		return conn.Invoke(context.Background(), "/sakuracloud.webhook.v1.Solver/"+method, req, &emptypb.Empty{})
+	}
+	ch := map[string]any{
+		"resolvedFQDN": "_acme-challenge.example.com.",
+		"resolvedZone": "example.com.",
+		"key":          "key",
+		"config":       map[string]any{"zoneID": 1},
+	}
+
+	require.NoError(t, call("Present", ch))
+	assert.Equal(t, []string{"key"}, solver.Zones.TXT("_acme-challenge.example.com."))
+	require.NoError(t, call("CleanUp", ch))
+	assert.Empty(t, solver.Zones.TXT("_acme-challenge.example.com."))
+
+	ch["resolvedZone"] = "example.org."
+	assert.Equal(t, codes.Unknown, status.Code(call("Present", ch)))
+	ch["resolvedZone"] = 1
+	assert.Equal(t, codes.InvalidArgument, status.Code(call("Present", ch)))
+}
+
+func TestGRPCSolverLegoProvider(t *testing.T) {
+	solver := fake.New("", "example.com")
+	p := legoprovider.New(legoprovider.NewGRPCSolver(dialGRPCSolver(t, solver, grpcClients{"lego": {"acme-*"}}, "lego")), "acme-clients", []byte(`{"zoneID": 1}`))
+	p.SetNameservers(serveSOA(t, func() uint32 { return 1 }))
+
+	require.NoError(t, p.Present("www.example.com", "token", "keyAuth"))
+	assert.Equal(t, []string{legoprovider.Value("keyAuth")}, solver.Zones.TXT("_acme-challenge.www.example.com."))
+	require.NoError(t, p.CleanUp("www.example.com", "token", "keyAuth"))
+	assert.Empty(t, solver.Zones.TXT("_acme-challenge.www.example.com."))
+}
+
+func TestGRPCError(t *testing.T) {
+	assert.NoError(t, grpcError(nil))
+	assert.Equal(t, codes.Unknown, status.Code(grpcError(errors.New("boom"))))
+	assert.Equal(t, codes.Unavailable, status.Code(grpcError(&retriableError{reason: "maintenance", err: errors.New("503")})))
+}
+
+func TestDecodeChallengeRequest(t *testing.T) {
+	req, err := structpb.NewStruct(map[string]any{
+		"resolvedFQDN":      "_acme-challenge.example.com.",
+		"resourceNamespace": "acme",
+		"config":            map[string]any{"zoneID": 1},
+	})
+	require.NoError(t, err)
+	ch, err := decodeChallengeRequest(req)
+	require.NoError(t, err)
+	assert.Equal(t, &v1alpha1.ChallengeRequest{
+		ResolvedFQDN:      "_acme-challenge.example.com.",
+		ResourceNamespace: "acme",
+		Config:            ch.Config,
+	}, ch)
+	assert.JSONEq(t, `{"zoneID": 1}`, string(ch.Config.Raw))
+}
+
+func TestGRPCClientNamespaces(t *testing.T) {
+	clients, err := parseGRPCClients([]string{"lego=acme", "lego=team-*", "spiffe://cluster/ns/ci/sa/acme=ci"})
+	require.NoError(t, err)
+	assert.Equal(t, grpcClients{"lego": {"acme", "team-*"}, "spiffe://cluster/ns/ci/sa/acme": {"ci"}}, clients)
+	_, err = parseGRPCClients([]string{"lego"})
+	assert.ErrorContains(t, err, "is not identity=namespace")
+	_, err = parseGRPCClients([]string{"lego=["})
+	assert.Error(t, err)
+
+	solver := fake.New("", "example.com")
+	call := func(conn *grpc.ClientConn, ns string) error {
+		req, err := structpb.NewStruct(map[string]any{
+			"resolvedFQDN":      "_acme-challenge.example.com.",
+			"resolvedZone":      "example.com.",
+			"resourceNamespace": ns,
+			"key":               "key",
+		})
+		require.NoError(t, err)
+		return conn.Invoke(context.Background(), "/sakuracloud.webhook.v1.Solver/Present", req, &emptypb.Empty{})
+	}
+	conn := dialGRPCSolver(t, solver, clients, "lego")
+	assert.NoError(t, call(conn, "acme"))
+	assert.NoError(t, call(conn, "team-a"))
+	err = call(conn, "kube-system")
+	assert.Equal(t, codes.PermissionDenied, status.Code(err), "the Secrets of other namespaces cannot be used")
+	assert.ErrorContains(t, err, `client lego may not use resourceNamespace "kube-system"`)
+	assert.Equal(t, codes.PermissionDenied, status.Code(call(dialGRPCSolver(t, solver, clients, "other"), "acme")))
+	assert.Equal(t, codes.Unauthenticated, status.Code(call(dialGRPCSolver(t, solver, clients, ""), "acme")))
+}
+
+// contextRecordingSolver records the context of the challenges it handles.
+type contextRecordingSolver struct {
+	*fake.Solver
+	deadline chan bool
+}
+
+func (s *contextRecordingSolver) presentContext(ctx context.Context, _ *v1alpha1.ChallengeRequest) error {
+	_, ok := ctx.Deadline()
+	s.deadline <- ok
+	return nil
+}
+
+func (s *contextRecordingSolver) cleanUpContext(context.Context, *v1alpha1.ChallengeRequest) error {
+	return nil
+}
+
+func TestGRPCSolverContext(t *testing.T) {
+	solver := &contextRecordingSolver{Solver: fake.New(""), deadline: make(chan bool, 1)}
+	conn := dialGRPCSolver(t, solver, grpcClients{"client": {"*"}}, "client")
+	req, err := structpb.NewStruct(map[string]any{"resolvedFQDN": "_acme-challenge.example.com."})
+	require.NoError(t, err)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	require.NoError(t, conn.Invoke(ctx, "/sakuracloud.webhook.v1.Solver/Present", req, &emptypb.Empty{}))
+	assert.True(t, <-solver.deadline, "the deadline of the client reaches the solver")
+}
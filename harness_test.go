@@ -0,0 +1,220 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/cert-manager/cert-manager/pkg/acme/webhook/apis/acme/v1alpha1"
+	sctesting "github.com/cert-manager/webhook-example/pkg/testing"
+	mdns "github.com/miekg/dns"
+	"github.com/sacloud/iaas-api-go"
+	"github.com/sacloud/iaas-api-go/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	extapi "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+)
+
+// newHarnessSolver returns a solver reading its API keys from secrets and
+// writing to zones, as Initialize would set it up without a cluster.
+func newHarnessSolver(zones *sctesting.ZoneClient, secrets *sctesting.SecretStore) *sakuraCloudDNSProviderSolver {
+	opts := newSolverOptions()
+	opts.PropagationCheckTimeout = 0
+	opts.ZoneBatchWindow = 20 * time.Millisecond
+	opts.AllowedSecretNamespaces = []string{"*"}
+	return &sakuraCloudDNSProviderSolver{
+		opts:          opts,
+		secretsClient: secrets.Client(),
+		batcher:       newZoneBatcher(opts.ZoneBatchWindow),
+		queue:         newZoneQueue(opts.ZoneWorkers),
+		presented:     newPresentCache(opts.PresentCacheTTL),
+		metadata:      newZoneMetadataCache(opts.ZoneMetadataCacheTTL),
+		history:       newZoneHistory(opts.ZoneHistorySize),
+		newZoneAPI:    func(string, string) zoneAPI { return zones },
+		verifications: &sync.WaitGroup{},
+	}
+}
+
+// newHarness returns a harness solver writing to zones, with the API key of
+// the Secret creds in the namespace acme that harnessChallenge refers to.
+func newHarness(t *testing.T, zones *sctesting.ZoneClient) *sakuraCloudDNSProviderSolver {
+	secrets := sctesting.NewSecretStore()
+	require.NoError(t, secrets.SetAPIKey("acme", "creds", "token", "secret"))
+	return newHarnessSolver(zones, secrets)
+}
+
+// serveZoneDoH serves the TXT records of zone 1 of zones over DNS over
+// HTTPS, and makes the propagation checks of c query it.
+func serveZoneDoH(t *testing.T, c *sakuraCloudDNSProviderSolver, zones *sctesting.ZoneClient) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		require.NoError(t, err)
+		req := new(mdns.Msg)
+		require.NoError(t, req.Unpack(body))
+		m := new(mdns.Msg)
+		m.SetReply(req)
+		zone := zones.Zone(1)
+		for _, q := range req.Question {
+			for _, r := range zone.Records {
+				if r.Type == types.DNSRecordTypes.TXT && r.Name+"."+zone.Name+"." == q.Name {
+					m.Answer = append(m.Answer, &mdns.TXT{
+						Hdr: mdns.RR_Header{Name: q.Name, Rrtype: mdns.TypeTXT, Class: mdns.ClassINET, Ttl: 1},
+						Txt: []string{txtValue(r.RData)},
+					})
+				}
+			}
+		}
+		out, err := m.Pack()
+		require.NoError(t, err)
+		_, _ = w.Write(out)
+	}))
+	t.Cleanup(srv.Close)
+	c.opts.PropagationChecker = "doh"
+	c.opts.PropagationDoHURL = srv.URL
+	c.opts.PropagationCheckInterval = 10 * time.Millisecond
+}
+
+func harnessChallenge(i int) *v1alpha1.ChallengeRequest {
+	return &v1alpha1.ChallengeRequest{
+		ResolvedFQDN:      fmt.Sprintf("_acme-challenge.host%d.example.com.", i),
+		ResolvedZone:      "example.com.",
+		ResourceNamespace: "acme",
+		Key:               fmt.Sprintf("key%d", i),
+		Config: &extapi.JSON{Raw: []byte(`{"zoneID": 1,
+			"accessTokenRef": {"name": "creds", "key": "accessToken"},
+			"accessTokenSecretRef": {"name": "creds", "key": "accessTokenSecret"}}`)},
+	}
+}
+
+func TestConcurrentPresents(t *testing.T) {
+	zones := sctesting.NewZoneClient(&iaas.DNS{ID: 1, Name: "example.com"})
+	zones.SetLatency(5 * time.Millisecond)
+	c := newHarness(t, zones)
+	clock := sctesting.NewClock(time.Now())
+	c.batcher.after = clock.After
+
+	// run runs fn for the 5 challenges at once, in one batch: the window
+	// only passes once all of them wait in it.
+	run := func(fn func(ch *v1alpha1.ChallengeRequest) error) {
+		var wg sync.WaitGroup
+		for i := 0; i < 5; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				assert.NoError(t, fn(harnessChallenge(i)))
+			}(i)
+		}
+		require.Eventually(t, func() bool { return c.batcher.len() == 5 }, 5*time.Second, time.Millisecond)
+		clock.Step(c.opts.ZoneBatchWindow)
+		wg.Wait()
+	}
+
+	run(c.Present)
+	assert.Len(t, zones.Zone(1).Records, 5)
+	assert.Equal(t, 1, zones.Updates(1), "edits are batched")
+
+	run(c.CleanUp)
+	assert.Empty(t, zones.Zone(1).Records)
+	assert.Equal(t, 2, zones.Updates(1))
+	assert.Equal(t, 1, zones.MaxConcurrentUpdates(1), "updates of a zone are serialized")
+}
+
+func TestPresentConflict(t *testing.T) {
+	zones := sctesting.NewZoneClient(&iaas.DNS{ID: 1, Name: "example.com"})
+	c := newHarness(t, zones)
+
+	zones.Conflict(1, 1)
+	assert.ErrorContains(t, c.Present(harnessChallenge(0)), "423")
+	assert.Empty(t, zones.Zone(1).Records)
+	require.NoError(t, c.Present(harnessChallenge(0)), "cert-manager retries")
+	assert.Len(t, zones.Zone(1).Records, 1)
+
+	// A record written by another client between the read and the update
+	// of the solver is not overwritten.
+	www := &iaas.DNSRecord{Name: "www", Type: types.DNSRecordTypes.A, RData: "192.0.2.1", TTL: 300}
+	zones.ConcurrentWrite(1, func(records iaas.DNSRecords) iaas.DNSRecords { return append(records, www) })
+	assert.ErrorContains(t, c.Present(harnessChallenge(1)), "409")
+	require.NoError(t, c.Present(harnessChallenge(1)), "cert-manager retries")
+	assert.Len(t, zones.Zone(1).Records, 3)
+	assert.Contains(t, zones.Zone(1).Records, www)
+}
+
+func TestAsyncPropagationCheck(t *testing.T) {
+	zones := sctesting.NewZoneClient(&iaas.DNS{ID: 1, Name: "example.com"})
+	c := newHarness(t, zones)
+	serveZoneDoH(t, c, zones)
+	c.opts.AsyncPropagationCheck = true
+	c.opts.PropagationCheckTimeout = 100 * time.Millisecond
+
+	require.NoError(t, c.Present(harnessChallenge(0)))
+	require.NoError(t, c.CleanUp(harnessChallenge(0)), "cert-manager cleans up before the check ends")
+	c.verifications.Wait()
+	assert.Empty(t, zones.Zone(1).Records, "the cleaned up record is not presented again")
+	assert.Equal(t, 2, zones.Updates(1))
+
+	unavailable := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer unavailable.Close()
+	c.opts.PropagationDoHURL = unavailable.URL
+	c.opts.PropagationCheckTimeout = time.Hour
+	ctx, stop := context.WithCancel(context.Background())
+	c.stopped = ctx
+	require.NoError(t, c.Present(harnessChallenge(1)))
+	stop()
+	shutdown := make(chan struct{})
+	go func() {
+		c.shutdown()
+		close(shutdown)
+	}()
+	select {
+	case <-shutdown:
+	case <-time.After(5 * time.Second):
+		t.Fatal("shutdown did not stop the propagation check")
+	}
+}
+
+func TestDrainWaitsForPropagationChecks(t *testing.T) {
+	zones := sctesting.NewZoneClient(&iaas.DNS{ID: 1, Name: "example.com"})
+	c := newHarness(t, zones)
+	serveZoneDoH(t, c, zones)
+	unavailable := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer unavailable.Close()
+	c.opts.PropagationDoHURL = unavailable.URL
+	c.opts.AsyncPropagationCheck = true
+	c.opts.PropagationCheckTimeout = 200 * time.Millisecond
+	c.drain = newDrainer(0, 5*time.Second)
+
+	require.NoError(t, c.Present(harnessChallenge(0)))
+	drained := make(chan int)
+	go func() { drained <- c.drain.drain(context.Background()) }()
+	select {
+	case <-drained:
+		t.Fatal("drained with a propagation check running")
+	case <-time.After(50 * time.Millisecond):
+	}
+	assert.Equal(t, 0, <-drained)
+	c.verifications.Wait()
+}
+
+func TestPresentAtZoneApex(t *testing.T) {
+	// A zone delegated for the challenge records of example.com only.
+	zones := sctesting.NewZoneClient(&iaas.DNS{ID: 1, Name: "_acme-challenge.example.com"})
+	c := newHarness(t, zones)
+	ch := harnessChallenge(0)
+	ch.ResolvedFQDN, ch.ResolvedZone = "_acme-challenge.example.com.", "_acme-challenge.example.com."
+
+	require.NoError(t, c.Present(ch))
+	records := zones.Zone(1).Records
+	require.Len(t, records, 1)
+	assert.Equal(t, "@", records[0].Name)
+	require.NoError(t, c.CleanUp(ch))
+	assert.Empty(t, zones.Zone(1).Records)
+}
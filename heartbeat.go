@@ -0,0 +1,248 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	coordinationv1 "k8s.io/api/coordination/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/klog/v2"
+)
+
+const (
+	heartbeatComponentLabel    = "app.kubernetes.io/component"
+	heartbeatComponent         = "cert-manager-webhook-sakuracloud-heartbeat"
+	heartbeatInstallationKey   = "cert-manager-webhook-sakuracloud/installation"
+	heartbeatGroupsKey         = "cert-manager-webhook-sakuracloud/groups"
+	heartbeatZonesKey          = "cert-manager-webhook-sakuracloud/zones"
+	heartbeatZoneWriteLifetime = 24 * time.Hour
+)
+
+// installationHeartbeat detects other installations of the webhook that
+// write to the same zones for the same API groups, a common cause of
+// challenge records that appear and disappear: every replica renews a Lease
+// listing its installation, groups and the zones it wrote to in the last
+// day, and looks at the Leases of the other installations. Leases that were
+// not renewed in time are deleted by the others. A nil heartbeat records
+// nothing.
+type installationHeartbeat struct {
+	client       kubernetes.Interface
+	namespace    string
+	installation string
+	holder       string
+	groups       []string
+	interval     time.Duration
+	now          func() time.Time
+
+	mu     sync.Mutex
+	writes map[int64]time.Time
+}
+
+// installationID identifies the installation the process belongs to, the
+// same for all its replicas: the UID of the kube-system namespace, which
+// identifies the cluster, the namespace ns the webhook runs in, and the
+// name of its Service, which tells the installations in one namespace
+// apart.
+func installationID(ctx context.Context, client kubernetes.Interface, ns, service string) (string, error) {
+	if service == "" {
+		return "", errors.New("--installation-id or --service-name is required to tell the installations in one namespace apart")
+	}
+	kubeSystem, err := client.CoreV1().Namespaces().Get(ctx, metav1.NamespaceSystem, metav1.GetOptions{})
+	if err != nil {
+		return "", fmt.Errorf("reading the UID of the cluster, set --installation-id instead: %w", err)
+	}
+	return fmt.Sprintf("%s/%s/%s", kubeSystem.UID, ns, service), nil
+}
+
+func newInstallationHeartbeat(client kubernetes.Interface, namespace, installation string, groups []string, interval time.Duration) *installationHeartbeat {
+	holder, _ := os.Hostname()
+	return &installationHeartbeat{
+		client:       client,
+		namespace:    namespace,
+		installation: installation,
+		holder:       holder,
+		groups:       groups,
+		interval:     interval,
+		now:          time.Now,
+		writes:       map[int64]time.Time{},
+	}
+}
+
+// wrote records a write to zoneID.
+func (h *installationHeartbeat) wrote(zoneID int64) {
+	if h == nil {
+		return
+	}
+	now := h.now()
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.writes[zoneID] = now
+}
+
+// zones returns the zones written to within heartbeatZoneWriteLifetime.
+func (h *installationHeartbeat) zones() []int64 {
+	now := h.now()
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	var zones []int64
+	for id, t := range h.writes {
+		if now.Sub(t) > heartbeatZoneWriteLifetime {
+			delete(h.writes, id)
+			continue
+		}
+		zones = append(zones, id)
+	}
+	slices.Sort(zones)
+	return zones
+}
+
+// run renews the Lease and checks the others every interval until stopCh
+// is closed, and then deletes the Lease.
+func (h *installationHeartbeat) run(stopCh <-chan struct{}) {
+	ctx := wait.ContextForChannel(stopCh)
+	wait.Until(func() {
+		if err := h.beat(ctx); err != nil {
+			sampledLog.Warningf("renewing the installation heartbeat in namespace %s: %v", h.namespace, err)
+		}
+	}, h.interval, stopCh)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	err := h.client.CoordinationV1().Leases(h.namespace).Delete(ctx, h.leaseName(), metav1.DeleteOptions{})
+	if err != nil && !apierrors.IsNotFound(err) {
+		klog.Warningf("deleting the heartbeat Lease %s/%s: %v", h.namespace, h.leaseName(), err)
+	}
+}
+
+// leaseName returns the name of the Lease of the replica.
+func (h *installationHeartbeat) leaseName() string {
+	sum := sha256.Sum256([]byte(h.installation + "/" + h.holder))
+	return "sakuracloud-webhook-" + hex.EncodeToString(sum[:])[:12]
+}
+
+func (h *installationHeartbeat) beat(ctx context.Context) error {
+	zones := h.zones()
+	if err := h.renew(ctx, zones); err != nil {
+		return err
+	}
+	leases, err := h.client.CoordinationV1().Leases(h.namespace).List(ctx, metav1.ListOptions{
+		LabelSelector: heartbeatComponentLabel + "=" + heartbeatComponent,
+	})
+	if err != nil {
+		return err
+	}
+	duplicates := map[string]bool{}
+	for _, l := range leases.Items {
+		if h.expired(&l) {
+			err := h.client.CoordinationV1().Leases(h.namespace).Delete(ctx, l.Name, metav1.DeleteOptions{})
+			if err != nil && !apierrors.IsNotFound(err) {
+				sampledLog.Warningf("deleting the expired heartbeat Lease %s/%s: %v", h.namespace, l.Name, err)
+			}
+			continue
+		}
+		d, ok := h.duplicate(&l, zones)
+		if !ok {
+			continue
+		}
+		duplicates[l.Annotations[heartbeatInstallationKey]] = true
+		sampledLog.Errorf("DUPLICATE INSTALLATION: installation %s (replica %s) also writes to zones %s for group %s; "+
+			"two webhook installations updating the same zones make challenge records flap, keep only one of them",
+			l.Annotations[heartbeatInstallationKey], holderIdentity(&l), formatZoneIDs(d.zones), strings.Join(d.groups, ","))
+	}
+	duplicateInstallations.Set(float64(len(duplicates)))
+	return nil
+}
+
+// renew creates or updates the Lease of the installation.
+func (h *installationHeartbeat) renew(ctx context.Context, zones []int64) error {
+	leases := h.client.CoordinationV1().Leases(h.namespace)
+	lease, err := leases.Get(ctx, h.leaseName(), metav1.GetOptions{})
+	create := apierrors.IsNotFound(err)
+	if err != nil && !create {
+		return err
+	}
+	if create {
+		lease = &coordinationv1.Lease{ObjectMeta: metav1.ObjectMeta{Name: h.leaseName(), Namespace: h.namespace}}
+	}
+	if lease.Labels == nil {
+		lease.Labels = map[string]string{}
+	}
+	if lease.Annotations == nil {
+		lease.Annotations = map[string]string{}
+	}
+	lease.Labels[heartbeatComponentLabel] = heartbeatComponent
+	lease.Annotations[heartbeatInstallationKey] = h.installation
+	lease.Annotations[heartbeatGroupsKey] = strings.Join(h.groups, ",")
+	lease.Annotations[heartbeatZonesKey] = formatZoneIDs(zones)
+	holder, duration := h.holder, int32((3 * h.interval).Seconds())
+	lease.Spec.HolderIdentity, lease.Spec.LeaseDurationSeconds = &holder, &duration
+	lease.Spec.RenewTime = &metav1.MicroTime{Time: h.now()}
+	if create {
+		_, err = leases.Create(ctx, lease, metav1.CreateOptions{})
+	} else {
+		_, err = leases.Update(ctx, lease, metav1.UpdateOptions{})
+	}
+	return err
+}
+
+// heartbeatOverlap is what another installation shares with this one.
+type heartbeatOverlap struct {
+	groups []string
+	zones  []int64
+}
+
+// expired reports whether the Lease l was not renewed in time, e.g. as its
+// replica is gone.
+func (h *installationHeartbeat) expired(l *coordinationv1.Lease) bool {
+	if l.Spec.RenewTime == nil || l.Spec.LeaseDurationSeconds == nil {
+		return true
+	}
+	return h.now().After(l.Spec.RenewTime.Add(time.Duration(*l.Spec.LeaseDurationSeconds) * time.Second))
+}
+
+// duplicate reports whether the Lease l is of another installation that
+// wrote to one of zones for one of the groups of h.
+func (h *installationHeartbeat) duplicate(l *coordinationv1.Lease, zones []int64) (heartbeatOverlap, bool) {
+	var o heartbeatOverlap
+	if l.Annotations[heartbeatInstallationKey] == h.installation {
+		return o, false
+	}
+	for _, g := range strings.Split(l.Annotations[heartbeatGroupsKey], ",") {
+		if slices.Contains(h.groups, g) {
+			o.groups = append(o.groups, g)
+		}
+	}
+	for _, s := range strings.Split(l.Annotations[heartbeatZonesKey], ",") {
+		if id, err := strconv.ParseInt(s, 10, 64); err == nil && slices.Contains(zones, id) {
+			o.zones = append(o.zones, id)
+		}
+	}
+	return o, len(o.groups) > 0 && len(o.zones) > 0
+}
+
+func holderIdentity(l *coordinationv1.Lease) string {
+	if l.Spec.HolderIdentity == nil {
+		return ""
+	}
+	return *l.Spec.HolderIdentity
+}
+
+func formatZoneIDs(zones []int64) string {
+	s := make([]string, len(zones))
+	for i, id := range zones {
+		s[i] = strconv.FormatInt(id, 10)
+	}
+	return strings.Join(s, ",")
+}
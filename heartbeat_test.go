@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestInstallationHeartbeat(t *testing.T) {
+	ctx := context.Background()
+	client := fake.NewSimpleClientset()
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	heartbeat := func(installation, holder string, groups ...string) *installationHeartbeat {
+		h := newInstallationHeartbeat(client, "cert-manager", installation, groups, time.Minute)
+		h.holder, h.now = holder, func() time.Time { return now }
+		return h
+	}
+
+	a0 := heartbeat("cluster-a/cert-manager", "webhook-a0", "acme.example.com")
+	a1 := heartbeat("cluster-a/cert-manager", "webhook-a1", "acme.example.com")
+	b := heartbeat("cluster-b/cert-manager", "webhook-b", "acme.example.com", "acme.example.org")
+	c := heartbeat("cluster-c/cert-manager", "webhook-c", "acme.example.net")
+	a0.wrote(1)
+	a1.wrote(1)
+	c.wrote(2)
+	for _, h := range []*installationHeartbeat{a0, a1, c} {
+		require.NoError(t, h.beat(ctx))
+		assert.Equal(t, 0.0, testutil.ToFloat64(duplicateInstallations), "replicas of the same installation are no duplicates")
+	}
+
+	b.wrote(2)
+	require.NoError(t, b.beat(ctx))
+	assert.Equal(t, 0.0, testutil.ToFloat64(duplicateInstallations), "installations of other groups are no duplicates")
+	b.wrote(1)
+	require.NoError(t, b.beat(ctx))
+	assert.Equal(t, 1.0, testutil.ToFloat64(duplicateInstallations))
+	require.NoError(t, a0.beat(ctx))
+	assert.Equal(t, 1.0, testutil.ToFloat64(duplicateInstallations))
+
+	leases, err := client.CoordinationV1().Leases("cert-manager").List(ctx, metav1.ListOptions{})
+	require.NoError(t, err)
+	assert.Len(t, leases.Items, 4, "every replica has a Lease")
+
+	now = now.Add(4 * time.Minute)
+	require.NoError(t, a0.beat(ctx))
+	assert.Equal(t, 0.0, testutil.ToFloat64(duplicateInstallations), "the Lease of b expired")
+	leases, err = client.CoordinationV1().Leases("cert-manager").List(ctx, metav1.ListOptions{})
+	require.NoError(t, err)
+	assert.Len(t, leases.Items, 1, "expired Leases are deleted")
+
+	now = now.Add(heartbeatZoneWriteLifetime)
+	assert.Empty(t, a0.zones(), "zones written to a day ago are forgotten")
+
+	var disabled *installationHeartbeat
+	disabled.wrote(1)
+}
+
+func TestInstallationID(t *testing.T) {
+	client := fake.NewSimpleClientset(&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "kube-system", UID: "cluster-a"}})
+	id, err := installationID(context.Background(), client, "cert-manager", "webhook-one")
+	require.NoError(t, err)
+	assert.Equal(t, "cluster-a/cert-manager/webhook-one", id)
+	other, err := installationID(context.Background(), client, "cert-manager", "webhook-two")
+	require.NoError(t, err)
+	assert.NotEqual(t, id, other, "two releases in one namespace are different installations")
+
+	_, err = installationID(context.Background(), client, "cert-manager", "")
+	assert.EqualError(t, err, "--installation-id or --service-name is required to tell the installations in one namespace apart")
+}
@@ -0,0 +1,253 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/cert-manager/cert-manager/pkg/acme/webhook/apis/acme/v1alpha1"
+	extapi "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/dynamic/dynamicinformer"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/util/workqueue"
+	"k8s.io/klog/v2"
+)
+
+var (
+	issuersResource        = schema.GroupVersionResource{Group: "cert-manager.io", Version: "v1", Resource: "issuers"}
+	clusterIssuersResource = schema.GroupVersionResource{Group: "cert-manager.io", Version: "v1", Resource: "clusterissuers"}
+)
+
+// issuerCheckTimeout bounds the check of the solver configs of one Issuer.
+const issuerCheckTimeout = time.Minute
+
+// issuerRetryDelay is how long the check of an Issuer waits to be retried
+// after it failed the first time. The delay doubles on every failure, up to
+// --issuer-check-interval.
+const issuerRetryDelay = 5 * time.Second
+
+// issuerChecker checks the solver configs of the Issuers and ClusterIssuers
+// referencing the webhook as soon as they are created or changed, and again
+// every --issuer-check-interval: their credential Secrets are read and their
+// zones are read with the API keys, exactly as the first challenge would.
+// A missing Secret or a revoked key then shows up in issuer_ready and the
+// log before a certificate is requested, rather than as a failed challenge.
+//
+// The informers only queue the Issuers; a worker checks them one at a time,
+// so that slow API calls do not hold up the informers, an Issuer changed
+// several times while it waits is checked once, and failed checks are
+// retried with a backoff.
+type issuerChecker struct {
+	solverName string
+	// clusterResourceNamespace is the namespace cert-manager reads the
+	// Secrets of ClusterIssuers from.
+	clusterResourceNamespace string
+
+	// checks read the Secrets and zones of one solver config, by the API
+	// group serving it; they are replaced in tests.
+	checks map[string]func(ctx context.Context, ch *v1alpha1.ChallengeRequest) error
+
+	queue workqueue.RateLimitingInterface
+	// issuers are the informer caches of the Issuers and ClusterIssuers,
+	// by kind.
+	issuers map[string]cache.Indexer
+}
+
+// issuerKey is an Issuer or ClusterIssuer in the queue of an issuerChecker.
+type issuerKey struct {
+	kind      string
+	namespace string
+	name      string
+}
+
+func newIssuerChecker(solverName, clusterResourceNamespace string, interval time.Duration) *issuerChecker {
+	return &issuerChecker{
+		solverName:               solverName,
+		clusterResourceNamespace: clusterResourceNamespace,
+		checks:                   map[string]func(ctx context.Context, ch *v1alpha1.ChallengeRequest) error{},
+		queue:                    workqueue.NewRateLimitingQueue(workqueue.NewItemExponentialFailureRateLimiter(issuerRetryDelay, max(interval, issuerRetryDelay))),
+		issuers:                  map[string]cache.Indexer{},
+	}
+}
+
+// startIssuerChecks checks the Issuers of the API groups of the solver until
+// stopCh is closed.
+func (c *sakuraCloudDNSProviderSolver) startIssuerChecks(client dynamic.Interface, stopCh <-chan struct{}) {
+	checker := newIssuerChecker(c.Name(), c.opts.ClusterResourceNamespace, c.opts.IssuerCheckInterval)
+	checker.checks[c.opts.groupNames[0]] = c.checkSolverConfig
+	for _, opts := range c.groups {
+		// The configs of a group are read with its own options, e.g.
+		// --config-shape, and the clients of the solver.
+		g := *c
+		g.opts = opts
+		checker.checks[opts.groupNames[0]] = g.checkSolverConfig
+	}
+	factory := dynamicinformer.NewDynamicSharedInformerFactory(client, c.opts.IssuerCheckInterval)
+	var synced []cache.InformerSynced
+	for kind, resource := range map[string]schema.GroupVersionResource{"Issuer": issuersResource, "ClusterIssuer": clusterIssuersResource} {
+		informer := factory.ForResource(resource).Informer()
+		checker.issuers[kind] = informer.GetIndexer()
+		synced = append(synced, informer.HasSynced)
+		_, _ = informer.AddEventHandler(checker.eventHandler(kind))
+	}
+	c.startInformers("Issuer informers", stopCh, func(stopCh <-chan struct{}) {
+		factory.Start(stopCh)
+		<-stopCh
+		factory.Shutdown()
+	}, synced...)
+	c.start("Issuer checks", stopCh, checker.run)
+	klog.Infof("checking the Issuers and ClusterIssuers of %s every %s", strings.Join(c.opts.groupNames, ", "), c.opts.IssuerCheckInterval)
+}
+
+// eventHandler queues the Issuers of kind that were added or changed.
+func (ic *issuerChecker) eventHandler(kind string) cache.ResourceEventHandler {
+	add := func(obj interface{}) {
+		if issuer, ok := obj.(*unstructured.Unstructured); ok {
+			ic.queue.Add(issuerKey{kind: kind, namespace: issuer.GetNamespace(), name: issuer.GetName()})
+		}
+	}
+	return cache.ResourceEventHandlerFuncs{
+		AddFunc: add,
+		UpdateFunc: func(oldObj, newObj interface{}) {
+			old, issuer := oldObj.(*unstructured.Unstructured), newObj.(*unstructured.Unstructured)
+			// Status updates by cert-manager change neither; a resync
+			// leaves the resource version as it was.
+			if issuer.GetGeneration() != old.GetGeneration() || issuer.GetResourceVersion() == old.GetResourceVersion() {
+				add(issuer)
+			}
+		},
+		DeleteFunc: func(obj interface{}) {
+			if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+				obj = tombstone.Obj
+			}
+			add(obj)
+		},
+	}
+}
+
+// run checks the queued Issuers until stopCh is closed.
+func (ic *issuerChecker) run(stopCh <-chan struct{}) {
+	go func() {
+		<-stopCh
+		ic.queue.ShutDown()
+	}()
+	for ic.processNext() {
+	}
+}
+
+// processNext checks the next queued Issuer, as found in the informer cache,
+// and queues it again with a backoff if the check failed. Deleted Issuers
+// are no longer exported. It returns false once the queue is shut down.
+func (ic *issuerChecker) processNext() bool {
+	item, shutdown := ic.queue.Get()
+	if shutdown {
+		return false
+	}
+	defer ic.queue.Done(item)
+	key := item.(issuerKey)
+	cacheKey := key.name
+	if key.namespace != "" {
+		cacheKey = key.namespace + "/" + key.name
+	}
+	obj, exists, err := ic.issuers[key.kind].GetByKey(cacheKey)
+	if err != nil || !exists {
+		issuerReady.DeleteLabelValues(key.kind, key.namespace, key.name)
+		ic.queue.Forget(item)
+		return true
+	}
+	if ic.checkIssuer(key.kind, obj.(*unstructured.Unstructured)) {
+		ic.queue.Forget(item)
+	} else {
+		ic.queue.AddRateLimited(item)
+	}
+	return true
+}
+
+// checkIssuer checks the solver configs of issuer that reference the
+// webhook, sets issuer_ready and reports whether they are all ready.
+// Issuers without any are not exported.
+func (ic *issuerChecker) checkIssuer(kind string, issuer *unstructured.Unstructured) bool {
+	configs := issuerSolverConfigs(issuer, ic.solverName, func(group string) bool { return ic.checks[group] != nil })
+	if len(configs) == 0 {
+		issuerReady.DeleteLabelValues(kind, issuer.GetNamespace(), issuer.GetName())
+		return true
+	}
+	ns := issuer.GetNamespace()
+	if kind == "ClusterIssuer" {
+		ns = ic.clusterResourceNamespace
+	}
+	name := issuer.GetName()
+	if issuer.GetNamespace() != "" {
+		name = issuer.GetNamespace() + "/" + name
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), issuerCheckTimeout)
+	defer cancel()
+	ready := 1.0
+	for i, config := range configs {
+		ch := &v1alpha1.ChallengeRequest{ResourceNamespace: ns, Config: &extapi.JSON{Raw: config.config}}
+		if err := ic.checks[config.group](ctx, ch); err != nil {
+			ready = 0
+			sampledLog.Warningf("%s %s: solver %d will fail its challenges: %v", kind, name, i, err)
+			continue
+		}
+		klog.V(4).Infof("%s %s: solver %d is ready", kind, name, i)
+	}
+	issuerReady.WithLabelValues(kind, issuer.GetNamespace(), issuer.GetName()).Set(ready)
+	return ready == 1
+}
+
+// issuerSolverConfig is the config of an ACME DNS01 solver of an Issuer and
+// the API group it is sent to.
+type issuerSolverConfig struct {
+	group  string
+	config []byte
+}
+
+// issuerSolverConfigs returns the configs of the ACME DNS01 solvers of
+// issuer that are served by solverName in the groups that served reports.
+func issuerSolverConfigs(issuer *unstructured.Unstructured, solverName string, served func(group string) bool) []issuerSolverConfig {
+	solvers, _, _ := unstructured.NestedSlice(issuer.Object, "spec", "acme", "solvers")
+	var configs []issuerSolverConfig
+	for _, s := range solvers {
+		solver, ok := s.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		group, _, _ := unstructured.NestedString(solver, "dns01", "webhook", "groupName")
+		name, _, _ := unstructured.NestedString(solver, "dns01", "webhook", "solverName")
+		if !served(group) || name != solverName {
+			continue
+		}
+		config, _, _ := unstructured.NestedFieldNoCopy(solver, "dns01", "webhook", "config")
+		raw, err := json.Marshal(config)
+		if err != nil {
+			continue
+		}
+		configs = append(configs, issuerSolverConfig{group: group, config: raw})
+	}
+	return configs
+}
+
+// checkSolverConfig resolves the solver config of ch and reads the metadata
+// of its zone and mirror zone, which reads the credential Secrets and proves
+// the API keys without reading the records.
+func (c *sakuraCloudDNSProviderSolver) checkSolverConfig(ctx context.Context, ch *v1alpha1.ChallengeRequest) error {
+	cfg, err := c.resolveConfig(ctx, ch)
+	if err != nil {
+		return err
+	}
+	if _, err := c.readZoneMetadata(ctx, &cfg, ch); err != nil {
+		return fmt.Errorf("zone %d: %w", cfg.ZoneID, err)
+	}
+	if mirror := cfg.mirror(); mirror != nil {
+		if _, err := c.readZoneMetadata(ctx, mirror, ch); err != nil {
+			return fmt.Errorf("mirror zone %d: %w", mirror.ZoneID, err)
+		}
+	}
+	return nil
+}
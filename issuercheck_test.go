@@ -0,0 +1,127 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/cert-manager/cert-manager/pkg/acme/webhook/apis/acme/v1alpha1"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/util/workqueue"
+)
+
+func testIssuer(kind, ns, name string, solvers ...interface{}) *unstructured.Unstructured {
+	return &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "cert-manager.io/v1",
+		"kind":       kind,
+		"metadata":   map[string]interface{}{"namespace": ns, "name": name},
+		"spec":       map[string]interface{}{"acme": map[string]interface{}{"solvers": solvers}},
+	}}
+}
+
+func webhookSolver(group, name string, zoneID int64) interface{} {
+	return map[string]interface{}{"dns01": map[string]interface{}{"webhook": map[string]interface{}{
+		"groupName":  group,
+		"solverName": name,
+		"config":     map[string]interface{}{"zoneID": zoneID},
+	}}}
+}
+
+func TestIssuerSolverConfigs(t *testing.T) {
+	issuer := testIssuer("Issuer", "tenant-a", "letsencrypt",
+		map[string]interface{}{"http01": map[string]interface{}{}},
+		webhookSolver("acme.example.com", "sakuracloud-dns-solver", 1),
+		webhookSolver("acme.example.org", "sakuracloud-dns-solver", 2),
+		webhookSolver("acme.example.com", "other", 3),
+		webhookSolver("acme.example.net", "sakuracloud-dns-solver", 4),
+		webhookSolver("acme.example.com", "sakuracloud-dns-solver", 5),
+	)
+	configs := issuerSolverConfigs(issuer, "sakuracloud-dns-solver", func(group string) bool {
+		return group == "acme.example.com" || group == "acme.example.net"
+	})
+	if assert.Len(t, configs, 3) {
+		assert.Equal(t, "acme.example.com", configs[0].group)
+		assert.JSONEq(t, `{"zoneID": 1}`, string(configs[0].config))
+		assert.Equal(t, "acme.example.net", configs[1].group, "every served group")
+		assert.JSONEq(t, `{"zoneID": 4}`, string(configs[1].config))
+		assert.JSONEq(t, `{"zoneID": 5}`, string(configs[2].config))
+	}
+}
+
+// newTestIssuerChecker returns a checker serving acme.example.com and
+// acme.extra.example, whose checks record the namespaces and groups of the
+// configs and fail for zone 2.
+func newTestIssuerChecker(checked *[]string) *issuerChecker {
+	ic := newIssuerChecker("sakuracloud-dns-solver", "cert-manager", time.Hour)
+	ic.queue = workqueue.NewRateLimitingQueue(workqueue.NewItemExponentialFailureRateLimiter(time.Millisecond, time.Millisecond))
+	for _, group := range []string{"acme.example.com", "acme.extra.example"} {
+		group := group
+		ic.checks[group] = func(_ context.Context, ch *v1alpha1.ChallengeRequest) error {
+			*checked = append(*checked, group+" "+ch.ResourceNamespace)
+			cfg, err := loadConfig(ch.Config, "native")
+			if err != nil {
+				return err
+			}
+			if cfg.ZoneID == 2 {
+				return errors.New("secret not found")
+			}
+			return nil
+		}
+	}
+	return ic
+}
+
+func TestCheckIssuer(t *testing.T) {
+	var checked []string
+	ic := newTestIssuerChecker(&checked)
+
+	assert.True(t, ic.checkIssuer("Issuer", testIssuer("Issuer", "tenant-a", "ready", webhookSolver("acme.example.com", "sakuracloud-dns-solver", 1))))
+	assert.False(t, ic.checkIssuer("ClusterIssuer", testIssuer("ClusterIssuer", "", "broken",
+		webhookSolver("acme.example.com", "sakuracloud-dns-solver", 1),
+		webhookSolver("acme.extra.example", "sakuracloud-dns-solver", 2))))
+	assert.True(t, ic.checkIssuer("Issuer", testIssuer("Issuer", "tenant-b", "unrelated", webhookSolver("acme.example.org", "sakuracloud-dns-solver", 1))))
+
+	assert.Equal(t, []string{"acme.example.com tenant-a", "acme.example.com cert-manager", "acme.extra.example cert-manager"}, checked)
+	assert.Equal(t, 1.0, testutil.ToFloat64(issuerReady.WithLabelValues("Issuer", "tenant-a", "ready")))
+	assert.Equal(t, 0.0, testutil.ToFloat64(issuerReady.WithLabelValues("ClusterIssuer", "", "broken")))
+	assert.False(t, issuerReady.DeleteLabelValues("Issuer", "tenant-b", "unrelated"), "Issuers of other groups are not exported")
+}
+
+func TestIssuerCheckQueue(t *testing.T) {
+	var checked []string
+	ic := newTestIssuerChecker(&checked)
+	issuers := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+	ic.issuers["Issuer"] = issuers
+	handler := ic.eventHandler("Issuer")
+
+	ready := testIssuer("Issuer", "tenant-a", "queued", webhookSolver("acme.example.com", "sakuracloud-dns-solver", 1))
+	require.NoError(t, issuers.Add(ready))
+	handler.OnAdd(ready, false)
+	handler.OnAdd(ready, false)
+	assert.Equal(t, 1, ic.queue.Len(), "queued once while it waits")
+	assert.True(t, ic.processNext())
+	assert.Equal(t, []string{"acme.example.com tenant-a"}, checked)
+	assert.Equal(t, 0, ic.queue.Len())
+
+	broken := testIssuer("Issuer", "tenant-a", "queued", webhookSolver("acme.example.com", "sakuracloud-dns-solver", 2))
+	broken.SetGeneration(2)
+	require.NoError(t, issuers.Update(broken))
+	handler.OnUpdate(ready, broken)
+	assert.True(t, ic.processNext())
+	assert.Equal(t, 0.0, testutil.ToFloat64(issuerReady.WithLabelValues("Issuer", "tenant-a", "queued")))
+	assert.Equal(t, 1, ic.queue.NumRequeues(issuerKey{kind: "Issuer", namespace: "tenant-a", name: "queued"}), "retried with a backoff")
+
+	require.NoError(t, issuers.Delete(broken))
+	handler.OnDelete(broken)
+	assert.True(t, ic.processNext())
+	assert.Len(t, checked, 2, "deleted Issuers are not checked")
+	assert.False(t, issuerReady.DeleteLabelValues("Issuer", "tenant-a", "queued"), "deleted Issuers are no longer exported")
+
+	ic.queue.ShutDown()
+	assert.False(t, ic.processNext())
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/leaderelection"
+	"k8s.io/client-go/tools/leaderelection/resourcelock"
+	"k8s.io/klog/v2"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+)
+
+// Timings of the leader Lease, the ones of controller-runtime. They are
+// variables for the tests.
+var (
+	leaseDuration = 15 * time.Second
+	renewDeadline = 10 * time.Second
+	retryPeriod   = 2 * time.Second
+)
+
+// startLeaderElection campaigns for the leader Lease in the background as
+// replica id. Only the leader writes to zones: followers stay ready, so that
+// a rolling update can bring up the new replicas while the old leader holds
+// the lease. They forward the challenges that reach them to the leader with
+// --leader-election-address, see leaderforward.go. Otherwise they reject
+// them with a retriable error, and cert-manager retries them with its
+// backoff until one reaches the leader, so that with N replicas about
+// (N-1)/N of the challenges are delayed. Losing the lease exits the process,
+// as it is done by controller-runtime.
+//
+// The lease is campaigned for with the runnables of mgr that need leader
+// election, which are stopped after the API server: on SIGTERM it is
+// released, with ReleaseOnCancel, once the challenges in flight are
+// answered, so a follower takes over without waiting for the lease to
+// expire. The leader election of the manager itself is not used, as
+// followers keep serving challenges and tell which replica is the leader.
+// Without a manager the election stops with stopCh.
+func startLeaderElection(client kubernetes.Interface, id string, opts *solverOptions, mgr manager.Manager, stopCh <-chan struct{}) (*leaderelection.LeaderElector, error) {
+	ns, err := namespaceOrOwn(opts.LeaderElectionNamespace)
+	if err != nil {
+		return nil, fmt.Errorf("--leader-election-namespace: %w", err)
+	}
+	le, run, err := newLeaderElector(client, id, ns, opts.LeaderElectionID)
+	if err != nil {
+		return nil, err
+	}
+	if mgr == nil {
+		go func() { _ = run(wait.ContextForChannel(stopCh)) }()
+		return le, nil
+	}
+	return le, mgr.Add(manager.RunnableFunc(run))
+}
+
+// newLeaderElector returns the elector of replica id for Lease ns/name, and
+// the function campaigning for it until its context is canceled, which
+// releases the lease.
+func newLeaderElector(client kubernetes.Interface, id, ns, name string) (*leaderelection.LeaderElector, func(context.Context) error, error) {
+	lock, err := resourcelock.New(resourcelock.LeasesResourceLock, ns, name,
+		client.CoreV1(), client.CoordinationV1(), resourcelock.ResourceLockConfig{Identity: id})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// ctx is the context the election runs with, set before it starts.
+	var ctx context.Context
+	le, err := leaderelection.NewLeaderElector(leaderelection.LeaderElectionConfig{
+		Lock:            lock,
+		LeaseDuration:   leaseDuration,
+		RenewDeadline:   renewDeadline,
+		RetryPeriod:     retryPeriod,
+		ReleaseOnCancel: true,
+		Name:            name,
+		Callbacks: leaderelection.LeaderCallbacks{
+			OnStartedLeading: func(context.Context) {
+				klog.Infof("%s became the leader of %s/%s", id, ns, name)
+			},
+			OnStoppedLeading: func() {
+				if ctx.Err() != nil {
+					klog.Infof("%s released the leader lease %s/%s", id, ns, name)
+					return
+				}
+				klog.Fatalf("%s lost the leader lease %s/%s", id, ns, name)
+			},
+			OnNewLeader: func(identity string) {
+				if identity != id {
+					klog.Infof("%s is the leader of %s/%s", identity, ns, name)
+				}
+			},
+		},
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	run := func(runCtx context.Context) error {
+		ctx = runCtx
+		le.Run(ctx)
+		return nil
+	}
+	return le, run, nil
+}
+
+// notLeaderError is returned to challenges that reach a follower.
+func notLeaderError(le *leaderelection.LeaderElector) error {
+	return &retriableError{
+		reason: "this replica is not the leader",
+		err:    fmt.Errorf("zone writes are done by %q", le.GetLeader()),
+	}
+}
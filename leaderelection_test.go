@@ -0,0 +1,140 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cert-manager/cert-manager/pkg/acme/webhook/apis/acme/v1alpha1"
+	sctesting "github.com/cert-manager/webhook-example/pkg/testing"
+	"github.com/sacloud/iaas-api-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestLeaderFailover(t *testing.T) {
+	// The lease would only expire after the test, so the follower takes
+	// over because the leader released it.
+	defer func(d, r, p time.Duration) { leaseDuration, renewDeadline, retryPeriod = d, r, p }(leaseDuration, renewDeadline, retryPeriod)
+	leaseDuration, renewDeadline, retryPeriod = time.Minute, 30*time.Second, 20*time.Millisecond
+
+	client := fake.NewSimpleClientset()
+	old, runOld, err := newLeaderElector(client, "old", "cert-manager", "webhook")
+	require.NoError(t, err)
+	follower, runFollower, err := newLeaderElector(client, "new", "cert-manager", "webhook")
+	require.NoError(t, err)
+
+	oldCtx, stopOld := context.WithCancel(context.Background())
+	oldStopped := make(chan struct{})
+	go func() {
+		_ = runOld(oldCtx)
+		close(oldStopped)
+	}()
+	require.Eventually(t, old.IsLeader, 5*time.Second, 10*time.Millisecond)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() { _ = runFollower(ctx) }()
+
+	zones := sctesting.NewZoneClient(&iaas.DNS{ID: 1, Name: "example.com"})
+	c := newHarness(t, zones)
+	c.leader = follower
+	c.initialized = make(chan struct{})
+	close(c.initialized)
+	c.checks = c.readinessChecks()
+	require.Eventually(t, func() bool { return follower.GetLeader() == "old" }, 5*time.Second, 10*time.Millisecond)
+
+	assert.NoError(t, c.readyz(nil), "followers stay ready for rolling updates")
+	err = c.Present(harnessChallenge(0))
+	var retriable *retriableError
+	require.ErrorAs(t, err, &retriable)
+	assert.ErrorContains(t, err, `zone writes are done by "old"`)
+	assert.Zero(t, zones.Updates(1))
+
+	stopOld()
+	<-oldStopped
+	lease, err := client.CoordinationV1().Leases("cert-manager").Get(context.Background(), "webhook", metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.NotEqual(t, "old", *lease.Spec.HolderIdentity, "released on shutdown")
+	require.Eventually(t, follower.IsLeader, 5*time.Second, 10*time.Millisecond, "taken over before the lease expired")
+	require.NoError(t, c.Present(harnessChallenge(0)))
+	assert.Len(t, zones.Zone(1).Records, 1)
+}
+
+func TestLeaderForwarding(t *testing.T) {
+	defer func(d, r, p time.Duration) { leaseDuration, renewDeadline, retryPeriod = d, r, p }(leaseDuration, renewDeadline, retryPeriod)
+	leaseDuration, renewDeadline, retryPeriod = time.Minute, 30*time.Second, 20*time.Millisecond
+
+	zones := sctesting.NewZoneClient(&iaas.DNS{ID: 1, Name: "example.com"})
+	leading := newHarness(t, zones)
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/apis/acme.example.com/v1alpha1/sakuracloud-dns-solver" || r.Header.Get("Authorization") != "Bearer follower-token" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		var payload v1alpha1.ChallengePayload
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&payload))
+		var err error
+		switch payload.Request.Action {
+		case v1alpha1.ChallengeActionPresent:
+			err = leading.Present(payload.Request)
+		case v1alpha1.ChallengeActionCleanUp:
+			err = leading.CleanUp(payload.Request)
+		}
+		payload.Response = &v1alpha1.ChallengeResponse{UID: payload.Request.UID, Success: err == nil}
+		if err != nil {
+			payload.Response.Result = &metav1.Status{Status: metav1.StatusFailure, Message: err.Error()}
+		}
+		w.WriteHeader(http.StatusCreated)
+		require.NoError(t, json.NewEncoder(w).Encode(&payload))
+	}))
+	defer srv.Close()
+
+	dir := t.TempDir()
+	caFile := filepath.Join(dir, "ca.crt")
+	require.NoError(t, os.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: srv.Certificate().Raw}), 0o600))
+	forwarder, err := newLeaderForwarder(caFile, "example.com")
+	require.NoError(t, err)
+	forwarder.tokenFile = filepath.Join(dir, "token")
+	require.NoError(t, os.WriteFile(forwarder.tokenFile, []byte("follower-token\n"), 0o600))
+
+	client := fake.NewSimpleClientset()
+	leaderID := leaderIdentity("old", strings.TrimPrefix(srv.URL, "https://"))
+	leader, runLeader, err := newLeaderElector(client, leaderID, "cert-manager", "webhook")
+	require.NoError(t, err)
+	follower, runFollower, err := newLeaderElector(client, "new", "cert-manager", "webhook")
+	require.NoError(t, err)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() { _ = runLeader(ctx) }()
+	require.Eventually(t, leader.IsLeader, 5*time.Second, 10*time.Millisecond)
+	go func() { _ = runFollower(ctx) }()
+
+	c := newHarness(t, sctesting.NewZoneClient(&iaas.DNS{ID: 1, Name: "example.com"}))
+	c.opts.groupNames = []string{"acme.example.com"}
+	c.leader, c.leaderID, c.forwarder = follower, "new", forwarder
+
+	require.NoError(t, c.Present(harnessChallenge(0)), "the follower waits for the leader and forwards to it")
+	assert.Len(t, zones.Zone(1).Records, 1, "written by the leader")
+	require.NoError(t, c.CleanUp(harnessChallenge(0)))
+	assert.Empty(t, zones.Zone(1).Records)
+
+	leading.opts.AllowedSecretNamespaces = []string{"other"}
+	assert.ErrorContains(t, c.Present(harnessChallenge(1)), "not in --allowed-secret-namespaces", "the error of the leader is returned")
+
+	forwarder.tokenFile = filepath.Join(dir, "missing")
+	assert.ErrorContains(t, c.Present(harnessChallenge(2)), "reading the ServiceAccount token")
+}
+
+func TestLeaderAddress(t *testing.T) {
+	assert.Equal(t, "10.0.0.1:443", leaderAddress(leaderIdentity("webhook-5d9f", "10.0.0.1:443")))
+	assert.Equal(t, "", leaderAddress(leaderIdentity("webhook-5d9f", "")))
+}
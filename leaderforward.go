@@ -0,0 +1,148 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/cert-manager/cert-manager/pkg/acme/webhook/apis/acme/v1alpha1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog/v2"
+)
+
+const serviceAccountTokenFile = "/var/run/secrets/kubernetes.io/serviceaccount/token"
+
+// leaderIdentity is the identity of a replica in the leader Lease: its
+// hostname and, with --leader-election-address, the address of its webhook
+// API, which hostnames cannot contain an underscore of.
+func leaderIdentity(hostname, address string) string {
+	if address == "" {
+		return hostname
+	}
+	return hostname + "_" + address
+}
+
+// leaderAddress returns the address of the webhook API in a leader
+// identity, or "" if the leader does not take forwarded challenges.
+func leaderAddress(identity string) string {
+	if i := strings.LastIndex(identity, "_"); i >= 0 {
+		return identity[i+1:]
+	}
+	return ""
+}
+
+// leaderForwarder sends the challenges reaching a follower to the webhook
+// API of the leader, as cert-manager would have, so that they need not wait
+// for cert-manager to retry them until they reach the leader. The requests
+// are authenticated with the ServiceAccount token of the follower, which
+// the chart allows to create ChallengePayloads like cert-manager.
+type leaderForwarder struct {
+	client    *http.Client
+	tokenFile string
+}
+
+// newLeaderForwarder returns a leaderForwarder verifying the serving
+// certificate of the leader with the CA bundle in caFile for serverName.
+func newLeaderForwarder(caFile, serverName string) (*leaderForwarder, error) {
+	pem, err := os.ReadFile(caFile)
+	if err != nil {
+		return nil, fmt.Errorf("reading --leader-forward-ca-file: %w", err)
+	}
+	roots := x509.NewCertPool()
+	if !roots.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("--leader-forward-ca-file %s holds no PEM certificate", caFile)
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = &tls.Config{RootCAs: roots, ServerName: serverName, MinVersion: tls.VersionTLS12}
+	return &leaderForwarder{client: &http.Client{Transport: transport}, tokenFile: serviceAccountTokenFile}, nil
+}
+
+// forward sends ch to solver of group at the leader at address, and returns
+// the error the leader answered with.
+func (f *leaderForwarder) forward(ctx context.Context, address, group, solver string, ch *v1alpha1.ChallengeRequest) error {
+	body, err := json.Marshal(&v1alpha1.ChallengePayload{
+		TypeMeta: metav1.TypeMeta{APIVersion: group + "/v1alpha1", Kind: "ChallengePayload"},
+		Request:  ch,
+	})
+	if err != nil {
+		return err
+	}
+	// The token is read for every request, as the kubelet rotates it.
+	token, err := os.ReadFile(f.tokenFile)
+	if err != nil {
+		return fmt.Errorf("reading the ServiceAccount token: %w", err)
+	}
+	url := fmt.Sprintf("https://%s/apis/%s/v1alpha1/%s", address, group, solver)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+strings.TrimSpace(string(token)))
+	resp, err := f.client.Do(req)
+	if err != nil {
+		return &retriableError{reason: "forwarding to the leader failed", err: err}
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		b, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return &retriableError{reason: "forwarding to the leader failed", err: fmt.Errorf("%s: %s: %s", url, resp.Status, strings.TrimSpace(string(b)))}
+	}
+
+	var payload v1alpha1.ChallengePayload
+	if err := json.NewDecoder(resp.Body).Decode(&payload); err != nil {
+		return fmt.Errorf("decoding the answer of the leader: %w", err)
+	}
+	switch {
+	case payload.Response == nil:
+		return errors.New("the leader answered without a response")
+	case payload.Response.Success:
+		return nil
+	case payload.Response.Result != nil:
+		return errors.New(payload.Response.Result.Message)
+	}
+	return errors.New("the leader failed the challenge")
+}
+
+// forwardToLeader sends ch to the leader for action when this replica is a
+// follower and --leader-election-address is set, and reports whether it
+// did. solver is the name of the solver ch reached. Until a leader is known,
+// ch waits for one, as long as the handler may.
+func (c *sakuraCloudDNSProviderSolver) forwardToLeader(parent context.Context, ch *v1alpha1.ChallengeRequest, action v1alpha1.ChallengeAction, solver string) (bool, error) {
+	if c.leader == nil || c.forwarder == nil || c.leader.IsLeader() {
+		return false, nil
+	}
+	ctx, cancel := c.handlerContext(parent)
+	defer cancel()
+	leader := c.leader.GetLeader()
+	for leader == "" {
+		select {
+		case <-ctx.Done():
+			return true, notLeaderError(c.leader)
+		case <-time.After(retryPeriod):
+		}
+		if c.leader.IsLeader() {
+			return false, nil
+		}
+		leader = c.leader.GetLeader()
+	}
+	address := leaderAddress(leader)
+	if address == "" || leader == c.leaderID {
+		// The leader takes no forwarded challenges, or this replica is
+		// about to lead.
+		return true, notLeaderError(c.leader)
+	}
+	forwarded := *ch
+	forwarded.Action = action
+	klog.V(4).Infof("forwarding the %s of %s to the leader %s", action, ch.ResolvedFQDN, leader)
+	return true, c.forwarder.forward(ctx, address, c.opts.groupNames[0], solver, &forwarded)
+}
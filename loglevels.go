@@ -0,0 +1,46 @@
+package main
+
+import (
+	"time"
+
+	"github.com/cert-manager/cert-manager/pkg/acme/webhook/apis/acme/v1alpha1"
+	"k8s.io/klog/v2"
+)
+
+// The verbosity levels of the solver logs. Each level adds to the ones
+// below it, so that --v picks how much of a challenge is logged:
+//
+//   - logLevelChallenge logs one line per Present and CleanUp, with its
+//     outcome and duration.
+//   - logLevelAPI adds a line per request to the Sakura Cloud API and the
+//     size of the zone updates.
+//   - logLevelRecords adds the records the challenges add and remove.
+//   - logLevelZones adds the whole record set of a zone before and after
+//     each update.
+const (
+	logLevelChallenge klog.Level = 2
+	logLevelAPI       klog.Level = 4
+	logLevelRecords   klog.Level = 6
+	logLevelZones     klog.Level = 8
+)
+
+// logAPIf logs a line at logLevelAPI.
+func logAPIf(format string, args ...any) {
+	klog.V(logLevelAPI).InfofDepth(1, format, args...)
+}
+
+// logRecordsf logs a line at logLevelRecords.
+func logRecordsf(format string, args ...any) {
+	klog.V(logLevelRecords).InfofDepth(1, format, args...)
+}
+
+// logChallengeResult logs the line of logLevelChallenge for an operation,
+// present or cleanup, on ch that started at start and returned err.
+func logChallengeResult(operation string, ch *v1alpha1.ChallengeRequest, start time.Time, err error) {
+	took := time.Since(start).Round(time.Millisecond)
+	if err != nil {
+		klog.V(logLevelChallenge).InfofDepth(1, "%s of %s in namespace %s failed after %s: %v", operation, ch.ResolvedFQDN, ch.ResourceNamespace, took, err)
+		return
+	}
+	klog.V(logLevelChallenge).InfofDepth(1, "%s of %s in namespace %s succeeded in %s", operation, ch.ResolvedFQDN, ch.ResourceNamespace, took)
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/cert-manager/cert-manager/pkg/acme/webhook/apis/acme/v1alpha1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"k8s.io/klog/v2"
+)
+
+// captureKlog makes klog write to the returned buffer at verbosity v until
+// the test ends.
+func captureKlog(t *testing.T, v klog.Level) *bytes.Buffer {
+	fs := flag.NewFlagSet("klog", flag.ContinueOnError)
+	klog.InitFlags(fs)
+	require.NoError(t, fs.Set("logtostderr", "false"))
+	require.NoError(t, fs.Set("v", strconv.Itoa(int(v))))
+	var buf bytes.Buffer
+	klog.SetOutput(&buf)
+	t.Cleanup(func() {
+		klog.Flush()
+		require.NoError(t, fs.Set("v", "0"))
+		require.NoError(t, fs.Set("logtostderr", "true"))
+	})
+	return &buf
+}
+
+func TestLogLevels(t *testing.T) {
+	ch := &v1alpha1.ChallengeRequest{ResolvedFQDN: "_acme-challenge.example.com.", ResourceNamespace: "default"}
+	logAll := func() {
+		logChallengeResult("present", ch, time.Now(), nil)
+		logChallengeResult("cleanup", ch, time.Now(), errors.New("api down"))
+		logAPIf("Sakura Cloud API GET /zone")
+		logRecordsf("present for entry=_acme-challenge")
+		klog.Flush()
+	}
+
+	buf := captureKlog(t, logLevelChallenge)
+	logAll()
+	assert.Contains(t, buf.String(), "present of _acme-challenge.example.com. in namespace default succeeded in")
+	assert.Contains(t, buf.String(), "cleanup of _acme-challenge.example.com. in namespace default failed after")
+	assert.Contains(t, buf.String(), "api down")
+	assert.NotContains(t, buf.String(), "Sakura Cloud API")
+	assert.NotContains(t, buf.String(), "entry=")
+
+	buf = captureKlog(t, logLevelRecords)
+	logAll()
+	assert.Contains(t, buf.String(), "succeeded in")
+	assert.Contains(t, buf.String(), "Sakura Cloud API GET /zone")
+	assert.Contains(t, buf.String(), "entry=_acme-challenge")
+}
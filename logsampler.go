@@ -0,0 +1,93 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/klog/v2"
+)
+
+// sampledLog collapses identical warnings and errors that repeat within its
+// window, e.g. the same API failure for every challenge while the Sakura
+// Cloud API is down. The first occurrence is logged right away and the
+// number of suppressed repeats is logged once per window.
+var sampledLog = newLogSampler(time.Minute)
+
+type sampledMessage struct {
+	isError    bool
+	first      time.Time
+	suppressed int
+}
+
+type logSampler struct {
+	mu       sync.Mutex
+	window   time.Duration
+	now      func() time.Time
+	messages map[string]*sampledMessage
+
+	// emit writes a log line; it is replaced in tests.
+	emit func(isError bool, msg string)
+}
+
+func newLogSampler(window time.Duration) *logSampler {
+	return &logSampler{
+		window:   window,
+		now:      time.Now,
+		messages: map[string]*sampledMessage{},
+		emit: func(isError bool, msg string) {
+			if isError {
+				klog.ErrorDepth(3, msg)
+			} else {
+				klog.WarningDepth(3, msg)
+			}
+		},
+	}
+}
+
+func (s *logSampler) Warningf(format string, args ...any) {
+	s.log(false, fmt.Sprintf(format, args...))
+}
+
+func (s *logSampler) Errorf(format string, args ...any) {
+	s.log(true, fmt.Sprintf(format, args...))
+}
+
+func (s *logSampler) log(isError bool, msg string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.window > 0 {
+		if m, ok := s.messages[msg]; ok && s.now().Sub(m.first) < s.window {
+			m.suppressed++
+			return
+		}
+		s.messages[msg] = &sampledMessage{isError: isError, first: s.now()}
+	}
+	s.emit(isError, msg)
+}
+
+// flush logs how often each message was suppressed and forgets the
+// messages whose window has passed.
+func (s *logSampler) flush() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := s.now()
+	for msg, m := range s.messages {
+		if m.suppressed > 0 {
+			s.emit(m.isError, fmt.Sprintf("%s (repeated %d more times since %s)", msg, m.suppressed, m.first.Format(time.RFC3339)))
+			m.suppressed = 0
+		}
+		if now.Sub(m.first) >= s.window {
+			delete(s.messages, msg)
+		}
+	}
+}
+
+// run flushes the summaries every window until stopCh is closed.
+func (s *logSampler) run(stopCh <-chan struct{}) {
+	if s.window <= 0 {
+		return
+	}
+	wait.Until(s.flush, s.window, stopCh)
+}
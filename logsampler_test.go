@@ -0,0 +1,42 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLogSampler(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	var logged []string
+	s := newLogSampler(time.Minute)
+	s.now = func() time.Time { return now }
+	s.emit = func(_ bool, msg string) { logged = append(logged, msg) }
+
+	for i := 0; i < 5; i++ {
+		s.Errorf("zone %d: %s", 1, "api down")
+	}
+	s.Warningf("other")
+	assert.Equal(t, []string{"zone 1: api down", "other"}, logged)
+
+	logged = nil
+	now = now.Add(time.Minute)
+	s.flush()
+	assert.Equal(t, []string{"zone 1: api down (repeated 4 more times since 2024-01-01T00:00:00Z)"}, logged)
+	assert.Empty(t, s.messages)
+
+	logged = nil
+	s.Errorf("zone %d: %s", 1, "api down")
+	assert.Equal(t, []string{"zone 1: api down"}, logged, "a message is logged again after its window")
+}
+
+func TestLogSamplerDisabled(t *testing.T) {
+	var logged int
+	s := newLogSampler(0)
+	s.emit = func(bool, string) { logged++ }
+	for i := 0; i < 3; i++ {
+		s.Errorf("same")
+	}
+	assert.Equal(t, 3, logged)
+}
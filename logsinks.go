@@ -0,0 +1,237 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-logr/logr"
+	logsapi "k8s.io/component-base/logs/api/v1"
+)
+
+// registerLogFormats adds the logfmt and syslog formats to --logging-format
+// next to the built-in text and json. It has to run before the server
+// command registers the logging flags.
+func registerLogFormats(opts *solverOptions) error {
+	if err := logsapi.RegisterLogFormat("logfmt", &kvLogFactory{}, logsapi.LoggingBetaOptions); err != nil {
+		return err
+	}
+	return logsapi.RegisterLogFormat("syslog", &kvLogFactory{opts: opts}, logsapi.LoggingBetaOptions)
+}
+
+// kvLogFactory creates loggers that write logfmt lines, either to stderr or,
+// when opts is set, as RFC 5424 messages to --syslog-address.
+type kvLogFactory struct {
+	opts *solverOptions
+}
+
+func (f *kvLogFactory) Create(c logsapi.LoggingConfiguration, o logsapi.LoggingOptions) (logr.Logger, logsapi.RuntimeControl) {
+	verbosity := &atomic.Uint32{}
+	verbosity.Store(uint32(c.Verbosity))
+
+	var out recordWriter
+	if f.opts == nil {
+		w := o.ErrorStream
+		if w == nil {
+			w = os.Stderr
+		}
+		out = &logfmtWriter{w: w}
+	} else {
+		sw, err := newSyslogWriter(f.opts.SyslogAddress, f.opts.SyslogFacility)
+		if err != nil {
+			// The logging configuration has no way to fail here; fall
+			// back to stderr so the problem is at least visible.
+			fmt.Fprintf(os.Stderr, "syslog logging disabled: %v\n", err)
+			out = &logfmtWriter{w: os.Stderr}
+		} else {
+			out = sw
+		}
+	}
+
+	sink := &kvSink{out: out, verbosity: verbosity}
+	return logr.New(sink), logsapi.RuntimeControl{
+		SetVerbosityLevel: func(v uint32) error {
+			verbosity.Store(v)
+			return nil
+		},
+	}
+}
+
+// recordWriter writes one formatted log record.
+type recordWriter interface {
+	write(t time.Time, isError bool, record []byte)
+}
+
+// kvSink is a logr.LogSink formatting records as logfmt key=value pairs.
+type kvSink struct {
+	out       recordWriter
+	verbosity *atomic.Uint32
+	name      string
+	values    []any
+}
+
+func (s *kvSink) Init(logr.RuntimeInfo) {}
+
+func (s *kvSink) Enabled(level int) bool {
+	return level >= 0 && uint32(level) <= s.verbosity.Load()
+}
+
+func (s *kvSink) Info(level int, msg string, kv ...any) {
+	s.log(false, level, msg, nil, kv)
+}
+
+func (s *kvSink) Error(err error, msg string, kv ...any) {
+	s.log(true, 0, msg, err, kv)
+}
+
+func (s *kvSink) WithValues(kv ...any) logr.LogSink {
+	n := *s
+	n.values = append(append([]any(nil), s.values...), kv...)
+	return &n
+}
+
+func (s *kvSink) WithName(name string) logr.LogSink {
+	n := *s
+	if n.name != "" {
+		name = n.name + "/" + name
+	}
+	n.name = name
+	return &n
+}
+
+func (s *kvSink) log(isError bool, level int, msg string, err error, kv []any) {
+	var b bytes.Buffer
+	if isError {
+		b.WriteString("level=error")
+	} else {
+		b.WriteString("level=info v=")
+		b.WriteString(strconv.Itoa(level))
+	}
+	if s.name != "" {
+		writeLogfmtPair(&b, "logger", s.name)
+	}
+	writeLogfmtPair(&b, "msg", msg)
+	if err != nil {
+		writeLogfmtPair(&b, "err", err.Error())
+	}
+	for _, pairs := range [][]any{s.values, kv} {
+		for i := 0; i < len(pairs); i += 2 {
+			var v any = "(MISSING)"
+			if i+1 < len(pairs) {
+				v = pairs[i+1]
+			}
+			writeLogfmtPair(&b, fmt.Sprint(pairs[i]), fmt.Sprint(v))
+		}
+	}
+	s.out.write(time.Now(), isError, b.Bytes())
+}
+
+func writeLogfmtPair(b *bytes.Buffer, key, value string) {
+	b.WriteByte(' ')
+	b.WriteString(strings.Map(func(r rune) rune {
+		if r <= ' ' || r == '=' || r == '"' {
+			return '_'
+		}
+		return r
+	}, key))
+	b.WriteByte('=')
+	if value == "" || strings.ContainsAny(value, " =\"\\") || strings.IndexFunc(value, func(r rune) bool { return r < ' ' }) >= 0 {
+		value = strconv.Quote(value)
+	}
+	b.WriteString(value)
+}
+
+// logfmtWriter writes timestamped logfmt lines.
+type logfmtWriter struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func (l *logfmtWriter) write(t time.Time, _ bool, record []byte) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	fmt.Fprintf(l.w, "ts=%s %s\n", t.UTC().Format(time.RFC3339Nano), record)
+}
+
+var syslogFacilities = map[string]int{
+	"kern": 0, "user": 1, "daemon": 3, "auth": 4,
+	"local0": 16, "local1": 17, "local2": 18, "local3": 19,
+	"local4": 20, "local5": 21, "local6": 22, "local7": 23,
+}
+
+// syslogWriter sends RFC 5424 messages over UDP, one per datagram, or over
+// TCP with octet-counting framing (RFC 6587). TCP connections are
+// re-established on the next message after a write error.
+type syslogWriter struct {
+	network  string
+	address  string
+	facility int
+	hostname string
+
+	mu   sync.Mutex
+	conn net.Conn
+}
+
+func newSyslogWriter(address, facility string) (*syslogWriter, error) {
+	if address == "" {
+		return nil, fmt.Errorf("--syslog-address is required with --logging-format=syslog")
+	}
+	u, err := url.Parse(address)
+	if err != nil {
+		return nil, fmt.Errorf("parsing --syslog-address: %w", err)
+	}
+	if u.Scheme != "udp" && u.Scheme != "tcp" {
+		return nil, fmt.Errorf("--syslog-address must be udp://host:port or tcp://host:port")
+	}
+	f, ok := syslogFacilities[facility]
+	if !ok {
+		return nil, fmt.Errorf("unknown --syslog-facility %q", facility)
+	}
+	hostname, _ := os.Hostname()
+	return &syslogWriter{network: u.Scheme, address: u.Host, facility: f, hostname: hostname}, nil
+}
+
+func (s *syslogWriter) write(t time.Time, isError bool, record []byte) {
+	severity := 6 // informational
+	if isError {
+		severity = 3
+	}
+	msg := fmt.Sprintf("<%d>1 %s %s %s %d - - %s",
+		s.facility*8+severity, t.UTC().Format(time.RFC3339Nano), nilValue(s.hostname),
+		"cert-manager-webhook-sakuracloud", os.Getpid(), record)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.conn == nil {
+		conn, err := net.DialTimeout(s.network, s.address, 5*time.Second)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "syslog: %v: %s\n", err, record)
+			return
+		}
+		s.conn = conn
+	}
+	if s.network == "tcp" {
+		msg = strconv.Itoa(len(msg)) + " " + msg
+	}
+	_ = s.conn.SetWriteDeadline(time.Now().Add(5 * time.Second))
+	if _, err := io.WriteString(s.conn, msg); err != nil {
+		fmt.Fprintf(os.Stderr, "syslog: %v: %s\n", err, record)
+		s.conn.Close()
+		s.conn = nil
+	}
+}
+
+func nilValue(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
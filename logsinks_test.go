@@ -0,0 +1,56 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	logsapi "k8s.io/component-base/logs/api/v1"
+)
+
+func TestLogfmtLogger(t *testing.T) {
+	var buf bytes.Buffer
+	log, _ := (&kvLogFactory{}).Create(logsapi.LoggingConfiguration{Verbosity: 2}, logsapi.LoggingOptions{ErrorStream: &buf})
+
+	log.WithName("solver").WithValues("zone", 123).Info("presented record", "fqdn", "_acme-challenge.example.com.", "note", `a "b"`)
+	log.V(3).Info("too verbose")
+	log.Error(errors.New("api down"), "update failed")
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	require.Len(t, lines, 2)
+	assert.Contains(t, lines[0], ` level=info v=0 logger=solver msg="presented record" zone=123 fqdn=_acme-challenge.example.com. note="a \"b\""`)
+	assert.True(t, strings.HasPrefix(lines[0], "ts="))
+	assert.Contains(t, lines[1], ` level=error msg="update failed" err="api down"`)
+}
+
+func TestSyslogWriterUDP(t *testing.T) {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer pc.Close()
+
+	w, err := newSyslogWriter("udp://"+pc.LocalAddr().String(), "local0")
+	require.NoError(t, err)
+	w.write(time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC), true, []byte(`level=error msg="x"`))
+
+	buf := make([]byte, 1024)
+	require.NoError(t, pc.SetReadDeadline(time.Now().Add(5*time.Second)))
+	n, _, err := pc.ReadFrom(buf)
+	require.NoError(t, err)
+	msg := string(buf[:n])
+	assert.True(t, strings.HasPrefix(msg, "<131>1 2024-01-02T03:04:05Z "), msg)
+	assert.True(t, strings.HasSuffix(msg, ` - - level=error msg="x"`), msg)
+}
+
+func TestNewSyslogWriterValidation(t *testing.T) {
+	_, err := newSyslogWriter("", "daemon")
+	assert.Error(t, err)
+	_, err = newSyslogWriter("unix:///dev/log", "daemon")
+	assert.Error(t, err)
+	_, err = newSyslogWriter("udp://localhost:514", "nope")
+	assert.Error(t, err)
+}
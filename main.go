@@ -2,30 +2,65 @@ package main
 
 import (
 	"context"
-	"encoding/json"
 	"errors"
+	"flag"
 	"fmt"
+	"math/rand"
 	"os"
 	"slices"
+	"strconv"
 	"strings"
+	"sync"
+	"time"
 
-	cmmeta "github.com/cert-manager/cert-manager/pkg/apis/meta/v1"
-	extapi "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
-	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/apiserver/pkg/features"
+	genericapiserver "k8s.io/apiserver/pkg/server"
+	utilfeature "k8s.io/apiserver/pkg/util/feature"
+	"k8s.io/client-go/dynamic"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/leaderelection"
+	"k8s.io/component-base/logs"
 	"k8s.io/klog/v2"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
 
+	"github.com/cert-manager/cert-manager/pkg/acme/webhook"
 	"github.com/cert-manager/cert-manager/pkg/acme/webhook/apis/acme/v1alpha1"
-	"github.com/cert-manager/cert-manager/pkg/acme/webhook/cmd"
+	"github.com/cert-manager/cert-manager/pkg/acme/webhook/cmd/server"
+	logf "github.com/cert-manager/cert-manager/pkg/logs"
+	"github.com/cert-manager/webhook-example/pkg/dnsname"
 	"github.com/sacloud/iaas-api-go"
-	"github.com/sacloud/iaas-api-go/types"
-	"github.com/sacloud/iaas-service-go/dns"
+	"github.com/spf13/cobra"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
 var GroupName = os.Getenv("GROUP_NAME")
 
+// subcommands are helper commands that run instead of the webhook server when
+// their name is given as the first argument, e.g. `webhook gen-secret`.
+var subcommands = map[string]func(args []string) error{
+	"drain":          runDrain,
+	"gen-secret":     runGenSecret,
+	"migrate":        runMigrate,
+	"restore":        runRestore,
+	"simulate":       runSimulate,
+	"support-bundle": runSupportBundle,
+}
+
 func main() {
+	if len(os.Args) > 1 {
+		if run, ok := subcommands[os.Args[1]]; ok {
+			if err := run(os.Args[2:]); err != nil && !errors.Is(err, flag.ErrHelp) {
+				fmt.Fprintf(os.Stderr, "%s: %v\n", os.Args[1], err)
+				os.Exit(1)
+			}
+			return
+		}
+	}
+
 	if GroupName == "" {
 		panic("GROUP_NAME must be specified")
 	}
@@ -35,11 +70,128 @@ func main() {
 	// You can register multiple DNS provider implementations with a single
 	// webhook, where the Name() method will be used to disambiguate between
 	// the different implementations.
-	cmd.RunWebhookServer(GroupName,
-		&sakuraCloudDNSProviderSolver{},
+	opts := newSolverOptions()
+	solver := &sakuraCloudDNSProviderSolver{opts: opts, initialized: make(chan struct{})}
+	groupSolvers := func(opts *solverOptions) []webhook.Solver {
+		g := newGroupSolver(solver, opts)
+		return []webhook.Solver{g, &stagingSolver{g.sakuraCloudDNSProviderSolver}}
+	}
+	runWebhookServer(GroupName, opts, groupSolvers,
+		solver,
+		&stagingSolver{solver},
 	)
 }
 
+// runWebhookServer is the equivalent of cmd.RunWebhookServer from
+// cert-manager, except that the solver's own flags are registered on the
+// server command before it is executed, and that the groups listed in
+// --groups-config are served too, by the solvers groupSolvers returns for
+// their options.
+func runWebhookServer(groupName string, opts *solverOptions, groupSolvers func(*solverOptions) []webhook.Solver, hooks ...webhook.Solver) {
+	stopCh := genericapiserver.SetupSignalHandler()
+
+	logs.InitLogs()
+	defer logs.FlushLogs()
+
+	if err := registerLogFormats(opts); err != nil {
+		klog.Fatalf("registering log formats: %v", err)
+	}
+
+	o := server.NewWebhookServerOptions(os.Stdout, os.Stderr, groupName, hooks...)
+	cmd := &cobra.Command{
+		Short: "Launch an ACME solver API server",
+		Long:  "Launch an ACME solver API server",
+		PreRunE: func(*cobra.Command, []string) error {
+			if err := opts.applyEnv(); err != nil {
+				return err
+			}
+			warnDeprecatedFlags(opts.flags, true)
+			return nil
+		},
+		RunE: func(*cobra.Command, []string) error {
+			if err := logf.ValidateAndApply(o.Logging); err != nil {
+				return err
+			}
+			var groups []groupConfig
+			if opts.GroupsConfig != "" {
+				var err error
+				if groups, err = loadGroupsConfig(opts.GroupsConfig); err != nil {
+					return fmt.Errorf("--groups-config: %w", err)
+				}
+			}
+			logStartupBanner(groupName, groups, hooks, opts)
+			opts.groupNames = []string{groupName}
+			for _, g := range groups {
+				opts.groupNames = append(opts.groupNames, g.GroupName)
+			}
+
+			// Set by cert-manager's server as well: the extension apiserver
+			// does not need priority and fairness.
+			utilruntime.Must(utilfeature.DefaultMutableFeatureGate.Set(fmt.Sprintf("%s=false", features.APIPriorityAndFairness)))
+			config, err := o.Config()
+			if err != nil {
+				return err
+			}
+			srv, err := config.Complete().New()
+			if err != nil {
+				return err
+			}
+			for _, g := range groups {
+				if g.GroupName == groupName {
+					return fmt.Errorf("--groups-config: group %s is already served as GROUP_NAME", g.GroupName)
+				}
+				groupOpts, err := opts.forGroup(g.Args)
+				if err != nil {
+					return fmt.Errorf("--groups-config: group %s: %w", g.GroupName, err)
+				}
+				groupOpts.groupNames = []string{g.GroupName}
+				if err := installSolverGroup(srv.GenericAPIServer, g.GroupName, groupSolvers(groupOpts)...); err != nil {
+					return err
+				}
+			}
+
+			// The server of cert-manager initializes the solvers with the
+			// in-cluster config as well.
+			restConfig, err := rest.InClusterConfig()
+			if err != nil {
+				return err
+			}
+			mgr, err := newManager(restConfig, opts)
+			if err != nil {
+				return err
+			}
+			for _, hook := range hooks {
+				if s, ok := hook.(interface{ useManager(manager.Manager) error }); ok {
+					if err := s.useManager(mgr); err != nil {
+						return err
+					}
+				}
+			}
+			if err := mgr.Add(newAPIServerRunnable(srv.GenericAPIServer)); err != nil {
+				return err
+			}
+			return mgr.Start(wait.ContextForChannel(stopCh))
+		},
+	}
+	logf.AddFlags(o.Logging, cmd.Flags())
+	o.RecommendedOptions.AddFlags(cmd.Flags())
+	opts.AddFlags(cmd.Flags())
+
+	if err := cmd.Execute(); err != nil {
+		klog.Errorf("error executing command: %v", err)
+		logs.FlushLogs()
+		os.Exit(1)
+	}
+
+	// The manager has stopped the server and the background loops, and
+	// released the leader lease; let the solvers clean up.
+	for _, hook := range hooks {
+		if s, ok := hook.(interface{ shutdown() }); ok {
+			s.shutdown()
+		}
+	}
+}
+
 // sakuraCloudDNSProviderSolver implements the provider-specific logic needed to
 // 'present' an ACME challenge TXT record for your own DNS provider.
 // To do so, it must implement the `github.com/cert-manager/cert-manager/pkg/acme/webhook.Solver`
@@ -52,48 +204,105 @@ type sakuraCloudDNSProviderSolver struct {
 	// 4. ensure your webhook's service account has the required RBAC role
 	//    assigned to it for interacting with the Kubernetes APIs you need.
 	client kubernetes.Interface
-}
 
-// sakuraCloudDNSProviderConfig is a structure that is used to decode into when
-// solving a DNS01 challenge.
-// This information is provided by cert-manager, and may be a reference to
-// additional configuration that's needed to solve the challenge for this
-// particular certificate or issuer.
-// This typically includes references to Secret resources containing DNS
-// provider credentials, in cases where a 'multi-tenant' DNS solver is being
-// created.
-// If you do *not* require per-issuer or per-certificate configuration to be
-// provided to your webhook, you can skip decoding altogether in favour of
-// using CLI flags or similar to provide configuration.
-// You should not include sensitive information here. If credentials need to
-// be used by your provider here, you should reference a Kubernetes Secret
-// resource and fetch these credentials using a Kubernetes clientset.
-type sakuraCloudDNSProviderConfig struct {
-	// Change the two fields below according to the format of the configuration
-	// to be decoded.
-	// These fields will be set by users in the
-	// `issuer.spec.acme.dns01.providers.webhook.config` field.
-
-	//Email           string `json:"email"`
-	//APIKeySecretRef v1alpha1.SecretKeySelector `json:"apiKeySecretRef"`
-	ZoneID               int64                    `json:"zoneID"`
-	AccessTokenRef       cmmeta.SecretKeySelector `json:"accessTokenRef"`
-	AccessTokenSecretRef cmmeta.SecretKeySelector `json:"accessTokenSecretRef"`
-}
+	// secretsClient reads credential Secrets. It is the same as client
+	// unless --secrets-kubeconfig points at another cluster.
+	secretsClient kubernetes.Interface
 
-func (c *sakuraCloudDNSProviderSolver) newClient(cfg *sakuraCloudDNSProviderConfig, ch *v1alpha1.ChallengeRequest) (*dns.Service, error) {
-	accessToken, err := c.getSecretString(&cfg.AccessTokenRef, ch.ResourceNamespace)
-	if err != nil {
-		return nil, err
-	}
-	accessTokenSecret, err := c.getSecretString(&cfg.AccessTokenSecretRef, ch.ResourceNamespace)
-	if err != nil {
-		return nil, err
-	}
+	// secrets serves credential Secrets from informers if --watch-namespaces
+	// is set, else it is nil and they are read from secretsClient.
+	secrets *secretWatcher
+
+	opts *solverOptions
+
+	// batcher groups concurrent edits of the same zone.
+	batcher *zoneBatcher
+
+	// queue limits the zone operations running at once to --zone-workers.
+	queue *zoneQueue
+
+	// presented caches recent successful Present calls.
+	presented *presentCache
 
-	return dns.New(
-		iaas.NewClient(accessToken, accessTokenSecret),
-	), nil
+	// metadata caches the IDs, names and nameservers of the zones read.
+	metadata *zoneMetadataCache
+
+	// budget counts zone updates against --daily-update-budget, if set.
+	budget *updateBudget
+
+	// history keeps the last --zone-history-size edits of every zone.
+	history *zoneHistory
+
+	// changes posts the zone edits to --change-event-url, if set.
+	changes *changeNotifier
+
+	// audit writes the zone writes to --audit-log-path, if set.
+	audit *auditLog
+
+	// alerts sends alerts to --notification-group-id, if set.
+	alerts *alertNotifier
+
+	// heartbeat detects other installations writing to the same zones with
+	// --heartbeat-namespace.
+	heartbeat *installationHeartbeat
+
+	// leader is set with --leader-elect; only the leader writes to zones.
+	// leaderID is the identity of this replica in the Lease, and forwarder
+	// sends the challenges reaching a follower to the leader with
+	// --leader-election-address.
+	leader    *leaderelection.LeaderElector
+	leaderID  string
+	forwarder *leaderForwarder
+
+	// registry records the challenge records written by the webhook. It is
+	// nil unless the registry is enabled, see registryEnabled.
+	registry *ownershipRegistry
+
+	// dynamic lists cert-manager Challenges for the registry garbage
+	// collection and the APIServices of the served groups, and watches
+	// Issuers with --issuer-check-interval.
+	dynamic dynamic.Interface
+
+	// pruner deletes stale challenge records with --prune-age.
+	pruner *recordPruner
+
+	// snapshots keeps the record sets of zones before they are updated. It
+	// is nil unless --snapshot-dir is set.
+	snapshots *snapshotStore
+
+	// delegation warns about zones whose public delegation misses their
+	// Sakura Cloud nameservers.
+	delegation *delegationChecker
+
+	// initialized, if set, is closed once Initialize has succeeded, for the
+	// solvers of the groups in --groups-config and /readyz.
+	initialized chan struct{}
+
+	// groups are the options of the groups in --groups-config, for the
+	// issuer checks.
+	groups []*solverOptions
+
+	// mgr runs the background loops of the solver, see start. It is nil
+	// outside of the webhook server.
+	mgr manager.Manager
+
+	// drain tracks the challenges in flight for the preStop hook, with
+	// --drain-bind-address.
+	drain *drainer
+
+	// checks are the conditions /readyz reports once initialized.
+	checks []readinessCheck
+
+	// stopped is canceled once the stopCh of Initialize is closed, and
+	// verifications counts the propagation checks of
+	// --async-propagation-check still running, which shutdown waits for. It
+	// is shared with the solvers of the other groups.
+	stopped       context.Context
+	verifications *sync.WaitGroup
+
+	// newZoneAPI, if set, replaces the Sakura Cloud DNS API client of an API
+	// key, for tests; see pkg/testing.
+	newZoneAPI func(accessToken, accessTokenSecret string) zoneAPI
 }
 
 // Name is used as the name for this DNS solver when referencing it on the ACME
@@ -112,79 +321,311 @@ func (c *sakuraCloudDNSProviderSolver) Name() string {
 // cert-manager itself will later perform a self check to ensure that the
 // solver has correctly configured the DNS provider.
 func (c *sakuraCloudDNSProviderSolver) Present(ch *v1alpha1.ChallengeRequest) error {
-	cfg, err := loadConfig(ch.Config)
-	if err != nil {
+	return c.present(context.Background(), ch, false)
+}
+
+// presentContext is Present for the requests of the gRPC service, which
+// are aborted with ctx.
+func (c *sakuraCloudDNSProviderSolver) presentContext(ctx context.Context, ch *v1alpha1.ChallengeRequest) error {
+	return c.present(ctx, ch, false)
+}
+
+// present implements Present. With injectFailures set, part of the calls
+// fail according to --inject-failure-rate; see stagingSolver.
+func (c *sakuraCloudDNSProviderSolver) present(parent context.Context, ch *v1alpha1.ChallengeRequest, injectFailures bool) (err error) {
+	if err := validateChallenge(ch); err != nil {
 		return err
 	}
-
-	client, err := c.newClient(&cfg, ch)
-	if err != nil {
+	solver := c.Name()
+	if injectFailures {
+		solver = (&stagingSolver{c}).Name()
+	}
+	if forwarded, err := c.forwardToLeader(parent, ch, v1alpha1.ChallengeActionPresent, solver); forwarded {
 		return err
 	}
-	zone, err := client.Read(&dns.ReadRequest{
-		ID: types.Int64ID(cfg.ZoneID),
-	})
+	defer c.drain.track()()
+	defer func(start time.Time) { logChallengeResult("present", ch, start, err) }(time.Now())
+	if problem := keyProblem(ch.Key); problem != "" {
+		malformedKeysTotal.Inc()
+		sampledLog.Warningf("the key of the challenge for %s in namespace %s (digest %s) %s: it does not look like an ACME DNS-01 key, check for corruption between cert-manager and the webhook",
+			ch.ResolvedFQDN, ch.ResourceNamespace, keyDigest(ch.Key), problem)
+	}
+	ctx, cancel := c.handlerContext(parent)
+	defer cancel()
+	ctx, calls := withAPICallCounter(ctx)
+	defer calls.observe("present")
+	cfg, err := c.resolveConfig(ctx, ch)
 	if err != nil {
-		return err
+		return c.deadlineError(ctx, maintenanceRetriable(err))
 	}
+	ctx, span := tracer.Start(ctx, "Present", trace.WithAttributes(
+		attribute.String("fqdn", ch.ResolvedFQDN),
+		attribute.Int64("zone.id", cfg.ZoneID),
+	))
+	defer func() {
+		observeChallenge(cfg.ZoneID, "present", err)
+		c.alerts.observeChallenge(cfg.ZoneID, "present", err)
+		endSpan(span, err)
+	}()
+	defer func() {
+		err = c.deadlineError(ctx, maintenanceRetriable(err))
+	}()
 
-	entry, err := c.getEntry(ch, zone)
+	if injectFailures && injectFailure(c.opts.InjectFailureRate, rand.Float64) {
+		return errInjectedFailure
+	}
+
+	rdata, err := txtRData(ch.Key)
+	if err != nil {
+		return fmt.Errorf("invalid challenge key: %w", err)
+	}
+
+	// The generation is recorded first, also when the record is already
+	// there, so that a delayed CleanUp of an older Challenge with the same
+	// key leaves it to this one.
+	entry := newRegistryEntry(&cfg, ch)
+	if err := c.registry.presenting(entry); err != nil {
+		return fmt.Errorf("recording %s in the registry: %w", ch.ResolvedFQDN, err)
+	}
+	cacheKey := newPresentKey(&cfg, ch, keyDigest(ch.Key))
+	if c.presented.hit(cacheKey) {
+		logRecordsf("%s was presented recently, skipping", ch.ResolvedFQDN)
+		return nil
+	}
+
+	// The serials are read before the edit is queued, so that the zone is
+	// not held by the lookups.
+	refresh := c.newZoneRefresh(ctx, ch.ResolvedZone)
+	changed, err := c.presentRecord(ctx, &cfg, ch, rdata)
 	if err != nil {
 		return err
 	}
-	klog.V(6).Infof("present for entry=%s, zone=%s", entry, zone.Name)
+	if mirror := cfg.mirror(); mirror != nil {
+		if _, err := c.presentRecord(ctx, mirror, ch, rdata); err != nil {
+			return fmt.Errorf("presenting %s in mirror zone %d: %w", ch.ResolvedFQDN, mirror.ZoneID, err)
+		}
+	}
+	if changed {
+		refresh.wait(ctx)
+	}
+	if !c.opts.AsyncPropagationCheck {
+		if err := c.checkPropagation(ctx, &cfg, ch, rdata, true); err != nil {
+			return err
+		}
+	}
+	c.presented.add(cacheKey)
+	if !c.opts.AsyncPropagationCheck && cfg.PropagationCheckTimeout.Duration > 0 {
+		entry.propagationChecked(nil)
+	}
+	if err := c.registry.put(entry); err != nil {
+		sampledLog.Warningf("recording %s in the registry: %v", ch.ResolvedFQDN, err)
+	}
+	if c.opts.AsyncPropagationCheck {
+		c.goVerifyPropagation(cfg, ch, rdata)
+	}
+	return nil
+}
+
+func newPresentKey(cfg *sakuraCloudDNSProviderConfig, ch *v1alpha1.ChallengeRequest, digest string) presentKey {
+	return presentKey{
+		namespace: ch.ResourceNamespace,
+		zoneID:    cfg.ZoneID,
+		fqdn:      ch.ResolvedFQDN,
+		keyDigest: digest,
+	}
+}
+
+// presentRecord makes sure the zone holds the challenge TXT record and
+// reports whether the zone had to be updated for that.
+func (c *sakuraCloudDNSProviderSolver) presentRecord(ctx context.Context, cfg *sakuraCloudDNSProviderConfig, ch *v1alpha1.ChallengeRequest, rdata string) (bool, error) {
+	edit := &zoneEdit{op: zoneOp{fqdn: ch.ResolvedFQDN, keyDigest: keyDigest(ch.Key)}, apply: func(zone *iaas.DNS) (bool, error) {
+		entry, err := c.getEntry(ch, zone)
+		if err != nil {
+			return false, err
+		}
+		logRecordsf("present for entry=%s, zone=%s", entry, zone.Name)
 
-	records := zone.GetRecords()
-	isExists := false
-	for _, record := range records {
-		if record.Name == entry && record.Type == types.DNSRecordTypes.TXT {
-			record.RData = ch.Key
-			isExists = true
-			break
+		changed, err := presentTXT(zone, ch.ResolvedFQDN, entry, rdata, *cfg.TTL, cfg.TXTConflictPolicy)
+		if err != nil {
+			return false, err
 		}
+		if owner := c.opts.ExternalDNSOwnerID; owner != "" {
+			added, err := addExternalDNSOwner(zone, entry, owner, *cfg.TTL)
+			if err != nil {
+				return false, err
+			}
+			changed = changed || added
+		}
+		return changed, nil
+	}}
+	c.editZone(ctx, cfg, ch, edit)
+	if edit.err != nil {
+		if isNotFoundError(edit.err) {
+			return false, zoneNotFoundError(cfg.ZoneID, edit.err)
+		}
+		return false, edit.err
 	}
-	if !isExists {
-		records.Add(&iaas.DNSRecord{
-			Name:  entry,
-			Type:  types.DNSRecordTypes.TXT,
-			RData: ch.Key,
-			TTL:   60,
+	return edit.changed, nil
+}
+
+// editZone applies edit to the configured zone. Edits of the same zone with
+// the same credentials are grouped into a single zone write when
+// --zone-batch-window is set, and the writes wait for one of the
+// --zone-workers. With --record-engine=v1 every edit is written on its own,
+// one at a time per zone.
+func (c *sakuraCloudDNSProviderSolver) editZone(ctx context.Context, cfg *sakuraCloudDNSProviderConfig, ch *v1alpha1.ChallengeRequest, edit *zoneEdit) {
+	if c.leader != nil && !c.leader.IsLeader() {
+		edit.err = notLeaderError(c.leader)
+		return
+	}
+	key := batchKey(ch.ResourceNamespace, cfg)
+	edit.calls = apiCallCounterFrom(ctx)
+	if edit.op != (zoneOp{}) {
+		edit.op.scope = key
+		edit.actor = challengeActor(ch)
+	}
+	zone := strconv.FormatInt(cfg.ZoneID, 10)
+	if c.opts.RecordEngine == "v1" {
+		edit.unmerged = true
+		c.queue.do(ctx, zone, []*zoneEdit{edit}, func(edits []*zoneEdit) {
+			c.applyEdits(ctx, cfg, ch, edits)
 		})
+		return
 	}
-	_, err = client.Update(&dns.UpdateRequest{
-		ID:           zone.ID,
-		Records:      records,
-		SettingsHash: zone.SettingsHash,
+	c.batcher.do(ctx, key, edit, func(ctx context.Context, edits []*zoneEdit) {
+		c.queue.do(ctx, zone, edits, func(edits []*zoneEdit) {
+			c.applyEdits(ctx, cfg, ch, edits)
+		})
 	})
-	return err
 }
 
-func (c *sakuraCloudDNSProviderSolver) getEntry(ch *v1alpha1.ChallengeRequest, zone *iaas.DNS) (string, error) {
-	zoneName := zone.Name
-	if zoneName[len(zoneName)-1] != '.' {
-		zoneName += "."
+// batchKey identifies the edits that may share a read and a write of the
+// zone of cfg: those of challenges in namespace ns whose configs select the
+// same zone, the same way, with the same API keys, including the secondary
+// ones they fall back to. The zone is read with the config of any of them.
+func batchKey(ns string, cfg *sakuraCloudDNSProviderConfig) string {
+	key := fmt.Sprintf("%s/%d/%s", ns, cfg.ZoneID, cfg.ZoneName)
+	for _, cred := range cfg.credentials() {
+		key += fmt.Sprintf("/%s:%s,%s:%s", cred.accessTokenRef.Name, cred.accessTokenRef.Key, cred.accessTokenSecretRef.Name, cred.accessTokenSecretRef.Key)
 	}
-	if !strings.HasSuffix(ch.ResolvedZone, zoneName) {
-		return "", fmt.Errorf("invalid zone, resolvedZone: %s, zoneName: %s", ch.ResolvedZone, zoneName)
+	return key
+}
+
+// applyEdits reads the zone once, applies every edit to it and writes it
+// back if any of them changed it. Errors are reported on the edits, and the
+// API requests are shared evenly among them.
+func (c *sakuraCloudDNSProviderSolver) applyEdits(ctx context.Context, cfg *sakuraCloudDNSProviderConfig, ch *v1alpha1.ChallengeRequest, edits []*zoneEdit) {
+	ctx, calls := withAPICallCounter(ctx)
+	defer func() {
+		for _, e := range edits {
+			e.calls.add(calls, 1/float64(len(edits)))
+		}
+	}()
+	defer c.history.record(cfg.ZoneID, edits)
+	var zone *iaas.DNS
+	defer func() { c.audit.record(cfg.ZoneID, zone, edits) }()
+	client, zone, err := c.readZone(ctx, cfg, ch)
+	if err != nil {
+		for _, e := range edits {
+			e.err = err
+		}
+		return
 	}
 
-	entry, ok := strings.CutSuffix(ch.ResolvedFQDN, "."+zoneName)
-	if !ok {
-		return "", fmt.Errorf("invalid fqdn, resolvedFQDN: %s, zoneName: %s", ch.ResolvedFQDN, zoneName)
+	// The edits change the records in place, so the dump of the zone as it
+	// was read has to be taken first.
+	var before string
+	if klog.V(logLevelZones).Enabled() {
+		before = recordLines(zone.Records)
+	}
+	var snapshot *zoneSnapshot
+	if c.snapshots != nil || c.opts.VerifyZoneUpdates {
+		snapshot = newZoneSnapshot(zone, time.Now())
+	}
+	var original iaas.DNSRecords
+	if c.opts.VerifyZoneUpdates {
+		original = snapshot.records()
 	}
-	return entry, nil
-}
 
-func (c *sakuraCloudDNSProviderSolver) getSecretString(ref *cmmeta.SecretKeySelector, ns string) (string, error) {
-	secret, err := c.client.CoreV1().Secrets(ns).Get(context.TODO(), ref.Name, v1.GetOptions{})
+	start := time.Now()
+	changed := 0
+	for _, e := range edits {
+		e.changed, e.err = e.apply(zone)
+		if e.changed {
+			changed++
+		}
+	}
+	observePhase("compute", start)
+	if changed == 0 {
+		return
+	}
+	if deferCleanups(client, edits) {
+		klog.Infof("deferring %d challenge record cleanups in zone %s, see --daily-update-budget", changed, zone.Name)
+		return
+	}
+	if len(edits) > 1 {
+		klog.Infof("grouped %d challenge record changes for zone %s into a single update", changed, zone.Name)
+	}
+	if err := c.snapshots.save(snapshot); err != nil {
+		sampledLog.Warningf("saving a snapshot of zone %s: %v", zone.Name, err)
+	}
+	start = time.Now()
+	err = c.updateRecordsVerified(ctx, client, zone, original)
+	observePhase("zone_update", start)
 	if err != nil {
-		return "", err
+		for _, e := range edits {
+			if e.changed {
+				e.changed, e.err = false, err
+			}
+		}
+		return
+	}
+	c.changes.notify(zone, edits)
+	c.heartbeat.wrote(zone.ID.Int64())
+	if klog.V(logLevelZones).Enabled() {
+		klog.Infof("records of zone %s before the update:\n%s", zone.Name, before)
+		klog.Infof("records of zone %s after the update:\n%s", zone.Name, recordLines(zone.Records))
 	}
+}
+
+// updateRecords replaces the record set of zone with records. Every zone
+// write of the solver goes through here. records is normally zone.Records
+// edited in place; it is compacted and sorted rather than copied, so large
+// zones are not duplicated in memory on every challenge.
+//
+// The update only carries the records and the settings hash, not the name,
+// description, tags or icon of the zone. The API has no way to send fewer
+// records: it replaces the whole record set, so the size of every update
+// grows with the zone and is reported per zone.
+func (c *sakuraCloudDNSProviderSolver) updateRecords(ctx context.Context, client zoneAPI, zone *iaas.DNS, records iaas.DNSRecords) error {
+	records, removed := dedupeRecords(records)
+	if removed > 0 {
+		klog.Infof("removing %d duplicate records from zone %s", removed, zone.Name)
+	}
+	sortRecords(records)
+	zone.Records = records
+	ctx, size := withRequestBodySize(ctx)
+	_, err := client.UpdateSettings(ctx, zone.ID, &iaas.DNSUpdateSettingsRequest{
+		Records:      records,
+		SettingsHash: zone.SettingsHash,
+	})
+	if n := size.Load(); n > 0 {
+		zoneUpdateBytes.WithLabelValues(zoneLabels.nameLabel(zone)).Set(float64(n))
+		logAPIf("sent %d records of zone %s in a %d byte update", len(records), zone.Name, n)
+	}
+	return err
+}
 
-	if accessToken, ok := secret.Data[ref.Key]; ok {
-		return string(accessToken), nil
+// getEntry returns the name of the challenge record of ch relative to zone.
+func (c *sakuraCloudDNSProviderSolver) getEntry(ch *v1alpha1.ChallengeRequest, zone *iaas.DNS) (string, error) {
+	if !dnsname.MatchZone(ch.ResolvedZone, zone.Name) {
+		return "", fmt.Errorf("invalid zone, resolvedZone: %s, zoneName: %s", ch.ResolvedZone, dnsname.NormalizeZone(zone.Name))
+	}
+	entry, err := dnsname.RelativeName(ch.ResolvedFQDN, zone.Name)
+	if err != nil {
+		return "", fmt.Errorf("invalid fqdn, resolvedFQDN: %s, zoneName: %s", ch.ResolvedFQDN, dnsname.NormalizeZone(zone.Name))
 	}
-	return "", errors.New("accessToken not found")
+	return entry, nil
 }
 
 // CleanUp should delete the relevant TXT record from the DNS provider console.
@@ -194,48 +635,129 @@ func (c *sakuraCloudDNSProviderSolver) getSecretString(ref *cmmeta.SecretKeySele
 // This is in order to facilitate multiple DNS validations for the same domain
 // concurrently.
 func (c *sakuraCloudDNSProviderSolver) CleanUp(ch *v1alpha1.ChallengeRequest) error {
-	cfg, err := loadConfig(ch.Config)
-	if err != nil {
-		return err
-	}
+	return c.cleanUpContext(context.Background(), ch)
+}
 
-	client, err := c.newClient(&cfg, ch)
-	if err != nil {
+// cleanUpContext is CleanUp for the requests of the gRPC service, which are
+// aborted with ctx.
+func (c *sakuraCloudDNSProviderSolver) cleanUpContext(ctx context.Context, ch *v1alpha1.ChallengeRequest) error {
+	if err := validateChallenge(ch); err != nil {
 		return err
 	}
-	zone, err := client.Read(&dns.ReadRequest{
-		ID: types.Int64ID(cfg.ZoneID),
-	})
-	if err != nil {
+	if forwarded, err := c.forwardToLeader(ctx, ch, v1alpha1.ChallengeActionCleanUp, c.Name()); forwarded {
 		return err
 	}
+	return c.cleanUp(ctx, ch, keyDigest(ch.Key))
+}
 
-	entry, err := c.getEntry(ch, zone)
+// cleanUp implements CleanUp for the challenge whose key has the given
+// keyDigest. ch.Key is not used, as the registry entries of the cleanups
+// retried in the background only know the digest.
+func (c *sakuraCloudDNSProviderSolver) cleanUp(parent context.Context, ch *v1alpha1.ChallengeRequest, digest string) (err error) {
+	defer c.drain.track()()
+	defer func(start time.Time) { logChallengeResult("cleanup", ch, start, err) }(time.Now())
+	ctx, cancel := c.handlerContext(parent)
+	defer cancel()
+	ctx, calls := withAPICallCounter(ctx)
+	defer calls.observe("cleanup")
+	cfg, err := c.resolveConfig(ctx, ch)
 	if err != nil {
-		return err
+		return c.deadlineError(ctx, maintenanceRetriable(err))
 	}
+	ctx, span := tracer.Start(ctx, "CleanUp", trace.WithAttributes(
+		attribute.String("fqdn", ch.ResolvedFQDN),
+		attribute.Int64("zone.id", cfg.ZoneID),
+	))
+	defer func() {
+		observeChallenge(cfg.ZoneID, "cleanup", err)
+		c.alerts.observeChallenge(cfg.ZoneID, "cleanup", err)
+		endSpan(span, err)
+	}()
+	defer func() {
+		err = c.deadlineError(ctx, maintenanceRetriable(err))
+	}()
+
+	c.presented.remove(newPresentKey(&cfg, ch, digest))
 
-	records := zone.GetRecords()
-	isExists := false
-	records = slices.DeleteFunc(records, func(d *iaas.DNSRecord) bool {
-		if d.Name == entry && d.Type == types.DNSRecordTypes.TXT {
-			isExists = true
-			return true
+	owned := newRegistryEntry(&cfg, ch)
+	owned.KeyDigest = digest
+	var newer *registryEntry
+	edit := &zoneEdit{op: zoneOp{fqdn: ch.ResolvedFQDN, keyDigest: digest, cleanup: true}, apply: func(zone *iaas.DNS) (bool, error) {
+		entry, err := c.getEntry(ch, zone)
+		if err != nil {
+			return false, err
 		}
-		return false
-	})
-	if isExists {
-		klog.V(6).Infof("cleanup for entry=%s, zone=%s", entry, zone.Name)
-		_, err = client.Update(&dns.UpdateRequest{
-			ID:           zone.ID,
-			Records:      records,
-			SettingsHash: zone.SettingsHash,
-		})
-		return err
+
+		// The registry is read after the zone: a Present of another
+		// Challenge that wrote to the zone before has recorded its
+		// generation by then, and one writing after re-adds the record.
+		if newer, err = c.registry.presentedAfter(owned, string(ch.UID)); err != nil {
+			return false, fmt.Errorf("reading the registry: %w", err)
+		} else if newer != nil {
+			klog.Infof("keeping %s, it was presented again by Challenge %s (generation %d) after the one being cleaned up", ch.ResolvedFQDN, newer.ChallengeUID, newer.Generation)
+			return false, nil
+		}
+
+		changed := cleanUpTXT(zone, entry, digest)
+		if owner := c.opts.ExternalDNSOwnerID; owner != "" && removeExternalDNSOwner(zone, entry, owner) {
+			changed = true
+		}
+		if !changed {
+			return false, nil
+		}
+		logRecordsf("cleanup for entry=%s, zone=%s", entry, zone.Name)
+		return true, nil
+	}}
+	c.editZone(ctx, &cfg, ch, edit)
+	if newer != nil {
+		return nil
+	}
+	if edit.err == nil && !edit.changed {
+		// The record was removed by hand or by an earlier CleanUp whose
+		// response was lost; the zone was read but not written.
+		noopCleanupsTotal.Inc()
+		logRecordsf("%s is already gone from zone %d, nothing to clean up", ch.ResolvedFQDN, cfg.ZoneID)
+	}
+	if mirror := cfg.mirror(); mirror != nil && (edit.err == nil || isNotFoundError(edit.err)) {
+		mirrorEdit := &zoneEdit{op: edit.op, apply: edit.apply}
+		c.editZone(ctx, mirror, ch, mirrorEdit)
+		switch {
+		case mirrorEdit.err == nil:
+		case isNotFoundError(mirrorEdit.err):
+			klog.Infof("mirror DNS zone %d no longer exists, nothing to clean up for %s", mirror.ZoneID, ch.ResolvedFQDN)
+		default:
+			edit.err = fmt.Errorf("cleaning up %s in mirror zone %d: %w", ch.ResolvedFQDN, mirror.ZoneID, mirrorEdit.err)
+		}
+	}
+
+	if edit.err != nil {
+		if !isNotFoundError(edit.err) {
+			if err := c.registry.cleanupFailed(owned, edit.err); err != nil {
+				sampledLog.Warningf("recording the failed cleanup of %s: %v", ch.ResolvedFQDN, err)
+			} else if c.registry != nil {
+				klog.Infof("cleanup of %s failed, it will be retried in the background", ch.ResolvedFQDN)
+			}
+			return edit.err
+		}
+		// The zone was deleted since Present, so is the record.
+		klog.Infof("DNS zone %d no longer exists, nothing to clean up for %s", cfg.ZoneID, ch.ResolvedFQDN)
+	}
+	if err := c.registry.remove(owned); err != nil {
+		sampledLog.Warningf("removing %s from the registry: %v", ch.ResolvedFQDN, err)
 	}
 	return nil
 }
 
+// shutdown is called by runWebhookServer once the server has stopped.
+func (c *sakuraCloudDNSProviderSolver) shutdown() {
+	if c.verifications != nil {
+		c.verifications.Wait()
+	}
+	if c.opts.CleanupOnShutdown {
+		c.cleanUpOnShutdown(c.opts.ShutdownCleanupAge)
+	}
+}
+
 // Initialize will be called when the webhook first starts.
 // This method can be used to instantiate the webhook, i.e. initialising
 // connections or warming up caches.
@@ -245,27 +767,295 @@ func (c *sakuraCloudDNSProviderSolver) CleanUp(ch *v1alpha1.ChallengeRequest) er
 // provider accounts.
 // The stopCh can be used to handle early termination of the webhook, in cases
 // where a SIGTERM or similar signal is sent to the webhook process.
+// It is closed once the manager stops the API server; background loops are
+// started with c.start instead, so that the manager waits for them.
 func (c *sakuraCloudDNSProviderSolver) Initialize(kubeClientConfig *rest.Config, stopCh <-chan struct{}) error {
+	if err := validateFailureRate(c.opts.InjectFailureRate); err != nil {
+		return err
+	}
+	c.stopped = wait.ContextForChannel(stopCh)
+	c.verifications = &sync.WaitGroup{}
+	if err := c.opts.validateTTL(); err != nil {
+		return err
+	}
+	if c.opts.DrainBindAddress != "" && c.opts.DrainDelay > c.opts.DrainTimeout {
+		return fmt.Errorf("--drain-delay %s is above --drain-timeout %s", c.opts.DrainDelay, c.opts.DrainTimeout)
+	}
+	if !slices.Contains(registryStores, c.opts.RegistryStore) {
+		return fmt.Errorf("unknown --registry-store %q, use one of %s", c.opts.RegistryStore, strings.Join(registryStores, ", "))
+	}
+	if c.opts.RegistryStore != "configmap" && c.opts.RegistryConfigMap != "" {
+		return fmt.Errorf("--registry-configmap is only used by --registry-store=configmap, not %s", c.opts.RegistryStore)
+	}
+	if c.opts.DailyUpdateBudget > 0 && !c.opts.registryEnabled() {
+		return errors.New("--daily-update-budget requires the registry, which keeps the deferred cleanups")
+	}
+	if c.opts.DailyUpdateBudget > 0 && c.opts.RegistryStore == "memory" {
+		return errors.New("--daily-update-budget requires a registry that survives restarts, --registry-store=memory loses the cleanups deferred to the next day")
+	}
+	if len(c.opts.ReadinessZones) > 0 && !slices.ContainsFunc(c.opts.CredentialProviders, func(p string) bool { return p != "secret" }) {
+		return errors.New("--readiness-zones reads the zones with the API key of the installation, add env, file or vault to --credential-providers")
+	}
+	if c.opts.LeaderElectionAddress != "" && (!c.opts.LeaderElect || c.opts.LeaderForwardCAFile == "" || c.opts.LeaderForwardServerName == "") {
+		return errors.New("--leader-election-address requires --leader-elect, --leader-forward-ca-file and --leader-forward-server-name")
+	}
+	if len(c.opts.AllowedSecretNamespaces) == 0 {
+		return errors.New("--allowed-secret-namespaces is required: list the namespaces of the Issuers whose credentials the webhook may use, or * for all namespaces")
+	}
+	if c.opts.CleanupMaxAttempts < 0 {
+		return errors.New("--cleanup-max-attempts must not be negative")
+	}
+	if len(c.opts.ReadinessZones) > 0 && c.opts.ReadinessZoneInterval <= 0 {
+		return errors.New("--readiness-zone-interval must be positive")
+	}
+	if c.opts.PruneAge > 0 && (!c.opts.registryEnabled() || len(c.opts.PruneZones) == 0) {
+		return errors.New("--prune-age requires the registry and --prune-zones")
+	}
+	if c.opts.PruneAge > 0 && c.opts.RegistryStore == "memory" {
+		return errors.New("--prune-age requires a registry shared by the replicas, --registry-store=memory keeps the age of the records in each replica")
+	}
+	if !slices.Contains(recordEngines, c.opts.RecordEngine) {
+		return fmt.Errorf("unknown --record-engine %q, use one of %s", c.opts.RecordEngine, strings.Join(recordEngines, ", "))
+	}
+	if !slices.Contains(configShapes, c.opts.ConfigShape) {
+		return fmt.Errorf("unknown --config-shape %q, use one of %s", c.opts.ConfigShape, strings.Join(configShapes, ", "))
+	}
+	if !slices.Contains(propagationCheckers, c.opts.PropagationChecker) {
+		return fmt.Errorf("unknown --propagation-checker %q, use one of %s", c.opts.PropagationChecker, strings.Join(propagationCheckers, ", "))
+	}
+	if !slices.Contains(txtConflictPolicies, c.opts.TXTConflictPolicy) {
+		return fmt.Errorf("unknown --txt-conflict-policy %q, use one of %s", c.opts.TXTConflictPolicy, strings.Join(txtConflictPolicies, ", "))
+	}
+	for _, name := range c.opts.CredentialProviders {
+		if _, err := c.credentialProvider(name); err != nil {
+			return fmt.Errorf("--credential-providers: %w", err)
+		}
+		switch {
+		case name == "file" && c.opts.CredentialsDir == "":
+			return errors.New("the file credential provider requires --credentials-dir")
+		case name == "vault" && (c.opts.VaultAddr == "" || c.opts.VaultPath == "" || c.opts.VaultTokenFile == ""):
+			return errors.New("the vault credential provider requires --vault-addr, --vault-path and --vault-token-file")
+		}
+	}
+	if c.opts.SecretsNamespace != "" && len(c.opts.WatchNamespaces) > 0 && !slices.Contains(c.opts.WatchNamespaces, c.opts.SecretsNamespace) {
+		return fmt.Errorf("--secrets-namespace %s is not in --watch-namespaces", c.opts.SecretsNamespace)
+	}
+
+	if err := setRetryStatusCodes(c.opts.APIRetryStatusCodes); err != nil {
+		return err
+	}
+
 	cl, err := kubernetes.NewForConfig(kubeClientConfig)
 	if err != nil {
 		return err
 	}
 
 	c.client = cl
+	sampledLog.window = c.opts.LogSampleWindow
+	zoneLabels.allow, zoneLabels.max = c.opts.MetricsZones, c.opts.MetricsMaxZones
+	apiDebugLogging = c.opts.APIDebugLogging
+	apiBreaker.threshold, apiBreaker.cooldown = c.opts.CircuitBreakerFailures, c.opts.CircuitBreakerCooldown
+	apiMaintenance.backoff = c.opts.MaintenanceBackoff
+	c.start("log sampler", stopCh, sampledLog.run)
+	c.batcher = newZoneBatcher(c.opts.ZoneBatchWindow)
+	c.queue = newZoneQueue(c.opts.ZoneWorkers)
+	c.presented = newPresentCache(c.opts.PresentCacheTTL)
+	c.metadata = newZoneMetadataCache(c.opts.ZoneMetadataCacheTTL)
+	c.budget = newUpdateBudget(c.opts.DailyUpdateBudget)
+	c.history = newZoneHistory(c.opts.ZoneHistorySize)
+	if c.changes, err = newChangeNotifier(c.opts.ChangeEventURL, c.opts.ChangeEventTokenFile); err != nil {
+		return err
+	}
+	if c.changes != nil {
+		c.start("change events", stopCh, c.changes.run)
+	}
+	monitor := newResourceMonitor([]sizedCache{
+		{"present_cache", c.presented.len},
+		{"zone_metadata", c.metadata.len},
+		{"zone_batches", c.batcher.len},
+		{"zone_history", c.history.len},
+		{"change_events", c.changes.len},
+		{"nameservers", zoneNameservers.len},
+		{"credential_statuses", credentialStatuses.len},
+	}, c.opts.CacheSoftLimit, c.opts.GoroutineSoftLimit, c.opts.HeapSoftLimit)
+	c.start("resource monitor", stopCh, monitor.run)
+	c.audit, err = newAuditLog(c.opts.AuditLogPath, c.opts.AuditLogFormat, int64(c.opts.AuditLogMaxSize)<<20, c.opts.AuditLogMaxBackups)
+	if err != nil {
+		return err
+	}
+	c.alerts, err = newAlertNotifier(c.opts.NotificationGroupID, c.opts.NotificationFailureThreshold, c.opts.NotificationInterval)
+	if err != nil {
+		return err
+	}
+	if c.alerts != nil {
+		apiBreaker.onOpen = func() { c.alerts.circuitBreakerOpened(apiBreaker) }
+	}
+	c.delegation = newDelegationChecker(cl, stopCh)
+
+	c.secretsClient, err = newSecretsClient(cl, c.opts.SecretsKubeconfig)
+	if err != nil {
+		return err
+	}
+	if len(c.opts.WatchNamespaces) == 0 {
+		checkSecretAccess(context.Background(), c.secretsClient, []string{secretAccessCheckNamespace(c.opts)}, "get")
+	} else {
+		checkSecretAccess(context.Background(), c.secretsClient, c.opts.WatchNamespaces, "list", "watch")
+		secrets := newSecretWatcher(c.secretsClient, c.opts.WatchNamespaces)
+		c.startInformers("credential Secret informers", stopCh, secrets.run, secrets.synced...)
+		if err := secrets.waitForSync(stopCh); err != nil {
+			return err
+		}
+		c.secrets = secrets
+	}
+
+	if c.opts.HeartbeatNamespace != "" {
+		id := c.opts.InstallationID
+		if id == "" {
+			ns, err := namespaceOrOwn("")
+			if err != nil {
+				return fmt.Errorf("--heartbeat-namespace: %w", err)
+			}
+			if id, err = installationID(context.Background(), cl, ns, c.opts.ServiceName); err != nil {
+				return fmt.Errorf("--heartbeat-namespace: %w", err)
+			}
+		}
+		c.heartbeat = newInstallationHeartbeat(c.secretsClient, c.opts.HeartbeatNamespace, id, c.opts.groupNames, c.opts.HeartbeatInterval)
+		c.start("heartbeat", stopCh, c.heartbeat.run)
+	}
+	if len(c.opts.ReadinessZones) > 0 {
+		c.start("zone readiness checks", stopCh, func(stopCh <-chan struct{}) {
+			c.checkZoneReadiness(c.opts.ReadinessZoneInterval, stopCh)
+		})
+	}
+
+	if c.opts.LeaderElect {
+		hostname, err := os.Hostname()
+		if err != nil {
+			return err
+		}
+		c.leaderID = leaderIdentity(hostname, c.opts.LeaderElectionAddress)
+		if c.opts.LeaderElectionAddress != "" {
+			if c.forwarder, err = newLeaderForwarder(c.opts.LeaderForwardCAFile, c.opts.LeaderForwardServerName); err != nil {
+				return err
+			}
+		}
+		c.leader, err = startLeaderElection(cl, c.leaderID, c.opts, c.mgr, stopCh)
+		if err != nil {
+			return fmt.Errorf("starting leader election: %w", err)
+		}
+	}
+
+	if c.dynamic, err = dynamic.NewForConfig(kubeClientConfig); err != nil {
+		return err
+	}
+	if len(c.opts.groupNames) > 0 {
+		// Outside of a cluster only the served groups are checked.
+		var service string
+		if ns, _ := namespaceOrOwn(""); ns != "" && c.opts.ServiceName != "" {
+			service = ns + "/" + c.opts.ServiceName
+		}
+		// The check only warns, so the webhook does not wait for it.
+		go func() {
+			groupRegistrations.set(checkGroupRegistrations(c.stopContext(), c.dynamic, c.opts.groupNames, service))
+		}()
+	}
+
+	if c.opts.registryEnabled() {
+		var ns string
+		if c.opts.RegistryStore != "memory" {
+			if ns, err = namespaceOrOwn(c.opts.RegistryNamespace); err != nil {
+				return fmt.Errorf("--registry-namespace: %w", err)
+			}
+		}
+		store, err := c.newRegistryStore(cl, ns)
+		if err != nil {
+			return err
+		}
+		c.registry = &ownershipRegistry{store: store}
+		c.start("cleanup retries", stopCh, func(stopCh <-chan struct{}) { c.retryCleanups(c.opts.CleanupRetryInterval, stopCh) })
+		if c.opts.RegistryGCInterval > 0 {
+			c.start("registry garbage collection", stopCh, func(stopCh <-chan struct{}) {
+				c.collectRegistryGarbage(c.opts.RegistryGCInterval, stopCh)
+			})
+		}
+		if c.opts.PruneAge > 0 {
+			c.pruner = newRecordPruner(c.opts.PruneAge)
+			c.start("record pruning", stopCh, func(stopCh <-chan struct{}) { c.pruneChallengeRecords(c.opts.PruneInterval, stopCh) })
+		}
+	}
+
+	if c.opts.IssuerCheckInterval > 0 && len(c.opts.groupNames) > 0 {
+		c.startIssuerChecks(c.dynamic, stopCh)
+	}
+
+	if c.opts.SnapshotDir != "" {
+		if err := os.MkdirAll(c.opts.SnapshotDir, 0o700); err != nil {
+			return fmt.Errorf("--snapshot-dir: %w", err)
+		}
+		c.snapshots = &snapshotStore{dir: c.opts.SnapshotDir, retention: c.opts.SnapshotRetention}
+	}
+
+	if err := setupTracing(stopCh); err != nil {
+		return fmt.Errorf("configuring the OTLP trace exporter: %w", err)
+	}
+	if otelExporterEnabled("OTEL_METRICS_EXPORTER") {
+		exporter, err := newOTLPExporter(metricsRegistry)
+		if err != nil {
+			return fmt.Errorf("configuring the OTLP metrics exporter: %w", err)
+		}
+		c.start("OTLP metrics exporter", stopCh, exporter.run)
+	}
+	if addr := c.opts.DrainBindAddress; addr != "" {
+		c.drain = newDrainer(c.opts.DrainDelay, c.opts.DrainTimeout)
+		if err := serveDrain(addr, c.drain, stopCh); err != nil {
+			return err
+		}
+	}
+	c.checks = c.readinessChecks()
+	if addr := c.opts.DebugBindAddress; addr != "" {
+		token, err := debugToken(addr, c.opts.DebugTokenFile)
+		if err != nil {
+			return err
+		}
+		serveDebug(addr, token, c.history, stopCh)
+	}
+	if addr := c.opts.GRPCBindAddress; addr != "" {
+		tlsConfig, err := grpcServerTLS(c.opts.GRPCTLSCertFile, c.opts.GRPCTLSKeyFile, c.opts.GRPCClientCAFile)
+		if err != nil {
+			return err
+		}
+		clients, err := parseGRPCClients(c.opts.GRPCClientNamespaces)
+		if err != nil {
+			return err
+		}
+		if len(clients) == 0 {
+			return errors.New("--grpc-bind-address requires --grpc-client-namespaces, the namespaces each client certificate may use")
+		}
+		if err := serveGRPC(addr, tlsConfig, c, clients, stopCh); err != nil {
+			return err
+		}
+	}
+	if c.initialized != nil {
+		close(c.initialized)
+	}
 	return nil
 }
 
-// loadConfig is a small helper function that decodes JSON configuration into
-// the typed config struct.
-func loadConfig(cfgJSON *extapi.JSON) (sakuraCloudDNSProviderConfig, error) {
-	cfg := sakuraCloudDNSProviderConfig{}
-	// handle the 'base case' where no configuration has been provided
-	if cfgJSON == nil {
-		return cfg, nil
+// readinessChecks returns the conditions /readyz reports.
+func (c *sakuraCloudDNSProviderSolver) readinessChecks() []readinessCheck {
+	var checks []readinessCheck
+	if f := c.opts.tlsCertFile; f != nil && f.Value.String() != "" {
+		check := servingCertCheck(f.Value.String(), c.opts.ServingCertExpiryWindow)
+		if err := check.check(); err != nil {
+			klog.Warning(err)
+		}
+		checks = append(checks, check)
 	}
-	if err := json.Unmarshal(cfgJSON.Raw, &cfg); err != nil {
-		return cfg, fmt.Errorf("error decoding solver config: %v", err)
+	if apiBreaker.threshold > 0 {
+		checks = append(checks, circuitBreakerCheck(apiBreaker))
 	}
-
-	return cfg, nil
+	if c.drain != nil {
+		checks = append(checks, drainCheck(c.drain))
+	}
+	return checks
 }
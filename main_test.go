@@ -1,3 +1,8 @@
+//go:build conformance
+
+// The conformance suite runs a Kubernetes control plane from the binaries
+// that `make test` downloads, so it is only built with the conformance tag.
+
 package main
 
 import (
@@ -6,7 +11,7 @@ import (
 
 	acmetest "github.com/cert-manager/cert-manager/test/acme"
 
-	"github.com/cert-manager/webhook-example/example"
+	"github.com/cert-manager/webhook-example/pkg/fake"
 )
 
 var (
@@ -26,7 +31,7 @@ func TestRunsSuite(t *testing.T) {
 	//	acmetest.SetManifestPath("testdata/my-custom-solver"),
 	//	acmetest.SetBinariesPath("_test/kubebuilder/bin"),
 	//)
-	solver := example.New("59351")
+	solver := fake.New("127.0.0.1:59351", "example.com")
 	fixture := acmetest.NewFixture(solver,
 		acmetest.SetResolvedZone("example.com."),
 		acmetest.SetManifestPath("testdata/my-custom-solver"),
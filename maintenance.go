@@ -0,0 +1,120 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"k8s.io/klog/v2"
+)
+
+// apiMaintenance tracks maintenance of the Sakura Cloud API. It is
+// configured from --maintenance-backoff; with a zero backoff it is never
+// entered.
+var apiMaintenance = &maintenanceWindow{now: time.Now}
+
+// maintenanceError is returned for API requests while the API is under
+// maintenance.
+type maintenanceError struct {
+	until time.Time
+}
+
+func (e *maintenanceError) Error() string {
+	return fmt.Sprintf("Sakura Cloud API is under maintenance (503 Service Unavailable), backing off until %s", e.until.Format(time.RFC3339))
+}
+
+// maintenanceWindow is entered when the API answers 503, which it does
+// during maintenance. Requests are then rejected without being sent for
+// backoff, or as long as the response asked for with Retry-After. The first
+// request after that probes the API: another 503 extends the window, any
+// other response leaves it.
+type maintenanceWindow struct {
+	backoff time.Duration
+	now     func() time.Time
+
+	mu    sync.Mutex
+	until time.Time
+}
+
+// check returns a maintenanceError while the window is open.
+func (m *maintenanceWindow) check() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if now := m.now(); now.Before(m.until) {
+		return &maintenanceError{until: m.until}
+	}
+	return nil
+}
+
+// record updates the window from the status code of a response and the
+// Retry-After it carried, if any.
+func (m *maintenanceWindow) record(code int, retryAfter time.Duration) {
+	if m.backoff <= 0 {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if code != http.StatusServiceUnavailable {
+		if !m.until.IsZero() {
+			klog.Infof("Sakura Cloud API is available again")
+			m.until = time.Time{}
+			apiMaintenanceGauge.Set(0)
+		}
+		return
+	}
+	entering := m.until.IsZero()
+	m.until = m.now().Add(max(m.backoff, retryAfter))
+	apiMaintenanceGauge.Set(1)
+	if entering {
+		klog.Warningf("Sakura Cloud API answered 503 Service Unavailable, assuming maintenance and sending no requests until %s", m.until.Format(time.RFC3339))
+	}
+}
+
+// retryAfter parses the Retry-After header of resp, given in seconds or as
+// an HTTP date.
+func retryAfter(resp *http.Response, now time.Time) time.Duration {
+	value := resp.Header.Get("Retry-After")
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil {
+		return time.Duration(seconds) * time.Second
+	}
+	if t, err := http.ParseTime(value); err == nil {
+		return t.Sub(now)
+	}
+	return 0
+}
+
+// maintenanceTransport sends requests through apiMaintenance.
+type maintenanceTransport struct {
+	window *maintenanceWindow
+	next   http.RoundTripper
+}
+
+func (t *maintenanceTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if err := t.window.check(); err != nil {
+		return nil, err
+	}
+	resp, err := t.next.RoundTrip(req)
+	if err == nil {
+		t.window.record(resp.StatusCode, retryAfter(resp, t.window.now()))
+	}
+	return resp, err
+}
+
+// maintenanceRetriable turns an error caused by API maintenance into a
+// retriable error, so that the events of the challenge explain the delay.
+func maintenanceRetriable(err error) error {
+	var maintenance *maintenanceError
+	if !errors.As(err, &maintenance) {
+		return err
+	}
+	return &retriableError{
+		reason: fmt.Sprintf("Sakura Cloud API maintenance until %s", maintenance.until.Format(time.RFC3339)),
+		err:    err,
+	}
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMaintenanceWindow(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	m := &maintenanceWindow{backoff: time.Minute, now: func() time.Time { return now }}
+
+	assert.NoError(t, m.check())
+	m.record(http.StatusInternalServerError, 0)
+	assert.NoError(t, m.check(), "other server errors are not maintenance")
+
+	m.record(http.StatusServiceUnavailable, 0)
+	err := m.check()
+	var maintenance *maintenanceError
+	require.ErrorAs(t, err, &maintenance)
+	assert.Equal(t, now.Add(time.Minute), maintenance.until)
+	assert.ErrorContains(t, err, "under maintenance")
+
+	now = now.Add(time.Minute)
+	assert.NoError(t, m.check(), "lets requests probe after the backoff")
+	m.record(http.StatusServiceUnavailable, 5*time.Minute)
+	require.ErrorAs(t, m.check(), &maintenance)
+	assert.Equal(t, now.Add(5*time.Minute), maintenance.until, "a longer Retry-After is honored")
+
+	now = now.Add(5 * time.Minute)
+	m.record(http.StatusOK, 0)
+	assert.NoError(t, m.check())
+	assert.True(t, m.until.IsZero(), "a successful probe leaves the window")
+}
+
+func TestMaintenanceWindowDisabled(t *testing.T) {
+	m := &maintenanceWindow{now: time.Now}
+	m.record(http.StatusServiceUnavailable, time.Minute)
+	assert.NoError(t, m.check())
+}
+
+func TestRetryAfter(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	for value, want := range map[string]time.Duration{
+		"":                              0,
+		"120":                           2 * time.Minute,
+		"Mon, 01 Jan 2024 00:10:00 GMT": 10 * time.Minute,
+		"soon":                          0,
+	} {
+		resp := &http.Response{Header: http.Header{}}
+		if value != "" {
+			resp.Header.Set("Retry-After", value)
+		}
+		assert.Equal(t, want, retryAfter(resp, now), value)
+	}
+}
+
+func TestMaintenanceRetriable(t *testing.T) {
+	plain := errors.New("boom")
+	assert.Same(t, plain, maintenanceRetriable(plain))
+	assert.NoError(t, maintenanceRetriable(nil))
+
+	until := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	err := maintenanceRetriable(&maintenanceError{until: until})
+	var retriable *retriableError
+	require.ErrorAs(t, err, &retriable)
+	assert.ErrorContains(t, err, "Sakura Cloud API maintenance until 2024-01-01T00:00:00Z")
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+
+	genericapiserver "k8s.io/apiserver/pkg/server"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/klog/v2"
+	ctrlcache "sigs.k8s.io/controller-runtime/pkg/cache"
+	"sigs.k8s.io/controller-runtime/pkg/healthz"
+	ctrllog "sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+	ctrlmetrics "sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+// newManager returns the controller-runtime Manager the process runs under.
+// It serves the metrics and health probes, and runs the API server handling
+// the challenges, the leader election and the background loops of the
+// solvers, so that they share one lifecycle. On shutdown it stops the API
+// server, which answers the challenges in flight, and the loops first, then
+// the leader election, which releases the lease, then the informers they
+// read from; the solvers clean up once everything has stopped.
+func newManager(config *rest.Config, opts *solverOptions) (manager.Manager, error) {
+	// The manager serves the registry of controller-runtime; it is replaced
+	// by the solver metrics, which never included the client metrics of
+	// the libraries.
+	ctrlmetrics.Registry = metricsRegistry
+	ctrllog.SetLogger(klog.NewKlogr())
+
+	metricsAddr := opts.MetricsBindAddress
+	if metricsAddr == "" {
+		metricsAddr = "0"
+	}
+	mgr, err := manager.New(config, manager.Options{
+		MetricsBindAddress:     metricsAddr,
+		HealthProbeBindAddress: opts.HealthProbeBindAddress,
+		LivenessEndpointName:   "/healthz",
+		ReadinessEndpointName:  "/readyz",
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err := mgr.AddHealthzCheck("ping", healthz.Ping); err != nil {
+		return nil, err
+	}
+	return mgr, nil
+}
+
+// apiServerRunnable runs the API server serving the challenges until the
+// manager stops. It runs on every replica: followers reject the challenges
+// that reach them.
+type apiServerRunnable struct {
+	server interface {
+		Run(stopCh <-chan struct{}) error
+	}
+}
+
+func newAPIServerRunnable(s *genericapiserver.GenericAPIServer) apiServerRunnable {
+	return apiServerRunnable{server: s.PrepareRun()}
+}
+
+func (r apiServerRunnable) Start(ctx context.Context) error {
+	return r.server.Run(ctx.Done())
+}
+
+func (apiServerRunnable) NeedLeaderElection() bool {
+	return false
+}
+
+// backgroundRunnable runs a background loop of the solver on every replica
+// until the manager stops; loops that only the leader should run check
+// c.leader themselves.
+type backgroundRunnable func(stopCh <-chan struct{})
+
+func (r backgroundRunnable) Start(ctx context.Context) error {
+	r(ctx.Done())
+	return nil
+}
+
+func (backgroundRunnable) NeedLeaderElection() bool {
+	return false
+}
+
+// informerRunnable runs informers under the manager as one of its caches.
+// The manager stops its caches only once the API server and the background
+// loops have stopped, so that these never read from stopped informers.
+type informerRunnable struct {
+	// Cache is nil: the manager only calls WaitForCacheSync of the caches
+	// it runs.
+	ctrlcache.Cache
+	// run starts the informers and stops them once stopCh is closed.
+	run    func(stopCh <-chan struct{})
+	synced []cache.InformerSynced
+}
+
+func (r *informerRunnable) Start(ctx context.Context) error {
+	r.run(ctx.Done())
+	return nil
+}
+
+func (r *informerRunnable) GetCache() ctrlcache.Cache {
+	return r
+}
+
+func (r *informerRunnable) WaitForCacheSync(ctx context.Context) bool {
+	return cache.WaitForCacheSync(ctx.Done(), r.synced...)
+}
+
+// useManager makes the solver run its background loops under mgr, and
+// adds its readiness checks to /readyz.
+func (c *sakuraCloudDNSProviderSolver) useManager(mgr manager.Manager) error {
+	if c.mgr != nil {
+		return nil
+	}
+	c.mgr = mgr
+	return mgr.AddReadyzCheck("solver", c.readyz)
+}
+
+// start runs fn in the background until the process stops: under the
+// manager, which waits for it on shutdown, or without one until stopCh is
+// closed.
+func (c *sakuraCloudDNSProviderSolver) start(name string, stopCh <-chan struct{}, fn func(stopCh <-chan struct{})) {
+	if c.mgr == nil {
+		go fn(stopCh)
+		return
+	}
+	if err := c.mgr.Add(backgroundRunnable(fn)); err != nil {
+		klog.Errorf("not starting %s: %v", name, err)
+	}
+}
+
+// startInformers runs informers until the process stops: under the manager,
+// which stops them after everything reading from them, or without one until
+// stopCh is closed. run starts them and stops them once stopCh is closed;
+// synced report whether they have listed their resources.
+func (c *sakuraCloudDNSProviderSolver) startInformers(name string, stopCh <-chan struct{}, run func(stopCh <-chan struct{}), synced ...cache.InformerSynced) {
+	if c.mgr == nil {
+		go run(stopCh)
+		return
+	}
+	if err := c.mgr.Add(&informerRunnable{run: run, synced: synced}); err != nil {
+		klog.Errorf("not starting %s: %v", name, err)
+	}
+}
+
+// readyz fails until Initialize has succeeded, then while any of the
+// readinessChecks of the solver fails.
+func (c *sakuraCloudDNSProviderSolver) readyz(*http.Request) error {
+	select {
+	case <-c.initialized:
+	default:
+		return errors.New("the solver is not initialized")
+	}
+	var failed []string
+	for _, check := range c.checks {
+		if err := check.check(); err != nil {
+			failed = append(failed, check.name+": "+err.Error())
+		}
+	}
+	if len(failed) > 0 {
+		klog.V(4).Infof("readiness check failed: %s", strings.Join(failed, "; "))
+		return errors.New(strings.Join(failed, "; "))
+	}
+	return nil
+}
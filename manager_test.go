@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/rest"
+)
+
+func TestReadyz(t *testing.T) {
+	c := &sakuraCloudDNSProviderSolver{initialized: make(chan struct{})}
+	assert.EqualError(t, c.readyz(nil), "the solver is not initialized")
+
+	var drainErr error
+	c.checks = []readinessCheck{
+		{name: "draining", check: func() error { return drainErr }},
+		{name: "serving-certificate", check: func() error { return nil }},
+	}
+	close(c.initialized)
+	assert.NoError(t, c.readyz(nil))
+
+	drainErr = errors.New("draining for shutdown")
+	assert.EqualError(t, c.readyz(nil), "draining: draining for shutdown")
+}
+
+func TestStartWithoutManager(t *testing.T) {
+	c := &sakuraCloudDNSProviderSolver{}
+	stopCh, stopped := make(chan struct{}), make(chan struct{})
+	c.start("test loop", stopCh, func(stopCh <-chan struct{}) {
+		<-stopCh
+		close(stopped)
+	})
+	close(stopCh)
+	<-stopped
+}
+
+func TestManagerStopsInformersLast(t *testing.T) {
+	mgr, err := newManager(&rest.Config{Host: "http://127.0.0.1:1"}, newSolverOptions())
+	require.NoError(t, err)
+	c := &sakuraCloudDNSProviderSolver{}
+	require.NoError(t, c.useManager(mgr))
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	done := make(chan error, 1)
+	go func() { done <- mgr.Start(ctx) }()
+
+	// As in Initialize, which runs once the manager has started.
+	client := fake.NewSimpleClientset(&corev1.Secret{ObjectMeta: metav1.ObjectMeta{Namespace: "tenant-a", Name: "sakuracloud"}})
+	w := newSecretWatcher(client, []string{"tenant-a"})
+	informersStopped := make(chan struct{})
+	c.startInformers("credential Secret informers", ctx.Done(), func(stopCh <-chan struct{}) {
+		w.run(stopCh)
+		close(informersStopped)
+	}, w.synced...)
+	require.NoError(t, w.waitForSync(ctx.Done()))
+
+	running := make(chan struct{})
+	var stoppedFirst bool
+	var readErr error
+	c.start("test loop", ctx.Done(), func(stopCh <-chan struct{}) {
+		close(running)
+		<-stopCh
+		// A loop finishing its work on shutdown still reads from the
+		// informers, which do not stop meanwhile.
+		select {
+		case <-informersStopped:
+		case <-time.After(100 * time.Millisecond):
+			stoppedFirst = true
+		}
+		_, readErr = w.get("tenant-a", "sakuracloud")
+	})
+	<-running
+
+	cancel()
+	require.NoError(t, <-done)
+	select {
+	case <-informersStopped:
+	default:
+		t.Fatal("the manager returned before the informers stopped")
+	}
+	assert.True(t, stoppedFirst, "the loop stopped before the informers")
+	assert.NoError(t, readErr)
+}
@@ -0,0 +1,353 @@
+package main
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+)
+
+const metricsNamespace = "sakuracloud_webhook"
+
+// metricsRegistry is kept separate from the apiserver's legacy registry so
+// the solver metrics can be served on their own port without authentication
+// delegation. The manager serves it, see newManager.
+var metricsRegistry = prometheus.NewRegistry()
+
+var (
+	credentialUsedTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "credential_used_total",
+		Help:      "Number of zone reads that succeeded, by the credential slot (primary or secondary) that was accepted by the API.",
+	}, []string{"credential"})
+
+	apiRequestsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "api_requests_total",
+		Help:      "Number of HTTP requests sent to the Sakura Cloud API, including retries, by credential hash, method and response code.",
+	}, []string{"credential", "method", "code"})
+
+	apiRetriesTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "api_retries_total",
+		Help:      "Number of Sakura Cloud API requests that were retried by reason: rate_limit (429), conflict (423), timeout, 5xx, other 4xx of --api-retry-status-codes or network.",
+	}, []string{"reason"})
+
+	apiRequestBodyBytes = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "api_request_body_bytes",
+		Help:      "Size of the request bodies sent to the Sakura Cloud API. Zone updates carry the whole record set, so this grows with the zone.",
+		Buckets:   prometheus.ExponentialBuckets(512, 4, 8),
+	}, []string{"method"})
+
+	apiRequestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "api_request_duration_seconds",
+		Help:      "Latency of HTTP requests to the Sakura Cloud API by method and response code.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"method", "code"})
+
+	secretFetchDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "secret_fetch_duration_seconds",
+		Help:      "Latency of reading credential Secrets from the Kubernetes API by result.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"result"})
+
+	zoneRecords = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "zone_records",
+		Help:      "Number of records in the zone as of the last read.",
+	}, []string{"zone"})
+
+	zoneUpdateBytes = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "zone_update_bytes",
+		Help:      "Size of the request body of the last update of the zone. Updates carry the whole record set of the zone.",
+	}, []string{"zone"})
+
+	servingCertNotAfter = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "serving_certificate_not_after_seconds",
+		Help:      "Expiry of the webhook serving certificate as a Unix timestamp.",
+	})
+
+	challengesTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "challenges_total",
+		Help:      "Number of Present and CleanUp calls by zone ID, operation and result (success or failure). Zones over --metrics-max-zones or outside --metrics-zones are counted as \"other\".",
+	}, []string{"zone", "operation", "result"})
+
+	challengeLastSuccess = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "challenge_last_success_timestamp_seconds",
+		Help:      "Time of the last successful Present or CleanUp by zone ID and operation.",
+	}, []string{"zone", "operation"})
+
+	challengeLastFailure = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "challenge_last_failure_timestamp_seconds",
+		Help:      "Time of the last failed Present or CleanUp by zone ID and operation.",
+	}, []string{"zone", "operation"})
+
+	challengePhaseDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "challenge_phase_duration_seconds",
+		Help:      "Time spent in each phase of handling a challenge: secret_fetch, zone_read, compute, zone_update, zone_refresh (--refresh-after-write) and verify (the propagation check).",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"phase"})
+
+	propagationChecksTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "propagation_checks_total",
+		Help:      "Number of propagation checks of presented records by result (success or failure).",
+	}, []string{"result"})
+
+	zoneReady = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "zone_ready",
+		Help:      "1 if the zone of --readiness-zones could be read with the API key of the installation and was available at the last check, 0 otherwise, by zone ID and name.",
+	}, []string{"zone", "name"})
+
+	zoneUnavailable = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "zone_unavailable",
+		Help:      "1 if the zone was not available as of the last read, e.g. while being transferred or suspended, 0 otherwise.",
+	}, []string{"zone"})
+
+	zoneNameserverMismatch = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "zone_nameserver_mismatch",
+		Help:      "1 if the public NS records of the zone miss one of the nameservers Sakura Cloud assigned to it, 0 otherwise.",
+	}, []string{"zone"})
+
+	registryEntriesCollectedTotal = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "registry_entries_collected_total",
+		Help:      "Number of registry entries removed because their Challenge no longer exists and their record is gone from the zone.",
+	})
+
+	recordsPrunedTotal = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "records_pruned_total",
+		Help:      "Number of challenge records deleted by --prune-age.",
+	})
+
+	zoneRollbacksTotal = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "zone_rollbacks_total",
+		Help:      "Number of zone updates rolled back by --verify-zone-updates because the zone did not hold the written records.",
+	})
+
+	driftRepairsTotal = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "drift_repairs_total",
+		Help:      "Number of challenge records that vanished from the zone after a successful update and were presented again.",
+	})
+
+	workQueueDepth = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "work_queue_depth",
+		Help:      "Number of zone operations waiting for one of the --zone-workers.",
+	})
+
+	workQueueWait = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "work_queue_wait_seconds",
+		Help:      "Time zone operations waited for one of the --zone-workers.",
+		Buckets:   prometheus.DefBuckets,
+	})
+
+	zoneLockAcquisitionsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "zone_lock_acquisitions_total",
+		Help:      "Number of zone operations by zone ID and result: free (the zone had no operation running or waiting) or contended (the operation queued behind others of the same zone).",
+	}, []string{"zone", "result"})
+
+	zoneLockWait = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "zone_lock_wait_seconds",
+		Help:      "Time contended zone operations waited for the operations of the same zone ahead of them, by zone ID.",
+		Buckets:   []float64{.1, .25, .5, 1, 2.5, 5, 10, 30, 60, 120, 300},
+	}, []string{"zone"})
+
+	zoneLockHold = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "zone_lock_hold_seconds",
+		Help:      "Time zone operations kept other operations of the same zone waiting, from reading the zone to writing it, by zone ID.",
+		Buckets:   []float64{.1, .25, .5, 1, 2.5, 5, 10, 30, 60},
+	}, []string{"zone"})
+
+	workQueueMergedTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "work_queue_merged_total",
+		Help:      "Number of challenge record changes dropped from the zone queue by reason: duplicate (the same change was already waiting) or superseded (a CleanUp arrived for a waiting Present).",
+	}, []string{"reason"})
+
+	updateBudgetRemaining = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "update_budget_remaining",
+		Help:      "Zone updates left today (UTC) in the --daily-update-budget of each credential hash.",
+	}, []string{"credential"})
+
+	noopCleanupsTotal = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "noop_cleanups_total",
+		Help:      "Number of CleanUps that found their challenge record already gone, e.g. deleted by hand, and did not write the zone.",
+	})
+
+	malformedKeysTotal = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "malformed_keys_total",
+		Help:      "Number of Presents whose challenge key does not look like the base64url SHA-256 digest of an ACME key authorization.",
+	})
+
+	cleanupsDeferredTotal = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "cleanups_deferred_total",
+		Help:      "Number of challenge record cleanups deferred because their API key used up its --daily-update-budget.",
+	})
+
+	deprecatedConfigUsageTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "deprecated_config_usage_total",
+		Help:      "Number of uses of deprecated Issuer config fields and flags, by field or --flag.",
+	}, []string{"field"})
+
+	changeEventsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "change_events_total",
+		Help:      "Number of change events for --change-event-url, by result: sent, failed after the retries, or dropped because too many were waiting.",
+	}, []string{"result"})
+
+	auditRecordsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "audit_records_total",
+		Help:      "Number of records for --audit-log-path, by result: written, or failed to be written.",
+	}, []string{"result"})
+
+	cacheEntries = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "cache_entries",
+		Help:      "Number of entries of the in-memory caches and queues of the webhook, by cache.",
+	}, []string{"cache"})
+
+	secretAccessDenied = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "secret_access_denied",
+		Help:      "1 if the startup RBAC check found that the webhook may not get (or, with --watch-namespaces, list and watch) the credential Secrets, 0 if it may.",
+	})
+
+	issuerReady = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "issuer_ready",
+		Help:      "1 if the credential Secrets and zones of the solver configs of an Issuer or ClusterIssuer could be read by the last check of --issuer-check-interval, 0 if not.",
+	}, []string{"kind", "namespace", "name"})
+
+	duplicateInstallations = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "duplicate_installations",
+		Help:      "Number of other webhook installations seen by --heartbeat-namespace writing to the same zones for the same API groups.",
+	})
+
+	alertNotificationsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "alert_notifications_total",
+		Help:      "Number of alerts sent to the Simple Notification group of --notification-group-id, by result: sent or failed.",
+	}, []string{"result"})
+
+	apiCallsPerChallenge = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "api_calls_per_challenge",
+		Help:      "Sakura Cloud API requests per Present or CleanUp, by kind: read, update, verification, retry and total. The requests of a zone update are shared among the challenges it was made for.",
+		Buckets:   []float64{0, .25, .5, 1, 2, 3, 4, 6, 8, 12, 16},
+	}, []string{"operation", "kind"})
+
+	drainingGauge = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "draining",
+		Help:      "1 once the replica is draining for shutdown, see --drain-bind-address, 0 otherwise.",
+	})
+
+	apiMaintenanceGauge = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "api_maintenance",
+		Help:      "1 while the Sakura Cloud API answers 503 and requests are held back for --maintenance-backoff, 0 otherwise.",
+	})
+)
+
+func init() {
+	metricsRegistry.MustRegister(
+		credentialUsedTotal,
+		apiRequestsTotal,
+		apiRetriesTotal,
+		apiRequestBodyBytes,
+		apiRequestDuration,
+		secretFetchDuration,
+		zoneRecords,
+		zoneUpdateBytes,
+		servingCertNotAfter,
+		challengesTotal,
+		challengeLastSuccess,
+		challengeLastFailure,
+		challengePhaseDuration,
+		propagationChecksTotal,
+		zoneNameserverMismatch,
+		registryEntriesCollectedTotal,
+		recordsPrunedTotal,
+		zoneRollbacksTotal,
+		driftRepairsTotal,
+		workQueueDepth,
+		workQueueWait,
+		zoneLockAcquisitionsTotal,
+		zoneLockWait,
+		zoneUnavailable,
+		zoneReady,
+		zoneLockHold,
+		workQueueMergedTotal,
+		apiMaintenanceGauge,
+		drainingGauge,
+		apiCallsPerChallenge,
+		updateBudgetRemaining,
+		noopCleanupsTotal,
+		malformedKeysTotal,
+		cleanupsDeferredTotal,
+		deprecatedConfigUsageTotal,
+		changeEventsTotal,
+		auditRecordsTotal,
+		alertNotificationsTotal,
+		duplicateInstallations,
+		secretAccessDenied,
+		issuerReady,
+		cacheEntries,
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+}
+
+// observeChallenge records the outcome of a Present or CleanUp for zoneID.
+func observeChallenge(zoneID int64, operation string, err error) {
+	zone := zoneLabels.idLabel(strconv.FormatInt(zoneID, 10))
+	if err != nil {
+		challengesTotal.WithLabelValues(zone, operation, "failure").Inc()
+		challengeLastFailure.WithLabelValues(zone, operation).SetToCurrentTime()
+		return
+	}
+	challengesTotal.WithLabelValues(zone, operation, "success").Inc()
+	challengeLastSuccess.WithLabelValues(zone, operation).SetToCurrentTime()
+}
+
+// observePropagation records the result of a propagation check.
+func observePropagation(err error) {
+	if err != nil {
+		propagationChecksTotal.WithLabelValues("failure").Inc()
+		return
+	}
+	propagationChecksTotal.WithLabelValues("success").Inc()
+}
+
+// observePhase records the time spent in phase since start.
+func observePhase(phase string, start time.Time) {
+	challengePhaseDuration.WithLabelValues(phase).Observe(time.Since(start).Seconds())
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	sctesting "github.com/cert-manager/webhook-example/pkg/testing"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/sacloud/iaas-api-go"
+	"github.com/sacloud/iaas-api-go/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestZoneRecordsMetric(t *testing.T) {
+	www := &iaas.DNSRecord{Name: "www", Type: types.DNSRecordTypes.A, RData: "192.0.2.1", TTL: 300}
+	mail := &iaas.DNSRecord{Name: "mail", Type: types.DNSRecordTypes.A, RData: "192.0.2.2", TTL: 300}
+	zones := sctesting.NewZoneClient(&iaas.DNS{ID: 1, Name: "records.example", Records: iaas.DNSRecords{www, mail}})
+	c := newHarness(t, zones)
+	ch := harnessChallenge(0)
+	ch.ResolvedFQDN, ch.ResolvedZone = "_acme-challenge.records.example.", "records.example."
+	records := func() float64 { return testutil.ToFloat64(zoneRecords.WithLabelValues("records.example")) }
+
+	// The size of the zone as of the last read, before the update.
+	require.NoError(t, c.Present(ch))
+	assert.Equal(t, 2.0, records())
+	require.NoError(t, c.CleanUp(ch))
+	assert.Equal(t, 3.0, records())
+	assert.Equal(t, iaas.DNSRecords{mail, www}, zones.Zone(1).Records, "the other records are kept")
+}
+
+func TestChallengeOutcomeMetrics(t *testing.T) {
+	zones := sctesting.NewZoneClient(&iaas.DNS{ID: 1, Name: "example.com"})
+	c := newHarness(t, zones)
+	ch := harnessChallenge(0)
+	// The metrics are global: the other tests of zone 1 count too.
+	total := func(operation, result string) float64 {
+		return testutil.ToFloat64(challengesTotal.WithLabelValues("1", operation, result))
+	}
+	last := func(g *prometheus.GaugeVec, operation string) float64 {
+		return testutil.ToFloat64(g.WithLabelValues("1", operation))
+	}
+	presented, failed, cleanedUp := total("present", "success"), total("present", "failure"), total("cleanup", "success")
+	lastPresented, lastCleanupFailure := last(challengeLastSuccess, "present"), last(challengeLastFailure, "cleanup")
+	start := float64(time.Now().Unix())
+
+	zones.Conflict(1, 1)
+	require.Error(t, c.Present(ch))
+	assert.Equal(t, failed+1, total("present", "failure"))
+	assert.Equal(t, presented, total("present", "success"))
+	assert.GreaterOrEqual(t, last(challengeLastFailure, "present"), start)
+	assert.Equal(t, lastPresented, last(challengeLastSuccess, "present"), "no success yet")
+
+	require.NoError(t, c.Present(ch))
+	require.NoError(t, c.CleanUp(ch))
+	assert.Equal(t, presented+1, total("present", "success"))
+	assert.Equal(t, failed+1, total("present", "failure"))
+	assert.Equal(t, cleanedUp+1, total("cleanup", "success"))
+	assert.GreaterOrEqual(t, last(challengeLastSuccess, "present"), start)
+	assert.GreaterOrEqual(t, last(challengeLastSuccess, "cleanup"), start)
+	assert.Equal(t, lastCleanupFailure, last(challengeLastFailure, "cleanup"))
+}
+
+func TestChallengePhaseDuration(t *testing.T) {
+	zones := sctesting.NewZoneClient(&iaas.DNS{ID: 1, Name: "example.com"})
+	zones.SetLatency(50 * time.Millisecond)
+	c := newHarness(t, zones)
+	serveZoneDoH(t, c, zones)
+	c.opts.PropagationCheckTimeout = time.Second
+	phase := func(name string) *dto.Histogram {
+		m := &dto.Metric{}
+		require.NoError(t, challengePhaseDuration.WithLabelValues(name).(prometheus.Metric).Write(m))
+		return m.GetHistogram()
+	}
+	phases := []string{"secret_fetch", "zone_read", "compute", "zone_update", "verify"}
+	before := map[string]*dto.Histogram{}
+	for _, name := range phases {
+		before[name] = phase(name)
+	}
+
+	require.NoError(t, c.Present(harnessChallenge(0)))
+	for _, name := range phases {
+		assert.Equal(t, before[name].GetSampleCount()+1, phase(name).GetSampleCount(), name)
+	}
+	// The update of the zone is where the time went.
+	assert.GreaterOrEqual(t, phase("zone_update").GetSampleSum()-before["zone_update"].GetSampleSum(), 0.05)
+	assert.Less(t, phase("compute").GetSampleSum()-before["compute"].GetSampleSum(), 0.05)
+}
@@ -0,0 +1,294 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"slices"
+	"strings"
+	"syscall"
+
+	cmmeta "github.com/cert-manager/cert-manager/pkg/apis/meta/v1"
+	"github.com/sacloud/iaas-api-go"
+	"github.com/sacloud/iaas-api-go/search"
+	"github.com/sacloud/iaas-api-go/types"
+	corev1 "k8s.io/api/core/v1"
+	extapi "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/clientcmd"
+	"sigs.k8s.io/yaml"
+)
+
+// migrateEnv is what the `migrate` command talks to, replaced in tests.
+type migrateEnv struct {
+	newAPI     func(accessToken, accessTokenSecret string) simulateAPI
+	newClients func(kubeconfig string) (kubernetes.Interface, dynamic.Interface, error)
+}
+
+// runMigrate implements the `migrate` command. It reads an Issuer or
+// ClusterIssuer whose DNS01 solvers use another Sakura Cloud webhook,
+// checks that the Secrets and zones of their configs work, and prints the
+// Issuer with the solvers pointed at this webhook, preceded by a Secret if
+// --secret-name is given.
+func runMigrate(args []string) error {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	return migrate(ctx, args, os.Stdout, migrateEnv{
+		newAPI: func(accessToken, accessTokenSecret string) simulateAPI {
+			return iaas.NewDNSOp(newAPICaller(accessToken, accessTokenSecret))
+		},
+		newClients: func(kubeconfig string) (kubernetes.Interface, dynamic.Interface, error) {
+			rules := clientcmd.NewDefaultClientConfigLoadingRules()
+			rules.ExplicitPath = kubeconfig
+			cfg, err := clientcmd.NewNonInteractiveDeferredLoadingClientConfig(rules, &clientcmd.ConfigOverrides{}).ClientConfig()
+			if err != nil {
+				return nil, nil, err
+			}
+			client, err := kubernetes.NewForConfig(cfg)
+			if err != nil {
+				return nil, nil, err
+			}
+			dyn, err := dynamic.NewForConfig(cfg)
+			return client, dyn, err
+		},
+	})
+}
+
+func migrate(ctx context.Context, args []string, out io.Writer, env migrateEnv) error {
+	fs := flag.NewFlagSet("migrate", flag.ContinueOnError)
+	kind := fs.String("kind", "Issuer", "kind of the issuer to migrate: Issuer or ClusterIssuer")
+	name := fs.String("name", "", "name of the issuer to migrate")
+	namespace := fs.String("namespace", "default", "namespace of the Issuer")
+	fromGroup := fs.String("from-group", "", "groupName of the webhook the solvers use now")
+	fromShape := fs.String("from-shape", "cert-manager", "field names of the configs of that webhook: "+strings.Join(configShapes, ", "))
+	groupName := fs.String("group-name", os.Getenv("GROUP_NAME"), "groupName this webhook is installed with, defaults to $GROUP_NAME")
+	issuerName := fs.String("issuer-name", "", "name of the printed issuer, defaults to --name, which replaces the issuer when applied")
+	secretName := fs.String("secret-name", "", "copy the API key into a new Secret of this name and reference it, instead of the Secrets the configs reference")
+	clusterResourceNamespace := fs.String("cluster-resource-namespace", "cert-manager", "namespace cert-manager reads the Secrets of ClusterIssuers from")
+	kubeconfig := fs.String("kubeconfig", "", "kubeconfig of the cluster of the issuer, defaults to $KUBECONFIG or ~/.kube/config")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	switch {
+	case *name == "":
+		return errors.New("--name is required")
+	case *fromGroup == "":
+		return errors.New("--from-group is required")
+	case *groupName == "":
+		return errors.New("--group-name is required")
+	case *fromGroup == *groupName:
+		return errors.New("--from-group and --group-name are the same")
+	case !slices.Contains(configShapes, *fromShape):
+		return fmt.Errorf("unknown --from-shape %q, use one of %s", *fromShape, strings.Join(configShapes, ", "))
+	}
+	resource, ns := issuersResource, *namespace
+	switch *kind {
+	case "Issuer":
+	case "ClusterIssuer":
+		resource, ns = clusterIssuersResource, *clusterResourceNamespace
+	default:
+		return fmt.Errorf("unknown --kind %q, use Issuer or ClusterIssuer", *kind)
+	}
+
+	client, dyn, err := env.newClients(*kubeconfig)
+	if err != nil {
+		return fmt.Errorf("connecting to the cluster: %w", err)
+	}
+	ri := dyn.Resource(resource)
+	var issuer *unstructured.Unstructured
+	if *kind == "Issuer" {
+		issuer, err = ri.Namespace(*namespace).Get(ctx, *name, metav1.GetOptions{})
+	} else {
+		issuer, err = ri.Get(ctx, *name, metav1.GetOptions{})
+	}
+	if err != nil {
+		return fmt.Errorf("reading %s %s: %w", *kind, *name, err)
+	}
+
+	// The Secrets are read with the kubeconfig of the operator, whose RBAC
+	// decides which namespaces may be read.
+	opts := newSolverOptions()
+	opts.AllowedSecretNamespaces = []string{"*"}
+	m := &issuerMigration{
+		solver:    &sakuraCloudDNSProviderSolver{opts: opts, secretsClient: client},
+		newAPI:    env.newAPI,
+		namespace: ns,
+		shape:     *fromShape,
+	}
+	if *secretName != "" {
+		m.secret = &corev1.Secret{
+			TypeMeta:   metav1.TypeMeta{APIVersion: "v1", Kind: "Secret"},
+			ObjectMeta: metav1.ObjectMeta{Namespace: ns, Name: *secretName},
+			Type:       corev1.SecretTypeOpaque,
+		}
+	}
+	solvers, _, _ := unstructured.NestedSlice(issuer.Object, "spec", "acme", "solvers")
+	migrated := 0
+	for i, s := range solvers {
+		solver, ok := s.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		if group, _, _ := unstructured.NestedString(solver, "dns01", "webhook", "groupName"); group != *fromGroup {
+			continue
+		}
+		config, _, _ := unstructured.NestedFieldNoCopy(solver, "dns01", "webhook", "config")
+		raw, err := json.Marshal(config)
+		if err != nil {
+			return fmt.Errorf("solver %d: %w", i, err)
+		}
+		converted, err := m.convert(ctx, raw)
+		if err != nil {
+			return fmt.Errorf("solver %d: %w", i, err)
+		}
+		webhook := map[string]interface{}{
+			"groupName":  *groupName,
+			"solverName": (&sakuraCloudDNSProviderSolver{}).Name(),
+			"config":     converted.config,
+		}
+		if err := unstructured.SetNestedField(solver, webhook, "dns01", "webhook"); err != nil {
+			return fmt.Errorf("solver %d: %w", i, err)
+		}
+		fmt.Fprintf(out, "# solver %d: zone %s (%d) can be read with the API key of %s\n", i, converted.zone.Name, converted.zone.ID.Int64(), converted.location)
+		migrated++
+	}
+	if migrated == 0 {
+		return fmt.Errorf("%s %s has no DNS01 solver with groupName %s", *kind, *name, *fromGroup)
+	}
+	if err := unstructured.SetNestedSlice(issuer.Object, solvers, "spec", "acme", "solvers"); err != nil {
+		return err
+	}
+
+	if m.secret != nil {
+		b, err := yaml.Marshal(m.secret)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(out, "%s---\n", b)
+	}
+	printed := &unstructured.Unstructured{Object: map[string]interface{}{"spec": issuer.Object["spec"]}}
+	printed.SetAPIVersion(issuer.GetAPIVersion())
+	printed.SetKind(issuer.GetKind())
+	printed.SetName(issuer.GetName())
+	if *issuerName != "" {
+		printed.SetName(*issuerName)
+	}
+	printed.SetNamespace(issuer.GetNamespace())
+	printed.SetLabels(issuer.GetLabels())
+	annotations := issuer.GetAnnotations()
+	delete(annotations, corev1.LastAppliedConfigAnnotation)
+	printed.SetAnnotations(annotations)
+	b, err := yaml.Marshal(printed.Object)
+	if err != nil {
+		return err
+	}
+	_, err = out.Write(b)
+	return err
+}
+
+// issuerMigration converts the solver configs of one issuer.
+type issuerMigration struct {
+	// solver reads the Secrets of the configs.
+	solver *sakuraCloudDNSProviderSolver
+	newAPI func(accessToken, accessTokenSecret string) simulateAPI
+	// namespace is the namespace the Secrets of the issuer are read from.
+	namespace string
+	shape     string
+
+	// secret, if set, receives the API key the configs are changed to
+	// reference.
+	secret *corev1.Secret
+}
+
+// migratedConfig is a solver config converted by issuerMigration.
+type migratedConfig struct {
+	config map[string]interface{}
+	zone   *iaas.DNS
+	// location tells where the primary API key was read from.
+	location string
+}
+
+// convert decodes raw in the shape of the other webhook, reads the zone with
+// every API key of it and returns it in the native shape, with the ID and
+// name of the zone set so that the zone cannot change unnoticed.
+func (m *issuerMigration) convert(ctx context.Context, raw []byte) (*migratedConfig, error) {
+	cfg, err := loadConfig(&extapi.JSON{Raw: raw}, m.shape)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.ConfigRef != nil {
+		return nil, errors.New("configRef is not supported, migrate the config of the ConfigMap by hand")
+	}
+	if cfg.ZoneID == 0 && cfg.ZoneName == "" {
+		return nil, errors.New("the config names no zone")
+	}
+
+	migrated := &migratedConfig{}
+	var primary apiKey
+	for _, cred := range cfg.credentials() {
+		key, err := m.solver.resolveAPIKey(ctx, cred, m.namespace)
+		if err != nil {
+			return nil, fmt.Errorf("%s credential: %w", cred.name, err)
+		}
+		zone, err := migrationZone(ctx, m.newAPI(key.token, key.secret), &cfg)
+		if err != nil {
+			return nil, fmt.Errorf("%s credential: %w", cred.name, err)
+		}
+		if cred.name == "primary" {
+			primary, migrated.zone, migrated.location = key, zone, key.location
+		}
+	}
+	cfg.ZoneID, cfg.ZoneName = migrated.zone.ID.Int64(), migrated.zone.Name
+
+	if m.secret != nil {
+		if token, ok := m.secret.Data["accessToken"]; ok && (string(token) != primary.token || string(m.secret.Data["accessTokenSecret"]) != primary.secret) {
+			return nil, fmt.Errorf("the solvers use different API keys, which cannot share Secret %s", m.secret.Name)
+		}
+		m.secret.Data = map[string][]byte{"accessToken": []byte(primary.token), "accessTokenSecret": []byte(primary.secret)}
+		ref := cmmeta.LocalObjectReference{Name: m.secret.Name}
+		cfg.AccessTokenRef = cmmeta.SecretKeySelector{LocalObjectReference: ref, Key: "accessToken"}
+		cfg.AccessTokenSecretRef = cmmeta.SecretKeySelector{LocalObjectReference: ref, Key: "accessTokenSecret"}
+		migrated.location = fmt.Sprintf("%s/%s (was %s)", m.namespace, m.secret.Name, primary.location)
+	}
+
+	b, err := json.Marshal(cfg)
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(b, &migrated.config); err != nil {
+		return nil, err
+	}
+	return migrated, nil
+}
+
+// migrationZone reads the zone of cfg with api: by its ID, checking its
+// name if cfg has one, or else by its name.
+func migrationZone(ctx context.Context, api simulateAPI, cfg *sakuraCloudDNSProviderConfig) (*iaas.DNS, error) {
+	if cfg.ZoneID != 0 {
+		zone, err := api.Read(ctx, types.Int64ID(cfg.ZoneID))
+		if err != nil {
+			return nil, fmt.Errorf("reading zone %d: %w", cfg.ZoneID, err)
+		}
+		return zone, cfg.checkZone(zone)
+	}
+	name := strings.TrimSuffix(cfg.ZoneName, ".")
+	res, err := api.Find(ctx, &iaas.FindCondition{Filter: search.Filter{search.Key("Name"): search.ExactMatch(name)}})
+	if err != nil {
+		return nil, fmt.Errorf("looking up zone %s: %w", name, err)
+	}
+	i := slices.IndexFunc(res.DNS, func(z *iaas.DNS) bool { return strings.EqualFold(z.Name, name) })
+	if i < 0 {
+		return nil, fmt.Errorf("no DNS zone is named %s", name)
+	}
+	// Find does not return the records of the zone, nor does the config
+	// need them, but reading the zone is what the challenges will do.
+	return api.Read(ctx, res.DNS[i].ID)
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/sacloud/iaas-api-go"
+	"github.com/sacloud/iaas-api-go/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/fake"
+	"sigs.k8s.io/yaml"
+)
+
+func newMigrateEnv(t *testing.T, issuerYAML string) (migrateEnv, *[]string) {
+	issuer := &unstructured.Unstructured{}
+	require.NoError(t, yaml.Unmarshal([]byte(issuerYAML), &issuer.Object))
+	dyn := dynamicfake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(),
+		map[schema.GroupVersionResource]string{issuersResource: "IssuerList", clusterIssuersResource: "ClusterIssuerList"}, issuer)
+	client := fake.NewSimpleClientset(&corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "other-webhook"},
+		Data:       map[string][]byte{"token": []byte("tok"), "secret": []byte("sec")},
+	})
+	var keys []string
+	api := &memoryZoneAPI{zone: &iaas.DNS{ID: types.Int64ID(123), Name: "example.com"}}
+	return migrateEnv{
+		newAPI: func(token, secret string) simulateAPI {
+			keys = append(keys, token+":"+secret)
+			return api
+		},
+		newClients: func(string) (kubernetes.Interface, dynamic.Interface, error) { return client, dyn, nil },
+	}, &keys
+}
+
+const otherWebhookIssuer = `
+apiVersion: cert-manager.io/v1
+kind: Issuer
+metadata:
+  name: letsencrypt
+  namespace: default
+  resourceVersion: "42"
+spec:
+  acme:
+    server: https://acme-v02.api.letsencrypt.org/directory
+    solvers:
+    - http01:
+        ingress: {}
+    - selector:
+        dnsZones: [example.com]
+      dns01:
+        webhook:
+          groupName: acme.other.example
+          solverName: sakuracloud
+          config:
+            hostedZoneName: example.com
+            apiTokenSecretRef: {name: other-webhook, key: token}
+            apiSecretSecretRef: {name: other-webhook, key: secret}
+`
+
+func TestMigrate(t *testing.T) {
+	env, keys := newMigrateEnv(t, otherWebhookIssuer)
+	var out bytes.Buffer
+	require.NoError(t, migrate(context.Background(), []string{
+		"--name=letsencrypt", "--from-group=acme.other.example", "--group-name=acme.example.com",
+	}, &out, env))
+	assert.Equal(t, []string{"tok:sec"}, *keys, "the zone is read with the API key of the Secret")
+	assert.Contains(t, out.String(), "# solver 1: zone example.com (123) can be read with the API key of default/other-webhook[token,secret]")
+
+	issuer := &unstructured.Unstructured{}
+	require.NoError(t, yaml.Unmarshal(out.Bytes(), &issuer.Object))
+	assert.Equal(t, "letsencrypt", issuer.GetName())
+	assert.Empty(t, issuer.GetResourceVersion())
+	solvers, _, _ := unstructured.NestedSlice(issuer.Object, "spec", "acme", "solvers")
+	require.Len(t, solvers, 2)
+	webhook, _, _ := unstructured.NestedMap(solvers[1].(map[string]interface{}), "dns01", "webhook")
+	assert.Equal(t, "acme.example.com", webhook["groupName"])
+	assert.Equal(t, "sakuracloud-dns-solver", webhook["solverName"])
+	config := webhook["config"].(map[string]interface{})
+	assert.EqualValues(t, 123, config["zoneID"])
+	assert.Equal(t, "example.com", config["zoneName"])
+	assert.Equal(t, map[string]interface{}{"name": "other-webhook", "key": "token"}, config["accessTokenRef"])
+	assert.Equal(t, map[string]interface{}{"name": "other-webhook", "key": "secret"}, config["accessTokenSecretRef"])
+	assert.NotContains(t, config, "apiTokenSecretRef")
+}
+
+func TestMigrateCopiesSecret(t *testing.T) {
+	env, _ := newMigrateEnv(t, otherWebhookIssuer)
+	var out bytes.Buffer
+	require.NoError(t, migrate(context.Background(), []string{
+		"--name=letsencrypt", "--from-group=acme.other.example", "--group-name=acme.example.com",
+		"--secret-name=sakuracloud-dns-credentials", "--issuer-name=letsencrypt-sakuracloud",
+	}, &out, env))
+
+	docs := strings.Split(out.String(), "---\n")
+	require.Len(t, docs, 2)
+	secret := &corev1.Secret{}
+	require.NoError(t, yaml.Unmarshal([]byte(docs[0]), secret))
+	assert.Equal(t, "sakuracloud-dns-credentials", secret.Name)
+	assert.Equal(t, map[string][]byte{"accessToken": []byte("tok"), "accessTokenSecret": []byte("sec")}, secret.Data)
+
+	issuer := &unstructured.Unstructured{}
+	require.NoError(t, yaml.Unmarshal([]byte(docs[1]), &issuer.Object))
+	assert.Equal(t, "letsencrypt-sakuracloud", issuer.GetName())
+	solvers, _, _ := unstructured.NestedSlice(issuer.Object, "spec", "acme", "solvers")
+	ref, _, _ := unstructured.NestedString(solvers[1].(map[string]interface{}), "dns01", "webhook", "config", "accessTokenRef", "name")
+	assert.Equal(t, "sakuracloud-dns-credentials", ref)
+}
+
+func TestMigrateValidates(t *testing.T) {
+	env, _ := newMigrateEnv(t, strings.ReplaceAll(otherWebhookIssuer, "hostedZoneName: example.com", "hostedZoneName: example.org"))
+	err := migrate(context.Background(), []string{
+		"--name=letsencrypt", "--from-group=acme.other.example", "--group-name=acme.example.com",
+	}, &bytes.Buffer{}, env)
+	assert.ErrorContains(t, err, "solver 1: primary credential: no DNS zone is named example.org")
+
+	env, _ = newMigrateEnv(t, strings.ReplaceAll(otherWebhookIssuer, "key: secret", "key: missing"))
+	err = migrate(context.Background(), []string{
+		"--name=letsencrypt", "--from-group=acme.other.example", "--group-name=acme.example.com",
+	}, &bytes.Buffer{}, env)
+	assert.ErrorContains(t, err, "solver 1: primary credential")
+
+	err = migrate(context.Background(), []string{
+		"--name=letsencrypt", "--from-group=acme.unknown.example", "--group-name=acme.example.com",
+	}, &bytes.Buffer{}, env)
+	assert.ErrorContains(t, err, "has no DNS01 solver with groupName acme.unknown.example")
+}
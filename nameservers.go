@@ -0,0 +1,178 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	mdns "github.com/miekg/dns"
+)
+
+// Bounds on how long the NS set of a zone is cached, whatever the TTL of
+// the NS records.
+const (
+	minNameserverCacheTTL = time.Minute
+	maxNameserverCacheTTL = time.Hour
+)
+
+// nameserverLookupTimeout bounds a lookup of the NS set of a zone, which is
+// shared by the callers waiting for it and canceled with none of them.
+const nameserverLookupTimeout = 15 * time.Second
+
+// zoneNameservers caches the NS sets queried by the authoritative
+// propagation checker when --propagation-nameservers is not set.
+var zoneNameservers = &nameserverCache{
+	now: time.Now,
+	lookup: func(ctx context.Context, zone string) ([]string, time.Duration, error) {
+		resolvers, err := systemResolvers()
+		if err != nil {
+			return nil, 0, err
+		}
+		return lookupNS(ctx, resolvers, zone)
+	},
+}
+
+// nameserverCache remembers the NS set of zones for the TTL of their NS
+// records. Failed lookups are not cached. Concurrent lookups of the same
+// zone are shared, so the challenges of a large Order do not all query the
+// resolvers when the NS set of their zone is not cached.
+type nameserverCache struct {
+	now    func() time.Time
+	lookup func(ctx context.Context, zone string) ([]string, time.Duration, error)
+
+	mu       sync.Mutex
+	entries  map[string]cachedNameservers
+	inflight map[string]*nameserverLookup
+}
+
+type cachedNameservers struct {
+	servers []string
+	expires time.Time
+}
+
+// nameserverLookup is a lookup in progress. Its results are set before done
+// is closed.
+type nameserverLookup struct {
+	done    chan struct{}
+	servers []string
+	err     error
+}
+
+// len returns the number of zones with cached nameservers.
+func (n *nameserverCache) len() int {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return len(n.entries)
+}
+
+// get returns the nameservers of zone. A caller whose ctx is done stops
+// waiting for the lookup, which goes on for the others.
+func (n *nameserverCache) get(ctx context.Context, zone string) ([]string, error) {
+	zone = mdns.CanonicalName(zone)
+
+	n.mu.Lock()
+	if e, ok := n.entries[zone]; ok && n.now().Before(e.expires) {
+		n.mu.Unlock()
+		return e.servers, nil
+	}
+	l, ok := n.inflight[zone]
+	if !ok {
+		if n.inflight == nil {
+			n.inflight = map[string]*nameserverLookup{}
+		}
+		l = &nameserverLookup{done: make(chan struct{})}
+		n.inflight[zone] = l
+		go n.run(context.WithoutCancel(ctx), zone, l)
+	}
+	n.mu.Unlock()
+
+	select {
+	case <-l.done:
+		return l.servers, l.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// run looks up the nameservers of zone for l, within
+// nameserverLookupTimeout, and caches them.
+func (n *nameserverCache) run(ctx context.Context, zone string, l *nameserverLookup) {
+	ctx, cancel := context.WithTimeout(ctx, nameserverLookupTimeout)
+	defer cancel()
+	servers, ttl, err := n.lookup(ctx, zone)
+	ttl = min(max(ttl, minNameserverCacheTTL), maxNameserverCacheTTL)
+
+	n.mu.Lock()
+	delete(n.inflight, zone)
+	if err == nil {
+		if n.entries == nil {
+			n.entries = map[string]cachedNameservers{}
+		}
+		n.entries[zone] = cachedNameservers{servers: servers, expires: n.now().Add(ttl)}
+	}
+	n.mu.Unlock()
+	l.servers, l.err = servers, err
+	close(l.done)
+}
+
+// lookupNS asks resolvers for the NS records of zone, in turn until one
+// answers. It returns the nameservers, sorted, and the smallest TTL of the
+// records.
+func lookupNS(ctx context.Context, resolvers []string, zone string) ([]string, time.Duration, error) {
+	m := new(mdns.Msg)
+	m.SetQuestion(mdns.Fqdn(zone), mdns.TypeNS)
+
+	client := &mdns.Client{Timeout: 5 * time.Second}
+	var errs []string
+	for _, resolver := range resolvers {
+		if _, _, err := net.SplitHostPort(resolver); err != nil {
+			resolver = net.JoinHostPort(resolver, "53")
+		}
+		in, _, err := client.ExchangeContext(ctx, m, resolver)
+		if err != nil {
+			errs = append(errs, err.Error())
+			continue
+		}
+		if in.Rcode != mdns.RcodeSuccess {
+			errs = append(errs, fmt.Sprintf("%s: %s", resolver, mdns.RcodeToString[in.Rcode]))
+			continue
+		}
+
+		var servers []string
+		var ttl uint32
+		for _, rr := range in.Answer {
+			ns, ok := rr.(*mdns.NS)
+			if !ok {
+				continue
+			}
+			servers = append(servers, strings.TrimSuffix(ns.Ns, "."))
+			if ttl == 0 || ns.Hdr.Ttl < ttl {
+				ttl = ns.Hdr.Ttl
+			}
+		}
+		if len(servers) == 0 {
+			errs = append(errs, fmt.Sprintf("%s: no NS records", resolver))
+			continue
+		}
+		sort.Strings(servers)
+		return servers, time.Duration(ttl) * time.Second, nil
+	}
+	return nil, 0, fmt.Errorf("looking up the nameservers of %s: %s", zone, strings.Join(errs, "; "))
+}
+
+// systemResolvers returns the nameservers in /etc/resolv.conf.
+func systemResolvers() ([]string, error) {
+	conf, err := mdns.ClientConfigFromFile("/etc/resolv.conf")
+	if err != nil {
+		return nil, fmt.Errorf("reading /etc/resolv.conf: %w", err)
+	}
+	servers := make([]string, 0, len(conf.Servers))
+	for _, s := range conf.Servers {
+		servers = append(servers, net.JoinHostPort(s, conf.Port))
+	}
+	return servers, nil
+}
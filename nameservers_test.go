@@ -0,0 +1,154 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	mdns "github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNameserverCache(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	var lookups int
+	var fail bool
+	n := &nameserverCache{
+		now: func() time.Time { return now },
+		lookup: func(ctx context.Context, zone string) ([]string, time.Duration, error) {
+			lookups++
+			if fail {
+				return nil, 0, errors.New("SERVFAIL")
+			}
+			return []string{"ns1." + zone}, 10 * time.Second, nil
+		},
+	}
+	ctx := context.Background()
+
+	servers, err := n.get(ctx, "Example.com.")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"ns1.example.com."}, servers)
+	_, err = n.get(ctx, "example.com.")
+	require.NoError(t, err)
+	assert.Equal(t, 1, lookups, "the NS set is cached")
+
+	now = now.Add(30 * time.Second)
+	_, err = n.get(ctx, "example.com.")
+	require.NoError(t, err)
+	assert.Equal(t, 1, lookups, "short TTLs are cached for at least a minute")
+
+	now = now.Add(time.Minute)
+	fail = true
+	_, err = n.get(ctx, "example.com.")
+	assert.Error(t, err)
+	_, err = n.get(ctx, "example.com.")
+	assert.Error(t, err)
+	assert.Equal(t, 3, lookups, "failed lookups are not cached")
+}
+
+func TestNameserverCacheSharesLookups(t *testing.T) {
+	var lookups atomic.Int32
+	n := &nameserverCache{
+		now: time.Now,
+		lookup: func(ctx context.Context, zone string) ([]string, time.Duration, error) {
+			lookups.Add(1)
+			time.Sleep(10 * time.Millisecond)
+			return []string{"ns1." + zone}, time.Hour, nil
+		},
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 200; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			servers, err := n.get(context.Background(), "example.com.")
+			assert.NoError(t, err)
+			assert.Equal(t, []string{"ns1.example.com."}, servers)
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, int32(1), lookups.Load(), "concurrent challenges share one lookup")
+	assert.Empty(t, n.inflight)
+}
+
+func TestNameserverCacheDetachesLookups(t *testing.T) {
+	release := make(chan struct{})
+	var lookupErr error
+	n := &nameserverCache{
+		now: time.Now,
+		lookup: func(ctx context.Context, zone string) ([]string, time.Duration, error) {
+			select {
+			case <-release:
+			case <-ctx.Done():
+				lookupErr = ctx.Err()
+				return nil, 0, ctx.Err()
+			}
+			deadline, ok := ctx.Deadline()
+			assert.True(t, ok, "the lookup has its own timeout")
+			assert.WithinDuration(t, time.Now().Add(nameserverLookupTimeout), deadline, nameserverLookupTimeout)
+			return []string{"ns1." + zone}, time.Hour, nil
+		},
+	}
+
+	// The caller that started the lookup gives up on it.
+	first, cancel := context.WithCancel(context.Background())
+	firstErr := make(chan error, 1)
+	go func() {
+		_, err := n.get(first, "example.com.")
+		firstErr <- err
+	}()
+	require.Eventually(t, func() bool {
+		n.mu.Lock()
+		defer n.mu.Unlock()
+		return len(n.inflight) == 1
+	}, 5*time.Second, time.Millisecond)
+	second := make(chan []string, 1)
+	go func() {
+		servers, err := n.get(context.Background(), "example.com.")
+		assert.NoError(t, err)
+		second <- servers
+	}()
+	cancel()
+	assert.ErrorIs(t, <-firstErr, context.Canceled)
+
+	close(release)
+	assert.Equal(t, []string{"ns1.example.com."}, <-second, "the others still get the result")
+	assert.NoError(t, lookupErr)
+}
+
+func TestLookupNS(t *testing.T) {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	server := &mdns.Server{PacketConn: pc, Handler: mdns.HandlerFunc(func(w mdns.ResponseWriter, q *mdns.Msg) {
+		m := new(mdns.Msg)
+		m.SetReply(q)
+		if q.Question[0].Name != "example.com." {
+			m.Rcode = mdns.RcodeNameError
+		} else {
+			for i, ns := range []string{"ns2.example.net.", "ns1.example.net."} {
+				m.Answer = append(m.Answer, &mdns.NS{
+					Hdr: mdns.RR_Header{Name: "example.com.", Rrtype: mdns.TypeNS, Class: mdns.ClassINET, Ttl: uint32(3600 - i)},
+					Ns:  ns,
+				})
+			}
+		}
+		_ = w.WriteMsg(m)
+	})}
+	go func() { _ = server.ActivateAndServe() }()
+	defer server.Shutdown()
+
+	ctx := context.Background()
+	servers, ttl, err := lookupNS(ctx, []string{pc.LocalAddr().String()}, "example.com.")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"ns1.example.net", "ns2.example.net"}, servers)
+	assert.Equal(t, 3599*time.Second, ttl)
+
+	_, _, err = lookupNS(ctx, []string{pc.LocalAddr().String()}, "example.org.")
+	assert.ErrorContains(t, err, "NXDOMAIN")
+}
@@ -0,0 +1,745 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/spf13/pflag"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// solverOptions holds the solver's own command line flags. They are
+// registered on the webhook server command next to the generic apiserver
+// flags, so they are parsed before Initialize is called.
+type solverOptions struct {
+	// MetricsBindAddress is the address the Prometheus metrics endpoint
+	// listens on. Empty or "0" disables the endpoint.
+	MetricsBindAddress string
+
+	// GRPCBindAddress is the address the gRPC solver service listens on,
+	// see grpcserver.go. Empty disables the service. It is served with
+	// GRPCTLSCertFile and GRPCTLSKeyFile and only accepts clients with a
+	// certificate signed by GRPCClientCAFile, for the resourceNamespaces
+	// GRPCClientNamespaces allows their identity.
+	GRPCBindAddress      string
+	GRPCTLSCertFile      string
+	GRPCTLSKeyFile       string
+	GRPCClientCAFile     string
+	GRPCClientNamespaces []string
+
+	// AllowedSecretNamespaces restricts the namespaces of the Issuers whose
+	// credential Secrets may be read. Entries may be shell patterns as
+	// understood by path.Match; "*" allows every namespace. Every other
+	// namespace is denied, and Initialize requires it to be set. It is
+	// checked against the namespace of the Issuer, also when
+	// SecretsNamespace reads the Secret from elsewhere.
+	AllowedSecretNamespaces []string
+
+	// SecretsKubeconfig points at a kubeconfig of another cluster from which
+	// credential Secrets are read instead of the local cluster.
+	SecretsKubeconfig string
+
+	// SecretsNamespace, if set, overrides the namespace credential Secrets
+	// are read from. It is mostly useful together with SecretsKubeconfig,
+	// where the management cluster does not mirror workload namespaces.
+	SecretsNamespace string
+
+	// CredentialProviders are the sources of API keys, asked in order, see
+	// credentialproviders.go. CredentialsDir is the directory of the file
+	// provider, and VaultAddr, VaultPath and VaultTokenFile configure the
+	// vault provider.
+	CredentialProviders []string
+	CredentialsDir      string
+
+	// InstallationKeyNamespaces are the namespaces (or path.Match patterns)
+	// of the challenges that may use the key of the installation, from the
+	// env and file providers and the vault provider without {namespace} in
+	// its path. Empty serves it to no challenge.
+	InstallationKeyNamespaces []string
+	VaultAddr                 string
+	VaultPath                 string
+	VaultTokenFile            string
+
+	// WatchNamespaces, if set, makes the webhook watch the credential
+	// Secrets of these namespaces with informers and read them from their
+	// cache, see secretwatch.go. Secrets of other namespaces are denied.
+	WatchNamespaces []string
+
+	// IssuerCheckInterval, if set, makes the webhook check the solver
+	// configs of the Issuers and ClusterIssuers of GROUP_NAME when they
+	// change and every interval, see issuercheck.go.
+	IssuerCheckInterval time.Duration
+
+	// ClusterResourceNamespace is the --cluster-resource-namespace of
+	// cert-manager, which the Secrets of ClusterIssuers are read from.
+	ClusterResourceNamespace string
+
+	// PropagationCheckTimeout is how long Present waits for the record to be
+	// served by PropagationNameservers, or by the NS set of the zone when it
+	// is empty. Zero disables the check.
+	PropagationCheckTimeout  time.Duration
+	PropagationCheckInterval time.Duration
+	PropagationNameservers   []string
+
+	// PropagationChecker selects how the propagation check looks up the
+	// record: authoritative queries PropagationNameservers, recursive
+	// queries PropagationResolvers and doh queries PropagationDoHURL.
+	PropagationChecker   string
+	PropagationResolvers []string
+	PropagationDoHURL    string
+
+	// TXTConflictPolicy is what Present does when the challenge name holds
+	// a TXT value that is not an ACME challenge value: fail, append or
+	// overwrite.
+	TXTConflictPolicy string
+
+	// AsyncPropagationCheck makes Present return once the zone is updated
+	// and runs the propagation check in the background.
+	AsyncPropagationCheck bool
+
+	// ZoneBatchWindow is how long the first edit of a zone waits for edits
+	// of other challenges in the same zone, so they are written together.
+	// Zero writes every challenge on its own.
+	ZoneBatchWindow time.Duration
+
+	// RecordEngine selects how challenge records are written: "v2" groups
+	// edits with ZoneBatchWindow and merges the edits waiting for a zone,
+	// "v1" reads and writes the zone for every edit on its own, as before
+	// the redesign, to fall back to if v2 misbehaves.
+	RecordEngine string
+
+	// ConfigShape is the shape of the solver configs: "native", or
+	// "cert-manager" for the field names of the providers built into
+	// cert-manager, see certManagerConfigFields.
+	ConfigShape string
+
+	// ZoneWorkers is how many zone operations run at once. Zones take turns
+	// for the free workers. Zero does not limit them, beyond one operation
+	// per zone.
+	ZoneWorkers int
+
+	// PresentCacheTTL is how long a successful Present is remembered, so
+	// that repeated calls for the same record skip reading the zone.
+	PresentCacheTTL time.Duration
+
+	// ZoneMetadataCacheTTL is how long the ID, name and nameservers of a
+	// zone are remembered, so that the Issuer checks and the propagation
+	// checkers do not read the zone.
+	ZoneMetadataCacheTTL time.Duration
+
+	// HealthProbeBindAddress is the address /healthz and /readyz are served
+	// on. Empty or "0" disables the probes.
+	HealthProbeBindAddress string
+
+	// DrainBindAddress is the loopback address the drain endpoint of the
+	// preStop hook is served on, see drain.go. Empty disables it.
+	DrainBindAddress string
+
+	// DrainDelay is how long a draining replica keeps serving the
+	// ChallengeRequests still sent to it, and DrainTimeout how long draining
+	// waits for the challenges in flight at most.
+	DrainDelay   time.Duration
+	DrainTimeout time.Duration
+
+	// DebugBindAddress is the address the debug endpoints, see debug.go, are
+	// served on. Empty disables them. Unless it is a loopback address, they
+	// require the bearer token in DebugTokenFile.
+	DebugBindAddress string
+	DebugTokenFile   string
+
+	// ZoneHistorySize is how many of their last edits are kept per zone for
+	// the debug endpoints. Zero keeps none.
+	ZoneHistorySize int
+
+	// ChangeEventURL receives a JSON changeEvent for every record the
+	// solver writes, with the bearer token in ChangeEventTokenFile if set.
+	ChangeEventURL       string
+	ChangeEventTokenFile string
+
+	// AuditLogPath receives an auditRecord in AuditLogFormat for every
+	// record the solver writes or fails to write; "-" is stdout. The file
+	// is rotated once it grows beyond AuditLogMaxSize megabytes, keeping
+	// AuditLogMaxBackups old files.
+	AuditLogPath       string
+	AuditLogFormat     string
+	AuditLogMaxSize    int
+	AuditLogMaxBackups int
+
+	// CacheSoftLimit, GoroutineSoftLimit and HeapSoftLimit (in megabytes)
+	// log a warning when the entries of a cache, the goroutines or the heap
+	// grow beyond them. Zero disables the warning.
+	CacheSoftLimit     int
+	GoroutineSoftLimit int
+	HeapSoftLimit      int
+
+	// ServingCertExpiryWindow makes /readyz fail once the serving
+	// certificate expires within it.
+	ServingCertExpiryWindow time.Duration
+
+	// LeaderElect restricts zone writes to the replica holding the
+	// LeaderElectionID Lease in LeaderElectionNamespace.
+	LeaderElect             bool
+	LeaderElectionID        string
+	LeaderElectionNamespace string
+
+	// LeaderElectionAddress is the address of the webhook API of this
+	// replica, which followers forward challenges to while it leads. The
+	// serving certificate of the leader is verified with the CA bundle in
+	// LeaderForwardCAFile for LeaderForwardServerName.
+	LeaderElectionAddress   string
+	LeaderForwardCAFile     string
+	LeaderForwardServerName string
+
+	// RegistryConfigMap is the ConfigMap in RegistryNamespace that records
+	// the challenge records written by the webhook. Empty disables the
+	// configmap store.
+	RegistryConfigMap string
+	RegistryNamespace string
+
+	// RegistryStore is where the registry keeps its entries: "configmap"
+	// in RegistryConfigMap, "crd" in SakuraCloudChallengeRecords in
+	// RegistryNamespace, or "memory" in the replica, lost on restart.
+	RegistryStore string
+
+	// CleanupRetryInterval is how often failed cleanups recorded in the
+	// registry are retried in the background. The delay after a failed
+	// attempt doubles with every attempt, and a cleanup is given up after
+	// CleanupMaxAttempts attempts; zero retries until it succeeds.
+	CleanupRetryInterval time.Duration
+	CleanupMaxAttempts   int
+
+	// RegistryGCInterval is how often registry entries of challenges that
+	// no longer exist are removed. Zero disables it.
+	RegistryGCInterval time.Duration
+
+	// GroupsConfig is a file listing extra API groups to serve, each with
+	// its own values of the groupScopedFlags.
+	GroupsConfig string
+
+	// ServiceName is the Service in front of the webhook, in its namespace,
+	// which the APIServices of the served groups should send to. Empty
+	// skips checking where the APIServices send to.
+	ServiceName string
+
+	// VerifyZoneUpdates reads every zone back after updating it and undoes
+	// the edits of the update if the zone is not in the state that was
+	// written, see updateRecordsVerified.
+	VerifyZoneUpdates bool
+
+	// RefreshAfterWrite is how long Present waits for the nameservers of a
+	// zone to serve a new SOA serial after updating it. Zero disables it.
+	RefreshAfterWrite time.Duration
+
+	// PruneAge, if set, deletes challenge records older than it from the
+	// zones in PruneZones every PruneInterval. It requires the registry.
+	PruneAge      time.Duration
+	PruneZones    []int64
+	PruneInterval time.Duration
+
+	// ReadinessZones are checked every ReadinessZoneInterval to be readable
+	// with the key of the installation and available, see zonereadiness.go.
+	ReadinessZones        []int64
+	ReadinessZoneInterval time.Duration
+
+	// CleanupOnShutdown deletes the records of failed cleanups and of
+	// challenges older than ShutdownCleanupAge when the webhook stops,
+	// unless LeaderElect is set.
+	CleanupOnShutdown  bool
+	ShutdownCleanupAge time.Duration
+
+	// SyslogAddress and SyslogFacility configure --logging-format=syslog.
+	SyslogAddress  string
+	SyslogFacility string
+
+	// LogSampleWindow is the window within which identical warnings and
+	// errors are logged only once.
+	LogSampleWindow time.Duration
+
+	// MetricsZones and MetricsMaxZones limit which zones get their own zone
+	// label on the metrics; the rest are folded into "other".
+	MetricsZones    []string
+	MetricsMaxZones int
+
+	// SnapshotDir is the directory the record set of a zone is saved to
+	// before every update, keeping SnapshotRetention snapshots per zone.
+	// Empty disables snapshots.
+	SnapshotDir       string
+	SnapshotRetention int
+
+	// InjectFailureRate is the percentage of Present calls through the
+	// staging solver that fail on purpose.
+	InjectFailureRate float64
+
+	// APIDebugLogging logs the requests to and responses from the Sakura
+	// Cloud API, with credentials and record values redacted.
+	APIDebugLogging bool
+
+	// CircuitBreakerFailures is the number of consecutive failed API
+	// requests after which requests are rejected for CircuitBreakerCooldown
+	// and the replica reports not ready. Zero disables the breaker.
+	CircuitBreakerFailures int
+	CircuitBreakerCooldown time.Duration
+
+	// APIRetryStatusCodes are the HTTP status codes of API responses that
+	// are retried; the others fail the request right away.
+	APIRetryStatusCodes []int
+
+	// NotificationGroupID is the Sakura Cloud Simple Notification group
+	// alerted when the circuit breaker opens or the challenges of a zone
+	// fail NotificationFailureThreshold times in a row, at most once per
+	// NotificationInterval per alert. Empty disables the alerts.
+	NotificationGroupID          string
+	NotificationFailureThreshold int
+	NotificationInterval         time.Duration
+
+	// ExternalDNSOwnerID, if set, makes Present write an external-dns
+	// ownership record with this owner ID next to challenge records, so
+	// external-dns instances sharing the zone leave them alone.
+	ExternalDNSOwnerID string
+
+	// DailyUpdateBudget is how many zone updates each API key may make per
+	// UTC day and replica. Once it is used up, CleanUps are deferred to the
+	// next day.
+	// Zero does not limit the updates.
+	DailyUpdateBudget int
+
+	// MaintenanceBackoff is how long API requests are held back after the
+	// API answered 503 Service Unavailable, as it does during maintenance.
+	// Zero retries 503 like other server errors.
+	MaintenanceBackoff time.Duration
+
+	// HandlerTimeout bounds the handling of a single Present or CleanUp.
+	// Zero leaves it unbounded.
+	HandlerTimeout time.Duration
+
+	// DefaultTTL is the TTL of challenge records of Issuers that do not set
+	// ttl.
+	DefaultTTL int
+
+	// MinTTL and MaxTTL bound the TTL of challenge records, whatever the
+	// Issuer config sets. Zero leaves the TTL unbounded on that side.
+	MinTTL int
+	MaxTTL int
+
+	// HeartbeatNamespace, if set, holds the Leases the installations of the
+	// webhook renew every HeartbeatInterval to detect each other.
+	// InstallationID identifies this installation in them.
+	HeartbeatNamespace string
+	HeartbeatInterval  time.Duration
+	InstallationID     string
+
+	// flags holds the solver flags, for applyEnv.
+	flags *pflag.FlagSet
+
+	// groupNames are the API groups served by the process, GROUP_NAME
+	// first, for the heartbeat and the issuer checks. The options of a
+	// group of --groups-config hold that group only.
+	groupNames []string
+
+	// tlsCertFile is the apiserver's --tls-cert-file flag, looked up once
+	// the server command has registered it.
+	tlsCertFile *pflag.Flag
+}
+
+func newSolverOptions() *solverOptions {
+	return &solverOptions{
+		MetricsBindAddress:           ":8080",
+		PropagationCheckInterval:     5 * time.Second,
+		PropagationChecker:           "authoritative",
+		TXTConflictPolicy:            "fail",
+		PropagationDoHURL:            "https://cloudflare-dns.com/dns-query",
+		HealthProbeBindAddress:       ":8081",
+		DrainDelay:                   5 * time.Second,
+		DrainTimeout:                 time.Minute,
+		ServingCertExpiryWindow:      24 * time.Hour,
+		LeaderElectionID:             "cert-manager-webhook-sakuracloud",
+		CleanupRetryInterval:         time.Minute,
+		CleanupMaxAttempts:           10,
+		RegistryGCInterval:           time.Hour,
+		ZoneMetadataCacheTTL:         time.Hour,
+		RegistryStore:                "configmap",
+		RecordEngine:                 "v2",
+		ConfigShape:                  "native",
+		ClusterResourceNamespace:     "cert-manager",
+		CredentialProviders:          []string{"secret"},
+		PruneInterval:                time.Hour,
+		ReadinessZoneInterval:        5 * time.Minute,
+		ShutdownCleanupAge:           time.Hour,
+		SyslogFacility:               "daemon",
+		LogSampleWindow:              time.Minute,
+		SnapshotRetention:            10,
+		DefaultTTL:                   60,
+		CircuitBreakerCooldown:       30 * time.Second,
+		NotificationFailureThreshold: 5,
+		NotificationInterval:         time.Hour,
+		HeartbeatInterval:            time.Minute,
+		MaintenanceBackoff:           2 * time.Minute,
+		APIRetryStatusCodes:          []int{http.StatusLocked, http.StatusTooManyRequests, http.StatusServiceUnavailable},
+		HandlerTimeout:               50 * time.Second,
+		ZoneHistorySize:              20,
+		AuditLogFormat:               "jsonl",
+		AuditLogMaxSize:              100,
+		AuditLogMaxBackups:           5,
+	}
+}
+
+// AddFlags registers the solver flags on fs.
+func (o *solverOptions) AddFlags(fs *pflag.FlagSet) {
+	o.flags = pflag.NewFlagSet("solver", pflag.ContinueOnError)
+	o.addSolverFlags(o.flags)
+	fs.AddFlagSet(o.flags)
+
+	o.tlsCertFile = fs.Lookup("tls-cert-file")
+}
+
+func (o *solverOptions) addSolverFlags(fs *pflag.FlagSet) {
+	fs.StringVar(&o.MetricsBindAddress, "metrics-bind-address", o.MetricsBindAddress,
+		"The address the metrics endpoint binds to. Set to 0 to disable it.")
+	fs.StringVar(&o.GRPCBindAddress, "grpc-bind-address", o.GRPCBindAddress,
+		"Address of the gRPC solver service for ACME clients outside of Kubernetes, see api/solver.proto. Empty disables the service.")
+	fs.StringVar(&o.GRPCTLSCertFile, "grpc-tls-cert-file", o.GRPCTLSCertFile,
+		"Serving certificate of the gRPC solver service.")
+	fs.StringVar(&o.GRPCTLSKeyFile, "grpc-tls-key-file", o.GRPCTLSKeyFile,
+		"Private key of --grpc-tls-cert-file.")
+	fs.StringVar(&o.GRPCClientCAFile, "grpc-client-ca-file", o.GRPCClientCAFile,
+		"CA certificates that sign the client certificates the gRPC solver service accepts. Clients without one are rejected.")
+	fs.StringSliceVar(&o.GRPCClientNamespaces, "grpc-client-namespaces", o.GRPCClientNamespaces,
+		"identity=namespace entries: the resourceNamespaces (or path.Match patterns) whose Secrets the gRPC client with that certificate common name, DNS or URI name may use. "+
+			"Repeat an identity for several namespaces. Requests for any other namespace are rejected; required with --grpc-bind-address.")
+	fs.StringSliceVar(&o.AllowedSecretNamespaces, "allowed-secret-namespaces", o.AllowedSecretNamespaces,
+		"Namespaces (or path.Match patterns) of the Issuers whose credential Secrets the webhook may read. "+
+			"Required, use * for all namespaces. Secrets for Issuers in any other namespace are denied regardless of RBAC, also when --secrets-namespace is set.")
+	fs.StringVar(&o.SecretsKubeconfig, "secrets-kubeconfig", o.SecretsKubeconfig,
+		"Path to a kubeconfig of a remote (management) cluster to read credential Secrets from. Defaults to the local cluster.")
+	fs.StringVar(&o.SecretsNamespace, "secrets-namespace", o.SecretsNamespace,
+		"Namespace to read credential Secrets from instead of the Issuer's namespace.")
+	fs.StringSliceVar(&o.CredentialProviders, "credential-providers", o.CredentialProviders,
+		"Sources of API keys, asked in order until one has the key: secret (the Secrets referenced by the Issuer config), "+
+			"env (SAKURACLOUD_ACCESS_TOKEN and SAKURACLOUD_ACCESS_TOKEN_SECRET), file (--credentials-dir) or vault (--vault-addr).")
+	fs.StringSliceVar(&o.InstallationKeyNamespaces, "installation-key-namespaces", o.InstallationKeyNamespaces,
+		"Namespaces (or path.Match patterns) of the challenges that may use the API key of the installation: the env and file credential providers, "+
+			"and the vault provider when --vault-path has no {namespace}. Empty serves it to no challenge.")
+	fs.StringVar(&o.CredentialsDir, "credentials-dir", o.CredentialsDir,
+		"Directory holding the files accessToken and accessTokenSecret, for the file credential provider.")
+	fs.StringVar(&o.VaultAddr, "vault-addr", o.VaultAddr,
+		"Address of HashiCorp Vault, for the vault credential provider.")
+	fs.StringVar(&o.VaultPath, "vault-path", o.VaultPath,
+		"API path of the KV version 2 secret holding accessToken and accessTokenSecret, e.g. secret/data/sakuracloud/{namespace}. "+
+			"{namespace} is replaced by the namespace of the challenge.")
+	fs.StringVar(&o.VaultTokenFile, "vault-token-file", o.VaultTokenFile,
+		"File holding the Vault token, e.g. written by the Vault Agent. It is read for every challenge.")
+	fs.StringSliceVar(&o.WatchNamespaces, "watch-namespaces", o.WatchNamespaces,
+		"Namespaces whose credential Secrets are watched and served from a cache instead of being read on every challenge. "+
+			"When set, the webhook only needs to list and watch Secrets in these namespaces, and Secrets in any other namespace are denied.")
+	fs.DurationVar(&o.IssuerCheckInterval, "issuer-check-interval", o.IssuerCheckInterval,
+		"Watch the Issuers and ClusterIssuers of GROUP_NAME, and read the credential Secrets and zones of their solver configs when they change and this often, "+
+			"exporting issuer_ready before any certificate is requested. 0 disables it.")
+	fs.StringVar(&o.ClusterResourceNamespace, "cluster-resource-namespace", o.ClusterResourceNamespace,
+		"The --cluster-resource-namespace of cert-manager, which the credential Secrets of ClusterIssuers are read from by --issuer-check-interval.")
+	fs.DurationVar(&o.PropagationCheckTimeout, "propagation-check-timeout", o.PropagationCheckTimeout,
+		"How long Present waits for the TXT record to be served by the authoritative nameservers. "+
+			"If it does not appear and is missing from the zone, it is presented once more. 0 disables the check.")
+	fs.DurationVar(&o.PropagationCheckInterval, "propagation-check-interval", o.PropagationCheckInterval,
+		"Interval between propagation check queries.")
+	fs.StringSliceVar(&o.PropagationNameservers, "propagation-nameservers", o.PropagationNameservers,
+		"Authoritative nameservers queried by the propagation check. Defaults to the NS records of the zone.")
+	fs.StringVar(&o.PropagationChecker, "propagation-checker", o.PropagationChecker,
+		"How the propagation check looks up the record: authoritative (queries --propagation-nameservers), "+
+			"recursive (queries --propagation-resolvers) or doh (DNS-over-HTTPS to --propagation-doh-url).")
+	fs.StringVar(&o.TXTConflictPolicy, "txt-conflict-policy", o.TXTConflictPolicy,
+		"What Present does when the challenge name already holds a TXT value that is not an ACME challenge value: "+
+			"fail, append (write the challenge record next to it) or overwrite.")
+	fs.StringSliceVar(&o.PropagationResolvers, "propagation-resolvers", o.PropagationResolvers,
+		"Resolvers queried by the recursive propagation checker. Defaults to the nameservers in /etc/resolv.conf.")
+	fs.StringVar(&o.PropagationDoHURL, "propagation-doh-url", o.PropagationDoHURL,
+		"DNS-over-HTTPS endpoint queried by the doh propagation checker.")
+	fs.BoolVar(&o.AsyncPropagationCheck, "async-propagation-check", o.AsyncPropagationCheck,
+		"Return from Present as soon as the zone is updated and run the propagation check in the background. "+
+			"The result is exported as a metric and recorded in the registry.")
+	fs.DurationVar(&o.ZoneBatchWindow, "zone-batch-window", o.ZoneBatchWindow,
+		"How long to collect challenges for the same zone before writing them in a single zone update. 0 disables grouping.")
+	fs.StringVar(&o.RecordEngine, "record-engine", o.RecordEngine,
+		"How challenge records are written: v2 groups the challenges of a zone (--zone-batch-window) and merges duplicate waiting edits; v1 reads and writes the zone for every challenge on its own. Can be set per API group in --groups-config to roll v2 out gradually.")
+	fs.StringVar(&o.ConfigShape, "config-shape", o.ConfigShape,
+		"The field names of the Issuer solver configs: native, or cert-manager for hostedZoneID, hostedZoneName, apiTokenSecretRef (the access token) and apiSecretSecretRef (the access token secret), as used by other Sakura Cloud webhooks. Can be set per API group in --groups-config to serve the group name of another webhook.")
+	fs.IntVar(&o.ZoneWorkers, "zone-workers", o.ZoneWorkers,
+		"How many zone reads and updates run at once. Waiting operations are taken from each zone in turn. 0 does not limit them. Operations of one zone never run at once.")
+	fs.DurationVar(&o.PresentCacheTTL, "present-cache-ttl", o.PresentCacheTTL,
+		"How long a successful Present is remembered. Repeated Present calls for the same record within it return without reading the zone. 0 disables the cache.")
+	fs.DurationVar(&o.ZoneMetadataCacheTTL, "zone-metadata-cache-ttl", o.ZoneMetadataCacheTTL,
+		"How long the ID, name and nameservers of a zone are remembered once read, per API key. The Issuer checks use them instead of reading the zone, and the authoritative propagation checker falls back to the nameservers of the zone when its NS records cannot be looked up. 0 disables the cache.")
+	fs.StringVar(&o.HealthProbeBindAddress, "health-probe-bind-address", o.HealthProbeBindAddress,
+		"The address the /healthz and /readyz endpoints bind to. Set to 0 to disable them.")
+	fs.StringVar(&o.DrainBindAddress, "drain-bind-address", o.DrainBindAddress,
+		"The loopback address the drain endpoint binds to, e.g. 127.0.0.1:8083. The preStop hook runs `webhook drain` to make /readyz fail and wait for the challenges in flight before the process is stopped. Empty disables it.")
+	fs.DurationVar(&o.DrainDelay, "drain-delay", o.DrainDelay,
+		"How long a draining webhook keeps serving the challenges still sent to it after /readyz started failing, for the endpoints of the Service to drop the pod.")
+	fs.DurationVar(&o.DrainTimeout, "drain-timeout", o.DrainTimeout,
+		"How long draining waits for the challenges in flight at most, including --drain-delay. Keep it below the terminationGracePeriodSeconds of the pod.")
+	fs.StringVar(&o.DebugBindAddress, "debug-bind-address", o.DebugBindAddress,
+		"The address the debug endpoints (/debug/credentials, /debug/zones/history) bind to, e.g. 127.0.0.1:8082 to reach them with kubectl port-forward. Empty disables them.")
+	fs.StringVar(&o.DebugTokenFile, "debug-token-file", o.DebugTokenFile,
+		"File holding a bearer token the debug endpoints require. Required unless --debug-bind-address is a loopback address.")
+	fs.IntVar(&o.ZoneHistorySize, "zone-history-size", o.ZoneHistorySize,
+		"How many of their last edits are kept in memory per zone, for /debug/zones/history. 0 keeps none.")
+	fs.StringVar(&o.ChangeEventURL, "change-event-url", o.ChangeEventURL,
+		"URL to POST a JSON event to for every record written to a zone, e.g. to file change records in a CMDB. Empty disables the events.")
+	fs.StringVar(&o.ChangeEventTokenFile, "change-event-token-file", o.ChangeEventTokenFile,
+		"File holding a bearer token sent with the events of --change-event-url.")
+	fs.StringVar(&o.AuditLogPath, "audit-log-path", o.AuditLogPath,
+		"File to write an audit record to for every record written to a zone or failed to be written, for SIEM systems. - writes to stdout. Empty disables the audit log.")
+	fs.StringVar(&o.AuditLogFormat, "audit-log-format", o.AuditLogFormat,
+		"Format of the audit records: jsonl (one JSON object per line) or cef (ArcSight Common Event Format).")
+	fs.IntVar(&o.AuditLogMaxSize, "audit-log-max-size", o.AuditLogMaxSize,
+		"Size in megabytes after which --audit-log-path is rotated to <path>.1. 0 never rotates it.")
+	fs.IntVar(&o.AuditLogMaxBackups, "audit-log-max-backups", o.AuditLogMaxBackups,
+		"Number of rotated audit log files kept. 0 deletes the file when it is rotated.")
+	fs.IntVar(&o.CacheSoftLimit, "cache-soft-limit", o.CacheSoftLimit,
+		"Log a warning when an in-memory cache or queue of the webhook holds more entries than this. 0 disables the warning.")
+	fs.IntVar(&o.GoroutineSoftLimit, "goroutine-soft-limit", o.GoroutineSoftLimit,
+		"Log a warning when the webhook runs more goroutines than this. 0 disables the warning.")
+	fs.IntVar(&o.HeapSoftLimit, "heap-soft-limit", o.HeapSoftLimit,
+		"Log a warning when the heap of the webhook grows beyond this many megabytes. 0 disables the warning.")
+	fs.DurationVar(&o.ServingCertExpiryWindow, "serving-cert-expiry-window", o.ServingCertExpiryWindow,
+		"Report not ready once the certificate given by --tls-cert-file expires within this duration.")
+	fs.BoolVar(&o.LeaderElect, "leader-elect", o.LeaderElect,
+		"Only let the replica holding the leader Lease write to zones. Other replicas stay ready and forward the challenges reaching them to the leader with --leader-election-address, "+
+			"or else reject them with a retriable error, which cert-manager retries with its backoff.")
+	fs.StringVar(&o.LeaderElectionID, "leader-election-id", o.LeaderElectionID,
+		"Name of the Lease used for leader election.")
+	fs.StringVar(&o.LeaderElectionNamespace, "leader-election-namespace", o.LeaderElectionNamespace,
+		"Namespace of the leader election Lease. Defaults to the namespace of the service account.")
+	fs.StringVar(&o.LeaderElectionAddress, "leader-election-address", o.LeaderElectionAddress,
+		"host:port of the webhook API of this replica, e.g. $(POD_IP):443, which the other replicas forward challenges to while it is the leader. "+
+			"They authenticate with their ServiceAccount token, which needs to be allowed to create the ChallengePayloads of the solvers. Requires --leader-forward-ca-file and --leader-forward-server-name.")
+	fs.StringVar(&o.LeaderForwardCAFile, "leader-forward-ca-file", o.LeaderForwardCAFile,
+		"CA bundle verifying the serving certificate of the leader when forwarding challenges to it.")
+	fs.StringVar(&o.LeaderForwardServerName, "leader-forward-server-name", o.LeaderForwardServerName,
+		"Name the serving certificate of the leader is verified for when forwarding challenges to it, e.g. the DNS name of the Service.")
+	fs.StringVar(&o.RegistryConfigMap, "registry-configmap", o.RegistryConfigMap,
+		"Name of the ConfigMap recording the challenge records written by the webhook. Failed cleanups are retried in the background from it. Empty disables the registry of --registry-store=configmap.")
+	fs.StringVar(&o.RegistryNamespace, "registry-namespace", o.RegistryNamespace,
+		"Namespace of the registry ConfigMap or SakuraCloudChallengeRecords. Defaults to the namespace of the service account.")
+	fs.StringVar(&o.RegistryStore, "registry-store", o.RegistryStore,
+		"Where the registry keeps its entries: configmap (in --registry-configmap), crd (one SakuraCloudChallengeRecord per record, for large clusters) or memory (in the replica, lost on restart). crd and memory enable the registry without --registry-configmap.")
+	fs.DurationVar(&o.CleanupRetryInterval, "cleanup-retry-interval", o.CleanupRetryInterval,
+		"How often failed cleanups recorded in the registry are retried. The delay after a failed attempt doubles with every attempt, up to an hour.")
+	fs.IntVar(&o.CleanupMaxAttempts, "cleanup-max-attempts", o.CleanupMaxAttempts,
+		"Number of failed attempts after which the cleanup of a record is given up and the record left in the zone and the registry, for --cleanup-on-shutdown or --prune-age. 0 retries until the cleanup succeeds.")
+	fs.DurationVar(&o.RegistryGCInterval, "registry-gc-interval", o.RegistryGCInterval,
+		"How often registry entries whose Challenge no longer exists and whose record is gone from the zone are removed. 0 disables it.")
+	fs.StringVar(&o.GroupsConfig, "groups-config", o.GroupsConfig,
+		"YAML file listing extra API groups to serve besides GROUP_NAME, each with its own solver flags (groups: [{groupName: ..., args: [--default-ttl=120]}]).")
+	fs.StringVar(&o.ServiceName, "service-name", o.ServiceName,
+		"Name of the Service in front of the webhook, in its namespace, set by the Helm chart. The startup check of the APIServices warns about those of served groups sending elsewhere, "+
+			"and about unavailable ones sending groups the webhook does not serve to it. Empty skips both.")
+	fs.BoolVar(&o.VerifyZoneUpdates, "verify-zone-updates", o.VerifyZoneUpdates,
+		"Read every zone back after updating it and undo the added and deleted records of the update on top of the records read back if the zone does not hold the written records.")
+	fs.DurationVar(&o.RefreshAfterWrite, "refresh-after-write", o.RefreshAfterWrite,
+		"After Present updated a zone, wait up to this long for its authoritative nameservers (or --propagation-nameservers) to serve a new SOA serial before returning, so that cert-manager's self check finds the record on its first try. The API cannot trigger a NOTIFY; this only waits. 0 disables it.")
+	fs.DurationVar(&o.PruneAge, "prune-age", o.PruneAge,
+		"Delete _acme-challenge TXT records older than this from the zones in --prune-zones, whoever created them. "+
+			"The age is taken from the registry; records missing from it are aged from when the webhook first saw them. Requires the registry, not --registry-store=memory. 0 disables pruning.")
+	fs.Int64SliceVar(&o.PruneZones, "prune-zones", o.PruneZones,
+		"IDs of the zones --prune-age applies to.")
+	fs.DurationVar(&o.PruneInterval, "prune-interval", o.PruneInterval,
+		"How often the zones in --prune-zones are pruned.")
+	fs.Int64SliceVar(&o.ReadinessZones, "readiness-zones", o.ReadinessZones,
+		"IDs of zones to check every --readiness-zone-interval: whether they can be read with the API key of the installation (the env, file or vault credential providers) and are available. "+
+			"The result is exported per zone as sakuracloud_webhook_zone_ready.")
+	fs.DurationVar(&o.ReadinessZoneInterval, "readiness-zone-interval", o.ReadinessZoneInterval,
+		"How often the zones in --readiness-zones are checked.")
+	fs.BoolVar(&o.CleanupOnShutdown, "cleanup-on-shutdown", o.CleanupOnShutdown,
+		"Once the webhook has stopped serving, delete the records in the registry whose cleanup failed or that were presented more than --shutdown-cleanup-age ago. Requires the registry. Has no effect with --leader-elect, where the next leader retries the failed cleanups instead.")
+	fs.DurationVar(&o.ShutdownCleanupAge, "shutdown-cleanup-age", o.ShutdownCleanupAge,
+		"Age after which a presented record is considered abandoned by --cleanup-on-shutdown.")
+	fs.StringVar(&o.SyslogAddress, "syslog-address", o.SyslogAddress,
+		"Syslog server logs are sent to with --logging-format=syslog, as udp://host:port or tcp://host:port.")
+	fs.StringVar(&o.SyslogFacility, "syslog-facility", o.SyslogFacility,
+		"Syslog facility used with --logging-format=syslog: kern, user, daemon, auth or local0-7.")
+	fs.DurationVar(&o.LogSampleWindow, "log-sample-window", o.LogSampleWindow,
+		"Identical warnings and errors repeated within this window are logged once, followed by a count of the repeats. 0 logs every occurrence.")
+	fs.StringSliceVar(&o.MetricsZones, "metrics-zones", o.MetricsZones,
+		"Zone IDs or names (or path.Match patterns) that get their own zone label on the metrics. Other zones are reported as \"other\". Empty allows all zones.")
+	fs.IntVar(&o.MetricsMaxZones, "metrics-max-zones", o.MetricsMaxZones,
+		"Maximum number of zones that get their own zone label on the metrics, counted by zone ID whether a metric labels them with their ID or name. Zones seen after the limit is reached are reported as \"other\". 0 is unlimited.")
+	fs.StringVar(&o.SnapshotDir, "snapshot-dir", o.SnapshotDir,
+		"Directory to save the records of a zone to before every update, for the restore command. Empty disables snapshots.")
+	fs.IntVar(&o.SnapshotRetention, "snapshot-retention", o.SnapshotRetention,
+		"Number of snapshots kept per zone in --snapshot-dir. 0 keeps all of them.")
+	fs.Float64Var(&o.InjectFailureRate, "inject-failure-rate", o.InjectFailureRate,
+		"Percentage (0-100) of Present calls to the sakuracloud-dns-solver-staging solver that fail on purpose, to rehearse alerting and retries. "+
+			"The sakuracloud-dns-solver solver is never affected.")
+	fs.BoolVar(&o.APIDebugLogging, "api-debug-logging", o.APIDebugLogging,
+		"Log every request to and response from the Sakura Cloud API, with credentials and record values redacted.")
+	fs.IntVar(&o.CircuitBreakerFailures, "circuit-breaker-failures", o.CircuitBreakerFailures,
+		"Number of consecutive failed Sakura Cloud API requests (transport errors and 5xx) after which API requests are rejected "+
+			"and /readyz fails for --circuit-breaker-cooldown. 0 disables the circuit breaker.")
+	fs.DurationVar(&o.CircuitBreakerCooldown, "circuit-breaker-cooldown", o.CircuitBreakerCooldown,
+		"How long the circuit breaker stays open before letting a request through again.")
+	fs.IntSliceVar(&o.APIRetryStatusCodes, "api-retry-status-codes", o.APIRetryStatusCodes,
+		"HTTP status codes of Sakura Cloud API responses that are retried with backoff. Other error responses fail the request right away; transport errors are always retried.")
+	fs.StringVar(&o.HeartbeatNamespace, "heartbeat-namespace", o.HeartbeatNamespace,
+		"Namespace, in the cluster of --secrets-kubeconfig if set, where the webhook installations renew a Lease listing the zones they write to, "+
+			"to warn when another installation writes to the same zones for the same API group. Empty disables the check.")
+	fs.DurationVar(&o.HeartbeatInterval, "heartbeat-interval", o.HeartbeatInterval,
+		"Interval at which the Lease in --heartbeat-namespace is renewed and the others are checked.")
+	fs.StringVar(&o.InstallationID, "installation-id", o.InstallationID,
+		"ID of the installation in --heartbeat-namespace, the same for all its replicas. "+
+			"Defaults to the UID of the kube-system namespace, the namespace of the webhook and --service-name.")
+	fs.StringVar(&o.NotificationGroupID, "notification-group-id", o.NotificationGroupID,
+		"ID of a Sakura Cloud Simple Notification group to alert when the circuit breaker opens or challenges keep failing, "+
+			"with the API key of SAKURACLOUD_ACCESS_TOKEN and SAKURACLOUD_ACCESS_TOKEN_SECRET. Empty disables the alerts.")
+	fs.IntVar(&o.NotificationFailureThreshold, "notification-failure-threshold", o.NotificationFailureThreshold,
+		"Number of consecutive failed challenges of a zone that alerts --notification-group-id. 0 only alerts on the circuit breaker.")
+	fs.DurationVar(&o.NotificationInterval, "notification-interval", o.NotificationInterval,
+		"Minimum time between two alerts for the same zone or for the circuit breaker.")
+	fs.StringVar(&o.ExternalDNSOwnerID, "external-dns-owner-id", o.ExternalDNSOwnerID,
+		"Owner ID of the external-dns ownership TXT records written next to challenge records, for zones co-managed by external-dns. "+
+			"Ownership records of external-dns are never modified or deleted either way. Empty writes none.")
+	fs.IntVar(&o.DailyUpdateBudget, "daily-update-budget", o.DailyUpdateBudget,
+		"Number of zone updates each Sakura Cloud API key may make per UTC day and replica, counted in memory. Once it is used up, cleanups are deferred to the next day "+
+			"and retried from the registry, which must not be --registry-store=memory, while Presents are still made. 0 does not limit the updates.")
+	fs.DurationVar(&o.MaintenanceBackoff, "maintenance-backoff", o.MaintenanceBackoff,
+		"How long no Sakura Cloud API requests are sent after the API answered 503 Service Unavailable, as it does during maintenance. "+
+			"A longer Retry-After of the response is honored. Challenges fail with a retriable error meanwhile. 0 retries 503 like other server errors.")
+	fs.DurationVar(&o.HandlerTimeout, "handler-timeout", o.HandlerTimeout,
+		"Deadline for handling a single Present or CleanUp, including API retries and the propagation check. "+
+			"Keep it below the request timeout of the kube-apiserver (1m by default). 0 disables the deadline.")
+	fs.IntVar(&o.DefaultTTL, "default-ttl", o.DefaultTTL,
+		"TTL of the challenge records of Issuers whose config does not set ttl. Must be positive and within --min-ttl and --max-ttl.")
+	fs.IntVar(&o.MinTTL, "min-ttl", o.MinTTL,
+		"Lowest TTL of challenge records. Lower ttl values of Issuer configs are raised to it. 0 sets no lower bound.")
+	fs.IntVar(&o.MaxTTL, "max-ttl", o.MaxTTL,
+		"Highest TTL of challenge records. Higher ttl values of Issuer configs are lowered to it. 0 sets no upper bound.")
+}
+
+// envPrefix is prepended to the environment variables of the solver flags.
+const envPrefix = "SAKURACLOUD_WEBHOOK_"
+
+// flagEnvName returns the environment variable of a solver flag, e.g.
+// SAKURACLOUD_WEBHOOK_DEFAULT_TTL for --default-ttl.
+func flagEnvName(flag string) string {
+	return envPrefix + strings.ToUpper(strings.ReplaceAll(flag, "-", "_"))
+}
+
+// applyEnv sets the solver flags that were not given on the command line
+// from their environment variables. It runs after the command line has been
+// parsed, so flags take precedence over the environment, which takes
+// precedence over the built-in defaults.
+func (o *solverOptions) applyEnv() error {
+	var errs []error
+	o.flags.VisitAll(func(f *pflag.Flag) {
+		value, ok := os.LookupEnv(flagEnvName(f.Name))
+		if !ok || f.Changed {
+			return
+		}
+		if err := f.Value.Set(value); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", flagEnvName(f.Name), err))
+		}
+	})
+	return errors.Join(errs...)
+}
+
+// validateTTL checks --default-ttl, --min-ttl and --max-ttl. The default
+// must lie within the bounds, so that records of Issuers that do not set
+// ttl get the TTL of --default-ttl.
+func (o *solverOptions) validateTTL() error {
+	switch {
+	case o.DefaultTTL <= 0:
+		return fmt.Errorf("--default-ttl must be positive, got %d", o.DefaultTTL)
+	case o.MinTTL < 0:
+		return fmt.Errorf("--min-ttl must not be negative, got %d", o.MinTTL)
+	case o.MaxTTL < 0:
+		return fmt.Errorf("--max-ttl must not be negative, got %d", o.MaxTTL)
+	case o.MinTTL > 0 && o.MaxTTL > 0 && o.MinTTL > o.MaxTTL:
+		return fmt.Errorf("--min-ttl %d is above --max-ttl %d", o.MinTTL, o.MaxTTL)
+	case o.MinTTL > 0 && o.DefaultTTL < o.MinTTL:
+		return fmt.Errorf("--default-ttl %d is below --min-ttl %d", o.DefaultTTL, o.MinTTL)
+	case o.MaxTTL > 0 && o.DefaultTTL > o.MaxTTL:
+		return fmt.Errorf("--default-ttl %d is above --max-ttl %d", o.DefaultTTL, o.MaxTTL)
+	}
+	return nil
+}
+
+// applyDefaults fills the fields the Issuer config leaves unset from the
+// flags. The precedence is, from lowest to highest: built-in defaults,
+// environment variables, command line flags, the Issuer config. The TTL is
+// then clamped to --min-ttl and --max-ttl.
+func (o *solverOptions) applyDefaults(cfg *sakuraCloudDNSProviderConfig) {
+	ttl := o.DefaultTTL
+	if cfg.TTL != nil {
+		ttl = *cfg.TTL
+	}
+	if o.MinTTL > 0 {
+		ttl = max(ttl, o.MinTTL)
+	}
+	if o.MaxTTL > 0 {
+		ttl = min(ttl, o.MaxTTL)
+	}
+	if cfg.TTL != nil && *cfg.TTL != ttl {
+		logRecordsf("using ttl %d instead of %d of the Issuer config, see --min-ttl and --max-ttl", ttl, *cfg.TTL)
+	}
+	cfg.TTL = &ttl
+	if cfg.PropagationCheckTimeout == nil {
+		cfg.PropagationCheckTimeout = &metav1.Duration{Duration: o.PropagationCheckTimeout}
+	}
+	if cfg.PropagationChecker == "" {
+		cfg.PropagationChecker = o.PropagationChecker
+	}
+	if cfg.TXTConflictPolicy == "" {
+		cfg.TXTConflictPolicy = o.TXTConflictPolicy
+	}
+}
+
+// registryEnabled reports whether the challenge records are tracked in a
+// registry: always with the crd and memory stores, with the configmap store
+// once its ConfigMap is named.
+func (o *solverOptions) registryEnabled() bool {
+	if o.RegistryStore == "" || o.RegistryStore == "configmap" {
+		return o.RegistryConfigMap != ""
+	}
+	return true
+}
+
+// secretNamespaceAllowed reports whether the credentials of the Issuers in
+// ns may be resolved.
+func (o *solverOptions) secretNamespaceAllowed(ns string) bool {
+	return namespaceMatches(o.AllowedSecretNamespaces, ns)
+}
+
+// installationKeyAllowed reports whether the challenges in ns may use the
+// key of the installation.
+func (o *solverOptions) installationKeyAllowed(ns string) bool {
+	return namespaceMatches(o.InstallationKeyNamespaces, ns)
+}
+
+// namespaceMatches reports whether ns matches one of patterns.
+func namespaceMatches(patterns []string, ns string) bool {
+	for _, pattern := range patterns {
+		if ok, err := path.Match(pattern, ns); err == nil && ok {
+			return true
+		}
+	}
+	return false
+}
+
+const serviceAccountNamespaceFile = "/var/run/secrets/kubernetes.io/serviceaccount/namespace"
+
+// namespaceOrOwn returns ns, or the namespace the webhook runs in if ns is
+// empty.
+func namespaceOrOwn(ns string) (string, error) {
+	if ns != "" {
+		return ns, nil
+	}
+	data, err := os.ReadFile(serviceAccountNamespaceFile)
+	if err != nil {
+		return "", fmt.Errorf("a namespace is required outside of a cluster: %w", err)
+	}
+	return strings.TrimSpace(string(data)), nil
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/spf13/pflag"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestSecretNamespaceAllowed(t *testing.T) {
+	opts := newSolverOptions()
+	assert.False(t, opts.secretNamespaceAllowed("anything"), "an empty allow list denies every namespace")
+
+	opts.AllowedSecretNamespaces = []string{"*"}
+	assert.True(t, opts.secretNamespaceAllowed("anything"))
+
+	opts.AllowedSecretNamespaces = []string{"cert-manager", "platform-*"}
+	assert.True(t, opts.secretNamespaceAllowed("cert-manager"))
+	assert.True(t, opts.secretNamespaceAllowed("platform-dns"))
+	assert.False(t, opts.secretNamespaceAllowed("tenant-a"))
+	assert.False(t, opts.secretNamespaceAllowed(""))
+}
+
+func TestApplyEnv(t *testing.T) {
+	t.Setenv("SAKURACLOUD_WEBHOOK_DEFAULT_TTL", "120")
+	t.Setenv("SAKURACLOUD_WEBHOOK_ZONE_BATCH_WINDOW", "2s")
+
+	opts := newSolverOptions()
+	fs := pflag.NewFlagSet("test", pflag.ContinueOnError)
+	opts.AddFlags(fs)
+	require.NoError(t, fs.Parse([]string{"--zone-batch-window=5s"}))
+	require.NoError(t, opts.applyEnv())
+
+	assert.Equal(t, 120, opts.DefaultTTL, "the environment overrides built-in defaults")
+	assert.Equal(t, 5*time.Second, opts.ZoneBatchWindow, "flags override the environment")
+
+	t.Setenv("SAKURACLOUD_WEBHOOK_DEFAULT_TTL", "sixty")
+	opts = newSolverOptions()
+	opts.AddFlags(pflag.NewFlagSet("test", pflag.ContinueOnError))
+	assert.ErrorContains(t, opts.applyEnv(), "SAKURACLOUD_WEBHOOK_DEFAULT_TTL")
+}
+
+func TestApplyDefaults(t *testing.T) {
+	opts := newSolverOptions()
+	opts.DefaultTTL = 120
+	opts.PropagationCheckTimeout = time.Minute
+
+	cfg := sakuraCloudDNSProviderConfig{}
+	opts.applyDefaults(&cfg)
+	assert.Equal(t, 120, *cfg.TTL)
+	assert.Equal(t, time.Minute, cfg.PropagationCheckTimeout.Duration)
+
+	ttl := 30
+	cfg = sakuraCloudDNSProviderConfig{TTL: &ttl, PropagationCheckTimeout: &metav1.Duration{}}
+	opts.applyDefaults(&cfg)
+	assert.Equal(t, 30, *cfg.TTL, "the Issuer config overrides the flags")
+	assert.Zero(t, cfg.PropagationCheckTimeout.Duration, "an Issuer can disable the propagation check")
+}
+
+func TestApplyDefaultsClampsTTL(t *testing.T) {
+	opts := newSolverOptions()
+	opts.MinTTL, opts.MaxTTL = 30, 300
+
+	for issuer, want := range map[int]int{1: 30, 30: 30, 120: 120, 86400: 300} {
+		ttl := issuer
+		cfg := sakuraCloudDNSProviderConfig{TTL: &ttl}
+		opts.applyDefaults(&cfg)
+		assert.Equal(t, want, *cfg.TTL, "ttl %d", issuer)
+		assert.Equal(t, issuer, ttl, "the Issuer config is not changed")
+	}
+
+	opts.DefaultTTL = 10
+	cfg := sakuraCloudDNSProviderConfig{}
+	opts.applyDefaults(&cfg)
+	assert.Equal(t, 30, *cfg.TTL, "the default is clamped as well")
+
+	opts.MinTTL, opts.MaxTTL = 0, 0
+	ttl := 86400
+	cfg = sakuraCloudDNSProviderConfig{TTL: &ttl}
+	opts.applyDefaults(&cfg)
+	assert.Equal(t, 86400, *cfg.TTL, "zero leaves the TTL unbounded")
+}
+
+func TestValidateTTL(t *testing.T) {
+	for name, tc := range map[string]struct {
+		defaultTTL, minTTL, maxTTL int
+		err                        string
+	}{
+		"defaults":        {defaultTTL: 60},
+		"within bounds":   {defaultTTL: 60, minTTL: 30, maxTTL: 300},
+		"at the bounds":   {defaultTTL: 30, minTTL: 30, maxTTL: 30},
+		"zero default":    {defaultTTL: 0, err: "--default-ttl must be positive, got 0"},
+		"negative":        {defaultTTL: -60, err: "--default-ttl must be positive, got -60"},
+		"negative min":    {defaultTTL: 60, minTTL: -1, err: "--min-ttl must not be negative, got -1"},
+		"negative max":    {defaultTTL: 60, maxTTL: -1, err: "--max-ttl must not be negative, got -1"},
+		"min above max":   {defaultTTL: 60, minTTL: 300, maxTTL: 30, err: "--min-ttl 300 is above --max-ttl 30"},
+		"below min":       {defaultTTL: 10, minTTL: 30, err: "--default-ttl 10 is below --min-ttl 30"},
+		"above max":       {defaultTTL: 600, maxTTL: 300, err: "--default-ttl 600 is above --max-ttl 300"},
+		"above unbounded": {defaultTTL: 86400, minTTL: 30},
+	} {
+		t.Run(name, func(t *testing.T) {
+			opts := newSolverOptions()
+			opts.DefaultTTL, opts.MinTTL, opts.MaxTTL = tc.defaultTTL, tc.minTTL, tc.maxTTL
+			err := opts.validateTTL()
+			if tc.err == "" {
+				assert.NoError(t, err)
+				return
+			}
+			assert.EqualError(t, err, tc.err)
+		})
+	}
+}
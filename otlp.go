@@ -0,0 +1,328 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	colmetricspb "go.opentelemetry.io/proto/otlp/collector/metrics/v1"
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+	metricspb "go.opentelemetry.io/proto/otlp/metrics/v1"
+	resourcepb "go.opentelemetry.io/proto/otlp/resource/v1"
+	"google.golang.org/protobuf/proto"
+	"k8s.io/klog/v2"
+)
+
+// otlpClient sends the OTLP/HTTP requests of one signal, e.g. "metrics".
+// It is configured with the standard OTEL_* environment variables, where
+// those of the signal, e.g. OTEL_EXPORTER_OTLP_METRICS_ENDPOINT, take
+// precedence; only the http/protobuf protocol is supported. The metrics and
+// the traces are exported through it, so both go to the same collector with
+// the same resource.
+type otlpClient struct {
+	client   *http.Client
+	endpoint string
+	headers  map[string]string
+	resource *resourcepb.Resource
+}
+
+func newOTLPClient(signal string) (*otlpClient, error) {
+	env := func(name string) string {
+		return envOr("OTEL_EXPORTER_OTLP_"+strings.ToUpper(signal)+"_"+name, os.Getenv("OTEL_EXPORTER_OTLP_"+name))
+	}
+	if p := env("PROTOCOL"); p != "" && p != "http/protobuf" {
+		return nil, fmt.Errorf("OTLP protocol %q is not supported, use http/protobuf", p)
+	}
+
+	endpoint := os.Getenv("OTEL_EXPORTER_OTLP_" + strings.ToUpper(signal) + "_ENDPOINT")
+	if endpoint == "" {
+		endpoint = strings.TrimSuffix(envOr("OTEL_EXPORTER_OTLP_ENDPOINT", "http://localhost:4318"), "/") + "/v1/" + signal
+	}
+
+	headers, err := parseOTELList(env("HEADERS"))
+	if err != nil {
+		return nil, fmt.Errorf("parsing OTLP headers: %w", err)
+	}
+	timeout, err := envMillis(env("TIMEOUT"), 10*time.Second)
+	if err != nil {
+		return nil, fmt.Errorf("parsing OTLP timeout: %w", err)
+	}
+	attrs, err := parseOTELList(os.Getenv("OTEL_RESOURCE_ATTRIBUTES"))
+	if err != nil {
+		return nil, fmt.Errorf("parsing OTEL_RESOURCE_ATTRIBUTES: %w", err)
+	}
+	if name := os.Getenv("OTEL_SERVICE_NAME"); name != "" {
+		attrs["service.name"] = name
+	} else if _, ok := attrs["service.name"]; !ok {
+		attrs["service.name"] = "cert-manager-webhook-sakuracloud"
+	}
+
+	return &otlpClient{
+		client:   &http.Client{Timeout: timeout},
+		endpoint: endpoint,
+		headers:  headers,
+		resource: &resourcepb.Resource{Attributes: stringAttributes(attrs)},
+	}, nil
+}
+
+// post sends req to the endpoint.
+func (c *otlpClient) post(ctx context.Context, req proto.Message) error {
+	body, err := proto.Marshal(req)
+	if err != nil {
+		return err
+	}
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, c.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	httpReq.Header.Set("Content-Type", "application/x-protobuf")
+	for k, v := range c.headers {
+		httpReq.Header.Set(k, v)
+	}
+	resp, err := c.client.Do(httpReq)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("%s responded %s: %s", c.endpoint, resp.Status, msg)
+	}
+	return nil
+}
+
+// otlpExporter periodically pushes the solver metrics over OTLP/HTTP. It
+// gathers the same registry that /metrics serves, so both see identical
+// instrumentation.
+type otlpExporter struct {
+	*otlpClient
+	gatherer prometheus.Gatherer
+	interval time.Duration
+	start    time.Time
+}
+
+func newOTLPExporter(gatherer prometheus.Gatherer) (*otlpExporter, error) {
+	client, err := newOTLPClient("metrics")
+	if err != nil {
+		return nil, err
+	}
+	interval, err := envMillis(os.Getenv("OTEL_METRIC_EXPORT_INTERVAL"), time.Minute)
+	if err != nil {
+		return nil, fmt.Errorf("parsing OTEL_METRIC_EXPORT_INTERVAL: %w", err)
+	}
+	return &otlpExporter{
+		otlpClient: client,
+		gatherer:   gatherer,
+		interval:   interval,
+		start:      time.Now(),
+	}, nil
+}
+
+// run exports every interval until stopCh is closed, and once more on the
+// way out.
+func (e *otlpExporter) run(stopCh <-chan struct{}) {
+	klog.Infof("exporting metrics to %s every %s", e.endpoint, e.interval)
+	ticker := time.NewTicker(e.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stopCh:
+			if err := e.export(context.Background()); err != nil {
+				sampledLog.Errorf("exporting metrics: %v", err)
+			}
+			return
+		case <-ticker.C:
+			if err := e.export(context.Background()); err != nil {
+				sampledLog.Errorf("exporting metrics: %v", err)
+			}
+		}
+	}
+}
+
+func (e *otlpExporter) export(ctx context.Context) error {
+	families, err := e.gatherer.Gather()
+	if err != nil {
+		return err
+	}
+	return e.post(ctx, &colmetricspb.ExportMetricsServiceRequest{
+		ResourceMetrics: []*metricspb.ResourceMetrics{{
+			Resource: e.resource,
+			ScopeMetrics: []*metricspb.ScopeMetrics{{
+				Scope:   &commonpb.InstrumentationScope{Name: "github.com/ophum/cert-manager-webhook-sakuracloud"},
+				Metrics: toOTLPMetrics(families, e.start, time.Now()),
+			}},
+		}},
+	})
+}
+
+// toOTLPMetrics converts gathered Prometheus metrics to OTLP. Counters
+// become monotonic cumulative sums, histograms keep their explicit buckets
+// and untyped metrics are exported as gauges.
+func toOTLPMetrics(families []*dto.MetricFamily, start, now time.Time) []*metricspb.Metric {
+	startNano, nowNano := uint64(start.UnixNano()), uint64(now.UnixNano())
+
+	var metrics []*metricspb.Metric
+	for _, mf := range families {
+		m := &metricspb.Metric{Name: mf.GetName(), Description: mf.GetHelp()}
+		switch mf.GetType() {
+		case dto.MetricType_COUNTER:
+			sum := &metricspb.Sum{
+				AggregationTemporality: metricspb.AggregationTemporality_AGGREGATION_TEMPORALITY_CUMULATIVE,
+				IsMonotonic:            true,
+			}
+			for _, pm := range mf.GetMetric() {
+				sum.DataPoints = append(sum.DataPoints, numberDataPoint(pm, pm.GetCounter().GetValue(), startNano, nowNano))
+			}
+			m.Data = &metricspb.Metric_Sum{Sum: sum}
+		case dto.MetricType_GAUGE, dto.MetricType_UNTYPED:
+			gauge := &metricspb.Gauge{}
+			for _, pm := range mf.GetMetric() {
+				v := pm.GetGauge().GetValue()
+				if mf.GetType() == dto.MetricType_UNTYPED {
+					v = pm.GetUntyped().GetValue()
+				}
+				gauge.DataPoints = append(gauge.DataPoints, numberDataPoint(pm, v, 0, nowNano))
+			}
+			m.Data = &metricspb.Metric_Gauge{Gauge: gauge}
+		case dto.MetricType_HISTOGRAM:
+			hist := &metricspb.Histogram{
+				AggregationTemporality: metricspb.AggregationTemporality_AGGREGATION_TEMPORALITY_CUMULATIVE,
+			}
+			for _, pm := range mf.GetMetric() {
+				hist.DataPoints = append(hist.DataPoints, histogramDataPoint(pm, startNano, nowNano))
+			}
+			m.Data = &metricspb.Metric_Histogram{Histogram: hist}
+		case dto.MetricType_SUMMARY:
+			summary := &metricspb.Summary{}
+			for _, pm := range mf.GetMetric() {
+				dp := &metricspb.SummaryDataPoint{
+					Attributes:        labelAttributes(pm.GetLabel()),
+					StartTimeUnixNano: startNano,
+					TimeUnixNano:      nowNano,
+					Count:             pm.GetSummary().GetSampleCount(),
+					Sum:               pm.GetSummary().GetSampleSum(),
+				}
+				for _, q := range pm.GetSummary().GetQuantile() {
+					dp.QuantileValues = append(dp.QuantileValues, &metricspb.SummaryDataPoint_ValueAtQuantile{
+						Quantile: q.GetQuantile(),
+						Value:    q.GetValue(),
+					})
+				}
+				summary.DataPoints = append(summary.DataPoints, dp)
+			}
+			m.Data = &metricspb.Metric_Summary{Summary: summary}
+		default:
+			continue
+		}
+		metrics = append(metrics, m)
+	}
+	return metrics
+}
+
+func numberDataPoint(pm *dto.Metric, v float64, startNano, nowNano uint64) *metricspb.NumberDataPoint {
+	return &metricspb.NumberDataPoint{
+		Attributes:        labelAttributes(pm.GetLabel()),
+		StartTimeUnixNano: startNano,
+		TimeUnixNano:      nowNano,
+		Value:             &metricspb.NumberDataPoint_AsDouble{AsDouble: v},
+	}
+}
+
+// histogramDataPoint converts the cumulative Prometheus buckets to the
+// per-bucket counts of OTLP, where the last count is the overflow bucket.
+func histogramDataPoint(pm *dto.Metric, startNano, nowNano uint64) *metricspb.HistogramDataPoint {
+	h := pm.GetHistogram()
+	sum := h.GetSampleSum()
+	dp := &metricspb.HistogramDataPoint{
+		Attributes:        labelAttributes(pm.GetLabel()),
+		StartTimeUnixNano: startNano,
+		TimeUnixNano:      nowNano,
+		Count:             h.GetSampleCount(),
+		Sum:               &sum,
+	}
+	var prev uint64
+	for _, b := range h.GetBucket() {
+		if math.IsInf(b.GetUpperBound(), 1) {
+			continue
+		}
+		dp.ExplicitBounds = append(dp.ExplicitBounds, b.GetUpperBound())
+		dp.BucketCounts = append(dp.BucketCounts, b.GetCumulativeCount()-prev)
+		prev = b.GetCumulativeCount()
+	}
+	dp.BucketCounts = append(dp.BucketCounts, h.GetSampleCount()-prev)
+	return dp
+}
+
+func labelAttributes(labels []*dto.LabelPair) []*commonpb.KeyValue {
+	attrs := make([]*commonpb.KeyValue, 0, len(labels))
+	for _, l := range labels {
+		attrs = append(attrs, &commonpb.KeyValue{
+			Key:   l.GetName(),
+			Value: &commonpb.AnyValue{Value: &commonpb.AnyValue_StringValue{StringValue: l.GetValue()}},
+		})
+	}
+	return attrs
+}
+
+func stringAttributes(m map[string]string) []*commonpb.KeyValue {
+	attrs := make([]*commonpb.KeyValue, 0, len(m))
+	for k, v := range m {
+		attrs = append(attrs, &commonpb.KeyValue{
+			Key:   k,
+			Value: &commonpb.AnyValue{Value: &commonpb.AnyValue_StringValue{StringValue: v}},
+		})
+	}
+	return attrs
+}
+
+// parseOTELList parses the key1=value1,key2=value2 format used by
+// OTEL_EXPORTER_OTLP_HEADERS and OTEL_RESOURCE_ATTRIBUTES. Values are URL
+// decoded.
+func parseOTELList(s string) (map[string]string, error) {
+	m := map[string]string{}
+	for _, kv := range strings.Split(s, ",") {
+		if strings.TrimSpace(kv) == "" {
+			continue
+		}
+		k, v, ok := strings.Cut(kv, "=")
+		if !ok {
+			return nil, fmt.Errorf("missing '=' in %q", kv)
+		}
+		v, err := url.PathUnescape(strings.TrimSpace(v))
+		if err != nil {
+			return nil, err
+		}
+		m[strings.TrimSpace(k)] = v
+	}
+	return m, nil
+}
+
+func envOr(key, fallback string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return fallback
+}
+
+// envMillis parses a duration given in milliseconds, as the OTEL_*
+// environment variables do.
+func envMillis(s string, fallback time.Duration) (time.Duration, error) {
+	if s == "" {
+		return fallback, nil
+	}
+	ms, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return 0, err
+	}
+	return time.Duration(ms) * time.Millisecond, nil
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	colmetricspb "go.opentelemetry.io/proto/otlp/collector/metrics/v1"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestOTLPExporter(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	counter := prometheus.NewCounterVec(prometheus.CounterOpts{Name: "requests_total", Help: "h"}, []string{"code"})
+	hist := prometheus.NewHistogram(prometheus.HistogramOpts{Name: "size_bytes", Help: "h", Buckets: []float64{1, 10}})
+	reg.MustRegister(counter, hist)
+	counter.WithLabelValues("200").Add(3)
+	for _, v := range []float64{0.5, 5, 50} {
+		hist.Observe(v)
+	}
+
+	var got colmetricspb.ExportMetricsServiceRequest
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/v1/metrics", r.URL.Path)
+		assert.Equal(t, "secret", r.Header.Get("Authorization"))
+		body, err := io.ReadAll(r.Body)
+		require.NoError(t, err)
+		require.NoError(t, proto.Unmarshal(body, &got))
+	}))
+	defer srv.Close()
+
+	t.Setenv("OTEL_EXPORTER_OTLP_ENDPOINT", srv.URL)
+	t.Setenv("OTEL_EXPORTER_OTLP_HEADERS", "Authorization=secret")
+	t.Setenv("OTEL_RESOURCE_ATTRIBUTES", "k8s.cluster.name=test")
+	e, err := newOTLPExporter(reg)
+	require.NoError(t, err)
+	require.NoError(t, e.export(context.Background()))
+
+	require.Len(t, got.ResourceMetrics, 1)
+	assert.Len(t, got.ResourceMetrics[0].Resource.Attributes, 2)
+	metrics := got.ResourceMetrics[0].ScopeMetrics[0].Metrics
+	require.Len(t, metrics, 2)
+
+	sum := metrics[0].GetSum()
+	require.NotNil(t, sum)
+	assert.True(t, sum.IsMonotonic)
+	assert.Equal(t, 3.0, sum.DataPoints[0].GetAsDouble())
+	assert.Equal(t, "code", sum.DataPoints[0].Attributes[0].Key)
+
+	dp := metrics[1].GetHistogram().DataPoints[0]
+	assert.Equal(t, []float64{1, 10}, dp.ExplicitBounds)
+	assert.Equal(t, []uint64{1, 1, 1}, dp.BucketCounts)
+	assert.Equal(t, uint64(3), dp.Count)
+}
+
+func TestOTLPExporterRejectsGRPC(t *testing.T) {
+	t.Setenv("OTEL_EXPORTER_OTLP_PROTOCOL", "grpc")
+	_, err := newOTLPExporter(prometheus.NewRegistry())
+	assert.Error(t, err)
+}
@@ -0,0 +1,54 @@
+// Package dnsname holds the name arithmetic of the webhook: matching the
+// zones cert-manager resolves against Sakura Cloud DNS zones and computing
+// record names relative to them. The functions are pure, so tools that
+// validate or generate Issuer configs can reuse them.
+//
+// Names are compared as is. cert-manager passes lowercase names, and Sakura
+// Cloud DNS zone names are lowercase.
+package dnsname
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Apex is the relative name of the zone apex in Sakura Cloud DNS records.
+const Apex = "@"
+
+// NormalizeZone returns zone as a fully qualified name, with a final dot.
+// Sakura Cloud returns zone names without one, while cert-manager passes
+// them with it. The empty name is the root zone.
+func NormalizeZone(zone string) string {
+	if !strings.HasSuffix(zone, ".") {
+		zone += "."
+	}
+	return zone
+}
+
+// MatchZone reports whether resolvedZone, the zone cert-manager found for a
+// challenge, is zone or lies below it. Both may be given with or without
+// the final dot. Names only match at label boundaries, so example.com does
+// not match badexample.com.
+func MatchZone(resolvedZone, zone string) bool {
+	resolvedZone, zone = NormalizeZone(resolvedZone), NormalizeZone(zone)
+	return zone == "." || resolvedZone == zone || strings.HasSuffix(resolvedZone, "."+zone)
+}
+
+// RelativeName returns fqdn relative to zone, the record name Sakura Cloud
+// DNS expects: "_acme-challenge.www" for _acme-challenge.www.example.com.
+// in example.com, and Apex for the zone itself. It fails if fqdn is not in
+// zone.
+func RelativeName(fqdn, zone string) (string, error) {
+	fqdn, zone = NormalizeZone(fqdn), NormalizeZone(zone)
+	if fqdn == zone {
+		return Apex, nil
+	}
+	if zone == "." {
+		return strings.TrimSuffix(fqdn, "."), nil
+	}
+	name, ok := strings.CutSuffix(fqdn, "."+zone)
+	if !ok || name == "" {
+		return "", fmt.Errorf("%s is not in zone %s", fqdn, zone)
+	}
+	return name, nil
+}
@@ -0,0 +1,76 @@
+package dnsname
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNormalizeZone(t *testing.T) {
+	for in, want := range map[string]string{
+		"example.com":      "example.com.",
+		"example.com.":     "example.com.",
+		"sub.example.com":  "sub.example.com.",
+		"":                 ".",
+		".":                ".",
+		"Example.COM":      "Example.COM.",
+		"_acme-challenge.": "_acme-challenge.",
+	} {
+		assert.Equal(t, want, NormalizeZone(in), in)
+	}
+}
+
+func TestMatchZone(t *testing.T) {
+	tests := []struct {
+		resolvedZone string
+		zone         string
+		want         bool
+	}{
+		{"example.com.", "example.com", true},
+		{"example.com.", "example.com.", true},
+		{"example.com", "example.com", true},
+		{"sub.example.com.", "example.com", true},
+		{"a.b.example.com.", "example.com", true},
+		{"example.com.", ".", true},
+		{"example.com.", "", true},
+		{"badexample.com.", "example.com", false},
+		{"example.com.", "sub.example.com", false},
+		{"example.org.", "example.com", false},
+		{"com.", "example.com", false},
+		{".", "example.com", false},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, MatchZone(tt.resolvedZone, tt.zone), "MatchZone(%q, %q)", tt.resolvedZone, tt.zone)
+	}
+}
+
+func TestRelativeName(t *testing.T) {
+	tests := []struct {
+		fqdn    string
+		zone    string
+		want    string
+		wantErr bool
+	}{
+		{fqdn: "_acme-challenge.example.com.", zone: "example.com", want: "_acme-challenge"},
+		{fqdn: "_acme-challenge.example.com.", zone: "example.com.", want: "_acme-challenge"},
+		{fqdn: "_acme-challenge.example.com", zone: "example.com", want: "_acme-challenge"},
+		{fqdn: "_acme-challenge.www.example.com.", zone: "example.com", want: "_acme-challenge.www"},
+		{fqdn: "_acme-challenge.www.sub.example.com.", zone: "sub.example.com", want: "_acme-challenge.www"},
+		{fqdn: "example.com.", zone: "example.com", want: Apex},
+		{fqdn: "_acme-challenge.example.com.", zone: ".", want: "_acme-challenge.example.com"},
+		{fqdn: "_acme-challenge.badexample.com.", zone: "example.com", wantErr: true},
+		{fqdn: "_acme-challenge.example.org.", zone: "example.com", wantErr: true},
+		{fqdn: "com.", zone: "example.com", wantErr: true},
+		{fqdn: ".example.com.", zone: "example.com", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := RelativeName(tt.fqdn, tt.zone)
+		if tt.wantErr {
+			assert.Error(t, err, "RelativeName(%q, %q)", tt.fqdn, tt.zone)
+			continue
+		}
+		require.NoError(t, err, "RelativeName(%q, %q)", tt.fqdn, tt.zone)
+		assert.Equal(t, tt.want, got, "RelativeName(%q, %q)", tt.fqdn, tt.zone)
+	}
+}
@@ -0,0 +1,233 @@
+// Package fake provides a solver that keeps challenge records in the
+// in-memory Sakura Cloud DNS API of package testing instead of real zones,
+// for integration tests of binaries that embed the webhook. It presents and
+// cleans up records the way the real solver does: records are named
+// relative to the longest matching zone, Present is idempotent and CleanUp
+// only deletes the record of its key.
+//
+// The solver can serve its zones over DNS, so that the cert-manager DNS01
+// conformance tests can check the presented records:
+//
+//	solver := fake.New("127.0.0.1:59351", "example.com")
+//	fixture := acmetest.NewFixture(solver,
+//		acmetest.SetResolvedZone("example.com."),
+//		acmetest.SetDNSServer("127.0.0.1:59351"),
+//		acmetest.SetUseAuthoritative(false),
+//	)
+package fake
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/cert-manager/cert-manager/pkg/acme/webhook"
+	"github.com/cert-manager/cert-manager/pkg/acme/webhook/apis/acme/v1alpha1"
+	"github.com/cert-manager/webhook-example/pkg/dnsname"
+	sctesting "github.com/cert-manager/webhook-example/pkg/testing"
+	mdns "github.com/miekg/dns"
+	"github.com/sacloud/iaas-api-go"
+	"github.com/sacloud/iaas-api-go/types"
+	restclient "k8s.io/client-go/rest"
+)
+
+// SolverName is the name of the Sakura Cloud solver, which the fake solver
+// uses as well so that Issuers need no changes in tests.
+const SolverName = "sakuracloud-dns-solver"
+
+// recordTTL is the TTL of the challenge records, the default of the solver.
+const recordTTL = 60
+
+// Zones is an in-memory store of DNS zones and their TXT records. The zones
+// are held by a testing.ZoneClient, the in-memory Sakura Cloud DNS API the
+// unit tests of the solver use, so that tests can inspect them or script
+// failures of their updates through Client. It is safe for concurrent use.
+type Zones struct {
+	mu     sync.Mutex
+	client *sctesting.ZoneClient
+	// ids maps the names of the zones, with the final dot, to their IDs in
+	// client.
+	ids map[string]types.ID
+}
+
+// NewZones returns a store holding the empty zones names.
+func NewZones(names ...string) *Zones {
+	z := &Zones{client: sctesting.NewZoneClient(), ids: map[string]types.ID{}}
+	for _, name := range names {
+		z.Add(name)
+	}
+	return z
+}
+
+// Add adds the empty zone name, unless the store holds it already. The
+// zones get the IDs 1, 2, ... in the order they are added.
+func (z *Zones) Add(name string) {
+	z.mu.Lock()
+	defer z.mu.Unlock()
+	name = dnsname.NormalizeZone(name)
+	if _, ok := z.ids[name]; !ok {
+		id := types.ID(len(z.ids) + 1)
+		z.ids[name] = id
+		z.client.Put(&iaas.DNS{ID: id, Name: strings.TrimSuffix(name, ".")})
+	}
+}
+
+// Client returns the in-memory API holding the zones.
+func (z *Zones) Client() *sctesting.ZoneClient {
+	return z.client
+}
+
+// TXT returns the values of the TXT records of fqdn.
+func (z *Zones) TXT(fqdn string) []string {
+	z.mu.Lock()
+	defer z.mu.Unlock()
+	zone, ok := z.find(fqdn)
+	if !ok {
+		return nil
+	}
+	entry, err := dnsname.RelativeName(fqdn, zone)
+	if err != nil {
+		return nil
+	}
+	var values []string
+	for _, r := range z.client.Zone(z.ids[zone]).Records {
+		if r.Type == types.DNSRecordTypes.TXT && r.Name == entry {
+			values = append(values, r.RData)
+		}
+	}
+	return values
+}
+
+// find returns the longest zone holding fqdn. z.mu must be held.
+func (z *Zones) find(fqdn string) (string, bool) {
+	found := ""
+	for zone := range z.ids {
+		if dnsname.MatchZone(fqdn, zone) && len(zone) > len(found) {
+			found = zone
+		}
+	}
+	return found, found != ""
+}
+
+// edit reads ch's zone and updates it with the records apply returns for
+// its records and the name of the challenge record relative to it, unless
+// apply reports no change.
+func (z *Zones) edit(ch *v1alpha1.ChallengeRequest, apply func(records iaas.DNSRecords, entry string) (iaas.DNSRecords, bool)) error {
+	z.mu.Lock()
+	defer z.mu.Unlock()
+	zone, ok := z.find(ch.ResolvedZone)
+	if !ok {
+		return fmt.Errorf("no zone holds %s", ch.ResolvedZone)
+	}
+	entry, err := dnsname.RelativeName(ch.ResolvedFQDN, zone)
+	if err != nil {
+		return fmt.Errorf("invalid fqdn, resolvedFQDN: %s, zoneName: %s", ch.ResolvedFQDN, zone)
+	}
+	ctx := context.Background()
+	current, err := z.client.Read(ctx, z.ids[zone])
+	if err != nil {
+		return err
+	}
+	records, changed := apply(current.Records, entry)
+	if !changed {
+		return nil
+	}
+	_, err = z.client.UpdateSettings(ctx, current.ID, &iaas.DNSUpdateSettingsRequest{Records: records, SettingsHash: current.SettingsHash})
+	return err
+}
+
+// Solver implements the webhook.Solver interface of cert-manager against
+// Zones. The config of challenges is ignored.
+type Solver struct {
+	// Zones holds the records presented by the solver.
+	Zones *Zones
+
+	addr string
+}
+
+var _ webhook.Solver = (*Solver)(nil)
+
+// New returns a solver for the zones names. If addr is not empty, the
+// solver serves the TXT records of its zones over DNS on that UDP address
+// once initialized.
+func New(addr string, names ...string) *Solver {
+	return &Solver{Zones: NewZones(names...), addr: addr}
+}
+
+// Name returns SolverName.
+func (s *Solver) Name() string {
+	return SolverName
+}
+
+// Present adds the TXT record of ch, unless it exists already.
+func (s *Solver) Present(ch *v1alpha1.ChallengeRequest) error {
+	return s.Zones.edit(ch, func(records iaas.DNSRecords, entry string) (iaas.DNSRecords, bool) {
+		if slices.ContainsFunc(records, func(r *iaas.DNSRecord) bool { return isRecordOf(r, entry, ch.Key) }) {
+			return records, false
+		}
+		return append(records, &iaas.DNSRecord{Name: entry, Type: types.DNSRecordTypes.TXT, RData: ch.Key, TTL: recordTTL}), true
+	})
+}
+
+// CleanUp deletes the TXT record of ch. Records of other keys with the same
+// name are kept.
+func (s *Solver) CleanUp(ch *v1alpha1.ChallengeRequest) error {
+	return s.Zones.edit(ch, func(records iaas.DNSRecords, entry string) (iaas.DNSRecords, bool) {
+		n := len(records)
+		records = slices.DeleteFunc(records, func(r *iaas.DNSRecord) bool { return isRecordOf(r, entry, ch.Key) })
+		return records, len(records) != n
+	})
+}
+
+// isRecordOf reports whether r is the TXT record entry of key.
+func isRecordOf(r *iaas.DNSRecord, entry, key string) bool {
+	return r.Type == types.DNSRecordTypes.TXT && r.Name == entry && r.RData == key
+}
+
+// Initialize starts the DNS server, if any, until stopCh is closed.
+func (s *Solver) Initialize(_ *restclient.Config, stopCh <-chan struct{}) error {
+	if s.addr == "" {
+		return nil
+	}
+	pc, err := net.ListenPacket("udp", s.addr)
+	if err != nil {
+		return err
+	}
+	server := &mdns.Server{PacketConn: pc, Handler: mdns.HandlerFunc(s.serveDNS)}
+	go func() { _ = server.ActivateAndServe() }()
+	go func() {
+		<-stopCh
+		_ = server.Shutdown()
+	}()
+	return nil
+}
+
+// serveDNS answers TXT queries for the names in the zones of s. Queries for
+// names outside of them are refused.
+func (s *Solver) serveDNS(w mdns.ResponseWriter, req *mdns.Msg) {
+	m := new(mdns.Msg)
+	m.SetReply(req)
+	m.Authoritative = true
+	for _, q := range req.Question {
+		s.Zones.mu.Lock()
+		_, ok := s.Zones.find(q.Name)
+		s.Zones.mu.Unlock()
+		if !ok {
+			m.SetRcode(req, mdns.RcodeRefused)
+			break
+		}
+		if q.Qtype != mdns.TypeTXT {
+			continue
+		}
+		for _, v := range s.Zones.TXT(q.Name) {
+			m.Answer = append(m.Answer, &mdns.TXT{
+				Hdr: mdns.RR_Header{Name: q.Name, Rrtype: mdns.TypeTXT, Class: mdns.ClassINET, Ttl: 1},
+				Txt: []string{v},
+			})
+		}
+	}
+	_ = w.WriteMsg(m)
+}
@@ -0,0 +1,81 @@
+package fake
+
+import (
+	"net"
+	"testing"
+
+	"github.com/cert-manager/cert-manager/pkg/acme/webhook/apis/acme/v1alpha1"
+	mdns "github.com/miekg/dns"
+	"github.com/sacloud/iaas-api-go"
+	"github.com/sacloud/iaas-api-go/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func challenge(fqdn, zone, key string) *v1alpha1.ChallengeRequest {
+	return &v1alpha1.ChallengeRequest{ResolvedFQDN: fqdn, ResolvedZone: zone, Key: key}
+}
+
+func TestSolver(t *testing.T) {
+	s := New("", "example.com", "sub.example.com")
+	first := challenge("_acme-challenge.example.com.", "example.com.", "first")
+	second := challenge("_acme-challenge.example.com.", "example.com.", "second")
+	sub := challenge("_acme-challenge.www.sub.example.com.", "sub.example.com.", "sub")
+
+	require.NoError(t, s.Present(first))
+	require.NoError(t, s.Present(first), "Present is idempotent")
+	require.NoError(t, s.Present(second))
+	require.NoError(t, s.Present(sub))
+	assert.Equal(t, []string{"first", "second"}, s.Zones.TXT("_acme-challenge.example.com."))
+	assert.Equal(t, []string{"sub"}, s.Zones.TXT("_acme-challenge.www.sub.example.com."))
+	assert.Equal(t, iaas.DNSRecords{{Name: "_acme-challenge.www", Type: types.DNSRecordTypes.TXT, RData: "sub", TTL: 60}},
+		s.Zones.Client().Zone(s.Zones.ids["sub.example.com."]).Records, "records are kept in the longest matching zone")
+
+	require.NoError(t, s.CleanUp(first))
+	assert.Equal(t, []string{"second"}, s.Zones.TXT("_acme-challenge.example.com."), "other keys are kept")
+	require.NoError(t, s.CleanUp(second))
+	assert.Empty(t, s.Zones.TXT("_acme-challenge.example.com."))
+	assert.Empty(t, s.Zones.Client().Zone(s.Zones.ids["example.com."]).Records)
+
+	// Present only writes the zone when the record is missing, and fails
+	// with the errors of the API.
+	updates := s.Zones.Client().Updates(1)
+	s.Zones.Client().Conflict(1, 1)
+	assert.ErrorContains(t, s.Present(first), "423")
+	require.NoError(t, s.Present(first))
+	require.NoError(t, s.Present(first))
+	assert.Equal(t, updates+2, s.Zones.Client().Updates(1))
+
+	assert.ErrorContains(t, s.Present(challenge("_acme-challenge.example.org.", "example.org.", "key")), "no zone holds example.org.")
+	assert.ErrorContains(t, s.Present(challenge("_acme-challenge.example.org.", "example.com.", "key")), "invalid fqdn")
+}
+
+func TestSolverServesDNS(t *testing.T) {
+	s := New("", "example.com")
+	require.NoError(t, s.Present(challenge("_acme-challenge.example.com.", "example.com.", "key")))
+
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	server := &mdns.Server{PacketConn: pc, Handler: mdns.HandlerFunc(s.serveDNS)}
+	go func() { _ = server.ActivateAndServe() }()
+	defer server.Shutdown()
+
+	query := func(name string, qtype uint16) *mdns.Msg {
+		m := new(mdns.Msg)
+		m.SetQuestion(name, qtype)
+		r, err := mdns.Exchange(m, pc.LocalAddr().String())
+		require.NoError(t, err)
+		return r
+	}
+
+	r := query("_acme-challenge.example.com.", mdns.TypeTXT)
+	assert.Equal(t, mdns.RcodeSuccess, r.Rcode)
+	require.Len(t, r.Answer, 1)
+	assert.Equal(t, []string{"key"}, r.Answer[0].(*mdns.TXT).Txt)
+
+	r = query("_acme-challenge.example.com.", mdns.TypeCNAME)
+	assert.Equal(t, mdns.RcodeSuccess, r.Rcode)
+	assert.Empty(t, r.Answer)
+
+	assert.Equal(t, mdns.RcodeRefused, query("_acme-challenge.example.org.", mdns.TypeTXT).Rcode)
+}
@@ -0,0 +1,236 @@
+// Package legoprovider adapts the webhook to the challenge.Provider
+// interface of go-acme/lego, so that lego and tools built on it solve DNS01
+// challenges with the same record handling as cert-manager does:
+//
+//	conn, err := grpc.Dial("webhook.example.com:9443", grpc.WithTransportCredentials(creds))
+//	...
+//	provider := legoprovider.New(legoprovider.NewGRPCSolver(conn), "acme-clients", []byte(`{
+//		"zoneID": 111111111111,
+//		"accessTokenRef": {"name": "sakuracloud", "key": "accessToken"},
+//		"accessTokenSecretRef": {"name": "sakuracloud", "key": "accessTokenSecret"}
+//	}`))
+//	err = client.Challenge.SetDNS01Provider(provider)
+//
+// The provider satisfies the interface by its method set and does not
+// depend on lego. Like lego, it resolves the zone of the challenge record
+// by looking up the SOA of the record name and of its parents, with the
+// nameservers of /etc/resolv.conf unless told others by SetNameservers.
+package legoprovider
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/cert-manager/cert-manager/pkg/acme/webhook/apis/acme/v1alpha1"
+	"github.com/miekg/dns"
+	"google.golang.org/grpc"
+	"google.golang.org/protobuf/types/known/emptypb"
+	"google.golang.org/protobuf/types/known/structpb"
+	extapi "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+)
+
+// Solver presents and cleans up challenge records, like the webhook.Solver
+// of cert-manager.
+type Solver interface {
+	Present(ch *v1alpha1.ChallengeRequest) error
+	CleanUp(ch *v1alpha1.ChallengeRequest) error
+}
+
+// Provider implements the challenge.Provider interface of lego on top of a
+// Solver.
+type Provider struct {
+	solver    Solver
+	namespace string
+	config    []byte
+	// nameservers resolve the zones of the challenges, host:port each. The
+	// ones of /etc/resolv.conf are used if empty.
+	nameservers []string
+}
+
+// New returns a provider passing challenges to solver with config, the
+// Issuer config of the webhook in JSON. Secrets referenced by config are
+// read from namespace.
+func New(solver Solver, namespace string, config []byte) *Provider {
+	return &Provider{solver: solver, namespace: namespace, config: config}
+}
+
+// SetNameservers makes the provider resolve the zones of the challenges
+// with servers, host:port each, instead of the nameservers of
+// /etc/resolv.conf.
+func (p *Provider) SetNameservers(servers ...string) {
+	p.nameservers = servers
+}
+
+// Present presents the record of the challenge for domain.
+func (p *Provider) Present(domain, _, keyAuth string) error {
+	ch, err := p.challengeRequest(v1alpha1.ChallengeActionPresent, domain, keyAuth)
+	if err != nil {
+		return err
+	}
+	return p.solver.Present(ch)
+}
+
+// CleanUp deletes the record of the challenge for domain. Records of other
+// challenges for the same name are kept.
+func (p *Provider) CleanUp(domain, _, keyAuth string) error {
+	ch, err := p.challengeRequest(v1alpha1.ChallengeActionCleanUp, domain, keyAuth)
+	if err != nil {
+		return err
+	}
+	return p.solver.CleanUp(ch)
+}
+
+// challengeRequest returns the request cert-manager would send for the
+// challenge of domain. lego does not tell the zone, so it is resolved from
+// the SOA records like cert-manager does.
+func (p *Provider) challengeRequest(action v1alpha1.ChallengeAction, domain, keyAuth string) (*v1alpha1.ChallengeRequest, error) {
+	domain = strings.TrimPrefix(domain, "*.")
+	domain = strings.TrimSuffix(domain, ".") + "."
+	fqdn := "_acme-challenge." + domain
+	nameservers := p.nameservers
+	if len(nameservers) == 0 {
+		var err error
+		if nameservers, err = systemNameservers(); err != nil {
+			return nil, err
+		}
+	}
+	zone, err := FindZone(fqdn, nameservers)
+	if err != nil {
+		return nil, err
+	}
+	return &v1alpha1.ChallengeRequest{
+		Action:            action,
+		Type:              "dns-01",
+		DNSName:           strings.TrimSuffix(domain, "."),
+		Key:               Value(keyAuth),
+		ResourceNamespace: p.namespace,
+		ResolvedFQDN:      fqdn,
+		ResolvedZone:      zone,
+		Config:            &extapi.JSON{Raw: p.config},
+	}, nil
+}
+
+// FindZone returns the zone of fqdn, the closest name at or above it with an
+// SOA record, as dns01.FindZoneByFqdn of lego does. The SOA queries are
+// sent to nameservers, host:port each, in turn until one answers.
+func FindZone(fqdn string, nameservers []string) (string, error) {
+	fqdn = dns.Fqdn(fqdn)
+	for i, end := 0, false; !end; i, end = dns.NextLabel(fqdn, i) {
+		name := fqdn[i:]
+		m, err := querySOA(name, nameservers)
+		if err != nil {
+			return "", fmt.Errorf("finding the zone of %s: %w", fqdn, err)
+		}
+		switch m.Rcode {
+		case dns.RcodeSuccess, dns.RcodeNameError:
+		default:
+			return "", fmt.Errorf("finding the zone of %s: the SOA query of %s failed: %s", fqdn, name, dns.RcodeToString[m.Rcode])
+		}
+		for _, rr := range m.Answer {
+			// An SOA behind a CNAME is the one of the target, not of name.
+			if soa, ok := rr.(*dns.SOA); ok && strings.EqualFold(soa.Hdr.Name, name) {
+				return strings.ToLower(soa.Hdr.Name), nil
+			}
+		}
+	}
+	return "", fmt.Errorf("finding the zone of %s: no SOA record found", fqdn)
+}
+
+// querySOA queries the SOA of name, recursively, from the first of
+// nameservers that answers.
+func querySOA(name string, nameservers []string) (*dns.Msg, error) {
+	if len(nameservers) == 0 {
+		return nil, errors.New("no nameservers")
+	}
+	q := new(dns.Msg)
+	q.SetQuestion(name, dns.TypeSOA)
+	q.SetEdns0(4096, false)
+	client := &dns.Client{Timeout: 10 * time.Second}
+	var errs []error
+	for _, ns := range nameservers {
+		m, _, err := client.Exchange(q, ns)
+		if err == nil && m.Truncated {
+			m, _, err = (&dns.Client{Net: "tcp", Timeout: client.Timeout}).Exchange(q, ns)
+		}
+		if err == nil {
+			return m, nil
+		}
+		errs = append(errs, fmt.Errorf("%s: %w", ns, err))
+	}
+	return nil, errors.Join(errs...)
+}
+
+// systemNameservers returns the nameservers of /etc/resolv.conf.
+func systemNameservers() ([]string, error) {
+	conf, err := dns.ClientConfigFromFile("/etc/resolv.conf")
+	if err != nil {
+		return nil, fmt.Errorf("reading /etc/resolv.conf: %w", err)
+	}
+	servers := make([]string, 0, len(conf.Servers))
+	for _, s := range conf.Servers {
+		servers = append(servers, net.JoinHostPort(s, conf.Port))
+	}
+	return servers, nil
+}
+
+// Value returns the value of the TXT record for keyAuth, the unpadded
+// base64url SHA-256 digest that lego computes as well.
+func Value(keyAuth string) string {
+	sum := sha256.Sum256([]byte(keyAuth))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// DefaultTimeout bounds the calls of a GRPCSolver unless SetTimeout changes
+// it. Present waits for the record to propagate if the webhook checks the
+// propagation, so the timeout has to be longer than the
+// --propagation-check-timeout of the webhook.
+const DefaultTimeout = 2 * time.Minute
+
+// GRPCSolver is a Solver calling the gRPC solver service of the webhook,
+// see api/solver.proto and --grpc-bind-address.
+type GRPCSolver struct {
+	conn    grpc.ClientConnInterface
+	timeout time.Duration
+}
+
+// NewGRPCSolver returns a Solver calling the service on conn.
+func NewGRPCSolver(conn grpc.ClientConnInterface) *GRPCSolver {
+	return &GRPCSolver{conn: conn, timeout: DefaultTimeout}
+}
+
+// SetTimeout makes the calls of the solver fail with DeadlineExceeded after
+// d.
+func (s *GRPCSolver) SetTimeout(d time.Duration) {
+	s.timeout = d
+}
+
+// Present calls the Present method of the service.
+func (s *GRPCSolver) Present(ch *v1alpha1.ChallengeRequest) error {
+	return s.invoke("Present", ch)
+}
+
+// CleanUp calls the CleanUp method of the service.
+func (s *GRPCSolver) CleanUp(ch *v1alpha1.ChallengeRequest) error {
+	return s.invoke("CleanUp", ch)
+}
+
+func (s *GRPCSolver) invoke(method string, ch *v1alpha1.ChallengeRequest) error {
+	data, err := json.Marshal(ch)
+	if err != nil {
+		return fmt.Errorf("encoding the challenge request: %w", err)
+	}
+	req := new(structpb.Struct)
+	if err := req.UnmarshalJSON(data); err != nil {
+		return fmt.Errorf("encoding the challenge request: %w", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), s.timeout)
+	defer cancel()
+	return s.conn.Invoke(ctx, "/sakuracloud.webhook.v1.Solver/"+method, req, new(emptypb.Empty))
+}
@@ -0,0 +1,134 @@
+package legoprovider
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/cert-manager/cert-manager/pkg/acme/webhook/apis/acme/v1alpha1"
+	"github.com/cert-manager/webhook-example/pkg/fake"
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// serveZones serves the SOA records of zones, which are delegated by
+// nothing: every other name is NXDOMAIN. It returns the address of the
+// server.
+func serveZones(t *testing.T, zones ...string) string {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	server := &dns.Server{PacketConn: pc, Handler: dns.HandlerFunc(func(w dns.ResponseWriter, q *dns.Msg) {
+		m := new(dns.Msg)
+		m.SetReply(q)
+		m.Rcode = dns.RcodeNameError
+		for _, zone := range zones {
+			if q.Question[0].Name == zone {
+				m.Rcode = dns.RcodeSuccess
+				m.Answer = append(m.Answer, &dns.SOA{
+					Hdr:  dns.RR_Header{Name: zone, Rrtype: dns.TypeSOA, Class: dns.ClassINET, Ttl: 60},
+					Ns:   "ns1." + zone,
+					Mbox: "hostmaster." + zone,
+				})
+			}
+		}
+		_ = w.WriteMsg(m)
+	})}
+	go func() { _ = server.ActivateAndServe() }()
+	t.Cleanup(func() { _ = server.Shutdown() })
+	return pc.LocalAddr().String()
+}
+
+func TestProvider(t *testing.T) {
+	solver := fake.New("", "example.com")
+	p := New(solver, "acme-clients", []byte(`{"zoneID": 1}`))
+	p.SetNameservers(serveZones(t, "example.com.", "example.org."))
+
+	require.NoError(t, p.Present("www.example.com", "token", "first"))
+	require.NoError(t, p.Present("*.www.example.com", "token", "second"))
+	assert.Equal(t, []string{Value("first"), Value("second")}, solver.Zones.TXT("_acme-challenge.www.example.com."),
+		"wildcards share the record name of their domain")
+
+	require.NoError(t, p.CleanUp("www.example.com", "token", "first"))
+	assert.Equal(t, []string{Value("second")}, solver.Zones.TXT("_acme-challenge.www.example.com."))
+
+	assert.Error(t, p.Present("www.example.org", "token", "first"))
+}
+
+func TestChallengeRequest(t *testing.T) {
+	p := New(nil, "acme-clients", []byte(`{"zoneID": 1}`))
+	p.SetNameservers(serveZones(t, "example.com.", "sub.example.com."))
+
+	ch, err := p.challengeRequest("Present", "example.com", "keyAuth")
+	require.NoError(t, err)
+	assert.Equal(t, "_acme-challenge.example.com.", ch.ResolvedFQDN)
+	assert.Equal(t, "example.com.", ch.ResolvedZone)
+	assert.Equal(t, "acme-clients", ch.ResourceNamespace)
+	assert.JSONEq(t, `{"zoneID": 1}`, string(ch.Config.Raw))
+
+	ch, err = p.challengeRequest("Present", "*.www.example.com", "keyAuth")
+	require.NoError(t, err)
+	assert.Equal(t, "_acme-challenge.www.example.com.", ch.ResolvedFQDN)
+	assert.Equal(t, "example.com.", ch.ResolvedZone)
+
+	ch, err = p.challengeRequest("Present", "www.sub.example.com", "keyAuth")
+	require.NoError(t, err)
+	assert.Equal(t, "sub.example.com.", ch.ResolvedZone, "the closest zone")
+
+	_, err = p.challengeRequest("Present", "www.example.net", "keyAuth")
+	assert.ErrorContains(t, err, "finding the zone of _acme-challenge.www.example.net.: no SOA record found")
+}
+
+func TestFindZone(t *testing.T) {
+	_, err := FindZone("www.example.com", nil)
+	assert.ErrorContains(t, err, "no nameservers")
+
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	server := &dns.Server{PacketConn: pc, Handler: dns.HandlerFunc(func(w dns.ResponseWriter, q *dns.Msg) {
+		m := new(dns.Msg)
+		m.SetReply(q)
+		m.Rcode = dns.RcodeServerFailure
+		_ = w.WriteMsg(m)
+	})}
+	go func() { _ = server.ActivateAndServe() }()
+	t.Cleanup(func() { _ = server.Shutdown() })
+	_, err = FindZone("www.example.com", []string{pc.LocalAddr().String()})
+	assert.ErrorContains(t, err, "the SOA query of www.example.com. failed: SERVFAIL")
+
+	closed, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	require.NoError(t, closed.Close())
+	zone, err := FindZone("www.example.com", []string{closed.LocalAddr().String(), serveZones(t, "example.com.")})
+	require.NoError(t, err, "the next nameserver answers")
+	assert.Equal(t, "example.com.", zone)
+}
+
+// blockingConn is a grpc.ClientConnInterface whose calls last until their
+// context is done.
+type blockingConn struct {
+	grpc.ClientConnInterface
+}
+
+func (blockingConn) Invoke(ctx context.Context, _ string, _, _ any, _ ...grpc.CallOption) error {
+	<-ctx.Done()
+	return status.FromContextError(ctx.Err()).Err()
+}
+
+func TestGRPCSolverTimeout(t *testing.T) {
+	s := NewGRPCSolver(blockingConn{})
+	assert.Equal(t, DefaultTimeout, s.timeout)
+	s.SetTimeout(10 * time.Millisecond)
+	err := s.Present(&v1alpha1.ChallengeRequest{})
+	assert.Equal(t, codes.DeadlineExceeded, status.Code(err))
+}
+
+func TestValue(t *testing.T) {
+	// The unpadded base64url of the SHA-256 digest of the key authorization
+	// (RFC 8555, section 8.4): 9f86d081...b0f00a08 for "test".
+	assert.Equal(t, "n4bQgYhMfWWaL-qgxVrQFaO_TxsrC4Is0V1sFbDwCgg", Value("test"))
+}
@@ -0,0 +1,30 @@
+package testing
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestClock(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	c := NewClock(start)
+	after := c.After(time.Minute)
+	slept := make(chan struct{})
+	go func() {
+		c.Sleep(time.Hour)
+		close(slept)
+	}()
+	assert.Eventually(t, func() bool { return c.Waiters() == 2 }, time.Second, time.Millisecond)
+
+	c.Step(time.Minute)
+	assert.Equal(t, start.Add(time.Minute), <-after)
+	assert.Equal(t, time.Minute, c.Since(start))
+	assert.Equal(t, 1, c.Waiters(), "the sleep is not due")
+
+	c.Set(start.Add(2 * time.Hour))
+	<-slept
+	assert.Equal(t, 0, c.Waiters())
+	assert.Equal(t, start.Add(2*time.Hour), <-c.After(0))
+}
@@ -0,0 +1,15 @@
+// Package testing provides fakes for unit tests of the solver and of
+// integrations that embed it: a clock that only moves when told to, an
+// in-memory Sakura Cloud DNS zone client whose updates can be scripted to
+// conflict, and a store of credential Secrets. They are safe for concurrent
+// use, so that tests can run challenges in parallel to exercise batching,
+// locking and retries, with -race.
+//
+// Unlike package fake, which replaces the whole solver, these fakes replace
+// its dependencies:
+//
+//	zones := testing.NewZoneClient(&iaas.DNS{ID: 1, Name: "example.com"})
+//	zones.Conflict(1, 2) // the next two updates of zone 1 fail with 423 Locked
+//	secrets := testing.NewSecretStore()
+//	secrets.SetAPIKey("default", "sakuracloud", "token", "secret")
+package testing
@@ -0,0 +1,105 @@
+package testing
+
+import (
+	"context"
+	"sync"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+// Keys of the API key in the Secrets written by SetAPIKey, the ones of the
+// example Issuer configs.
+const (
+	AccessTokenKey       = "accessToken"
+	AccessTokenSecretKey = "accessTokenSecret"
+)
+
+// SecretStore holds credential Secrets for the solver. Its Client serves
+// them like the Kubernetes API server, counts the reads and fails the
+// ones scripted with FailGets.
+type SecretStore struct {
+	client *fake.Clientset
+
+	mu    sync.Mutex
+	gets  map[string]int
+	fails map[string][]error
+}
+
+// NewSecretStore returns an empty store.
+func NewSecretStore() *SecretStore {
+	s := &SecretStore{client: fake.NewSimpleClientset(), gets: map[string]int{}, fails: map[string][]error{}}
+	s.client.PrependReactor("get", "secrets", s.react)
+	return s
+}
+
+// Client returns the client serving the Secrets of the store, for the
+// secretsClient of the solver.
+func (s *SecretStore) Client() kubernetes.Interface {
+	return s.client
+}
+
+// Set creates or replaces Secret ns/name with data.
+func (s *SecretStore) Set(ns, name string, data map[string]string) error {
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Namespace: ns, Name: name},
+		Data:       map[string][]byte{},
+	}
+	for k, v := range data {
+		secret.Data[k] = []byte(v)
+	}
+	secrets := s.client.CoreV1().Secrets(ns)
+	_, err := secrets.Update(context.Background(), secret, metav1.UpdateOptions{})
+	if apierrors.IsNotFound(err) {
+		_, err = secrets.Create(context.Background(), secret, metav1.CreateOptions{})
+	}
+	return err
+}
+
+// SetAPIKey creates or replaces Secret ns/name holding an API key under
+// AccessTokenKey and AccessTokenSecretKey.
+func (s *SecretStore) SetAPIKey(ns, name, token, secret string) error {
+	return s.Set(ns, name, map[string]string{AccessTokenKey: token, AccessTokenSecretKey: secret})
+}
+
+// Delete deletes Secret ns/name.
+func (s *SecretStore) Delete(ns, name string) error {
+	return s.client.CoreV1().Secrets(ns).Delete(context.Background(), name, metav1.DeleteOptions{})
+}
+
+// FailGets makes the next reads of Secret ns/name fail with errs, one error
+// per read.
+func (s *SecretStore) FailGets(ns, name string, errs ...error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	key := ns + "/" + name
+	s.fails[key] = append(s.fails[key], errs...)
+}
+
+// Gets returns how many times Secret ns/name was read, including the reads
+// that failed.
+func (s *SecretStore) Gets(ns, name string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.gets[ns+"/"+name]
+}
+
+// react counts a get of a Secret and fails it if scripted to; otherwise the
+// object tracker of the client serves it.
+func (s *SecretStore) react(action k8stesting.Action) (bool, runtime.Object, error) {
+	get := action.(k8stesting.GetAction)
+	key := get.GetNamespace() + "/" + get.GetName()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.gets[key]++
+	if fails := s.fails[key]; len(fails) > 0 {
+		s.fails[key] = fails[1:]
+		return true, nil, fails[0]
+	}
+	return false, nil, nil
+}
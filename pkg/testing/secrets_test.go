@@ -0,0 +1,48 @@
+package testing
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestSecretStore(t *testing.T) {
+	s := NewSecretStore()
+	secrets := s.Client().CoreV1().Secrets("acme")
+	get := func() (map[string][]byte, error) {
+		secret, err := secrets.Get(context.Background(), "creds", metav1.GetOptions{})
+		if err != nil {
+			return nil, err
+		}
+		return secret.Data, nil
+	}
+
+	_, err := get()
+	assert.True(t, apierrors.IsNotFound(err))
+
+	require.NoError(t, s.SetAPIKey("acme", "creds", "token", "secret"))
+	data, err := get()
+	require.NoError(t, err)
+	assert.Equal(t, map[string][]byte{AccessTokenKey: []byte("token"), AccessTokenSecretKey: []byte("secret")}, data)
+	require.NoError(t, s.SetAPIKey("acme", "creds", "rotated", "secret"))
+	data, err = get()
+	require.NoError(t, err)
+	assert.Equal(t, "rotated", string(data[AccessTokenKey]))
+
+	unavailable := errors.New("connection refused")
+	s.FailGets("acme", "creds", unavailable)
+	_, err = get()
+	assert.ErrorIs(t, err, unavailable)
+	_, err = get()
+	assert.NoError(t, err, "only the scripted reads fail")
+	assert.Equal(t, 5, s.Gets("acme", "creds"))
+
+	require.NoError(t, s.Delete("acme", "creds"))
+	_, err = get()
+	assert.True(t, apierrors.IsNotFound(err))
+}
@@ -0,0 +1,286 @@
+package testing
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"runtime"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/sacloud/iaas-api-go"
+	"github.com/sacloud/iaas-api-go/search"
+	"github.com/sacloud/iaas-api-go/types"
+)
+
+// UpdateStep is a scripted step of an update of a zone, called with the
+// zone as stored before the update. It may change zone, like another
+// writer between the read and the update of the solver, and makes the
+// update fail if it returns an error.
+type UpdateStep func(zone *iaas.DNS) error
+
+// ZoneClient is an in-memory Sakura Cloud DNS API. It implements the
+// methods of iaas.DNSAPI the solver uses, Find, Read and UpdateSettings,
+// and returns the zones as copies, like the API. Updates of a zone run the
+// steps scripted for it first, in order.
+//
+// Like the API, every change of a zone gives it a new SettingsHash, and
+// updates sent with another SettingsHash than the one of the zone fail with
+// 409 Conflict. Updates without one always apply.
+type ZoneClient struct {
+	mu    sync.Mutex
+	zones map[types.ID]*iaas.DNS
+	steps map[types.ID][]UpdateStep
+	// hashes counts the SettingsHash handed out.
+	hashes int
+
+	reads, updates map[types.ID]int
+	// inFlight and maxInFlight count the concurrent updates of each zone.
+	inFlight, maxInFlight map[types.ID]int
+	latency               time.Duration
+}
+
+// NewZoneClient returns a client holding copies of zones.
+func NewZoneClient(zones ...*iaas.DNS) *ZoneClient {
+	c := &ZoneClient{
+		zones:       map[types.ID]*iaas.DNS{},
+		steps:       map[types.ID][]UpdateStep{},
+		reads:       map[types.ID]int{},
+		updates:     map[types.ID]int{},
+		inFlight:    map[types.ID]int{},
+		maxInFlight: map[types.ID]int{},
+	}
+	for _, zone := range zones {
+		c.Put(zone)
+	}
+	return c
+}
+
+// Put stores a copy of zone, replacing the zone with its ID. The zone gets
+// a new SettingsHash unless it has one.
+func (c *ZoneClient) Put(zone *iaas.DNS) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	zone = copyZone(zone)
+	if zone.SettingsHash == "" {
+		c.rehash(zone)
+	}
+	c.zones[zone.ID] = zone
+}
+
+// Delete deletes zone id, like another tool deleting it with the API; reads
+// and updates of the zone fail with 404 Not Found until it is Put again.
+func (c *ZoneClient) Delete(id types.ID) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.zones, id)
+}
+
+// Zone returns a copy of zone id, or nil if the client does not hold it.
+func (c *ZoneClient) Zone(id types.ID) *iaas.DNS {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if zone, ok := c.zones[id]; ok {
+		return copyZone(zone)
+	}
+	return nil
+}
+
+// Script appends steps to the ones run by the next updates of zone id, one
+// step per update.
+func (c *ZoneClient) Script(id types.ID, steps ...UpdateStep) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.steps[id] = append(c.steps[id], steps...)
+}
+
+// Conflict makes the next n updates of zone id fail with 423 Locked, as the
+// API does while another update of the zone is being applied.
+func (c *ZoneClient) Conflict(id types.ID, n int) {
+	for i := 0; i < n; i++ {
+		c.Script(id, func(*iaas.DNS) error {
+			return NewAPIError(http.MethodPut, id, http.StatusLocked, "lock", "the resource is locked by another request")
+		})
+	}
+}
+
+// ConcurrentWrite makes another writer change the records of zone id with
+// edit right before the next update, which then fails with 409 Conflict on
+// its stale SettingsHash, or overwrites the change if sent without one.
+func (c *ZoneClient) ConcurrentWrite(id types.ID, edit func(records iaas.DNSRecords) iaas.DNSRecords) {
+	c.Script(id, func(zone *iaas.DNS) error {
+		zone.Records = edit(zone.Records)
+		c.rehash(zone)
+		return nil
+	})
+}
+
+// SetLatency makes every update take d, so that updates the solver sends
+// concurrently overlap.
+func (c *ZoneClient) SetLatency(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.latency = d
+}
+
+// Reads returns how many times zone id was read.
+func (c *ZoneClient) Reads(id types.ID) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.reads[id]
+}
+
+// Updates returns how many updates of zone id were attempted, including
+// the ones that failed.
+func (c *ZoneClient) Updates(id types.ID) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.updates[id]
+}
+
+// MaxConcurrentUpdates returns how many updates of zone id were in flight
+// at once at most. The solver serializes them, so anything above 1 is a
+// bug.
+func (c *ZoneClient) MaxConcurrentUpdates(id types.ID) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.maxInFlight[id]
+}
+
+// Find returns the zones, without their records, that match the ID and
+// Name filters of conditions with ExactMatch. Other conditions are ignored.
+func (c *ZoneClient) Find(_ context.Context, conditions *iaas.FindCondition) (*iaas.DNSFindResult, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	res := &iaas.DNSFindResult{}
+	for _, zone := range c.zones {
+		if conditions != nil && !matchFilter(conditions.Filter, zone) {
+			continue
+		}
+		found := copyZone(zone)
+		found.Records = nil
+		res.DNS = append(res.DNS, found)
+	}
+	slices.SortFunc(res.DNS, func(a, b *iaas.DNS) int { return cmp.Compare(a.ID, b.ID) })
+	res.Total, res.Count = len(res.DNS), len(res.DNS)
+	return res, nil
+}
+
+// Read returns zone id, or a 404 APIError.
+func (c *ZoneClient) Read(_ context.Context, id types.ID) (*iaas.DNS, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.reads[id]++
+	zone, ok := c.zones[id]
+	if !ok {
+		return nil, NewAPIError(http.MethodGet, id, http.StatusNotFound, "not_found", "the resource is not found")
+	}
+	return copyZone(zone), nil
+}
+
+// UpdateSettings runs the next step scripted for zone id, if any, then
+// replaces the records of the zone with those of param, unless the
+// SettingsHash of param is stale. The update takes
+// the latency of SetLatency, during which other updates of the zone count
+// as concurrent.
+func (c *ZoneClient) UpdateSettings(_ context.Context, id types.ID, param *iaas.DNSUpdateSettingsRequest) (*iaas.DNS, error) {
+	c.mu.Lock()
+	c.updates[id]++
+	c.inFlight[id]++
+	c.maxInFlight[id] = max(c.maxInFlight[id], c.inFlight[id])
+	latency := c.latency
+	c.mu.Unlock()
+	if latency > 0 {
+		time.Sleep(latency)
+	} else {
+		runtime.Gosched()
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.inFlight[id]--
+	zone, ok := c.zones[id]
+	if !ok {
+		return nil, NewAPIError(http.MethodPut, id, http.StatusNotFound, "not_found", "the resource is not found")
+	}
+	if steps := c.steps[id]; len(steps) > 0 {
+		c.steps[id] = steps[1:]
+		if err := steps[0](zone); err != nil {
+			return nil, err
+		}
+	}
+	if param.SettingsHash != "" && param.SettingsHash != zone.SettingsHash {
+		return nil, NewAPIError(http.MethodPut, id, http.StatusConflict, "conflict", "the settings were changed by another request")
+	}
+	zone.Records = cloneRecords(param.Records)
+	c.rehash(zone)
+	return copyZone(zone), nil
+}
+
+// rehash gives zone a new SettingsHash. c.mu must be held.
+func (c *ZoneClient) rehash(zone *iaas.DNS) {
+	c.hashes++
+	zone.SettingsHash = fmt.Sprintf("%016x", c.hashes)
+}
+
+// NewAPIError returns the iaas.APIError the API responds to a request of
+// method on zone id with status and error_code code.
+func NewAPIError(method string, id types.ID, status int, code, msg string) iaas.APIError {
+	u := &url.URL{Scheme: "https", Host: "secure.sakura.ad.jp", Path: fmt.Sprintf("/cloud/zone/is1a/api/cloud/1.1/commonserviceitem/%s", id)}
+	return iaas.NewAPIError(method, u, status, &iaas.APIErrorResponse{
+		IsFatal:      true,
+		Status:       fmt.Sprintf("%d %s", status, http.StatusText(status)),
+		ErrorCode:    code,
+		ErrorMessage: msg,
+	})
+}
+
+// copyZone returns a copy of zone that shares nothing with it.
+func copyZone(zone *iaas.DNS) *iaas.DNS {
+	c := *zone
+	c.Records = cloneRecords(zone.Records)
+	c.DNSNameServers = slices.Clone(zone.DNSNameServers)
+	c.Tags = slices.Clone(zone.Tags)
+	return &c
+}
+
+// cloneRecords copies records, so that the records held by the client are
+// not shared with the solver.
+func cloneRecords(records iaas.DNSRecords) iaas.DNSRecords {
+	if records == nil {
+		return nil
+	}
+	clone := make(iaas.DNSRecords, len(records))
+	for i, r := range records {
+		c := *r
+		clone[i] = &c
+	}
+	return clone
+}
+
+// matchFilter reports whether zone matches the ID and Name conditions of
+// filter.
+func matchFilter(filter search.Filter, zone *iaas.DNS) bool {
+	for key, expr := range filter {
+		var value string
+		switch key.Field {
+		case "ID":
+			value = zone.ID.String()
+		case "Name":
+			value = zone.Name
+		default:
+			continue
+		}
+		eq, ok := expr.(*search.EqualExpression)
+		if !ok {
+			continue
+		}
+		if !slices.ContainsFunc(eq.Conditions, func(c any) bool { return fmt.Sprint(c) == value }) {
+			return false
+		}
+	}
+	return true
+}
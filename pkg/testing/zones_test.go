@@ -0,0 +1,95 @@
+package testing
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sacloud/iaas-api-go"
+	"github.com/sacloud/iaas-api-go/search"
+	"github.com/sacloud/iaas-api-go/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestZoneClient(t *testing.T) {
+	ctx := context.Background()
+	record := &iaas.DNSRecord{Name: "www", Type: types.DNSRecordTypes.A, RData: "192.0.2.1", TTL: 300}
+	c := NewZoneClient(&iaas.DNS{ID: 1, Name: "example.com", Records: iaas.DNSRecords{record}}, &iaas.DNS{ID: 2, Name: "example.org"})
+
+	zone, err := c.Read(ctx, 1)
+	require.NoError(t, err)
+	zone.Records[0].RData = "192.0.2.2"
+	assert.Equal(t, "192.0.2.1", c.Zone(1).Records[0].RData, "reads return copies")
+	assert.Equal(t, 1, c.Reads(1))
+	_, err = c.Read(ctx, 3)
+	assert.True(t, iaas.IsNotFoundError(err))
+
+	res, err := c.Find(ctx, &iaas.FindCondition{Filter: search.Filter{search.Key("ID"): search.ExactMatch("2")}})
+	require.NoError(t, err)
+	require.Len(t, res.DNS, 1)
+	assert.Equal(t, "example.org", res.DNS[0].Name)
+	res, err = c.Find(ctx, &iaas.FindCondition{})
+	require.NoError(t, err)
+	assert.Len(t, res.DNS, 2)
+	assert.Nil(t, res.DNS[0].Records, "found without records")
+
+	c.Conflict(1, 1)
+	var seen iaas.DNSRecords
+	c.ConcurrentWrite(1, func(records iaas.DNSRecords) iaas.DNSRecords {
+		seen = records
+		return nil
+	})
+	txt := iaas.DNSRecords{{Name: "_acme-challenge", Type: types.DNSRecordTypes.TXT, RData: `"key"`, TTL: 60}}
+	_, err = c.UpdateSettings(ctx, 1, &iaas.DNSUpdateSettingsRequest{Records: txt, SettingsHash: zone.SettingsHash})
+	var apiErr iaas.APIError
+	require.ErrorAs(t, err, &apiErr)
+	assert.Equal(t, http.StatusLocked, apiErr.ResponseCode())
+	assert.Equal(t, iaas.DNSRecords{record}, c.Zone(1).Records, "the failed update changed nothing")
+	assert.Nil(t, seen, "one step per update")
+
+	_, err = c.UpdateSettings(ctx, 1, &iaas.DNSUpdateSettingsRequest{Records: txt, SettingsHash: zone.SettingsHash})
+	require.ErrorAs(t, err, &apiErr)
+	assert.Equal(t, http.StatusConflict, apiErr.ResponseCode(), "the zone changed since it was read")
+	assert.Equal(t, iaas.DNSRecords{record}, seen)
+	assert.Empty(t, c.Zone(1).Records, "the concurrent write is kept")
+
+	zone, err = c.Read(ctx, 1)
+	require.NoError(t, err)
+	updated, err := c.UpdateSettings(ctx, 1, &iaas.DNSUpdateSettingsRequest{Records: txt, SettingsHash: zone.SettingsHash})
+	require.NoError(t, err)
+	assert.Equal(t, txt, updated.Records)
+	assert.NotEqual(t, zone.SettingsHash, updated.SettingsHash, "every write changes the hash")
+	_, err = c.UpdateSettings(ctx, 1, &iaas.DNSUpdateSettingsRequest{Records: txt, SettingsHash: zone.SettingsHash})
+	assert.ErrorAs(t, err, &apiErr)
+	assert.Equal(t, 4, c.Updates(1))
+
+	c.Delete(1)
+	assert.Nil(t, c.Zone(1))
+	_, err = c.Read(ctx, 1)
+	assert.True(t, iaas.IsNotFoundError(err))
+	_, err = c.UpdateSettings(ctx, 1, &iaas.DNSUpdateSettingsRequest{Records: txt})
+	assert.True(t, iaas.IsNotFoundError(err))
+}
+
+func TestZoneClientConcurrentUpdates(t *testing.T) {
+	c := NewZoneClient(&iaas.DNS{ID: 1, Name: "example.com"})
+	c.SetLatency(10 * time.Millisecond)
+	var wg sync.WaitGroup
+	for i := 0; i < 3; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			_, err := c.UpdateSettings(context.Background(), 1, &iaas.DNSUpdateSettingsRequest{
+				Records: iaas.DNSRecords{{Name: strconv.Itoa(i), Type: types.DNSRecordTypes.TXT, RData: "x", TTL: 60}},
+			})
+			assert.NoError(t, err)
+		}(i)
+	}
+	wg.Wait()
+	assert.Equal(t, 3, c.Updates(1))
+	assert.Greater(t, c.MaxConcurrentUpdates(1), 1)
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+
+	"github.com/sacloud/iaas-api-go"
+)
+
+// zonePlan lists the record changes that turn the records of a zone into a
+// target record set, e.g. for the dry run of `restore`. It is printed for
+// people by writeText and for pipelines by writeJSON.
+type zonePlan struct {
+	ZoneID  int64          `json:"zoneID"`
+	Zone    string         `json:"zone"`
+	Changes []recordChange `json:"changes"`
+}
+
+// recordChange is one change of a zonePlan. Added records only have After,
+// deleted ones only Before. A record whose value or TTL changes is a
+// deletion and an addition of the same name and type, shown as one change.
+type recordChange struct {
+	Action string         `json:"action"`
+	Name   string         `json:"name"`
+	Type   string         `json:"type"`
+	Before *plannedRecord `json:"before,omitempty"`
+	After  *plannedRecord `json:"after,omitempty"`
+}
+
+// plannedRecord is the value of a record in a recordChange. Challenge
+// records show their loggedRData.
+type plannedRecord struct {
+	RData string `json:"rdata"`
+	TTL   int    `json:"ttl"`
+}
+
+const (
+	planAdd    = "add"
+	planChange = "change"
+	planDelete = "delete"
+)
+
+// planZone returns the plan that replaces the records of zone with target.
+func planZone(zone *iaas.DNS, target iaas.DNSRecords) *zonePlan {
+	type nameType struct{ name, typ string }
+	key := func(r *iaas.DNSRecord) string {
+		return fmt.Sprintf("%s\t%s\t%s\t%d", r.Name, r.Type, r.RData, r.TTL)
+	}
+	only := func(records, other iaas.DNSRecords) map[nameType][]*iaas.DNSRecord {
+		keys := map[string]int{}
+		for _, r := range other {
+			keys[key(r)]++
+		}
+		m := map[nameType][]*iaas.DNSRecord{}
+		for _, r := range records {
+			if keys[key(r)] > 0 {
+				keys[key(r)]--
+				continue
+			}
+			k := nameType{r.Name, string(r.Type)}
+			m[k] = append(m[k], r)
+		}
+		return m
+	}
+	deleted, added := only(zone.Records, target), only(target, zone.Records)
+
+	planned := func(r *iaas.DNSRecord) *plannedRecord {
+		return &plannedRecord{RData: loggedRData(r), TTL: r.TTL}
+	}
+	p := &zonePlan{ZoneID: zone.ID.Int64(), Zone: zone.Name, Changes: []recordChange{}}
+	seen := map[nameType]bool{}
+	for _, group := range []map[nameType][]*iaas.DNSRecord{deleted, added} {
+		for k := range group {
+			if seen[k] {
+				continue
+			}
+			seen[k] = true
+			before, after := deleted[k], added[k]
+			for i := 0; i < max(len(before), len(after)); i++ {
+				c := recordChange{Name: k.name, Type: k.typ}
+				switch {
+				case i >= len(after):
+					c.Action, c.Before = planDelete, planned(before[i])
+				case i >= len(before):
+					c.Action, c.After = planAdd, planned(after[i])
+				default:
+					c.Action, c.Before, c.After = planChange, planned(before[i]), planned(after[i])
+				}
+				p.Changes = append(p.Changes, c)
+			}
+		}
+	}
+	slices.SortStableFunc(p.Changes, func(a, b recordChange) int {
+		return strings.Compare(a.Name+"\t"+a.Type, b.Name+"\t"+b.Type)
+	})
+	return p
+}
+
+// counts returns the number of additions, changes and deletions of p.
+func (p *zonePlan) counts() (add, change, del int) {
+	for _, c := range p.Changes {
+		switch c.Action {
+		case planAdd:
+			add++
+		case planChange:
+			change++
+		case planDelete:
+			del++
+		}
+	}
+	return add, change, del
+}
+
+// writeText prints p the way terraform prints plans: + for additions, ~ for
+// changes and - for deletions.
+func (p *zonePlan) writeText(w io.Writer) {
+	add, change, del := p.counts()
+	fmt.Fprintf(w, "zone %s (%d): %d to add, %d to change, %d to delete\n", p.Zone, p.ZoneID, add, change, del)
+	for _, c := range p.Changes {
+		switch c.Action {
+		case planAdd:
+			fmt.Fprintf(w, "+ %s\t%s\t%d %q\n", c.Name, c.Type, c.After.TTL, c.After.RData)
+		case planChange:
+			fmt.Fprintf(w, "~ %s\t%s\t%d %q -> %d %q\n", c.Name, c.Type, c.Before.TTL, c.Before.RData, c.After.TTL, c.After.RData)
+		case planDelete:
+			fmt.Fprintf(w, "- %s\t%s\t%d %q\n", c.Name, c.Type, c.Before.TTL, c.Before.RData)
+		}
+	}
+}
+
+// writeJSON prints p as indented JSON.
+func (p *zonePlan) writeJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(p)
+}
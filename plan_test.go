@@ -0,0 +1,54 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+
+	"github.com/sacloud/iaas-api-go"
+	"github.com/sacloud/iaas-api-go/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPlanZone(t *testing.T) {
+	record := func(name, typ, rdata string, ttl int) *iaas.DNSRecord {
+		return &iaas.DNSRecord{Name: name, Type: types.EDNSRecordType(typ), RData: rdata, TTL: ttl}
+	}
+	challenge := record("_acme-challenge", "TXT", `"token"`, 60)
+	zone := &iaas.DNS{ID: 1, Name: "example.com", Records: iaas.DNSRecords{
+		record("www", "A", "192.0.2.1", 300),
+		record("old", "A", "192.0.2.3", 300),
+		record("@", "MX", "10 mail.example.com.", 300),
+		challenge,
+	}}
+	target := iaas.DNSRecords{
+		record("@", "MX", "10 mail.example.com.", 300),
+		record("www", "A", "192.0.2.1", 600),
+		record("mail", "A", "192.0.2.2", 300),
+	}
+
+	p := planZone(zone, target)
+	assert.Equal(t, []recordChange{
+		{Action: planDelete, Name: "_acme-challenge", Type: "TXT", Before: &plannedRecord{RData: loggedRData(challenge), TTL: 60}},
+		{Action: planAdd, Name: "mail", Type: "A", After: &plannedRecord{RData: "192.0.2.2", TTL: 300}},
+		{Action: planDelete, Name: "old", Type: "A", Before: &plannedRecord{RData: "192.0.2.3", TTL: 300}},
+		{Action: planChange, Name: "www", Type: "A", Before: &plannedRecord{RData: "192.0.2.1", TTL: 300}, After: &plannedRecord{RData: "192.0.2.1", TTL: 600}},
+	}, p.Changes)
+
+	var text bytes.Buffer
+	p.writeText(&text)
+	assert.Equal(t, "zone example.com (1): 1 to add, 1 to change, 2 to delete\n"+
+		"- _acme-challenge\tTXT\t60 \""+loggedRData(challenge)+"\"\n"+
+		"+ mail\tA\t300 \"192.0.2.2\"\n"+
+		"- old\tA\t300 \"192.0.2.3\"\n"+
+		"~ www\tA\t300 \"192.0.2.1\" -> 600 \"192.0.2.1\"\n", text.String())
+
+	var js bytes.Buffer
+	require.NoError(t, p.writeJSON(&js))
+	var decoded zonePlan
+	require.NoError(t, json.Unmarshal(js.Bytes(), &decoded))
+	assert.Equal(t, *p, decoded)
+
+	assert.Empty(t, planZone(&iaas.DNS{Records: target}, target).Changes)
+}
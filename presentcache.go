@@ -0,0 +1,81 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// presentKey identifies a challenge record that was presented.
+type presentKey struct {
+	namespace string
+	zoneID    int64
+	fqdn      string
+	keyDigest string
+}
+
+// presentCache remembers successful Present calls for a short time, so that
+// cert-manager retrying Present for the same record while its self check is
+// still waiting does not read the zone again every time.
+type presentCache struct {
+	ttl time.Duration
+	now func() time.Time
+
+	mu      sync.Mutex
+	entries map[presentKey]time.Time
+}
+
+func newPresentCache(ttl time.Duration) *presentCache {
+	return &presentCache{
+		ttl:     ttl,
+		now:     time.Now,
+		entries: map[presentKey]time.Time{},
+	}
+}
+
+// len returns the number of remembered Presents, including expired ones
+// that were not looked up again.
+func (p *presentCache) len() int {
+	if p == nil {
+		return 0
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return len(p.entries)
+}
+
+// hit reports whether k was presented within the TTL.
+func (p *presentCache) hit(k presentKey) bool {
+	if p == nil || p.ttl <= 0 {
+		return false
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	expiry, ok := p.entries[k]
+	return ok && p.now().Before(expiry)
+}
+
+// add records a successful Present of k and drops expired entries.
+func (p *presentCache) add(k presentKey) {
+	if p == nil || p.ttl <= 0 {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	now := p.now()
+	for key, expiry := range p.entries {
+		if !now.Before(expiry) {
+			delete(p.entries, key)
+		}
+	}
+	p.entries[k] = now.Add(p.ttl)
+}
+
+// remove forgets k, e.g. because the record is being cleaned up.
+func (p *presentCache) remove(k presentKey) {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	delete(p.entries, k)
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPresentCache(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	p := newPresentCache(time.Minute)
+	p.now = func() time.Time { return now }
+
+	k := presentKey{namespace: "default", zoneID: 1, fqdn: "_acme-challenge.example.com.", keyDigest: keyDigest("key")}
+	assert.False(t, p.hit(k))
+
+	p.add(k)
+	assert.True(t, p.hit(k))
+	assert.False(t, p.hit(presentKey{namespace: "default", zoneID: 1, fqdn: k.fqdn, keyDigest: keyDigest("other")}))
+
+	now = now.Add(time.Minute)
+	assert.False(t, p.hit(k), "entry must expire after the TTL")
+
+	p.add(k)
+	p.remove(k)
+	assert.False(t, p.hit(k), "CleanUp must invalidate the entry")
+}
+
+func TestPresentCacheDisabled(t *testing.T) {
+	p := newPresentCache(0)
+	k := presentKey{keyDigest: keyDigest("key")}
+	p.add(k)
+	assert.False(t, p.hit(k))
+}
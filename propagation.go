@@ -0,0 +1,124 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/cert-manager/cert-manager/pkg/acme/webhook/apis/acme/v1alpha1"
+	"k8s.io/klog/v2"
+)
+
+// checkPropagation waits until the propagation checker of cfg sees the
+// challenge record. If it does not show up in time and repair is set, the
+// zone is read again: when the record has vanished from the zone (e.g. the
+// zone was re-applied by Terraform right after our update) it is presented
+// once more before giving up.
+func (c *sakuraCloudDNSProviderSolver) checkPropagation(ctx context.Context, cfg *sakuraCloudDNSProviderConfig, ch *v1alpha1.ChallengeRequest, rdata string, repair bool) (err error) {
+	timeout := cfg.PropagationCheckTimeout.Duration
+	if timeout <= 0 {
+		return nil
+	}
+	checker, err := c.propagationChecker(ctx, cfg.PropagationChecker, ch.ResolvedZone)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		observePropagation(err)
+	}()
+
+	start := time.Now()
+	err = c.waitForTXT(ctx, checker, ch.ResolvedFQDN, ch.Key, timeout)
+	observePhase("verify", start)
+	if err == nil {
+		return nil
+	}
+	if !repair {
+		return err
+	}
+
+	repaired, rerr := c.presentRecord(ctx, cfg, ch, rdata)
+	if rerr != nil {
+		return rerr
+	}
+	if repaired {
+		driftRepairsTotal.Inc()
+		klog.Warningf("TXT record %s disappeared from zone %d after it was presented, presented it again", ch.ResolvedFQDN, cfg.ZoneID)
+		if err = c.waitForTXT(ctx, checker, ch.ResolvedFQDN, ch.Key, timeout); err == nil {
+			return nil
+		}
+	}
+	return &retriableError{
+		reason: fmt.Sprintf("TXT record %s has not propagated yet", ch.ResolvedFQDN),
+		err:    err,
+	}
+}
+
+// goVerifyPropagation runs verifyPropagation in the background, until the
+// solver stops; shutdown and the drain wait for it.
+func (c *sakuraCloudDNSProviderSolver) goVerifyPropagation(cfg sakuraCloudDNSProviderConfig, ch *v1alpha1.ChallengeRequest, rdata string) {
+	c.verifications.Add(1)
+	done := c.drain.track()
+	go func() {
+		defer c.verifications.Done()
+		defer done()
+		c.verifyPropagation(cfg, ch, rdata)
+	}()
+}
+
+// verifyPropagation runs the propagation check of a record Present has
+// already returned for, with --async-propagation-check. The result is
+// exported as a metric and recorded on the registry entry of the record.
+// A record missing from the zone is not presented again: cert-manager may
+// have cleaned it up meanwhile, and nothing would delete it then.
+func (c *sakuraCloudDNSProviderSolver) verifyPropagation(cfg sakuraCloudDNSProviderConfig, ch *v1alpha1.ChallengeRequest, rdata string) {
+	if cfg.PropagationCheckTimeout.Duration <= 0 {
+		return
+	}
+	ctx := c.stopContext()
+	err := c.checkPropagation(ctx, &cfg, ch, rdata, false)
+	if ctx.Err() != nil {
+		return
+	}
+	if err != nil {
+		sampledLog.Warningf("verifying %s in zone %d: %v", ch.ResolvedFQDN, cfg.ZoneID, err)
+	} else {
+		klog.V(4).Infof("verified the propagation of %s", ch.ResolvedFQDN)
+	}
+	if rerr := c.registry.verified(newRegistryEntry(&cfg, ch), err); rerr != nil {
+		sampledLog.Warningf("recording the propagation of %s in the registry: %v", ch.ResolvedFQDN, rerr)
+	}
+}
+
+// stopContext returns a context canceled once the stopCh of Initialize is
+// closed.
+func (c *sakuraCloudDNSProviderSolver) stopContext() context.Context {
+	if c.stopped == nil {
+		return context.Background()
+	}
+	return c.stopped
+}
+
+// waitForTXT polls checker until it sees value among the TXT records of
+// fqdn, or timeout passes.
+func (c *sakuraCloudDNSProviderSolver) waitForTXT(ctx context.Context, checker propagationChecker, fqdn, value string, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	ticker := time.NewTicker(c.opts.PropagationCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		err := checker.check(ctx, fqdn, value)
+		if err == nil {
+			return nil
+		}
+		logRecordsf("waiting for %s to propagate: %v", fqdn, err)
+
+		select {
+		case <-ctx.Done():
+			return err
+		case <-ticker.C:
+		}
+	}
+}
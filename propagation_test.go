@@ -0,0 +1,66 @@
+package main
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	sctesting "github.com/cert-manager/webhook-example/pkg/testing"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/sacloud/iaas-api-go"
+	"github.com/sacloud/iaas-api-go/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// driftingZones reverts a zone right after the next reverts updates of it,
+// like Terraform re-applying the zone.
+type driftingZones struct {
+	*sctesting.ZoneClient
+	reverts atomic.Int32
+}
+
+func (z *driftingZones) UpdateSettings(ctx context.Context, id types.ID, param *iaas.DNSUpdateSettingsRequest) (*iaas.DNS, error) {
+	before := z.Zone(id)
+	zone, err := z.ZoneClient.UpdateSettings(ctx, id, param)
+	if err == nil && z.reverts.Add(-1) >= 0 {
+		z.Put(before)
+	}
+	return zone, err
+}
+
+func TestDriftRepair(t *testing.T) {
+	zones := &driftingZones{ZoneClient: sctesting.NewZoneClient(&iaas.DNS{ID: 1, Name: "example.com"})}
+	c := newHarness(t, zones.ZoneClient)
+	c.newZoneAPI = func(string, string) zoneAPI { return zones }
+	serveZoneDoH(t, c, zones.ZoneClient)
+	c.opts.PropagationCheckTimeout = 100 * time.Millisecond
+	repairs := testutil.ToFloat64(driftRepairsTotal)
+
+	// The record is gone once the propagation check times out, and is
+	// presented again.
+	zones.reverts.Store(1)
+	require.NoError(t, c.Present(harnessChallenge(0)))
+	assert.Len(t, zones.Zone(1).Records, 1)
+	assert.Equal(t, 2, zones.Updates(1))
+	assert.Equal(t, repairs+1, testutil.ToFloat64(driftRepairsTotal))
+
+	// Only once: the record lost again fails the challenge, for
+	// cert-manager to retry.
+	zones.reverts.Store(2)
+	err := c.Present(harnessChallenge(1))
+	var retriable *retriableError
+	require.ErrorAs(t, err, &retriable)
+	assert.ErrorContains(t, err, "TXT record _acme-challenge.host1.example.com. has not propagated yet")
+	assert.Equal(t, 4, zones.Updates(1))
+	assert.Equal(t, repairs+2, testutil.ToFloat64(driftRepairsTotal))
+
+	// A record that is in the zone but not served yet is not presented
+	// again.
+	c.opts.PropagationDoHURL = "http://127.0.0.1:1/dns-query"
+	zones.reverts.Store(0)
+	require.Error(t, c.Present(harnessChallenge(2)))
+	assert.Equal(t, 5, zones.Updates(1))
+	assert.Equal(t, repairs+2, testutil.ToFloat64(driftRepairsTotal))
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	mdns "github.com/miekg/dns"
+)
+
+// defaultPropagationNameservers are the authoritative nameservers of Sakura
+// Cloud DNS, queried by the authoritative propagation checker when the NS
+// set of a zone cannot be looked up.
+var defaultPropagationNameservers = []string{
+	"ns1.gslb1.sakura.ne.jp",
+	"ns2.gslb1.sakura.ne.jp",
+}
+
+// fallbackNameservers returns the nameservers to query for zone when its NS
+// set cannot be looked up: the ones Sakura Cloud assigned to the zone, if
+// its metadata is cached, else defaultPropagationNameservers.
+func (c *sakuraCloudDNSProviderSolver) fallbackNameservers(zone string) []string {
+	if servers, ok := c.metadata.nameservers(zone); ok {
+		return servers
+	}
+	return defaultPropagationNameservers
+}
+
+// propagationChecker reports whether a TXT record is visible. Clusters that
+// cannot send DNS queries to the internet can use the DNS-over-HTTPS
+// checker, or the recursive checker with the cluster resolver.
+type propagationChecker interface {
+	// check returns an error unless value is among the TXT values of fqdn.
+	check(ctx context.Context, fqdn, value string) error
+}
+
+// propagationCheckers are the values of --propagation-checker and of the
+// propagationChecker field of the Issuer config.
+var propagationCheckers = []string{"authoritative", "recursive", "doh"}
+
+// propagationChecker returns the checker called name for records in zone,
+// as configured by the flags. Without --propagation-nameservers the
+// authoritative checker queries the NS set of the zone, so that secondary
+// nameservers are checked too; if it cannot be looked up, the Sakura Cloud
+// nameservers of the zone are queried instead, see fallbackNameservers.
+func (c *sakuraCloudDNSProviderSolver) propagationChecker(ctx context.Context, name, zone string) (propagationChecker, error) {
+	switch name {
+	case "authoritative":
+		servers := c.opts.PropagationNameservers
+		if len(servers) == 0 {
+			var err error
+			if servers, err = zoneNameservers.get(ctx, zone); err != nil {
+				servers = c.fallbackNameservers(zone)
+				sampledLog.Warningf("%v, querying %s instead", err, strings.Join(servers, ", "))
+			}
+		}
+		return &dnsChecker{servers: servers}, nil
+	case "recursive":
+		servers := c.opts.PropagationResolvers
+		if len(servers) == 0 {
+			var err error
+			if servers, err = systemResolvers(); err != nil {
+				return nil, fmt.Errorf("reading the resolvers of the recursive propagation checker: %w", err)
+			}
+		}
+		return &dnsChecker{servers: servers, recursive: true}, nil
+	case "doh":
+		return &dohChecker{url: c.opts.PropagationDoHURL, client: &http.Client{Timeout: 5 * time.Second}}, nil
+	}
+	return nil, fmt.Errorf("unknown propagation checker %q, use one of %s", name, strings.Join(propagationCheckers, ", "))
+}
+
+// dnsChecker queries servers over DNS and requires every one of them to
+// return the value. Without recursive it asks the authoritative nameservers
+// directly; with it, resolvers, which may answer from their caches.
+type dnsChecker struct {
+	servers   []string
+	recursive bool
+}
+
+func (d *dnsChecker) check(ctx context.Context, fqdn, value string) error {
+	for _, server := range d.servers {
+		values, err := lookupTXT(ctx, server, fqdn, d.recursive)
+		if err != nil {
+			return err
+		}
+		if !slices.Contains(values, value) {
+			return fmt.Errorf("%s does not serve the expected TXT value for %s yet", server, fqdn)
+		}
+	}
+	return nil
+}
+
+// dohChecker queries a DNS-over-HTTPS resolver (RFC 8484).
+type dohChecker struct {
+	url    string
+	client *http.Client
+}
+
+func (d *dohChecker) check(ctx context.Context, fqdn, value string) error {
+	m := new(mdns.Msg)
+	m.SetQuestion(mdns.Fqdn(fqdn), mdns.TypeTXT)
+	m.Id = 0 // recommended by RFC 8484 for HTTP caching
+	query, err := m.Pack()
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, d.url, bytes.NewReader(query))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/dns-message")
+	req.Header.Set("Accept", "application/dns-message")
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("querying %s for %s: %w", d.url, fqdn, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("querying %s for %s: %s", d.url, fqdn, resp.Status)
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 64*1024))
+	if err != nil {
+		return fmt.Errorf("querying %s for %s: %w", d.url, fqdn, err)
+	}
+
+	in := new(mdns.Msg)
+	if err := in.Unpack(body); err != nil {
+		return fmt.Errorf("decoding the answer of %s for %s: %w", d.url, fqdn, err)
+	}
+	values, err := txtValues(in, d.url, fqdn)
+	if err != nil {
+		return err
+	}
+	if !slices.Contains(values, value) {
+		return fmt.Errorf("%s does not return the expected TXT value for %s yet", d.url, fqdn)
+	}
+	return nil
+}
+
+// lookupTXT sends a TXT query for fqdn to server.
+func lookupTXT(ctx context.Context, server, fqdn string, recursive bool) ([]string, error) {
+	if _, _, err := net.SplitHostPort(server); err != nil {
+		server = net.JoinHostPort(server, "53")
+	}
+
+	m := new(mdns.Msg)
+	m.SetQuestion(mdns.Fqdn(fqdn), mdns.TypeTXT)
+	m.RecursionDesired = recursive
+
+	client := &mdns.Client{Timeout: 5 * time.Second}
+	in, _, err := client.ExchangeContext(ctx, m, server)
+	if err != nil {
+		return nil, fmt.Errorf("querying %s for %s: %w", server, fqdn, err)
+	}
+	return txtValues(in, server, fqdn)
+}
+
+// txtValues returns the TXT values in the answer of server, treating
+// NXDOMAIN as no values.
+func txtValues(in *mdns.Msg, server, fqdn string) ([]string, error) {
+	if in.Rcode != mdns.RcodeSuccess && in.Rcode != mdns.RcodeNameError {
+		return nil, fmt.Errorf("querying %s for %s: %s", server, fqdn, mdns.RcodeToString[in.Rcode])
+	}
+
+	var values []string
+	for _, rr := range in.Answer {
+		if txt, ok := rr.(*mdns.TXT); ok {
+			values = append(values, strings.Join(txt.Txt, ""))
+		}
+	}
+	return values, nil
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	mdns "github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// txtAnswer answers TXT queries for _acme-challenge.example.com. with value
+// and every other query with NXDOMAIN.
+func txtAnswer(q *mdns.Msg, value string) *mdns.Msg {
+	m := new(mdns.Msg)
+	m.SetReply(q)
+	if q.Question[0].Name != "_acme-challenge.example.com." {
+		m.Rcode = mdns.RcodeNameError
+		return m
+	}
+	m.Answer = append(m.Answer, &mdns.TXT{
+		Hdr: mdns.RR_Header{Name: q.Question[0].Name, Rrtype: mdns.TypeTXT, Class: mdns.ClassINET, Ttl: 60},
+		Txt: []string{value},
+	})
+	return m
+}
+
+func TestDNSChecker(t *testing.T) {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	// The handler runs on a server goroutine.
+	var recursionDesired atomic.Bool
+	server := &mdns.Server{PacketConn: pc, Handler: mdns.HandlerFunc(func(w mdns.ResponseWriter, q *mdns.Msg) {
+		recursionDesired.Store(q.RecursionDesired)
+		_ = w.WriteMsg(txtAnswer(q, "key"))
+	})}
+	go func() { _ = server.ActivateAndServe() }()
+	defer server.Shutdown()
+
+	ctx := context.Background()
+	authoritative := &dnsChecker{servers: []string{pc.LocalAddr().String()}}
+	assert.NoError(t, authoritative.check(ctx, "_acme-challenge.example.com.", "key"))
+	assert.False(t, recursionDesired.Load())
+	assert.ErrorContains(t, authoritative.check(ctx, "_acme-challenge.example.com.", "other"), "does not serve")
+	assert.Error(t, authoritative.check(ctx, "_acme-challenge.example.org.", "key"))
+
+	recursive := &dnsChecker{servers: []string{pc.LocalAddr().String()}, recursive: true}
+	assert.NoError(t, recursive.check(ctx, "_acme-challenge.example.com.", "key"))
+	assert.True(t, recursionDesired.Load())
+}
+
+func TestDoHChecker(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "application/dns-message", r.Header.Get("Content-Type"))
+		body, err := io.ReadAll(r.Body)
+		require.NoError(t, err)
+		q := new(mdns.Msg)
+		require.NoError(t, q.Unpack(body))
+		out, err := txtAnswer(q, "key").Pack()
+		require.NoError(t, err)
+		w.Header().Set("Content-Type", "application/dns-message")
+		_, _ = w.Write(out)
+	}))
+	defer srv.Close()
+
+	checker := &dohChecker{url: srv.URL, client: srv.Client()}
+	ctx := context.Background()
+	assert.NoError(t, checker.check(ctx, "_acme-challenge.example.com.", "key"))
+	assert.ErrorContains(t, checker.check(ctx, "_acme-challenge.example.com.", "other"), "does not return")
+	assert.Error(t, checker.check(ctx, "_acme-challenge.example.org.", "key"))
+}
+
+func TestPropagationCheckerByName(t *testing.T) {
+	c := &sakuraCloudDNSProviderSolver{opts: newSolverOptions()}
+	c.opts.PropagationNameservers = []string{"ns.example.net"}
+	c.opts.PropagationResolvers = []string{"192.0.2.53"}
+	ctx := context.Background()
+
+	checker, err := c.propagationChecker(ctx, "authoritative", "example.com.")
+	require.NoError(t, err)
+	assert.Equal(t, &dnsChecker{servers: []string{"ns.example.net"}}, checker)
+
+	checker, err = c.propagationChecker(ctx, "recursive", "example.com.")
+	require.NoError(t, err)
+	assert.Equal(t, &dnsChecker{servers: []string{"192.0.2.53"}, recursive: true}, checker)
+
+	checker, err = c.propagationChecker(ctx, "doh", "example.com.")
+	require.NoError(t, err)
+	assert.IsType(t, &dohChecker{}, checker)
+
+	_, err = c.propagationChecker(ctx, "carrier-pigeon", "example.com.")
+	assert.ErrorContains(t, err, "unknown propagation checker")
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sacloud/iaas-api-go"
+	"github.com/sacloud/iaas-api-go/types"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/klog/v2"
+)
+
+// challengeRecordPrefix is the name of ACME DNS-01 challenge records,
+// relative to the zone.
+const challengeRecordPrefix = "_acme-challenge"
+
+// isChallengeRecord reports whether r is an ACME challenge record. The
+// ownership records external-dns keeps next to them are not.
+func isChallengeRecord(r *iaas.DNSRecord) bool {
+	return r.Type == types.DNSRecordTypes.TXT &&
+		(r.Name == challengeRecordPrefix || strings.HasPrefix(r.Name, challengeRecordPrefix+".")) &&
+		!isExternalDNSRecord(r)
+}
+
+// recordPruner deletes the challenge records older than --prune-age from
+// the zones in --prune-zones, whoever created them. The age of a record is
+// taken from its registry entry; records without one are aged from the
+// first time the pruner saw them, which restarts with the process.
+type recordPruner struct {
+	maxAge time.Duration
+	now    func() time.Time
+
+	mu        sync.Mutex
+	firstSeen map[string]time.Time
+}
+
+func newRecordPruner(maxAge time.Duration) *recordPruner {
+	return &recordPruner{maxAge: maxAge, now: time.Now, firstSeen: map[string]time.Time{}}
+}
+
+// seenKey identifies a record in firstSeen by the name and the key digest
+// of the record.
+func seenKey(zoneID int64, name, digest string) string {
+	return fmt.Sprintf("%d/%s/%s", zoneID, name, digest)
+}
+
+// stale returns the challenge records of zone older than maxAge, given the
+// times the records in presentedAt, keyed by seenKey, were presented.
+func (p *recordPruner) stale(zone *iaas.DNS, presentedAt map[string]time.Time) []*iaas.DNSRecord {
+	now := p.now()
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	var stale []*iaas.DNSRecord
+	seen := map[string]bool{}
+	for _, r := range zone.Records {
+		if !isChallengeRecord(r) {
+			continue
+		}
+		key := seenKey(zone.ID.Int64(), r.Name, recordDigest(r))
+		seen[key] = true
+		since, ok := presentedAt[key]
+		if !ok {
+			if since, ok = p.firstSeen[key]; !ok {
+				since = now
+				p.firstSeen[key] = now
+			}
+		}
+		if now.Sub(since) > p.maxAge {
+			stale = append(stale, r)
+		}
+	}
+	prefix := fmt.Sprintf("%d/", zone.ID.Int64())
+	for key := range p.firstSeen {
+		if strings.HasPrefix(key, prefix) && !seen[key] {
+			delete(p.firstSeen, key)
+		}
+	}
+	return stale
+}
+
+// pruneChallengeRecords prunes the zones in --prune-zones every interval
+// until stopCh is closed. With leader election only the leader prunes.
+func (c *sakuraCloudDNSProviderSolver) pruneChallengeRecords(interval time.Duration, stopCh <-chan struct{}) {
+	wait.Until(func() {
+		if c.leader != nil && !c.leader.IsLeader() {
+			return
+		}
+		ctx, cancel := context.WithTimeout(wait.ContextForChannel(stopCh), interval)
+		defer cancel()
+		entries, err := c.registry.list()
+		if err != nil {
+			sampledLog.Errorf("listing registry entries: %v", err)
+			return
+		}
+		for _, zoneID := range c.opts.PruneZones {
+			if err := c.pruneZone(ctx, zoneID, entries); err != nil {
+				sampledLog.Warningf("pruning challenge records of zone %d: %v", zoneID, err)
+			}
+		}
+	}, interval, stopCh)
+}
+
+// pruneZone deletes the stale challenge records of zoneID. The zone is read
+// and written with the config of one of its registry entries, so a zone the
+// webhook never presented a record in cannot be pruned.
+func (c *sakuraCloudDNSProviderSolver) pruneZone(ctx context.Context, zoneID int64, entries []*registryEntry) error {
+	i := slices.IndexFunc(entries, func(e *registryEntry) bool { return e.ZoneID == zoneID })
+	if i < 0 {
+		klog.V(4).Infof("no registry entry for zone %d, not pruning it", zoneID)
+		return nil
+	}
+	ch := entries[i].challengeRequest()
+	cfg, err := c.resolveConfig(ctx, ch)
+	if err != nil {
+		return err
+	}
+
+	var pruned []*iaas.DNSRecord
+	var prunedEntries []string
+	edit := &zoneEdit{apply: func(zone *iaas.DNS) (bool, error) {
+		presentedAt := map[string]time.Time{}
+		owners := map[string][]string{}
+		for _, e := range entries {
+			if e.ZoneID != zoneID {
+				continue
+			}
+			name, err := c.getEntry(e.challengeRequest(), zone)
+			if err != nil {
+				continue
+			}
+			key := seenKey(zoneID, name, e.KeyDigest)
+			if e.PresentedAt.After(presentedAt[key]) {
+				presentedAt[key] = e.PresentedAt
+			}
+			owners[key] = append(owners[key], e.id())
+		}
+		pruned = c.pruner.stale(zone, presentedAt)
+		if len(pruned) == 0 {
+			return false, nil
+		}
+		for _, r := range pruned {
+			prunedEntries = append(prunedEntries, owners[seenKey(zoneID, r.Name, recordDigest(r))]...)
+		}
+		zone.Records = slices.DeleteFunc(zone.Records, func(r *iaas.DNSRecord) bool {
+			return slices.Contains(pruned, r)
+		})
+		return true, nil
+	}}
+	c.editZone(ctx, &cfg, ch, edit)
+	if edit.err != nil {
+		return edit.err
+	}
+	if !edit.changed {
+		return nil
+	}
+
+	for _, r := range pruned {
+		klog.Warningf("pruned challenge record %s %s from zone %d, it was older than %s", r.Name, loggedRData(r), zoneID, c.pruner.maxAge)
+	}
+	recordsPrunedTotal.Add(float64(len(pruned)))
+	return c.registry.update(func(current map[string]*registryEntry) {
+		for _, id := range prunedEntries {
+			delete(current, id)
+		}
+	})
+}
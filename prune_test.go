@@ -0,0 +1,42 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/sacloud/iaas-api-go"
+	"github.com/sacloud/iaas-api-go/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIsChallengeRecord(t *testing.T) {
+	txt := types.DNSRecordTypes.TXT
+	assert.True(t, isChallengeRecord(&iaas.DNSRecord{Name: "_acme-challenge", Type: txt}))
+	assert.True(t, isChallengeRecord(&iaas.DNSRecord{Name: "_acme-challenge.www", Type: txt}))
+	assert.False(t, isChallengeRecord(&iaas.DNSRecord{Name: "_acme-challenge-not", Type: txt}))
+	assert.False(t, isChallengeRecord(&iaas.DNSRecord{Name: "_acme-challenge", Type: types.DNSRecordTypes.CNAME}))
+	assert.False(t, isChallengeRecord(&iaas.DNSRecord{Name: "_acme-challenge", Type: txt, RData: `"heritage=external-dns,external-dns/owner=default"`}),
+		"external-dns ownership records are never pruned")
+}
+
+func TestRecordPrunerStale(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	p := newRecordPruner(24 * time.Hour)
+	p.now = func() time.Time { return now }
+
+	registered := &iaas.DNSRecord{Name: "_acme-challenge", Type: types.DNSRecordTypes.TXT, RData: `"old"`}
+	unregistered := &iaas.DNSRecord{Name: "_acme-challenge.www", Type: types.DNSRecordTypes.TXT, RData: `"foreign"`}
+	other := &iaas.DNSRecord{Name: "www", Type: types.DNSRecordTypes.A, RData: "192.0.2.1"}
+	zone := &iaas.DNS{ID: 1, Records: iaas.DNSRecords{registered, unregistered, other}}
+	presentedAt := map[string]time.Time{seenKey(1, registered.Name, recordDigest(registered)): now.Add(-48 * time.Hour)}
+
+	assert.Equal(t, []*iaas.DNSRecord{registered}, p.stale(zone, presentedAt))
+
+	now = now.Add(25 * time.Hour)
+	assert.Equal(t, []*iaas.DNSRecord{registered, unregistered}, p.stale(zone, presentedAt),
+		"records missing from the registry are aged from when they were first seen")
+
+	zone.Records = iaas.DNSRecords{other}
+	assert.Empty(t, p.stale(zone, presentedAt))
+	assert.Empty(t, p.firstSeen, "records gone from the zone are forgotten")
+}
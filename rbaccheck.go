@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	authorizationv1 "k8s.io/api/authorization/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/klog/v2"
+)
+
+// secretAccessCheckNamespace returns the namespace checkSecretAccess asks
+// about: the one all Secrets are read from with --secrets-namespace, else
+// the first of --allowed-secret-namespaces that is not a pattern. Empty
+// asks about all namespaces.
+func secretAccessCheckNamespace(opts *solverOptions) string {
+	if opts.SecretsNamespace != "" {
+		return opts.SecretsNamespace
+	}
+	for _, ns := range opts.AllowedSecretNamespaces {
+		if !strings.ContainsAny(ns, `*?[\`) {
+			return ns
+		}
+	}
+	return ""
+}
+
+// checkSecretAccess asks the API server with SelfSubjectAccessReviews
+// whether the webhook may use verbs on Secrets in namespaces, and warns if
+// not: a missing RoleBinding for the credential Secrets is the most common
+// installation mistake, and otherwise only shows up once a challenge fails.
+// It never fails, as the webhook may still be allowed to read the Secrets
+// of some namespaces. An empty namespace asks about all namespaces.
+func checkSecretAccess(ctx context.Context, client kubernetes.Interface, namespaces []string, verbs ...string) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	checked, denied := false, false
+	for _, namespace := range namespaces {
+		where := "namespace " + namespace
+		if namespace == "" {
+			where = "all namespaces"
+		}
+		for _, verb := range verbs {
+			review, err := client.AuthorizationV1().SelfSubjectAccessReviews().Create(ctx, &authorizationv1.SelfSubjectAccessReview{
+				Spec: authorizationv1.SelfSubjectAccessReviewSpec{
+					ResourceAttributes: &authorizationv1.ResourceAttributes{
+						Namespace: namespace,
+						Verb:      verb,
+						Resource:  "secrets",
+					},
+				},
+			}, metav1.CreateOptions{})
+			if err != nil {
+				klog.Warningf("could not check whether the webhook may %s the credential Secrets in %s: %v", verb, where, err)
+				continue
+			}
+			checked = true
+			if review.Status.Allowed {
+				klog.V(2).Infof("the webhook may %s the credential Secrets in %s", verb, where)
+				continue
+			}
+			denied = true
+			reason := review.Status.Reason
+			if reason == "" {
+				reason = "no RBAC rule allows it"
+			}
+			klog.Warningf("the webhook may not %s Secrets in %s (%s); challenges whose credential Secrets it cannot read will fail. "+
+				"Bind the secret-reader ClusterRole of the Helm chart, or a Role allowing %s on secrets, to the service account of the webhook",
+				verb, where, reason, strings.Join(verbs, ", "))
+		}
+	}
+	if !checked {
+		return
+	}
+	if denied {
+		secretAccessDenied.Set(1)
+	} else {
+		secretAccessDenied.Set(0)
+	}
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func TestSecretAccessCheckNamespace(t *testing.T) {
+	assert.Equal(t, "", secretAccessCheckNamespace(&solverOptions{}))
+	assert.Equal(t, "tenant-a", secretAccessCheckNamespace(&solverOptions{AllowedSecretNamespaces: []string{"team-*", "tenant-a"}}))
+	assert.Equal(t, "", secretAccessCheckNamespace(&solverOptions{AllowedSecretNamespaces: []string{"team-*"}}))
+	assert.Equal(t, "credentials", secretAccessCheckNamespace(&solverOptions{SecretsNamespace: "credentials", AllowedSecretNamespaces: []string{"tenant-a"}}))
+}
+
+func TestCheckSecretAccess(t *testing.T) {
+	for _, allowed := range []bool{false, true} {
+		client := fake.NewSimpleClientset()
+		var review *authorizationv1.SelfSubjectAccessReview
+		client.PrependReactor("create", "selfsubjectaccessreviews", func(action k8stesting.Action) (bool, runtime.Object, error) {
+			review = action.(k8stesting.CreateAction).GetObject().(*authorizationv1.SelfSubjectAccessReview)
+			review.Status.Allowed = allowed
+			return true, review, nil
+		})
+
+		checkSecretAccess(context.Background(), client, []string{"tenant-a"}, "get")
+		if assert.NotNil(t, review) {
+			assert.Equal(t, authorizationv1.ResourceAttributes{Namespace: "tenant-a", Verb: "get", Resource: "secrets"}, *review.Spec.ResourceAttributes)
+		}
+		want := 1.0
+		if allowed {
+			want = 0
+		}
+		assert.Equal(t, want, testutil.ToFloat64(secretAccessDenied), "allowed=%v", allowed)
+	}
+}
+
+func TestCheckSecretAccessWatchNamespaces(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	var asked []authorizationv1.ResourceAttributes
+	client.PrependReactor("create", "selfsubjectaccessreviews", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		review := action.(k8stesting.CreateAction).GetObject().(*authorizationv1.SelfSubjectAccessReview)
+		asked = append(asked, *review.Spec.ResourceAttributes)
+		review.Status.Allowed = review.Spec.ResourceAttributes.Namespace == "tenant-a"
+		return true, review, nil
+	})
+
+	checkSecretAccess(context.Background(), client, []string{"tenant-b", "tenant-a"}, "list", "watch")
+	assert.Len(t, asked, 4)
+	assert.Equal(t, 1.0, testutil.ToFloat64(secretAccessDenied), "a namespace allowed later does not hide the denied one")
+}
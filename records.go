@@ -0,0 +1,143 @@
+package main
+
+import (
+	"cmp"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"slices"
+	"strings"
+	"unicode"
+
+	"github.com/sacloud/iaas-api-go"
+	"github.com/sacloud/iaas-api-go/types"
+)
+
+// maxTXTStringLength is the maximum length of a single TXT character-string
+// (RFC 1035 section 3.3). Longer values would have to be split into several
+// strings, which the solver never needs for ACME keys.
+const maxTXTStringLength = 255
+
+var (
+	txtEscaper   = strings.NewReplacer(`\`, `\\`, `"`, `\"`)
+	txtUnescaper = strings.NewReplacer(`\\`, `\`, `\"`, `"`)
+)
+
+// txtRData converts a challenge key into the RData of a TXT record as
+// accepted by the Sakura Cloud API. Values containing whitespace, quotes,
+// backslashes or semicolons are quoted and escaped; values that cannot be
+// represented in a single character-string are rejected with a descriptive
+// error instead of failing later in the API call.
+func txtRData(value string) (string, error) {
+	if value == "" {
+		return "", fmt.Errorf("TXT value must not be empty")
+	}
+	if len(value) > maxTXTStringLength {
+		return "", fmt.Errorf("TXT value is %d bytes long, at most %d bytes are supported", len(value), maxTXTStringLength)
+	}
+
+	quote := false
+	for i, r := range value {
+		if r > unicode.MaxASCII || !unicode.IsPrint(r) {
+			return "", fmt.Errorf("TXT value contains unsupported character %q at offset %d", r, i)
+		}
+		switch r {
+		case ' ', '"', '\\', ';':
+			quote = true
+		}
+	}
+	if !quote {
+		return value, nil
+	}
+	return `"` + txtEscaper.Replace(value) + `"`, nil
+}
+
+// txtValue returns the value held by the RData of a TXT record, undoing
+// the quoting of txtRData.
+func txtValue(rdata string) string {
+	if len(rdata) >= 2 && strings.HasPrefix(rdata, `"`) && strings.HasSuffix(rdata, `"`) {
+		return txtUnescaper.Replace(rdata[1 : len(rdata)-1])
+	}
+	return rdata
+}
+
+// keyDigest returns the hex encoded SHA-256 digest of a challenge key. The
+// registry, the caches and the logs only keep digests: records are matched
+// to challenges by digest, so key authorizations do not spread beyond the
+// zones.
+func keyDigest(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// recordDigest returns the keyDigest of the value of the TXT record r.
+func recordDigest(r *iaas.DNSRecord) string {
+	return keyDigest(txtValue(r.RData))
+}
+
+// loggedRData returns the RData of r as it may be logged: the values of
+// challenge records are replaced by the start of their digest.
+func loggedRData(r *iaas.DNSRecord) string {
+	if isChallengeRecord(r) {
+		return "sha256:" + recordDigest(r)[:12]
+	}
+	return r.RData
+}
+
+// dedupeRecords removes records that exactly duplicate (name, type, rdata
+// and TTL) an earlier record, keeping the first occurrence. Such duplicates
+// can be left behind by earlier partial operations. The slice is compacted in
+// place and the number of removed records is returned.
+func dedupeRecords(records iaas.DNSRecords) (iaas.DNSRecords, int) {
+	type recordKey struct {
+		name  string
+		typ   types.EDNSRecordType
+		rdata string
+		ttl   int
+	}
+
+	n := len(records)
+	seen := make(map[recordKey]struct{}, n)
+	records = slices.DeleteFunc(records, func(r *iaas.DNSRecord) bool {
+		k := recordKey{name: r.Name, typ: r.Type, rdata: r.RData, ttl: r.TTL}
+		if _, ok := seen[k]; ok {
+			return true
+		}
+		seen[k] = struct{}{}
+		return false
+	})
+	return records, n - len(records)
+}
+
+// sortRecords orders records by name, type, RData and TTL, in place, so
+// that the same record set is always sent in the same order: repeated
+// updates then carry byte-identical payloads, which keeps the audit log and
+// the zone dumps free of reorderings and lets the API tell an unchanged
+// record set from a changed one.
+func sortRecords(records iaas.DNSRecords) {
+	slices.SortStableFunc(records, func(a, b *iaas.DNSRecord) int {
+		if c := cmp.Compare(a.Name, b.Name); c != 0 {
+			return c
+		}
+		if c := cmp.Compare(a.Type, b.Type); c != 0 {
+			return c
+		}
+		if c := cmp.Compare(a.RData, b.RData); c != 0 {
+			return c
+		}
+		return cmp.Compare(a.TTL, b.TTL)
+	})
+}
+
+// recordLines renders records one per line as NAME TTL TYPE "RDATA", sorted,
+// so that dumps of a zone before and after an update can be diffed. RData is
+// quoted to keep every record on a single line whatever it contains, and
+// challenge records show their loggedRData.
+func recordLines(records iaas.DNSRecords) string {
+	lines := make([]string, 0, len(records))
+	for _, r := range records {
+		lines = append(lines, fmt.Sprintf("%s\t%d\t%s\t%q", r.Name, r.TTL, r.Type, loggedRData(r)))
+	}
+	slices.Sort(lines)
+	return strings.Join(lines, "\n")
+}
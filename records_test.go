@@ -0,0 +1,128 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	sctesting "github.com/cert-manager/webhook-example/pkg/testing"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/sacloud/iaas-api-go"
+	"github.com/sacloud/iaas-api-go/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTXTRData(t *testing.T) {
+	tests := []struct {
+		name    string
+		value   string
+		want    string
+		wantErr bool
+	}{
+		{name: "acme key", value: "LoqXcYV8q5ONbJQxbmR7SCTNo3tiAXDfowyjxAjEuX0", want: "LoqXcYV8q5ONbJQxbmR7SCTNo3tiAXDfowyjxAjEuX0"},
+		{name: "whitespace is quoted", value: "a b", want: `"a b"`},
+		{name: "quotes and backslashes are escaped", value: `a"b\c`, want: `"a\"b\\c"`},
+		{name: "empty", value: "", wantErr: true},
+		{name: "too long", value: string(make([]byte, 256)), wantErr: true},
+		{name: "control character", value: "a\nb", wantErr: true},
+		{name: "non ascii", value: "キー", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := txtRData(tt.value)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestDedupeRecords(t *testing.T) {
+	txt := types.DNSRecordTypes.TXT
+	records := iaas.DNSRecords{
+		{Name: "_acme-challenge", Type: txt, RData: "a", TTL: 60},
+		{Name: "www", Type: types.DNSRecordTypes.A, RData: "192.0.2.1", TTL: 300},
+		{Name: "_acme-challenge", Type: txt, RData: "a", TTL: 60},
+		{Name: "_acme-challenge", Type: txt, RData: "a", TTL: 120},
+		{Name: "_acme-challenge", Type: txt, RData: "b", TTL: 60},
+		{Name: "_acme-challenge", Type: txt, RData: "a", TTL: 60},
+	}
+
+	got, removed := dedupeRecords(records)
+	assert.Equal(t, 2, removed)
+	assert.Equal(t, iaas.DNSRecords{
+		{Name: "_acme-challenge", Type: txt, RData: "a", TTL: 60},
+		{Name: "www", Type: types.DNSRecordTypes.A, RData: "192.0.2.1", TTL: 300},
+		{Name: "_acme-challenge", Type: txt, RData: "a", TTL: 120},
+		{Name: "_acme-challenge", Type: txt, RData: "b", TTL: 60},
+	}, got)
+}
+
+func TestUpdateRecordsSortsRecords(t *testing.T) {
+	www := &iaas.DNSRecord{Name: "www", Type: types.DNSRecordTypes.A, RData: "192.0.2.1", TTL: 300}
+	mx := &iaas.DNSRecord{Name: "@", Type: types.DNSRecordTypes.MX, RData: "10 mail.example.com.", TTL: 300}
+	first := &iaas.DNSRecord{Name: "_acme-challenge", Type: types.DNSRecordTypes.TXT, RData: `"b"`, TTL: 60}
+	second := &iaas.DNSRecord{Name: "_acme-challenge", Type: types.DNSRecordTypes.TXT, RData: `"a"`, TTL: 60}
+	c := &sakuraCloudDNSProviderSolver{opts: newSolverOptions()}
+
+	var payloads []string
+	for _, records := range []iaas.DNSRecords{{www, first, mx, second}, {second, www, mx, first}} {
+		api := &recordingZoneAPI{}
+		zone := &iaas.DNS{ID: 1, Name: "example.com", SettingsHash: "hash"}
+		require.NoError(t, c.updateRecords(context.Background(), api, zone, records))
+		assert.Equal(t, iaas.DNSRecords{mx, second, first, www}, api.writes[0])
+		b, err := json.Marshal(&iaas.DNSUpdateSettingsRequest{Records: api.writes[0], SettingsHash: zone.SettingsHash})
+		require.NoError(t, err)
+		payloads = append(payloads, string(b))
+	}
+	assert.Equal(t, payloads[0], payloads[1], "the same records are sent byte for byte the same")
+}
+
+func TestRecordLines(t *testing.T) {
+	records := iaas.DNSRecords{
+		{Name: "www", Type: types.DNSRecordTypes.A, RData: "192.0.2.1", TTL: 300},
+		{Name: "_acme-challenge", Type: types.DNSRecordTypes.TXT, RData: "key", TTL: 60},
+		{Name: "note", Type: types.DNSRecordTypes.TXT, RData: "a\nb", TTL: 60},
+	}
+
+	assert.Equal(t, strings.Join([]string{
+		"_acme-challenge\t60\tTXT\t\"sha256:" + keyDigest("key")[:12] + "\"",
+		"note\t60\tTXT\t\"a\\nb\"",
+		"www\t300\tA\t\"192.0.2.1\"",
+	}, "\n"), recordLines(records))
+}
+
+func TestTXTValue(t *testing.T) {
+	for _, value := range []string{"key", "with space", `quote"and\backslash`, "semi;colon"} {
+		rdata, err := txtRData(value)
+		require.NoError(t, err)
+		assert.Equal(t, value, txtValue(rdata), rdata)
+	}
+}
+
+func TestCleanUpMissingRecord(t *testing.T) {
+	zones := sctesting.NewZoneClient(&iaas.DNS{ID: 1, Name: "example.com"})
+	c := newHarness(t, zones)
+	require.NoError(t, c.Present(harnessChallenge(0)))
+	noops := testutil.ToFloat64(noopCleanupsTotal)
+
+	// The record was deleted by hand: the zone is read, not written.
+	zones.Put(&iaas.DNS{ID: 1, Name: "example.com"})
+	reads, updates := zones.Reads(1), zones.Updates(1)
+	require.NoError(t, c.CleanUp(harnessChallenge(0)))
+	assert.Greater(t, zones.Reads(1), reads)
+	assert.Equal(t, updates, zones.Updates(1))
+	assert.Equal(t, noops+1, testutil.ToFloat64(noopCleanupsTotal))
+
+	// A cleanup that deletes the record is not counted.
+	require.NoError(t, c.Present(harnessChallenge(1)))
+	require.NoError(t, c.CleanUp(harnessChallenge(1)))
+	assert.Empty(t, zones.Zone(1).Records)
+	assert.Equal(t, noops+1, testutil.ToFloat64(noopCleanupsTotal))
+}
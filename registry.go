@@ -0,0 +1,263 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/cert-manager/cert-manager/pkg/acme/webhook/apis/acme/v1alpha1"
+	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+)
+
+// registryEntry is a challenge record the webhook wrote to a zone. It keeps
+// enough of the ChallengeRequest to clean the record up later without
+// cert-manager. The key is only kept as its keyDigest.
+type registryEntry struct {
+	Namespace    string          `json:"namespace"`
+	ZoneID       int64           `json:"zoneID"`
+	ResolvedFQDN string          `json:"resolvedFQDN"`
+	ResolvedZone string          `json:"resolvedZone"`
+	KeyDigest    string          `json:"keyDigest"`
+	Config       json.RawMessage `json:"config,omitempty"`
+	PresentedAt  time.Time       `json:"presentedAt"`
+
+	// Key is the key itself, which older versions recorded. It is replaced
+	// by KeyDigest when the entry is decoded.
+	Key string `json:"key,omitempty"`
+
+	// CleanupPending is set once CleanUp failed; the record is then
+	// deleted by the background retry loop. LastCleanupAttemptAt is when
+	// the last attempt failed.
+	CleanupPending       bool       `json:"cleanupPending,omitempty"`
+	CleanupAttempts      int        `json:"cleanupAttempts,omitempty"`
+	LastError            string     `json:"lastError,omitempty"`
+	LastCleanupAttemptAt *time.Time `json:"lastCleanupAttemptAt,omitempty"`
+
+	// Propagated is the result of the propagation check of the record, at
+	// PropagationCheckedAt, with the error in PropagationError if the
+	// record was not served in time. It is unset without a check, and until
+	// the background check of --async-propagation-check is done. Together
+	// with PresentedAt it tells how far a challenge got.
+	Propagated           *bool      `json:"propagated,omitempty"`
+	PropagationError     string     `json:"propagationError,omitempty"`
+	PropagationCheckedAt *time.Time `json:"propagationCheckedAt,omitempty"`
+
+	// ChallengeUID is the UID of the Challenge that presented the record
+	// last, and Generation orders the Presents recorded in the registry:
+	// every Present gets a higher one than the entries already recorded.
+	// A CleanUp of another Challenge with the same key is then older than
+	// the one the entry belongs to and keeps the record.
+	ChallengeUID string `json:"challengeUID,omitempty"`
+	Generation   int64  `json:"generation,omitempty"`
+
+	// Tool names the command that wrote the record, e.g. "simulate", for
+	// records written outside of cert-manager. The webhook deletes them
+	// once ExpiresAt has passed, in case the command was interrupted before
+	// it could delete the record itself.
+	Tool      string     `json:"tool,omitempty"`
+	ExpiresAt *time.Time `json:"expiresAt,omitempty"`
+}
+
+func newRegistryEntry(cfg *sakuraCloudDNSProviderConfig, ch *v1alpha1.ChallengeRequest) *registryEntry {
+	e := &registryEntry{
+		Namespace:    ch.ResourceNamespace,
+		ZoneID:       cfg.ZoneID,
+		ResolvedFQDN: ch.ResolvedFQDN,
+		ResolvedZone: ch.ResolvedZone,
+		KeyDigest:    keyDigest(ch.Key),
+		PresentedAt:  time.Now().UTC(),
+		ChallengeUID: string(ch.UID),
+	}
+	if ch.Config != nil {
+		e.Config = json.RawMessage(ch.Config.Raw)
+	}
+	return e
+}
+
+// id is the key of the entry in the registryStore.
+func (e *registryEntry) id() string {
+	sum := sha256.Sum256([]byte(fmt.Sprintf("%s/%d/%s/%s", e.Namespace, e.ZoneID, e.ResolvedFQDN, e.KeyDigest)))
+	return hex.EncodeToString(sum[:])[:16]
+}
+
+// challengeRequest rebuilds the parts of the ChallengeRequest cleanUp needs.
+// The key is unknown; cleanUp is given KeyDigest instead.
+func (e *registryEntry) challengeRequest() *v1alpha1.ChallengeRequest {
+	ch := &v1alpha1.ChallengeRequest{
+		Action:            v1alpha1.ChallengeActionCleanUp,
+		ResourceNamespace: e.Namespace,
+		ResolvedFQDN:      e.ResolvedFQDN,
+		ResolvedZone:      e.ResolvedZone,
+	}
+	if len(e.Config) > 0 {
+		ch.Config = &apiextensionsv1.JSON{Raw: e.Config}
+	}
+	return ch
+}
+
+// abandoned reports whether cert-manager is done with the record of e: its
+// cleanup failed, or it was presented more than maxAge before now.
+func (e *registryEntry) abandoned(now time.Time, maxAge time.Duration) bool {
+	return e.CleanupPending || e.expired(now) || now.Sub(e.PresentedAt) > maxAge
+}
+
+// expired reports whether the record of e was written by a command that
+// should have deleted it before now.
+func (e *registryEntry) expired(now time.Time) bool {
+	return e.ExpiresAt != nil && now.After(*e.ExpiresAt)
+}
+
+// ownershipRegistry tracks the records the webhook wrote in a
+// registryStore, selected by --registry-store. A nil registry tracks
+// nothing.
+type ownershipRegistry struct {
+	store registryStore
+}
+
+// update applies fn to the entries and writes them back, retrying on
+// conflicts with other replicas.
+func (r *ownershipRegistry) update(fn func(entries map[string]*registryEntry)) error {
+	if r == nil {
+		return nil
+	}
+	return r.store.update(fn)
+}
+
+// list returns all entries.
+func (r *ownershipRegistry) list() ([]*registryEntry, error) {
+	if r == nil {
+		return nil, nil
+	}
+	entries, err := r.store.load()
+	if err != nil {
+		return nil, err
+	}
+	list := make([]*registryEntry, 0, len(entries))
+	for _, e := range entries {
+		list = append(list, e)
+	}
+	return list, nil
+}
+
+// put records e, replacing an entry for the same record unless that one
+// has a newer generation.
+func (r *ownershipRegistry) put(e *registryEntry) error {
+	return r.update(func(entries map[string]*registryEntry) {
+		if existing, ok := entries[e.id()]; ok && existing.Generation > e.Generation {
+			return
+		}
+		entries[e.id()] = e
+	})
+}
+
+// presenting records e with the next generation before its record is
+// written, so that a CleanUp of another Challenge with the same key that
+// reads the zone after the write sees it.
+func (r *ownershipRegistry) presenting(e *registryEntry) error {
+	return r.update(func(entries map[string]*registryEntry) {
+		e.Generation = 0
+		for _, existing := range entries {
+			e.Generation = max(e.Generation, existing.Generation)
+		}
+		e.Generation++
+		entries[e.id()] = e
+	})
+}
+
+// presentedAfter returns the entry of the record of e if a Challenge other
+// than uid presented it last, i.e. with a newer generation than uid, or nil.
+// Without a uid, as for the background cleanups, it is always nil.
+func (r *ownershipRegistry) presentedAfter(e *registryEntry, uid string) (*registryEntry, error) {
+	if r == nil || uid == "" {
+		return nil, nil
+	}
+	entries, err := r.store.load()
+	if err != nil {
+		return nil, err
+	}
+	if existing, ok := entries[e.id()]; ok && existing.ChallengeUID != "" && existing.ChallengeUID != uid {
+		return existing, nil
+	}
+	return nil, nil
+}
+
+// remove forgets the record of e.
+func (r *ownershipRegistry) remove(e *registryEntry) error {
+	return r.update(func(entries map[string]*registryEntry) {
+		delete(entries, e.id())
+	})
+}
+
+// cleanupFailed marks the record of e for background cleanup.
+func (r *ownershipRegistry) cleanupFailed(e *registryEntry, cleanupErr error) error {
+	return r.update(func(entries map[string]*registryEntry) {
+		// fn may be applied again on a conflict, so e itself is never
+		// changed.
+		entry, ok := entries[e.id()]
+		if !ok {
+			copied := *e
+			entry = &copied
+		}
+		now := time.Now().UTC()
+		entry.CleanupPending = true
+		entry.CleanupAttempts++
+		entry.LastError = cleanupErr.Error()
+		entry.LastCleanupAttemptAt = &now
+		entries[e.id()] = entry
+	})
+}
+
+// verified records the result of the propagation check of e. Records that
+// were cleaned up meanwhile are not recorded again.
+func (r *ownershipRegistry) verified(e *registryEntry, propagationErr error) error {
+	return r.update(func(entries map[string]*registryEntry) {
+		existing, ok := entries[e.id()]
+		if !ok {
+			return
+		}
+		existing.propagationChecked(propagationErr)
+	})
+}
+
+// propagationChecked sets the result of the propagation check of e.
+func (e *registryEntry) propagationChecked(propagationErr error) {
+	propagated, now := propagationErr == nil, time.Now().UTC()
+	e.Propagated, e.PropagationCheckedAt = &propagated, &now
+	e.PropagationError = ""
+	if propagationErr != nil {
+		e.PropagationError = propagationErr.Error()
+	}
+}
+
+// decodeRegistryEntries decodes the entries of a registryStore, by id.
+// Entries recording the key itself are converted to its digest, under
+// their new id, and written back so on the next update.
+func decodeRegistryEntries(data map[string]string) (map[string]*registryEntry, error) {
+	entries := make(map[string]*registryEntry, len(data))
+	for k, v := range data {
+		e := &registryEntry{}
+		if err := json.Unmarshal([]byte(v), e); err != nil {
+			return nil, fmt.Errorf("decoding registry entry %s: %w", k, err)
+		}
+		if e.Key != "" {
+			e.KeyDigest, e.Key = keyDigest(e.Key), ""
+			k = e.id()
+		}
+		entries[k] = e
+	}
+	return entries, nil
+}
+
+func encodeRegistryEntries(entries map[string]*registryEntry) (map[string]string, error) {
+	data := make(map[string]string, len(entries))
+	for k, e := range entries {
+		b, err := json.Marshal(e)
+		if err != nil {
+			return nil, err
+		}
+		data[k] = string(b)
+	}
+	return data, nil
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/cert-manager/cert-manager/pkg/acme/webhook/apis/acme/v1alpha1"
+	sctesting "github.com/cert-manager/webhook-example/pkg/testing"
+	"github.com/sacloud/iaas-api-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestOwnershipRegistry(t *testing.T) {
+	r := &ownershipRegistry{store: &configMapStore{client: fake.NewSimpleClientset(), namespace: "cert-manager", name: "registry"}}
+	cfg := &sakuraCloudDNSProviderConfig{ZoneID: 1}
+	ch := &v1alpha1.ChallengeRequest{
+		ResourceNamespace: "default",
+		ResolvedFQDN:      "_acme-challenge.example.com.",
+		ResolvedZone:      "example.com.",
+		Key:               "key",
+		Config:            &apiextensionsv1.JSON{Raw: []byte(`{"zoneID":1}`)},
+	}
+
+	require.NoError(t, r.put(newRegistryEntry(cfg, ch)))
+	entries, err := r.list()
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	assert.False(t, entries[0].CleanupPending)
+
+	require.NoError(t, r.cleanupFailed(newRegistryEntry(cfg, ch), errors.New("api down")))
+	require.NoError(t, r.cleanupFailed(newRegistryEntry(cfg, ch), errors.New("api still down")))
+	entries, err = r.list()
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	assert.True(t, entries[0].CleanupPending)
+	assert.Equal(t, 2, entries[0].CleanupAttempts)
+	assert.Equal(t, "api still down", entries[0].LastError)
+	assert.NotNil(t, entries[0].LastCleanupAttemptAt)
+
+	retried := entries[0].challengeRequest()
+	assert.Equal(t, ch.ResolvedFQDN, retried.ResolvedFQDN)
+	assert.Equal(t, ch.ResolvedZone, retried.ResolvedZone)
+	assert.Empty(t, retried.Key, "the registry only keeps the digest of the key")
+	assert.Equal(t, keyDigest(ch.Key), entries[0].KeyDigest)
+	assert.JSONEq(t, string(ch.Config.Raw), string(retried.Config.Raw))
+
+	require.NoError(t, r.remove(newRegistryEntry(cfg, ch)))
+	entries, err = r.list()
+	require.NoError(t, err)
+	assert.Empty(t, entries)
+}
+
+func TestOwnershipRegistryMigratesKeys(t *testing.T) {
+	legacy := &registryEntry{Namespace: "default", ZoneID: 1, ResolvedFQDN: "_acme-challenge.example.com.", Key: "key"}
+	data, err := encodeRegistryEntries(map[string]*registryEntry{"legacy": legacy})
+	require.NoError(t, err)
+	client := fake.NewSimpleClientset(&corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "cert-manager", Name: "registry"},
+		Data:       data,
+	})
+	r := &ownershipRegistry{store: &configMapStore{client: client, namespace: "cert-manager", name: "registry"}}
+
+	entries, err := r.list()
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	assert.Empty(t, entries[0].Key)
+	assert.Equal(t, keyDigest("key"), entries[0].KeyDigest)
+
+	require.NoError(t, r.update(func(map[string]*registryEntry) {}))
+	cm, err := client.CoreV1().ConfigMaps("cert-manager").Get(context.Background(), "registry", metav1.GetOptions{})
+	require.NoError(t, err)
+	require.Contains(t, cm.Data, entries[0].id(), "the entry is stored under the id of its digest")
+	assert.NotContains(t, cm.Data[entries[0].id()], `"key":`)
+
+	cfg := &sakuraCloudDNSProviderConfig{ZoneID: 1}
+	require.NoError(t, r.remove(newRegistryEntry(cfg, &v1alpha1.ChallengeRequest{
+		ResourceNamespace: "default", ResolvedFQDN: "_acme-challenge.example.com.", Key: "key",
+	})))
+	entries, err = r.list()
+	require.NoError(t, err)
+	assert.Empty(t, entries)
+}
+
+func TestNilOwnershipRegistry(t *testing.T) {
+	var r *ownershipRegistry
+	assert.NoError(t, r.put(&registryEntry{}))
+	assert.NoError(t, r.presenting(&registryEntry{}))
+	newer, err := r.presentedAfter(&registryEntry{}, "uid")
+	assert.NoError(t, err)
+	assert.Nil(t, newer)
+	entries, err := r.list()
+	assert.NoError(t, err)
+	assert.Empty(t, entries)
+}
+
+func TestRegistryEntryAbandoned(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	fresh := &registryEntry{PresentedAt: now.Add(-time.Minute)}
+	assert.False(t, fresh.abandoned(now, time.Hour))
+
+	old := &registryEntry{PresentedAt: now.Add(-2 * time.Hour)}
+	assert.True(t, old.abandoned(now, time.Hour))
+
+	failed := &registryEntry{PresentedAt: now.Add(-time.Minute), CleanupPending: true}
+	assert.True(t, failed.abandoned(now, time.Hour))
+
+	expiresAt := now.Add(-time.Second)
+	simulated := &registryEntry{PresentedAt: now.Add(-time.Minute), Tool: "simulate", ExpiresAt: &expiresAt}
+	assert.True(t, simulated.expired(now))
+	assert.True(t, simulated.abandoned(now, time.Hour))
+	assert.False(t, simulated.expired(now.Add(-time.Minute)))
+	assert.False(t, fresh.expired(now))
+}
+
+func TestRegistryVerified(t *testing.T) {
+	r := &ownershipRegistry{store: &configMapStore{client: fake.NewSimpleClientset(), namespace: "cert-manager", name: "registry"}}
+	cfg := &sakuraCloudDNSProviderConfig{ZoneID: 1}
+	ch := &v1alpha1.ChallengeRequest{ResourceNamespace: "ns", ResolvedFQDN: "_acme-challenge.example.com.", ResolvedZone: "example.com.", Key: "key"}
+	e := newRegistryEntry(cfg, ch)
+
+	require.NoError(t, r.verified(e, nil))
+	entries, err := r.list()
+	require.NoError(t, err)
+	assert.Empty(t, entries, "records that are not registered are not recorded")
+
+	require.NoError(t, r.put(e))
+	require.NoError(t, r.verified(e, errors.New("not served")))
+	entries, err = r.list()
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	if assert.NotNil(t, entries[0].Propagated) {
+		assert.False(t, *entries[0].Propagated)
+	}
+	assert.Equal(t, "not served", entries[0].PropagationError)
+	assert.NotNil(t, entries[0].PropagationCheckedAt)
+
+	require.NoError(t, r.verified(e, nil))
+	entries, err = r.list()
+	require.NoError(t, err)
+	assert.True(t, *entries[0].Propagated)
+	assert.Empty(t, entries[0].PropagationError)
+}
+
+func TestCleanUpOfOlderChallengeKeepsRecord(t *testing.T) {
+	zones := sctesting.NewZoneClient(&iaas.DNS{ID: 1, Name: "example.com"})
+	c := newHarness(t, zones)
+	c.registry = &ownershipRegistry{store: &memoryStore{}}
+
+	older, newer := harnessChallenge(1), harnessChallenge(1)
+	older.UID, newer.UID = "older", "newer"
+	require.NoError(t, c.Present(older))
+	require.NoError(t, c.Present(newer))
+	entries, err := c.registry.list()
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	assert.Equal(t, "newer", entries[0].ChallengeUID)
+	assert.Equal(t, int64(2), entries[0].Generation)
+
+	stale := *entries[0]
+	stale.ChallengeUID, stale.Generation = "older", 1
+	require.NoError(t, c.registry.put(&stale))
+	entries, err = c.registry.list()
+	require.NoError(t, err)
+	assert.Equal(t, "newer", entries[0].ChallengeUID, "the entry of an older generation does not replace a newer one")
+
+	require.NoError(t, c.CleanUp(older))
+	assert.Len(t, zones.Zone(1).Records, 1, "the delayed CleanUp of the older Challenge keeps the record of the newer one")
+	entries, err = c.registry.list()
+	require.NoError(t, err)
+	assert.Len(t, entries, 1)
+
+	require.NoError(t, c.CleanUp(newer))
+	assert.Empty(t, zones.Zone(1).Records)
+	entries, err = c.registry.list()
+	require.NoError(t, err)
+	assert.Empty(t, entries)
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"github.com/sacloud/iaas-api-go/types"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/klog/v2"
+)
+
+// challengesResource are the cert-manager ACME Challenges.
+var challengesResource = schema.GroupVersionResource{Group: "acme.cert-manager.io", Version: "v1", Resource: "challenges"}
+
+// collectRegistryGarbage removes orphaned registry entries every interval
+// until stopCh is closed. Without it, entries of challenges whose CleanUp
+// never reached the webhook would pile up in the ConfigMap over the years.
+// With leader election only the leader collects.
+func (c *sakuraCloudDNSProviderSolver) collectRegistryGarbage(interval time.Duration, stopCh <-chan struct{}) {
+	wait.Until(func() {
+		if c.leader != nil && !c.leader.IsLeader() {
+			return
+		}
+		ctx, cancel := context.WithTimeout(wait.ContextForChannel(stopCh), interval)
+		defer cancel()
+		if err := c.collectOrphanedEntries(ctx); err != nil {
+			sampledLog.Warningf("collecting orphaned registry entries: %v", err)
+		}
+	}, interval, stopCh)
+}
+
+// collectOrphanedEntries removes the registry entries whose Challenge no
+// longer exists and whose record is no longer in the zone. Entries whose
+// cleanup failed are left to the retry loop, and entries whose record is
+// still in the zone are kept, so that the record can still be cleaned up.
+func (c *sakuraCloudDNSProviderSolver) collectOrphanedEntries(ctx context.Context) error {
+	entries, err := c.registry.list()
+	if err != nil {
+		return err
+	}
+	if len(entries) == 0 {
+		return nil
+	}
+	live, err := challengeKeys(ctx, c.dynamic)
+	if err != nil {
+		return err
+	}
+	orphaned := orphanedEntries(entries, live, func(e *registryEntry) (bool, error) {
+		return c.recordPresent(ctx, e)
+	})
+	if len(orphaned) == 0 {
+		return nil
+	}
+
+	removed := 0
+	err = c.registry.update(func(current map[string]*registryEntry) {
+		removed = 0
+		for _, e := range orphaned {
+			// The record may have been presented again meanwhile.
+			if cur, ok := current[e.id()]; ok && cur.PresentedAt.Equal(e.PresentedAt) && !cur.CleanupPending {
+				delete(current, e.id())
+				removed++
+			}
+		}
+	})
+	if err != nil {
+		return err
+	}
+	registryEntriesCollectedTotal.Add(float64(removed))
+	klog.Infof("removed %d orphaned entries from the registry", removed)
+	return nil
+}
+
+// orphanedEntries returns the entries that belong to no live Challenge,
+// identified by its key, and whose record present reports absent. Entries
+// whose record cannot be checked are kept.
+func orphanedEntries(entries []*registryEntry, live map[string]bool, present func(*registryEntry) (bool, error)) []*registryEntry {
+	var orphaned []*registryEntry
+	for _, e := range entries {
+		if e.CleanupPending || live[e.KeyDigest] {
+			continue
+		}
+		found, err := present(e)
+		if err != nil {
+			klog.V(4).Infof("not collecting the registry entry of %s in zone %d: %v", e.ResolvedFQDN, e.ZoneID, err)
+			continue
+		}
+		if found {
+			klog.V(4).Infof("the challenge of %s is gone but its record is still in zone %d, keeping its registry entry", e.ResolvedFQDN, e.ZoneID)
+			continue
+		}
+		orphaned = append(orphaned, e)
+	}
+	return orphaned
+}
+
+// challengeKeys returns the keys of all Challenges in the cluster.
+func challengeKeys(ctx context.Context, client dynamic.Interface) (map[string]bool, error) {
+	keys := map[string]bool{}
+	opts := metav1.ListOptions{Limit: 500}
+	for {
+		list, err := client.Resource(challengesResource).List(ctx, opts)
+		if err != nil {
+			return nil, err
+		}
+		for _, item := range list.Items {
+			if key, _, _ := unstructured.NestedString(item.Object, "spec", "key"); key != "" {
+				keys[keyDigest(key)] = true
+			}
+		}
+		if opts.Continue = list.GetContinue(); opts.Continue == "" {
+			return keys, nil
+		}
+	}
+}
+
+// recordPresent reports whether the record of e is still in its zone. A
+// deleted zone holds no records.
+func (c *sakuraCloudDNSProviderSolver) recordPresent(ctx context.Context, e *registryEntry) (bool, error) {
+	ch := e.challengeRequest()
+	cfg, err := c.resolveConfig(ctx, ch)
+	if err != nil {
+		return false, err
+	}
+	_, zone, err := c.readZone(ctx, &cfg, ch)
+	if isNotFoundError(err) {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	entry, err := c.getEntry(ch, zone)
+	if err != nil {
+		return false, err
+	}
+	for _, r := range zone.Records {
+		if r.Name == entry && r.Type == types.DNSRecordTypes.TXT && recordDigest(r) == e.KeyDigest {
+			return true, nil
+		}
+	}
+	return false, nil
+}
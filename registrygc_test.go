@@ -0,0 +1,58 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+)
+
+func TestOrphanedEntries(t *testing.T) {
+	live := &registryEntry{ResolvedFQDN: "_acme-challenge.live.example.com.", KeyDigest: keyDigest("live")}
+	pending := &registryEntry{ResolvedFQDN: "_acme-challenge.pending.example.com.", KeyDigest: keyDigest("pending"), CleanupPending: true}
+	leaked := &registryEntry{ResolvedFQDN: "_acme-challenge.leaked.example.com.", KeyDigest: keyDigest("leaked")}
+	unknown := &registryEntry{ResolvedFQDN: "_acme-challenge.unknown.example.com.", KeyDigest: keyDigest("unknown")}
+	gone := &registryEntry{ResolvedFQDN: "_acme-challenge.gone.example.com.", KeyDigest: keyDigest("gone")}
+
+	var checked []string
+	orphaned := orphanedEntries(
+		[]*registryEntry{live, pending, leaked, unknown, gone},
+		map[string]bool{keyDigest("live"): true},
+		func(e *registryEntry) (bool, error) {
+			checked = append(checked, e.ResolvedFQDN)
+			switch e {
+			case leaked:
+				return true, nil
+			case unknown:
+				return false, errors.New("API unavailable")
+			}
+			return false, nil
+		},
+	)
+	assert.Equal(t, []*registryEntry{gone}, orphaned)
+	assert.Equal(t, []string{leaked.ResolvedFQDN, unknown.ResolvedFQDN, gone.ResolvedFQDN}, checked, "the zone is only read for entries without a Challenge")
+}
+
+func TestChallengeKeys(t *testing.T) {
+	challenge := func(ns, name, key string) runtime.Object {
+		return &unstructured.Unstructured{Object: map[string]interface{}{
+			"apiVersion": "acme.cert-manager.io/v1",
+			"kind":       "Challenge",
+			"metadata":   map[string]interface{}{"namespace": ns, "name": name},
+			"spec":       map[string]interface{}{"key": key},
+		}}
+	}
+	client := dynamicfake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(),
+		map[schema.GroupVersionResource]string{challengesResource: "ChallengeList"},
+		challenge("a", "one", "key-1"), challenge("b", "two", "key-2"))
+
+	keys, err := challengeKeys(context.Background(), client)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]bool{keyDigest("key-1"): true, keyDigest("key-2"): true}, keys)
+}
@@ -0,0 +1,378 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/util/retry"
+)
+
+// registryStore keeps the entries of the ownershipRegistry. New stores only
+// have to implement it and be added to registryStores.
+type registryStore interface {
+	// update applies fn to the entries and saves them, retrying on
+	// conflicts with other replicas.
+	update(fn func(entries map[string]*registryEntry)) error
+	// load returns the entries by id.
+	load() (map[string]*registryEntry, error)
+}
+
+// registryStores are the values of --registry-store.
+var registryStores = []string{"configmap", "crd", "memory"}
+
+// challengeRecordsResource is the SakuraCloudChallengeRecord custom resource
+// of the crd registry store, installed by the Helm chart.
+var challengeRecordsResource = schema.GroupVersionResource{
+	Group:    "webhook.sakuracloud.ophum.github.io",
+	Version:  "v1alpha1",
+	Resource: "sakuracloudchallengerecords",
+}
+
+// newRegistryStore returns the store of --registry-store.
+func (c *sakuraCloudDNSProviderSolver) newRegistryStore(client kubernetes.Interface, ns string) (registryStore, error) {
+	switch c.opts.RegistryStore {
+	case "", "configmap":
+		return &configMapStore{client: client, namespace: ns, name: c.opts.RegistryConfigMap}, nil
+	case "crd":
+		return &crdStore{client: c.dynamic, namespace: ns}, nil
+	case "memory":
+		return &memoryStore{}, nil
+	}
+	return nil, fmt.Errorf("unknown registry store %q, use one of %s", c.opts.RegistryStore, strings.Join(registryStores, ", "))
+}
+
+// configMapStore keeps the entries in a ConfigMap, one JSON encoded entry per
+// key. All entries share the size limit of one object, so it suits clusters
+// with a few hundred challenges at a time.
+type configMapStore struct {
+	client    kubernetes.Interface
+	namespace string
+	name      string
+}
+
+func (s *configMapStore) update(fn func(entries map[string]*registryEntry)) error {
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		cm, err := s.client.CoreV1().ConfigMaps(s.namespace).Get(context.TODO(), s.name, metav1.GetOptions{})
+		create := apierrors.IsNotFound(err)
+		if create {
+			cm = &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Namespace: s.namespace, Name: s.name}}
+		} else if err != nil {
+			return err
+		}
+
+		entries, err := decodeRegistryEntries(cm.Data)
+		if err != nil {
+			return err
+		}
+		fn(entries)
+		if cm.Data, err = encodeRegistryEntries(entries); err != nil {
+			return err
+		}
+
+		if create {
+			_, err = s.client.CoreV1().ConfigMaps(s.namespace).Create(context.TODO(), cm, metav1.CreateOptions{})
+			if apierrors.IsAlreadyExists(err) {
+				return apierrors.NewConflict(corev1.Resource("configmaps"), s.name, err)
+			}
+			return err
+		}
+		_, err = s.client.CoreV1().ConfigMaps(s.namespace).Update(context.TODO(), cm, metav1.UpdateOptions{})
+		return err
+	})
+}
+
+func (s *configMapStore) load() (map[string]*registryEntry, error) {
+	cm, err := s.client.CoreV1().ConfigMaps(s.namespace).Get(context.TODO(), s.name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	return decodeRegistryEntries(cm.Data)
+}
+
+// memoryStore keeps the entries in the memory of the replica. They are lost
+// on restart and not shared between replicas, so failed cleanups are only
+// retried by the replica they failed on, for small clusters that would
+// rather not keep state in the API server.
+type memoryStore struct {
+	mu sync.Mutex
+	// data holds the encoded entries, so that callers never share them.
+	data map[string]string
+}
+
+func (s *memoryStore) update(fn func(entries map[string]*registryEntry)) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	entries, err := decodeRegistryEntries(s.data)
+	if err != nil {
+		return err
+	}
+	fn(entries)
+	data, err := encodeRegistryEntries(entries)
+	if err != nil {
+		return err
+	}
+	s.data = data
+	return nil
+}
+
+func (s *memoryStore) load() (map[string]*registryEntry, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return decodeRegistryEntries(s.data)
+}
+
+// crdStore keeps every entry in its own SakuraCloudChallengeRecord, named by
+// its id, so the number of entries is not limited by the size of one object,
+// updates of different records do not conflict, and the records can be
+// listed with kubectl.
+type crdStore struct {
+	client    dynamic.Interface
+	namespace string
+}
+
+// crdRecord is a SakuraCloudChallengeRecord and its entry, encoded from
+// its spec and status.
+type crdRecord struct {
+	obj  *unstructured.Unstructured
+	data string
+}
+
+// crdStatusFields are the fields of a registryEntry kept in the status of
+// its SakuraCloudChallengeRecord: how far the challenge got, as opposed to
+// the record it is about, which is kept in the spec.
+var crdStatusFields = []string{
+	"propagated", "propagationError", "propagationCheckedAt",
+	"cleanupPending", "cleanupAttempts", "lastError", "lastCleanupAttemptAt",
+}
+
+func (s *crdStore) records() (map[string]crdRecord, error) {
+	list, err := s.client.Resource(challengeRecordsResource).Namespace(s.namespace).List(context.TODO(), metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+	records := make(map[string]crdRecord, len(list.Items))
+	for i := range list.Items {
+		r, err := decodeCRDRecord(&list.Items[i])
+		if err != nil {
+			return nil, err
+		}
+		records[r.obj.GetName()] = r
+	}
+	return records, nil
+}
+
+// record returns the SakuraCloudChallengeRecord of id, or false if there is
+// none.
+func (s *crdStore) record(id string) (crdRecord, bool, error) {
+	obj, err := s.client.Resource(challengeRecordsResource).Namespace(s.namespace).Get(context.TODO(), id, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return crdRecord{}, false, nil
+	} else if err != nil {
+		return crdRecord{}, false, err
+	}
+	r, err := decodeCRDRecord(obj)
+	return r, err == nil, err
+}
+
+// decodeCRDRecord encodes the spec and status of obj as its entry, to be
+// compared with the entries written back by update.
+func decodeCRDRecord(obj *unstructured.Unstructured) (crdRecord, error) {
+	fields := map[string]interface{}{}
+	for _, part := range []string{"spec", "status"} {
+		m, _, _ := unstructured.NestedFieldNoCopy(obj.Object, part)
+		if m, ok := m.(map[string]interface{}); ok {
+			for k, v := range m {
+				fields[k] = v
+			}
+		}
+	}
+	b, err := json.Marshal(fields)
+	if err == nil {
+		e := &registryEntry{}
+		if err = json.Unmarshal(b, e); err == nil {
+			b, err = json.Marshal(e)
+		}
+	}
+	if err != nil {
+		return crdRecord{}, fmt.Errorf("decoding SakuraCloudChallengeRecord %s: %w", obj.GetName(), err)
+	}
+	return crdRecord{obj: obj, data: string(b)}, nil
+}
+
+func (s *crdStore) load() (map[string]*registryEntry, error) {
+	records, err := s.records()
+	if err != nil {
+		return nil, err
+	}
+	return decodeRegistryEntries(crdData(records))
+}
+
+// crdData returns the encoded entries of records, by id.
+func crdData(records map[string]crdRecord) map[string]string {
+	data := make(map[string]string, len(records))
+	for id, r := range records {
+		data[id] = r.data
+	}
+	return data
+}
+
+// applyToRecords applies fn to the entries of records and returns them encoded.
+func applyToRecords(records map[string]crdRecord, fn func(entries map[string]*registryEntry)) (map[string]string, error) {
+	entries, err := decodeRegistryEntries(crdData(records))
+	if err != nil {
+		return nil, err
+	}
+	fn(entries)
+	return encodeRegistryEntries(entries)
+}
+
+// update writes only the records whose entry fn changed, each guarded by
+// its resource version. On a conflict, only the conflicting record is read
+// again and fn applied to it once more: the records already written keep
+// the result of the first application, so that fn is applied once to each
+// record, and e.g. a failed cleanup is not counted twice.
+func (s *crdStore) update(fn func(entries map[string]*registryEntry)) error {
+	records, err := s.records()
+	if err != nil {
+		return err
+	}
+	data, err := applyToRecords(records, fn)
+	if err != nil {
+		return err
+	}
+
+	ids := make([]string, 0, len(data))
+	for id, entry := range data {
+		if r, ok := records[id]; !ok || r.data != entry {
+			ids = append(ids, id)
+		}
+	}
+	for id := range records {
+		if _, ok := data[id]; !ok {
+			ids = append(ids, id)
+		}
+	}
+	slices.Sort(ids)
+
+	for _, id := range ids {
+		reread := false
+		err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+			if reread {
+				r, exists, err := s.record(id)
+				if err != nil {
+					return err
+				}
+				delete(records, id)
+				if exists {
+					records[id] = r
+				}
+				if data, err = applyToRecords(records, fn); err != nil {
+					return err
+				}
+			}
+			reread = true
+			r, exists := records[id]
+			entry, keep := data[id]
+			return s.write(id, r, exists, entry, keep)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// write makes the SakuraCloudChallengeRecord of id hold the encoded entry,
+// or deletes it unless keep. r is the record as read, if it exists. The
+// spec and the status are written separately, as the API server ignores
+// the status on updates of the spec and the other way round.
+func (s *crdStore) write(id string, r crdRecord, exists bool, entry string, keep bool) error {
+	client := s.client.Resource(challengeRecordsResource).Namespace(s.namespace)
+	switch {
+	case !keep && !exists, keep && exists && r.data == entry:
+		return nil
+	case !keep:
+		rv := r.obj.GetResourceVersion()
+		err := client.Delete(context.TODO(), id, metav1.DeleteOptions{Preconditions: &metav1.Preconditions{ResourceVersion: &rv}})
+		if apierrors.IsNotFound(err) {
+			return nil
+		}
+		return err
+	}
+
+	spec, status, err := splitCRDEntry(entry)
+	if err != nil {
+		return err
+	}
+	obj := r.obj
+	if !exists {
+		obj = &unstructured.Unstructured{}
+		obj.SetAPIVersion(challengeRecordsResource.GroupVersion().String())
+		obj.SetKind("SakuraCloudChallengeRecord")
+		obj.SetNamespace(s.namespace)
+		obj.SetName(id)
+		obj.Object["spec"] = spec
+		obj, err = client.Create(context.TODO(), obj, metav1.CreateOptions{})
+		if apierrors.IsAlreadyExists(err) {
+			return apierrors.NewConflict(challengeRecordsResource.GroupResource(), id, err)
+		} else if err != nil {
+			return err
+		}
+	} else if !sameCRDField(obj, "spec", spec) {
+		obj = obj.DeepCopy()
+		obj.Object["spec"] = spec
+		if obj, err = client.Update(context.TODO(), obj, metav1.UpdateOptions{}); err != nil {
+			return err
+		}
+	}
+
+	if sameCRDField(obj, "status", status) {
+		return nil
+	}
+	obj = obj.DeepCopy()
+	obj.Object["status"] = status
+	_, err = client.UpdateStatus(context.TODO(), obj, metav1.UpdateOptions{})
+	return err
+}
+
+// splitCRDEntry splits the encoded entry into the spec and the status of its
+// SakuraCloudChallengeRecord.
+func splitCRDEntry(entry string) (spec, status map[string]interface{}, err error) {
+	if err := json.Unmarshal([]byte(entry), &spec); err != nil {
+		return nil, nil, err
+	}
+	status = map[string]interface{}{}
+	for _, f := range crdStatusFields {
+		if v, ok := spec[f]; ok {
+			status[f] = v
+			delete(spec, f)
+		}
+	}
+	return spec, status, nil
+}
+
+// sameCRDField reports whether field of obj, spec or status, already holds
+// want. They are compared encoded, as obj holds the numbers as read from
+// the API server.
+func sameCRDField(obj *unstructured.Unstructured, field string, want map[string]interface{}) bool {
+	have, _, _ := unstructured.NestedFieldNoCopy(obj.Object, field)
+	if have == nil {
+		have = map[string]interface{}{}
+	}
+	a, errA := json.Marshal(have)
+	b, errB := json.Marshal(want)
+	return errA == nil && errB == nil && string(a) == string(b)
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/cert-manager/cert-manager/pkg/acme/webhook/apis/acme/v1alpha1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func newFakeChallengeRecordsClient() *dynamicfake.FakeDynamicClient {
+	return dynamicfake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(),
+		map[schema.GroupVersionResource]string{challengeRecordsResource: "SakuraCloudChallengeRecordList"})
+}
+
+func TestRegistryStores(t *testing.T) {
+	stores := map[string]func() registryStore{
+		"memory": func() registryStore { return &memoryStore{} },
+		"crd": func() registryStore {
+			return &crdStore{client: newFakeChallengeRecordsClient(), namespace: "cert-manager"}
+		},
+	}
+	for name, newStore := range stores {
+		t.Run(name, func(t *testing.T) {
+			r := &ownershipRegistry{store: newStore()}
+			cfg := &sakuraCloudDNSProviderConfig{ZoneID: 1}
+			ch := func(key string) *v1alpha1.ChallengeRequest {
+				return &v1alpha1.ChallengeRequest{ResourceNamespace: "default", ResolvedFQDN: "_acme-challenge.example.com.", Key: key}
+			}
+
+			require.NoError(t, r.put(newRegistryEntry(cfg, ch("one"))))
+			require.NoError(t, r.put(newRegistryEntry(cfg, ch("two"))))
+			require.NoError(t, r.cleanupFailed(newRegistryEntry(cfg, ch("one")), errors.New("api down")))
+			entries, err := r.list()
+			require.NoError(t, err)
+			require.Len(t, entries, 2)
+			for _, e := range entries {
+				assert.Equal(t, e.KeyDigest == keyDigest("one"), e.CleanupPending)
+			}
+
+			// Entries handed out are copies; changing them does not change
+			// the store.
+			entries[0].LastError = "changed"
+			entries, err = r.list()
+			require.NoError(t, err)
+			for _, e := range entries {
+				assert.NotEqual(t, "changed", e.LastError)
+			}
+
+			require.NoError(t, r.remove(newRegistryEntry(cfg, ch("one"))))
+			entries, err = r.list()
+			require.NoError(t, err)
+			require.Len(t, entries, 1)
+			assert.Equal(t, keyDigest("two"), entries[0].KeyDigest)
+		})
+	}
+}
+
+func TestCRDStoreWritesChangedRecords(t *testing.T) {
+	client := newFakeChallengeRecordsClient()
+	r := &ownershipRegistry{store: &crdStore{client: client, namespace: "cert-manager"}}
+	cfg := &sakuraCloudDNSProviderConfig{ZoneID: 1}
+	one := newRegistryEntry(cfg, &v1alpha1.ChallengeRequest{ResourceNamespace: "default", ResolvedFQDN: "_acme-challenge.example.com.", Key: "one"})
+	two := newRegistryEntry(cfg, &v1alpha1.ChallengeRequest{ResourceNamespace: "default", ResolvedFQDN: "_acme-challenge.example.com.", Key: "two"})
+	require.NoError(t, r.put(one))
+	require.NoError(t, r.put(two))
+
+	obj, err := client.Resource(challengeRecordsResource).Namespace("cert-manager").Get(context.Background(), one.id(), metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, "SakuraCloudChallengeRecord", obj.GetKind())
+	assert.Equal(t, one.ResolvedFQDN, obj.Object["spec"].(map[string]interface{})["resolvedFQDN"])
+
+	writes := func() []string {
+		var writes []string
+		for _, action := range client.Actions() {
+			if action.GetVerb() != "list" {
+				writes = append(writes, action.GetVerb()+"/"+action.GetSubresource())
+			}
+		}
+		client.ClearActions()
+		return writes
+	}
+
+	client.ClearActions()
+	require.NoError(t, r.verified(two, nil))
+	assert.Equal(t, []string{"update/status"}, writes(), "only the status of the record of two changed")
+
+	again := *two
+	again.PresentedAt = two.PresentedAt.Add(time.Minute)
+	require.NoError(t, r.put(&again))
+	assert.Equal(t, []string{"update/", "update/status"}, writes(), "presented again, without a propagation check")
+}
+
+func TestCRDStoreStatus(t *testing.T) {
+	client := newFakeChallengeRecordsClient()
+	store := &crdStore{client: client, namespace: "cert-manager"}
+	r := &ownershipRegistry{store: store}
+	cfg := &sakuraCloudDNSProviderConfig{ZoneID: 1}
+	e := newRegistryEntry(cfg, &v1alpha1.ChallengeRequest{ResourceNamespace: "default", ResolvedFQDN: "_acme-challenge.example.com.", Key: "one"})
+	require.NoError(t, r.put(e))
+	require.NoError(t, r.verified(e, nil))
+	require.NoError(t, r.cleanupFailed(e, errors.New("api down")))
+
+	obj, err := client.Resource(challengeRecordsResource).Namespace("cert-manager").Get(context.Background(), e.id(), metav1.GetOptions{})
+	require.NoError(t, err)
+	spec, _, _ := unstructured.NestedMap(obj.Object, "spec")
+	status, _, _ := unstructured.NestedMap(obj.Object, "status")
+	assert.Equal(t, e.ResolvedFQDN, spec["resolvedFQDN"])
+	assert.NotContains(t, spec, "propagated")
+	assert.Equal(t, true, status["propagated"])
+	assert.Contains(t, status, "propagationCheckedAt")
+	assert.Equal(t, true, status["cleanupPending"])
+	assert.Equal(t, "api down", status["lastError"])
+	assert.Contains(t, status, "lastCleanupAttemptAt")
+
+	entries, err := store.load()
+	require.NoError(t, err)
+	require.Contains(t, entries, e.id())
+	assert.True(t, *entries[e.id()].Propagated)
+	assert.Equal(t, 1, entries[e.id()].CleanupAttempts)
+
+	// Records written before the status moved out of the spec are read
+	// from the spec, and moved to the status on the next update.
+	legacy := obj.DeepCopy()
+	delete(legacy.Object, "status")
+	require.NoError(t, unstructured.SetNestedField(legacy.Object, true, "spec", "cleanupPending"))
+	require.NoError(t, unstructured.SetNestedField(legacy.Object, int64(3), "spec", "cleanupAttempts"))
+	require.NoError(t, client.Tracker().Update(challengeRecordsResource, legacy, "cert-manager"))
+	require.NoError(t, r.cleanupFailed(e, errors.New("still down")))
+	obj, err = client.Resource(challengeRecordsResource).Namespace("cert-manager").Get(context.Background(), e.id(), metav1.GetOptions{})
+	require.NoError(t, err)
+	spec, _, _ = unstructured.NestedMap(obj.Object, "spec")
+	status, _, _ = unstructured.NestedMap(obj.Object, "status")
+	assert.NotContains(t, spec, "cleanupAttempts")
+	assert.EqualValues(t, 4, status["cleanupAttempts"])
+}
+
+func TestCRDStoreConflict(t *testing.T) {
+	client := newFakeChallengeRecordsClient()
+	store := &crdStore{client: client, namespace: "cert-manager"}
+	r := &ownershipRegistry{store: store}
+	cfg := &sakuraCloudDNSProviderConfig{ZoneID: 1}
+	one := newRegistryEntry(cfg, &v1alpha1.ChallengeRequest{ResourceNamespace: "default", ResolvedFQDN: "_acme-challenge.example.com.", Key: "one"})
+	two := newRegistryEntry(cfg, &v1alpha1.ChallengeRequest{ResourceNamespace: "default", ResolvedFQDN: "_acme-challenge.example.com.", Key: "two"})
+	require.NoError(t, r.put(one))
+	require.NoError(t, r.put(two))
+
+	// Another replica changes the record written last right before it is
+	// updated, which then conflicts.
+	early, late := min(one.id(), two.id()), max(one.id(), two.id())
+	conflicted := false
+	client.PrependReactor("update", "sakuracloudchallengerecords", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		obj := action.(k8stesting.UpdateAction).GetObject().(*unstructured.Unstructured)
+		if obj.GetName() != late || conflicted {
+			return false, nil, nil
+		}
+		conflicted = true
+		other, err := client.Tracker().Get(challengeRecordsResource, "cert-manager", late)
+		require.NoError(t, err)
+		other = other.DeepCopyObject()
+		require.NoError(t, unstructured.SetNestedField(other.(*unstructured.Unstructured).Object, "written by another replica", "spec", "tool"))
+		require.NoError(t, client.Tracker().Update(challengeRecordsResource, other, "cert-manager"))
+		return true, nil, apierrors.NewConflict(challengeRecordsResource.GroupResource(), late, errors.New("stale"))
+	})
+
+	require.NoError(t, r.update(func(entries map[string]*registryEntry) {
+		for _, e := range entries {
+			e.CleanupAttempts++
+		}
+	}))
+	assert.True(t, conflicted)
+	entries, err := store.load()
+	require.NoError(t, err)
+	assert.Equal(t, 1, entries[early].CleanupAttempts, "the record written before the conflict is not changed twice")
+	assert.Equal(t, 1, entries[late].CleanupAttempts)
+	assert.Equal(t, "written by another replica", entries[late].Tool, "the conflicting record is read again")
+}
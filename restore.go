@@ -0,0 +1,137 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/sacloud/iaas-api-go"
+	"github.com/sacloud/iaas-api-go/types"
+)
+
+// runRestore implements the `restore` command. It re-applies the record set
+// of a zone snapshot to the zone, e.g. after a bug or race damaged the zone.
+// Without --apply it only prints the plan of the records that would be
+// added, changed and deleted, as text or, with --output=json, as JSON.
+func runRestore(args []string) error {
+	return restore(args, os.Stdin, os.Stdout, func(accessToken, accessTokenSecret string) zoneAPI {
+		return iaas.NewDNSOp(newAPICaller(accessToken, accessTokenSecret))
+	})
+}
+
+func restore(args []string, in io.Reader, out io.Writer, newZoneAPI func(accessToken, accessTokenSecret string) zoneAPI) error {
+	fs := flag.NewFlagSet("restore", flag.ContinueOnError)
+	profile := fs.String("profile", "", "usacloud profile name to read the API key from (defaults to the current profile)")
+	snapshotPath := fs.String("snapshot", "-", "snapshot file to restore, - reads it from stdin")
+	zoneID := fs.Int64("zone-id", 0, "DNS zone to restore, must match the zone of the snapshot")
+	apply := fs.Bool("apply", false, "write the snapshot to the zone instead of only printing the changes")
+	allowEmpty := fs.Bool("allow-empty", false, "allow restoring a snapshot without records, which deletes every record of the zone")
+	output := fs.String("output", "text", "format of the plan: text, or json for pipelines; with json nothing else is printed")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *output != "text" && *output != "json" {
+		return fmt.Errorf("unknown --output %q, use text or json", *output)
+	}
+
+	snapshot, err := openZoneSnapshot(*snapshotPath, in)
+	if err != nil {
+		return err
+	}
+	if *zoneID == 0 {
+		return errors.New("--zone-id is required")
+	}
+	if snapshot.ZoneID != *zoneID {
+		return fmt.Errorf("the snapshot is of zone %d, not %d", snapshot.ZoneID, *zoneID)
+	}
+	if len(snapshot.Records) == 0 && !*allowEmpty {
+		return errors.New("the snapshot has no records, pass --allow-empty to delete every record of the zone")
+	}
+
+	accessToken, accessTokenSecret, err := profileAPIKey(*profile)
+	if err != nil {
+		return err
+	}
+	api := newZoneAPI(accessToken, accessTokenSecret)
+
+	ctx := context.Background()
+	zone, err := api.Read(ctx, types.Int64ID(*zoneID))
+	if err != nil {
+		return fmt.Errorf("reading zone %d: %w", *zoneID, err)
+	}
+	if zone.Name != snapshot.ZoneName {
+		return fmt.Errorf("the snapshot is of zone %s, but zone %d is %s", snapshot.ZoneName, *zoneID, zone.Name)
+	}
+
+	records := snapshot.records()
+	plan := planZone(zone, records)
+	text := out
+	if *output == "json" {
+		if err := plan.writeJSON(out); err != nil {
+			return err
+		}
+		text = io.Discard
+	}
+	if len(plan.Changes) == 0 {
+		fmt.Fprintf(text, "zone %s already matches the snapshot taken at %s\n", zone.Name, snapshot.TakenAt)
+		return nil
+	}
+	plan.writeText(text)
+	if !*apply {
+		fmt.Fprintln(text, "dry run, pass --apply to restore the snapshot")
+		return nil
+	}
+
+	// SettingsHash makes the update fail if the zone changed since it was
+	// read above.
+	if _, err := api.UpdateSettings(ctx, zone.ID, &iaas.DNSUpdateSettingsRequest{
+		Records:      records,
+		SettingsHash: zone.SettingsHash,
+	}); err != nil {
+		return fmt.Errorf("updating zone %s: %w", zone.Name, err)
+	}
+	fmt.Fprintf(text, "restored zone %s to the snapshot taken at %s\n", zone.Name, snapshot.TakenAt)
+	return nil
+}
+
+func openZoneSnapshot(path string, stdin io.Reader) (*zoneSnapshot, error) {
+	if path == "-" {
+		return readZoneSnapshot(stdin)
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return readZoneSnapshot(f)
+}
+
+// diffRecords returns the records only in current and only in target, in
+// the format of recordLines.
+func diffRecords(current, target iaas.DNSRecords) (removed, added []string) {
+	lines := func(records iaas.DNSRecords) map[string]bool {
+		m := map[string]bool{}
+		if s := recordLines(records); s != "" {
+			for _, l := range strings.Split(s, "\n") {
+				m[l] = true
+			}
+		}
+		return m
+	}
+	currentLines, targetLines := lines(current), lines(target)
+	for _, l := range strings.Split(recordLines(current), "\n") {
+		if l != "" && !targetLines[l] {
+			removed = append(removed, l)
+		}
+	}
+	for _, l := range strings.Split(recordLines(target), "\n") {
+		if l != "" && !currentLines[l] {
+			added = append(added, l)
+		}
+	}
+	return removed, added
+}
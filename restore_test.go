@@ -0,0 +1,93 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/sacloud/iaas-api-go"
+	"github.com/sacloud/iaas-api-go/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type staticZoneAPI struct {
+	zone    *iaas.DNS
+	updated *iaas.DNSUpdateSettingsRequest
+}
+
+func (a *staticZoneAPI) Read(context.Context, types.ID) (*iaas.DNS, error) {
+	return a.zone, nil
+}
+
+func (a *staticZoneAPI) UpdateSettings(_ context.Context, _ types.ID, param *iaas.DNSUpdateSettingsRequest) (*iaas.DNS, error) {
+	a.updated = param
+	return a.zone, nil
+}
+
+func TestRestore(t *testing.T) {
+	t.Setenv("SAKURACLOUD_ACCESS_TOKEN", "token")
+	t.Setenv("SAKURACLOUD_ACCESS_TOKEN_SECRET", "secret")
+
+	snapshotZone := &iaas.DNS{ID: 1, Name: "example.com", Records: iaas.DNSRecords{
+		{Name: "www", Type: types.DNSRecordTypes.A, RData: "192.0.2.1", TTL: 300},
+		{Name: "mail", Type: types.DNSRecordTypes.A, RData: "192.0.2.2", TTL: 300},
+	}}
+	snapshot, err := json.Marshal(newZoneSnapshot(snapshotZone, time.Now()))
+	require.NoError(t, err)
+
+	newAPI := func(api *staticZoneAPI) func(string, string) zoneAPI {
+		return func(string, string) zoneAPI { return api }
+	}
+	damaged := func() *staticZoneAPI {
+		return &staticZoneAPI{zone: &iaas.DNS{ID: 1, Name: "example.com", SettingsHash: "h", Records: iaas.DNSRecords{
+			{Name: "www", Type: types.DNSRecordTypes.A, RData: "192.0.2.9", TTL: 300},
+		}}}
+	}
+
+	t.Run("dry run", func(t *testing.T) {
+		api := damaged()
+		var out bytes.Buffer
+		require.NoError(t, restore([]string{"--zone-id=1"}, bytes.NewReader(snapshot), &out, newAPI(api)))
+		assert.Nil(t, api.updated)
+		assert.Contains(t, out.String(), "zone example.com (1): 1 to add, 1 to change, 0 to delete")
+		assert.Contains(t, out.String(), "+ mail\tA\t300 \"192.0.2.2\"")
+		assert.Contains(t, out.String(), "~ www\tA\t300 \"192.0.2.9\" -> 300 \"192.0.2.1\"")
+	})
+
+	t.Run("json plan", func(t *testing.T) {
+		api := damaged()
+		var out bytes.Buffer
+		require.NoError(t, restore([]string{"--zone-id=1", "--output=json"}, bytes.NewReader(snapshot), &out, newAPI(api)))
+		assert.Nil(t, api.updated)
+		assert.JSONEq(t, `{"zoneID": 1, "zone": "example.com", "changes": [
+			{"action": "add", "name": "mail", "type": "A", "after": {"rdata": "192.0.2.2", "ttl": 300}},
+			{"action": "change", "name": "www", "type": "A", "before": {"rdata": "192.0.2.9", "ttl": 300}, "after": {"rdata": "192.0.2.1", "ttl": 300}}
+		]}`, out.String(), "nothing but the plan is printed")
+	})
+
+	t.Run("apply", func(t *testing.T) {
+		api := damaged()
+		var out bytes.Buffer
+		require.NoError(t, restore([]string{"--zone-id=1", "--apply"}, bytes.NewReader(snapshot), &out, newAPI(api)))
+		if assert.NotNil(t, api.updated) {
+			assert.Equal(t, "h", api.updated.SettingsHash)
+			assert.Equal(t, recordLines(snapshotZone.Records), recordLines(api.updated.Records))
+		}
+	})
+
+	t.Run("zone mismatch", func(t *testing.T) {
+		api := damaged()
+		err := restore([]string{"--zone-id=2", "--apply"}, bytes.NewReader(snapshot), &bytes.Buffer{}, newAPI(api))
+		assert.ErrorContains(t, err, "not 2")
+		assert.Nil(t, api.updated)
+	})
+
+	t.Run("corrupt snapshot", func(t *testing.T) {
+		corrupt := bytes.Replace(snapshot, []byte("192.0.2.2"), []byte("192.0.2.3"), 1)
+		err := restore([]string{"--zone-id=1"}, bytes.NewReader(corrupt), &bytes.Buffer{}, newAPI(damaged()))
+		assert.ErrorContains(t, err, "corrupt")
+	})
+}
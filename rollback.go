@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/sacloud/iaas-api-go"
+	"k8s.io/klog/v2"
+)
+
+// verifyUpdate reads zone back after its records were replaced with want
+// and reports how the zone differs from what was written.
+func verifyUpdate(ctx context.Context, client zoneAPI, zone *iaas.DNS, want iaas.DNSRecords) (*iaas.DNS, error) {
+	got, err := client.Read(ctx, zone.ID)
+	if err != nil {
+		return nil, fmt.Errorf("reading zone %s back: %w", zone.Name, err)
+	}
+	missing, unexpected := diffRecords(want, got.Records)
+	if len(missing) == 0 && len(unexpected) == 0 {
+		return got, nil
+	}
+	var diff []string
+	for _, l := range missing {
+		diff = append(diff, "- "+l)
+	}
+	for _, l := range unexpected {
+		diff = append(diff, "+ "+l)
+	}
+	return got, fmt.Errorf("zone %s does not hold the written records:\n%s", zone.Name, strings.Join(diff, "\n"))
+}
+
+// updateRecordsVerified replaces the records of zone like updateRecords,
+// then reads the zone back. If the zone is not in the state that was
+// written, the edits, the difference between original, a copy of the
+// records before them, and the written records, are undone on top of the
+// records read back: a mismatch usually means another writer changed the
+// zone meanwhile, and its changes are kept. A nil original (without
+// --verify-zone-updates) skips the verification.
+func (c *sakuraCloudDNSProviderSolver) updateRecordsVerified(ctx context.Context, client zoneAPI, zone *iaas.DNS, original iaas.DNSRecords) error {
+	if err := c.updateRecords(ctx, client, zone, zone.Records); err != nil {
+		return err
+	}
+	if original == nil {
+		return nil
+	}
+
+	got, verr := verifyUpdate(withAPICallKind(ctx, "verification"), client, zone, zone.Records)
+	if verr == nil {
+		return nil
+	}
+	if got == nil {
+		// Whether the update took effect is unknown, so it is not undone.
+		return verr
+	}
+
+	zoneRollbacksTotal.Inc()
+	klog.Errorf("ROLLING BACK the update of zone %s: %v", zone.Name, verr)
+	restored := &iaas.DNS{ID: got.ID, Name: got.Name, SettingsHash: got.SettingsHash}
+	records, undone := revertEdits(got.Records, original, zone.Records)
+	if err := c.updateRecords(ctx, client, restored, records); err != nil {
+		klog.Errorf("rolling back the update of zone %s failed, restore it from a snapshot: %v", zone.Name, err)
+		return fmt.Errorf("%w; rolling back failed: %v", verr, err)
+	}
+	klog.Errorf("ROLLED BACK %d records of the update of zone %s, keeping the other changes read back", undone, zone.Name)
+	return &retriableError{reason: fmt.Sprintf("the update of zone %s was rolled back", zone.Name), err: verr}
+}
+
+// revertEdits undoes on current the edits that turned original into
+// written: the records written but not in original are removed, the ones
+// of original that were not written are added back. It returns the records
+// and how many edits were undone.
+func revertEdits(current, original, written iaas.DNSRecords) (iaas.DNSRecords, int) {
+	keys := func(records iaas.DNSRecords) map[string]bool {
+		m := make(map[string]bool, len(records))
+		for _, r := range records {
+			m[recordKey(r)] = true
+		}
+		return m
+	}
+	before, after := keys(original), keys(written)
+	reverted := make(iaas.DNSRecords, 0, len(current))
+	kept := map[string]bool{}
+	undone := 0
+	for _, r := range current {
+		k := recordKey(r)
+		if after[k] && !before[k] {
+			undone++
+			continue
+		}
+		reverted = append(reverted, r)
+		kept[k] = true
+	}
+	for _, r := range original {
+		if k := recordKey(r); !after[k] && !kept[k] {
+			reverted = append(reverted, r)
+			kept[k] = true
+			undone++
+		}
+	}
+	return reverted, undone
+}
+
+// recordKey identifies r by its name, TTL, type and RData.
+func recordKey(r *iaas.DNSRecord) string {
+	return recordLines(iaas.DNSRecords{r})
+}
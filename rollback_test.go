@@ -0,0 +1,87 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/sacloud/iaas-api-go"
+	"github.com/sacloud/iaas-api-go/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// recordingZoneAPI stores the written records and, if readBack is set,
+// returns it from Read instead of them.
+type recordingZoneAPI struct {
+	readBack iaas.DNSRecords
+	readErr  error
+	writes   []iaas.DNSRecords
+}
+
+func (a *recordingZoneAPI) Read(_ context.Context, id types.ID) (*iaas.DNS, error) {
+	if a.readErr != nil {
+		return nil, a.readErr
+	}
+	records := a.writes[len(a.writes)-1]
+	if a.readBack != nil {
+		records = a.readBack
+	}
+	return &iaas.DNS{ID: id, Name: "example.com", SettingsHash: "after", Records: records}, nil
+}
+
+func (a *recordingZoneAPI) UpdateSettings(_ context.Context, _ types.ID, param *iaas.DNSUpdateSettingsRequest) (*iaas.DNS, error) {
+	a.writes = append(a.writes, append(iaas.DNSRecords(nil), param.Records...))
+	return nil, nil
+}
+
+func TestUpdateRecordsVerified(t *testing.T) {
+	www := &iaas.DNSRecord{Name: "www", Type: types.DNSRecordTypes.A, RData: "192.0.2.1", TTL: 300}
+	challenge := &iaas.DNSRecord{Name: "_acme-challenge", Type: types.DNSRecordTypes.TXT, RData: `"key"`, TTL: 60}
+	mail := &iaas.DNSRecord{Name: "mail", Type: types.DNSRecordTypes.A, RData: "192.0.2.2", TTL: 300}
+	c := &sakuraCloudDNSProviderSolver{opts: newSolverOptions()}
+	ctx := context.Background()
+	newZone := func() *iaas.DNS {
+		return &iaas.DNS{ID: 1, Name: "example.com", SettingsHash: "before", Records: iaas.DNSRecords{www, challenge}}
+	}
+	original := iaas.DNSRecords{www}
+
+	t.Run("verified", func(t *testing.T) {
+		api := &recordingZoneAPI{}
+		require.NoError(t, c.updateRecordsVerified(ctx, api, newZone(), original))
+		assert.Len(t, api.writes, 1)
+	})
+
+	t.Run("rolled back", func(t *testing.T) {
+		api := &recordingZoneAPI{readBack: iaas.DNSRecords{www, challenge, mail}}
+		err := c.updateRecordsVerified(ctx, api, newZone(), original)
+		var retriable *retriableError
+		require.ErrorAs(t, err, &retriable)
+		assert.ErrorContains(t, err, "+ mail\t300\tA\t\"192.0.2.2\"")
+		require.Len(t, api.writes, 2)
+		assert.Equal(t, recordLines(iaas.DNSRecords{www, mail}), recordLines(api.writes[1]),
+			"only the challenge record is undone, the record of the other writer is kept")
+	})
+
+	t.Run("cleanup rolled back", func(t *testing.T) {
+		api := &recordingZoneAPI{readBack: iaas.DNSRecords{mail}}
+		cleaned := &iaas.DNS{ID: 1, Name: "example.com", SettingsHash: "before", Records: iaas.DNSRecords{www}}
+		err := c.updateRecordsVerified(ctx, api, cleaned, iaas.DNSRecords{www, challenge})
+		require.Error(t, err)
+		require.Len(t, api.writes, 2)
+		assert.Equal(t, recordLines(iaas.DNSRecords{mail, challenge}), recordLines(api.writes[1]),
+			"the deleted challenge record is added back, www deleted by the other writer is not")
+	})
+
+	t.Run("read back failed", func(t *testing.T) {
+		api := &recordingZoneAPI{readErr: errors.New("unavailable")}
+		assert.ErrorContains(t, c.updateRecordsVerified(ctx, api, newZone(), original), "unavailable")
+		assert.Len(t, api.writes, 1, "an update that may have taken effect is not undone")
+	})
+
+	t.Run("not verified", func(t *testing.T) {
+		api := &recordingZoneAPI{readErr: errors.New("unavailable")}
+		require.NoError(t, c.updateRecordsVerified(ctx, api, newZone(), nil))
+		assert.Len(t, api.writes, 1)
+	})
+}
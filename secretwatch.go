@@ -0,0 +1,87 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/klog/v2"
+)
+
+// secretWatchSyncTimeout is how long waitForSync waits for the Secrets
+// of the watched namespaces to be listed.
+const secretWatchSyncTimeout = time.Minute
+
+// secretWatcher serves credential Secrets from informers restricted to the
+// namespaces of --watch-namespaces, instead of reading them from the API
+// server on every challenge. The webhook then only needs to list and watch
+// Secrets in those namespaces, and the API server serves one watch per
+// namespace rather than a request per challenge.
+type secretWatcher struct {
+	namespaces []string
+	listers    map[string]corelisters.SecretNamespaceLister
+	factories  []informers.SharedInformerFactory
+	synced     []cache.InformerSynced
+}
+
+// newSecretWatcher returns a watcher of the Secrets of namespaces. Its
+// informers are started by run.
+func newSecretWatcher(client kubernetes.Interface, namespaces []string) *secretWatcher {
+	w := &secretWatcher{namespaces: namespaces, listers: map[string]corelisters.SecretNamespaceLister{}}
+	for _, ns := range namespaces {
+		if _, ok := w.listers[ns]; ok {
+			continue
+		}
+		factory := informers.NewSharedInformerFactoryWithOptions(client, 0, informers.WithNamespace(ns))
+		informer := factory.Core().V1().Secrets()
+		w.listers[ns] = informer.Lister().Secrets(ns)
+		w.synced = append(w.synced, informer.Informer().HasSynced)
+		w.factories = append(w.factories, factory)
+	}
+	return w
+}
+
+// run runs the informers until stopCh is closed, and waits for them to
+// stop.
+func (w *secretWatcher) run(stopCh <-chan struct{}) {
+	for _, factory := range w.factories {
+		factory.Start(stopCh)
+	}
+	<-stopCh
+	for _, factory := range w.factories {
+		factory.Shutdown()
+	}
+}
+
+// waitForSync waits for the informers to list the Secrets, until stopCh is
+// closed.
+func (w *secretWatcher) waitForSync(stopCh <-chan struct{}) error {
+	ctx, cancel := context.WithTimeout(context.Background(), secretWatchSyncTimeout)
+	defer cancel()
+	go func() {
+		select {
+		case <-stopCh:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	if !cache.WaitForCacheSync(ctx.Done(), w.synced...) {
+		return fmt.Errorf("--watch-namespaces: the Secrets of %v could not be listed within %s, check that the webhook may list and watch them", w.namespaces, secretWatchSyncTimeout)
+	}
+	klog.Infof("watching the credential Secrets of namespaces %v", w.namespaces)
+	return nil
+}
+
+// get returns the Secret name in ns from the informer of ns.
+func (w *secretWatcher) get(ns, name string) (*corev1.Secret, error) {
+	lister, ok := w.listers[ns]
+	if !ok {
+		return nil, fmt.Errorf("reading secret %s/%s is denied: namespace is not in --watch-namespaces", ns, name)
+	}
+	return lister.Get(name)
+}
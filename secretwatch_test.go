@@ -0,0 +1,54 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestSecretWatcher(t *testing.T) {
+	client := fake.NewSimpleClientset(
+		&corev1.Secret{ObjectMeta: metav1.ObjectMeta{Namespace: "tenant-a", Name: "sakuracloud"}, Data: map[string][]byte{"token": []byte("a")}},
+		&corev1.Secret{ObjectMeta: metav1.ObjectMeta{Namespace: "tenant-b", Name: "sakuracloud"}, Data: map[string][]byte{"token": []byte("b")}},
+	)
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+
+	w := newSecretWatcher(client, []string{"tenant-a"})
+	go w.run(stopCh)
+	require.NoError(t, w.waitForSync(stopCh))
+
+	secret, err := w.get("tenant-a", "sakuracloud")
+	require.NoError(t, err)
+	assert.Equal(t, "a", string(secret.Data["token"]))
+
+	_, err = w.get("tenant-a", "missing")
+	assert.True(t, apierrors.IsNotFound(err))
+
+	_, err = w.get("tenant-b", "sakuracloud")
+	assert.EqualError(t, err, "reading secret tenant-b/sakuracloud is denied: namespace is not in --watch-namespaces")
+}
+
+func TestGetSecretWatched(t *testing.T) {
+	client := fake.NewSimpleClientset(
+		&corev1.Secret{ObjectMeta: metav1.ObjectMeta{Namespace: "tenant-a", Name: "sakuracloud"}, Data: map[string][]byte{"token": []byte("a")}},
+	)
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+	w := newSecretWatcher(client, []string{"tenant-a"})
+	go w.run(stopCh)
+	require.NoError(t, w.waitForSync(stopCh))
+
+	c := &sakuraCloudDNSProviderSolver{secretsClient: client, secrets: w, opts: newSolverOptions()}
+	before := len(client.Actions())
+	secret, err := c.getSecret(context.Background(), "tenant-a", "sakuracloud")
+	require.NoError(t, err)
+	assert.Equal(t, "a", string(secret.Data["token"]))
+	assert.Len(t, client.Actions(), before, "the Secret is read from the cache")
+}
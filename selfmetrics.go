@@ -0,0 +1,80 @@
+package main
+
+import (
+	"runtime"
+	"time"
+
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/klog/v2"
+)
+
+// resourceCheckInterval is how often resourceMonitor samples the caches and
+// the runtime.
+const resourceCheckInterval = 30 * time.Second
+
+// sizedCache is an in-memory cache or queue whose number of entries is
+// exported by resourceMonitor.
+type sizedCache struct {
+	name string
+	len  func() int
+}
+
+// resourceMonitor exports the number of entries of the caches and queues of
+// the solver, and warns once when they, the goroutines or the heap grow
+// beyond their soft limits, and once more when they are back below. The
+// goroutines and the heap are exported by the Go collector of the metrics
+// registry. Zero limits are not checked.
+type resourceMonitor struct {
+	caches         []sizedCache
+	cacheLimit     int
+	goroutineLimit int
+	heapLimit      uint64
+
+	// over holds the limits that are exceeded.
+	over map[string]bool
+	// warn logs a warning; it is replaced in tests.
+	warn func(format string, args ...any)
+}
+
+func newResourceMonitor(caches []sizedCache, cacheLimit, goroutineLimit, heapLimitMB int) *resourceMonitor {
+	return &resourceMonitor{
+		caches:         caches,
+		cacheLimit:     cacheLimit,
+		goroutineLimit: goroutineLimit,
+		heapLimit:      uint64(heapLimitMB) << 20,
+		over:           map[string]bool{},
+		warn:           klog.Warningf,
+	}
+}
+
+func (m *resourceMonitor) run(stopCh <-chan struct{}) {
+	wait.Until(m.check, resourceCheckInterval, stopCh)
+}
+
+func (m *resourceMonitor) check() {
+	for _, c := range m.caches {
+		n := c.len()
+		cacheEntries.WithLabelValues(c.name).Set(float64(n))
+		m.compare("cache "+c.name, " entries", uint64(n), uint64(m.cacheLimit), "--cache-soft-limit")
+	}
+	m.compare("goroutines", "", uint64(runtime.NumGoroutine()), uint64(m.goroutineLimit), "--goroutine-soft-limit")
+	if m.heapLimit > 0 {
+		var stats runtime.MemStats
+		runtime.ReadMemStats(&stats)
+		m.compare("heap", " bytes", stats.HeapAlloc, m.heapLimit, "--heap-soft-limit")
+	}
+}
+
+// compare warns when value of what crosses limit.
+func (m *resourceMonitor) compare(what, unit string, value, limit uint64, flag string) {
+	if limit == 0 {
+		return
+	}
+	switch over := value > limit; {
+	case over && !m.over[what]:
+		m.warn("%s grew to %d%s, above %s (%d%s); this may be a leak, see the metrics of the webhook", what, value, unit, flag, limit, unit)
+	case !over && m.over[what]:
+		klog.Infof("%s is back below %s with %d%s", what, flag, value, unit)
+	}
+	m.over[what] = value > limit
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestResourceMonitor(t *testing.T) {
+	entries := 3
+	m := newResourceMonitor([]sizedCache{{"test", func() int { return entries }}}, 5, 0, 0)
+	var warnings []string
+	m.warn = func(format string, args ...any) { warnings = append(warnings, fmt.Sprintf(format, args...)) }
+
+	m.check()
+	assert.Equal(t, 3.0, testutil.ToFloat64(cacheEntries.WithLabelValues("test")))
+	assert.Empty(t, warnings)
+
+	entries = 6
+	m.check()
+	m.check()
+	assert.Equal(t, []string{
+		"cache test grew to 6 entries, above --cache-soft-limit (5 entries); this may be a leak, see the metrics of the webhook",
+	}, warnings, "the warning is logged once")
+
+	entries = 4
+	m.check()
+	entries = 7
+	m.check()
+	assert.Len(t, warnings, 2, "the warning is logged again after the cache was back below the limit")
+	assert.Equal(t, 7.0, testutil.ToFloat64(cacheEntries.WithLabelValues("test")))
+}
+
+func TestResourceMonitorGoroutines(t *testing.T) {
+	m := newResourceMonitor(nil, 0, 1, 1<<20)
+	var warnings []string
+	m.warn = func(format string, args ...any) { warnings = append(warnings, fmt.Sprintf(format, args...)) }
+	m.check()
+	assert.Len(t, warnings, 1, "only the goroutines exceed their limit")
+	assert.Contains(t, warnings[0], "above --goroutine-soft-limit (1)")
+}
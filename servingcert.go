@@ -0,0 +1,54 @@
+package main
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+)
+
+// servingCertCheck fails readiness once the serving certificate in certFile
+// expires within window. The file is read on every check, as the apiserver
+// reloads it when it is rotated; a certificate that is not renewed in time
+// would otherwise silently break the APIService.
+func servingCertCheck(certFile string, window time.Duration) readinessCheck {
+	return readinessCheck{
+		name: "serving-certificate",
+		check: func() error {
+			notAfter, err := readCertNotAfter(certFile)
+			if err != nil {
+				return err
+			}
+			servingCertNotAfter.Set(float64(notAfter.Unix()))
+			if time.Until(notAfter) < window {
+				return fmt.Errorf("serving certificate %s expires at %s, within %s", certFile, notAfter.Format(time.RFC3339), window)
+			}
+			return nil
+		},
+	}
+}
+
+// readCertNotAfter returns the expiry of the first certificate in certFile.
+func readCertNotAfter(certFile string) (time.Time, error) {
+	data, err := os.ReadFile(certFile)
+	if err != nil {
+		return time.Time{}, err
+	}
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			return time.Time{}, errors.New("no certificate found in " + certFile)
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("parsing %s: %w", certFile, err)
+		}
+		return cert.NotAfter, nil
+	}
+}
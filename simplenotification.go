@@ -0,0 +1,145 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	client "github.com/sacloud/api-client-go"
+	"github.com/sacloud/iaas-api-go"
+	"k8s.io/klog/v2"
+)
+
+// alertNotifier sends alerts to a Sakura Cloud Simple Notification group,
+// so that alerting stays within the Sakura Cloud account of the zones. It
+// alerts when the circuit breaker opens and when the challenges of a zone
+// fail failureThreshold times in a row. The same alert is sent at most once
+// per interval. A nil notifier sends nothing.
+type alertNotifier struct {
+	url              string
+	doer             client.HttpRequestDoer
+	failureThreshold int
+	interval         time.Duration
+	now              func() time.Time
+
+	mu       sync.Mutex
+	failures map[int64]int
+	sent     map[string]time.Time
+}
+
+// newAlertNotifier returns a notifier for the Simple Notification group
+// groupID, or nil if groupID is empty. The API key is read like usacloud
+// does, from SAKURACLOUD_ACCESS_TOKEN and SAKURACLOUD_ACCESS_TOKEN_SECRET or
+// the usacloud profile, as the keys of the Issuers may not be allowed to
+// send notifications.
+func newAlertNotifier(groupID string, failureThreshold int, interval time.Duration) (*alertNotifier, error) {
+	if groupID == "" {
+		return nil, nil
+	}
+	opts, err := client.DefaultOption()
+	if err != nil {
+		return nil, fmt.Errorf("--notification-group-id: loading the API key: %w", err)
+	}
+	if opts.AccessToken == "" || opts.AccessTokenSecret == "" {
+		return nil, errors.New("--notification-group-id requires SAKURACLOUD_ACCESS_TOKEN and SAKURACLOUD_ACCESS_TOKEN_SECRET")
+	}
+	// The notifications do not go through apiBreaker: they have to get
+	// out while it is open.
+	opts.HttpClient = &http.Client{}
+	opts.HttpRequestTimeout = 30
+	return &alertNotifier{
+		url:              fmt.Sprintf("%s/is1a/api/cloud/1.1/commonserviceitem/%s/simplenotification/message", iaas.SakuraCloudAPIRoot, groupID),
+		doer:             client.NewFactory(opts).NewHttpRequestDoer(),
+		failureThreshold: failureThreshold,
+		interval:         interval,
+		now:              time.Now,
+		failures:         map[int64]int{},
+		sent:             map[string]time.Time{},
+	}, nil
+}
+
+// observeChallenge counts the consecutive failed challenges of zoneID and
+// alerts once they reach the threshold.
+func (n *alertNotifier) observeChallenge(zoneID int64, operation string, err error) {
+	if n == nil || n.failureThreshold <= 0 {
+		return
+	}
+	n.mu.Lock()
+	if err == nil {
+		delete(n.failures, zoneID)
+		n.mu.Unlock()
+		return
+	}
+	n.failures[zoneID]++
+	failures := n.failures[zoneID]
+	n.mu.Unlock()
+	if failures >= n.failureThreshold {
+		n.alert(fmt.Sprintf("zone/%d", zoneID), fmt.Sprintf(
+			"cert-manager-webhook-sakuracloud: %d challenges in a row failed for zone %d, the last one (%s): %v",
+			failures, zoneID, operation, err))
+	}
+}
+
+// circuitBreakerOpened alerts that b opened.
+func (n *alertNotifier) circuitBreakerOpened(b *circuitBreaker) {
+	if n == nil {
+		return
+	}
+	n.alert("circuit-breaker", fmt.Sprintf(
+		"cert-manager-webhook-sakuracloud: the Sakura Cloud API failed %d times in a row, requests are rejected for %s",
+		b.threshold, b.cooldown))
+}
+
+// alert sends message in the background, unless the alert of key was sent
+// within the interval.
+func (n *alertNotifier) alert(key, message string) {
+	now := n.now()
+	n.mu.Lock()
+	if last, ok := n.sent[key]; ok && now.Sub(last) < n.interval {
+		n.mu.Unlock()
+		return
+	}
+	n.sent[key] = now
+	n.mu.Unlock()
+
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+		defer cancel()
+		if err := n.send(ctx, message); err != nil {
+			alertNotificationsTotal.WithLabelValues("failed").Inc()
+			sampledLog.Errorf("sending the alert %q to Simple Notification: %v", key, err)
+			return
+		}
+		alertNotificationsTotal.WithLabelValues("sent").Inc()
+		klog.Infof("sent the alert %q to Simple Notification", key)
+	}()
+}
+
+func (n *alertNotifier) send(ctx context.Context, message string) error {
+	body, err := json.Marshal(map[string]string{"Message": message})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, n.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := n.doer.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	}
+	return nil
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAlertNotifier(t *testing.T) {
+	errTest := errors.New("test error")
+	t.Setenv("SAKURACLOUD_ACCESS_TOKEN", "token")
+	t.Setenv("SAKURACLOUD_ACCESS_TOKEN_SECRET", "secret")
+	messages := make(chan string, 10)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, pass, _ := r.BasicAuth()
+		assert.Equal(t, "token:secret", user+":"+pass)
+		var body struct{ Message string }
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&body))
+		messages <- body.Message
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer srv.Close()
+
+	n, err := newAlertNotifier("113000000001", 2, time.Hour)
+	require.NoError(t, err)
+	assert.Equal(t, "https://secure.sakura.ad.jp/cloud/zone/is1a/api/cloud/1.1/commonserviceitem/113000000001/simplenotification/message", n.url)
+	n.url = srv.URL
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	n.now = func() time.Time { return now }
+	received := func() string {
+		select {
+		case m := <-messages:
+			return m
+		case <-time.After(5 * time.Second):
+			t.Fatal("no alert was sent")
+			return ""
+		}
+	}
+
+	n.observeChallenge(1, "present", errTest)
+	n.observeChallenge(1, "present", nil)
+	n.observeChallenge(1, "present", errTest)
+	n.observeChallenge(2, "cleanup", errTest)
+	assert.Empty(t, messages, "the failures did not reach the threshold")
+
+	n.observeChallenge(1, "cleanup", errTest)
+	assert.Equal(t, "cert-manager-webhook-sakuracloud: 2 challenges in a row failed for zone 1, the last one (cleanup): test error", received())
+	n.observeChallenge(1, "cleanup", errTest)
+	n.circuitBreakerOpened(&circuitBreaker{threshold: 5, cooldown: 30 * time.Second})
+	assert.Equal(t, "cert-manager-webhook-sakuracloud: the Sakura Cloud API failed 5 times in a row, requests are rejected for 30s", received())
+
+	now = now.Add(time.Hour)
+	n.observeChallenge(1, "cleanup", errTest)
+	assert.Contains(t, received(), "4 challenges in a row failed for zone 1", "alerts again after the interval")
+	assert.Empty(t, messages)
+}
+
+func TestNewAlertNotifier(t *testing.T) {
+	n, err := newAlertNotifier("", 5, time.Hour)
+	require.NoError(t, err)
+	assert.Nil(t, n)
+	n.observeChallenge(1, "present", errors.New("test error"))
+	n.circuitBreakerOpened(apiBreaker)
+
+	t.Setenv("SAKURACLOUD_ACCESS_TOKEN", "")
+	t.Setenv("SAKURACLOUD_ACCESS_TOKEN_SECRET", "")
+	t.Setenv("SAKURACLOUD_PROFILE_DIR", t.TempDir())
+	_, err = newAlertNotifier("113000000001", 5, time.Hour)
+	assert.ErrorContains(t, err, "requires SAKURACLOUD_ACCESS_TOKEN")
+}
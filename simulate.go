@@ -0,0 +1,305 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"slices"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/cert-manager/cert-manager/pkg/acme/webhook/apis/acme/v1alpha1"
+	cmmeta "github.com/cert-manager/cert-manager/pkg/apis/meta/v1"
+	"github.com/cert-manager/webhook-example/pkg/dnsname"
+	"github.com/sacloud/iaas-api-go"
+	"github.com/sacloud/iaas-api-go/search"
+	"github.com/sacloud/iaas-api-go/types"
+	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+// simulateAPI is the part of the DNS API the `simulate` command uses: the
+// zoneAPI of the solver, plus finding zones by name.
+type simulateAPI interface {
+	zoneAPI
+	Find(ctx context.Context, conditions *iaas.FindCondition) (*iaas.DNSFindResult, error)
+}
+
+// simulateEnv is what the `simulate` command talks to, replaced in tests.
+type simulateEnv struct {
+	newAPI        func(accessToken, accessTokenSecret string) simulateAPI
+	newKubeClient func(kubeconfig string) (kubernetes.Interface, error)
+	// newDynamicClient connects to the cluster for --registry-store=crd.
+	newDynamicClient func(kubeconfig string) (dynamic.Interface, error)
+
+	// checker, if set, replaces the checker of --propagation-checker.
+	checker propagationChecker
+}
+
+// simulateTool is the Tool of the registry entries of the `simulate`
+// command.
+const simulateTool = "simulate"
+
+// runSimulate implements the `simulate` command. It presents a challenge
+// record with a dummy key for --domain, waits until the propagation checker
+// sees it and deletes it again, as a preflight before pointing Issuers at
+// the zone. The record is deleted even if the command is interrupted; with
+// --registry-configmap, or --registry-store=crd, it is recorded in the
+// registry of the webhook, which deletes it should the command be killed
+// before it could.
+func runSimulate(args []string) error {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	return simulate(ctx, args, os.Stdout, simulateEnv{
+		newAPI: func(accessToken, accessTokenSecret string) simulateAPI {
+			return iaas.NewDNSOp(newAPICaller(accessToken, accessTokenSecret))
+		},
+		newKubeClient: func(kubeconfig string) (kubernetes.Interface, error) {
+			cfg, err := loadKubeconfig(kubeconfig)
+			if err != nil {
+				return nil, err
+			}
+			return kubernetes.NewForConfig(cfg)
+		},
+		newDynamicClient: func(kubeconfig string) (dynamic.Interface, error) {
+			cfg, err := loadKubeconfig(kubeconfig)
+			if err != nil {
+				return nil, err
+			}
+			return dynamic.NewForConfig(cfg)
+		},
+	})
+}
+
+// loadKubeconfig loads kubeconfig, or $KUBECONFIG or ~/.kube/config if it
+// is empty.
+func loadKubeconfig(kubeconfig string) (*rest.Config, error) {
+	rules := clientcmd.NewDefaultClientConfigLoadingRules()
+	rules.ExplicitPath = kubeconfig
+	return clientcmd.NewNonInteractiveDeferredLoadingClientConfig(rules, &clientcmd.ConfigOverrides{}).ClientConfig()
+}
+
+func simulate(ctx context.Context, args []string, out io.Writer, env simulateEnv) (err error) {
+	opts := newSolverOptions()
+	fs := flag.NewFlagSet("simulate", flag.ContinueOnError)
+	profile := fs.String("profile", "", "usacloud profile name to read the API key from (defaults to the current profile)")
+	domain := fs.String("domain", "", "domain to simulate a DNS01 challenge for; the record is _acme-challenge.<domain>")
+	zoneID := fs.Int64("zone-id", 0, "DNS zone holding --domain, looked up by name if not set")
+	ttl := fs.Int("ttl", opts.DefaultTTL, "TTL of the challenge record")
+	timeout := fs.Duration("timeout", 5*time.Minute, "how long to wait for the record to propagate")
+	fs.StringVar(&opts.PropagationChecker, "propagation-checker", opts.PropagationChecker,
+		"how to verify the record: "+strings.Join(propagationCheckers, ", "))
+	fs.Func("propagation-nameservers", "comma separated nameservers the authoritative checker queries instead of the NS set of the zone", func(s string) error {
+		opts.PropagationNameservers = strings.Split(s, ",")
+		return nil
+	})
+	fs.DurationVar(&opts.PropagationCheckInterval, "propagation-check-interval", opts.PropagationCheckInterval, "interval of the propagation checks")
+	fs.StringVar(&opts.RegistryConfigMap, "registry-configmap", "", "registry ConfigMap of the webhook to record the record in, so that the webhook deletes it should the command be killed")
+	fs.StringVar(&opts.RegistryNamespace, "registry-namespace", "", "namespace of --registry-configmap or of the SakuraCloudChallengeRecords of --registry-store=crd")
+	fs.StringVar(&opts.RegistryStore, "registry-store", opts.RegistryStore,
+		"--registry-store of the webhook: configmap (in --registry-configmap) or crd (SakuraCloudChallengeRecords, recorded without --registry-configmap)")
+	kubeconfig := fs.String("kubeconfig", "", "kubeconfig of the cluster of the webhook, defaults to $KUBECONFIG or ~/.kube/config")
+	secretName := fs.String("secret-name", "sakuracloud-dns-credentials", "Secret holding the API key, as written by gen-secret, which the webhook deletes the record with")
+	secretNamespace := fs.String("secret-namespace", "default", "namespace of --secret-name")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *domain == "" {
+		return errors.New("--domain is required")
+	}
+	switch opts.RegistryStore {
+	case "configmap":
+	case "crd":
+		if opts.RegistryConfigMap != "" {
+			return errors.New("--registry-configmap is only used by --registry-store=configmap, not crd")
+		}
+	case "memory":
+		return errors.New("--registry-store=memory keeps the registry in the memory of the webhook, which the command cannot record in")
+	default:
+		return fmt.Errorf("unknown --registry-store %q, use configmap or crd", opts.RegistryStore)
+	}
+	if opts.RegistryConfigMap != "" && opts.RegistryNamespace == "" {
+		return errors.New("--registry-configmap requires --registry-namespace")
+	}
+	if opts.RegistryStore == "crd" && opts.RegistryNamespace == "" {
+		return errors.New("--registry-store=crd requires --registry-namespace")
+	}
+	fqdn := "_acme-challenge." + dnsname.NormalizeZone(strings.TrimPrefix(*domain, "*."))
+
+	accessToken, accessTokenSecret, err := profileAPIKey(*profile)
+	if err != nil {
+		return err
+	}
+	api := env.newAPI(accessToken, accessTokenSecret)
+
+	zone, err := simulationZone(ctx, api, *zoneID, fqdn)
+	if err != nil {
+		return err
+	}
+	entry, err := dnsname.RelativeName(fqdn, zone.Name)
+	if err != nil {
+		return err
+	}
+	c := &sakuraCloudDNSProviderSolver{opts: opts}
+	checker := env.checker
+	if checker == nil {
+		if checker, err = c.propagationChecker(ctx, opts.PropagationChecker, zone.Name); err != nil {
+			return err
+		}
+	}
+	if opts.registryEnabled() {
+		var cl kubernetes.Interface
+		if opts.RegistryStore == "crd" {
+			c.dynamic, err = env.newDynamicClient(*kubeconfig)
+		} else {
+			cl, err = env.newKubeClient(*kubeconfig)
+		}
+		if err != nil {
+			return fmt.Errorf("connecting to the cluster of the registry: %w", err)
+		}
+		store, err := c.newRegistryStore(cl, opts.RegistryNamespace)
+		if err != nil {
+			return err
+		}
+		c.registry = &ownershipRegistry{store: store}
+	} else {
+		fmt.Fprintln(out, "the record is not recorded in a registry, pass --registry-configmap or --registry-store=crd so that the webhook deletes it should this command be killed")
+	}
+
+	key, err := simulationKey()
+	if err != nil {
+		return err
+	}
+	rdata, err := txtRData(key)
+	if err != nil {
+		return err
+	}
+	record := &iaas.DNSRecord{Name: entry, Type: types.DNSRecordTypes.TXT, RData: rdata, TTL: *ttl}
+
+	// The entry is recorded before the record is written, so that no record
+	// can be left behind without one.
+	owned, err := simulationEntry(zone, fqdn, key, *secretNamespace, *secretName, *timeout+time.Minute)
+	if err != nil {
+		return err
+	}
+	if err := c.registry.put(owned); err != nil {
+		return fmt.Errorf("recording %s in the registry: %w", fqdn, err)
+	}
+	// The record is added next to any TXT records at the name, e.g. of
+	// challenges in flight, rather than replacing them the way Present does.
+	fmt.Fprintf(out, "presenting %s in zone %s (%d)\n", fqdn, zone.Name, zone.ID.Int64())
+	if err := c.updateRecords(ctx, api, zone, append(zone.Records, record)); err != nil {
+		return fmt.Errorf("presenting %s: %w", fqdn, err)
+	}
+	defer func() {
+		// The record is deleted even if ctx was canceled by an interrupt.
+		ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), time.Minute)
+		defer cancel()
+		if cerr := cleanUpSimulation(ctx, c, api, zone.ID, record); cerr != nil {
+			err = errors.Join(err, fmt.Errorf("cleaning up %s: %w", fqdn, cerr))
+			if rerr := c.registry.cleanupFailed(owned, cerr); rerr != nil {
+				err = errors.Join(err, fmt.Errorf("recording the failed cleanup of %s: %w", fqdn, rerr))
+			}
+			return
+		}
+		if rerr := c.registry.remove(owned); rerr != nil {
+			err = errors.Join(err, fmt.Errorf("removing %s from the registry: %w", fqdn, rerr))
+		}
+		fmt.Fprintf(out, "cleaned up %s\n", fqdn)
+	}()
+
+	fmt.Fprintf(out, "waiting for the %s propagation checker to see %s\n", opts.PropagationChecker, fqdn)
+	start := time.Now()
+	if err := c.waitForTXT(ctx, checker, fqdn, key, *timeout); err != nil {
+		return fmt.Errorf("%s did not propagate: %w", fqdn, err)
+	}
+	fmt.Fprintf(out, "%s propagated after %s\n", fqdn, time.Since(start).Round(time.Second))
+	return nil
+}
+
+// simulationZone reads the zone with the given ID, or else the zone with
+// the longest name fqdn lies in.
+func simulationZone(ctx context.Context, api simulateAPI, zoneID int64, fqdn string) (*iaas.DNS, error) {
+	if zoneID != 0 {
+		zone, err := api.Read(ctx, types.Int64ID(zoneID))
+		if err != nil {
+			return nil, fmt.Errorf("reading zone %d: %w", zoneID, err)
+		}
+		if !dnsname.MatchZone(fqdn, zone.Name) {
+			return nil, fmt.Errorf("%s is not in zone %d (%s)", fqdn, zoneID, zone.Name)
+		}
+		return zone, nil
+	}
+	for name := strings.TrimSuffix(fqdn, "."); strings.Contains(name, "."); {
+		_, name, _ = strings.Cut(name, ".")
+		res, err := api.Find(ctx, &iaas.FindCondition{Filter: search.Filter{search.Key("Name"): search.ExactMatch(name)}})
+		if err != nil {
+			return nil, fmt.Errorf("looking up zone %s: %w", name, err)
+		}
+		if i := slices.IndexFunc(res.DNS, func(z *iaas.DNS) bool { return z.Name == name }); i >= 0 {
+			// Find does not return the records of the zone.
+			return api.Read(ctx, res.DNS[i].ID)
+		}
+	}
+	return nil, fmt.Errorf("no DNS zone holds %s, pass --zone-id", fqdn)
+}
+
+// simulationEntry returns the registry entry of the record of the `simulate`
+// command. Its config references the API key in the given Secret, with
+// which the webhook deletes the record once ttl has passed.
+func simulationEntry(zone *iaas.DNS, fqdn, key, secretNamespace, secretName string, ttl time.Duration) (*registryEntry, error) {
+	cfg := sakuraCloudDNSProviderConfig{
+		ZoneID:               zone.ID.Int64(),
+		ZoneName:             zone.Name,
+		AccessTokenRef:       cmmeta.SecretKeySelector{LocalObjectReference: cmmeta.LocalObjectReference{Name: secretName}, Key: "accessToken"},
+		AccessTokenSecretRef: cmmeta.SecretKeySelector{LocalObjectReference: cmmeta.LocalObjectReference{Name: secretName}, Key: "accessTokenSecret"},
+	}
+	raw, err := json.Marshal(cfg)
+	if err != nil {
+		return nil, err
+	}
+	e := newRegistryEntry(&cfg, &v1alpha1.ChallengeRequest{
+		ResourceNamespace: secretNamespace,
+		ResolvedFQDN:      fqdn,
+		ResolvedZone:      dnsname.NormalizeZone(zone.Name),
+		Key:               key,
+		Config:            &apiextensionsv1.JSON{Raw: raw},
+	})
+	expiresAt := e.PresentedAt.Add(ttl)
+	e.Tool, e.ExpiresAt = simulateTool, &expiresAt
+	return e, nil
+}
+
+// cleanUpSimulation deletes record from the zone, leaving any other record
+// at its name alone.
+func cleanUpSimulation(ctx context.Context, c *sakuraCloudDNSProviderSolver, api zoneAPI, zoneID types.ID, record *iaas.DNSRecord) error {
+	zone, err := api.Read(ctx, zoneID)
+	if err != nil {
+		return err
+	}
+	records := slices.DeleteFunc(zone.Records, func(r *iaas.DNSRecord) bool {
+		return r.Name == record.Name && r.Type == record.Type && r.RData == record.RData
+	})
+	return c.updateRecords(ctx, api, zone, records)
+}
+
+// simulationKey returns a dummy challenge key, shaped like the digests
+// cert-manager presents.
+func simulationKey() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
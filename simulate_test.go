@@ -0,0 +1,184 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/sacloud/iaas-api-go"
+	"github.com/sacloud/iaas-api-go/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+// memoryZoneAPI is a simulateAPI keeping a single zone in memory.
+type memoryZoneAPI struct {
+	zone *iaas.DNS
+}
+
+func (a *memoryZoneAPI) Find(context.Context, *iaas.FindCondition) (*iaas.DNSFindResult, error) {
+	return &iaas.DNSFindResult{DNS: []*iaas.DNS{{ID: a.zone.ID, Name: a.zone.Name}}}, nil
+}
+
+func (a *memoryZoneAPI) Read(context.Context, types.ID) (*iaas.DNS, error) {
+	zone := *a.zone
+	zone.Records = slices.Clone(a.zone.Records)
+	return &zone, nil
+}
+
+func (a *memoryZoneAPI) UpdateSettings(_ context.Context, _ types.ID, param *iaas.DNSUpdateSettingsRequest) (*iaas.DNS, error) {
+	a.zone.Records = slices.Clone(param.Records)
+	return a.zone, nil
+}
+
+// zoneChecker is a propagationChecker looking at the records of a
+// memoryZoneAPI.
+type zoneChecker struct {
+	api    *memoryZoneAPI
+	values []string
+	err    error
+
+	// onCheck, if set, is called on every check.
+	onCheck func()
+}
+
+func (z *zoneChecker) check(_ context.Context, fqdn, value string) error {
+	if z.onCheck != nil {
+		z.onCheck()
+	}
+	if z.err != nil {
+		return z.err
+	}
+	for _, r := range z.api.zone.Records {
+		if r.Name+".example.com." == fqdn && txtValue(r.RData) == value {
+			z.values = append(z.values, value)
+			return nil
+		}
+	}
+	return errors.New("not found")
+}
+
+func TestSimulate(t *testing.T) {
+	t.Setenv("SAKURACLOUD_ACCESS_TOKEN", "token")
+	t.Setenv("SAKURACLOUD_ACCESS_TOKEN_SECRET", "secret")
+
+	inFlight := &iaas.DNSRecord{Name: "_acme-challenge.www", Type: types.DNSRecordTypes.TXT, RData: "in-flight", TTL: 60}
+	newAPI := func() *memoryZoneAPI {
+		return &memoryZoneAPI{zone: &iaas.DNS{ID: 1, Name: "example.com", Records: iaas.DNSRecords{inFlight}}}
+	}
+	kube := fake.NewSimpleClientset()
+	records := newFakeChallengeRecordsClient()
+	run := func(ctx context.Context, api *memoryZoneAPI, checker *zoneChecker, args ...string) (string, error) {
+		env := simulateEnv{
+			newAPI:           func(string, string) simulateAPI { return api },
+			newKubeClient:    func(string) (kubernetes.Interface, error) { return kube, nil },
+			newDynamicClient: func(string) (dynamic.Interface, error) { return records, nil },
+		}
+		if checker != nil {
+			env.checker = checker
+		}
+		var out bytes.Buffer
+		err := simulate(ctx, args, &out, env)
+		return out.String(), err
+	}
+
+	t.Run("propagated", func(t *testing.T) {
+		api := newAPI()
+		checker := &zoneChecker{api: api}
+		out, err := run(context.Background(), api, checker, "--domain=www.example.com", "--propagation-check-interval=10ms")
+		require.NoError(t, err)
+		assert.Len(t, checker.values, 1, "the dummy record was presented")
+		assert.Equal(t, iaas.DNSRecords{inFlight}, api.zone.Records, "only the dummy record is cleaned up")
+		assert.Contains(t, out, "presenting _acme-challenge.www.example.com. in zone example.com (1)")
+		assert.Contains(t, out, "cleaned up _acme-challenge.www.example.com.")
+	})
+
+	t.Run("not propagated", func(t *testing.T) {
+		api := newAPI()
+		_, err := run(context.Background(), api, &zoneChecker{api: api, err: errors.New("SERVFAIL")},
+			"--domain=www.example.com", "--propagation-check-interval=10ms", "--timeout=50ms")
+		assert.ErrorContains(t, err, "did not propagate: SERVFAIL")
+		assert.Equal(t, iaas.DNSRecords{inFlight}, api.zone.Records)
+	})
+
+	t.Run("interrupted", func(t *testing.T) {
+		api := newAPI()
+		ctx, cancel := context.WithCancel(context.Background())
+		go func() {
+			time.Sleep(50 * time.Millisecond)
+			cancel()
+		}()
+		_, err := run(ctx, api, &zoneChecker{api: api, err: errors.New("not yet")}, "--domain=www.example.com", "--propagation-check-interval=10ms")
+		assert.Error(t, err)
+		assert.Equal(t, iaas.DNSRecords{inFlight}, api.zone.Records, "the record is cleaned up after an interrupt")
+	})
+
+	t.Run("registry", func(t *testing.T) {
+		api := newAPI()
+		registry := &ownershipRegistry{store: &configMapStore{client: kube, namespace: "cert-manager", name: "registry"}}
+		var recorded []*registryEntry
+		checker := &zoneChecker{api: api, err: errors.New("SERVFAIL")}
+		checker.onCheck = func() {
+			recorded, _ = registry.list()
+		}
+		start := time.Now()
+		_, err := run(context.Background(), api, checker, "--domain=www.example.com", "--propagation-check-interval=10ms", "--timeout=50ms",
+			"--registry-configmap=registry", "--registry-namespace=cert-manager", "--secret-namespace=acme")
+		assert.Error(t, err)
+
+		require.Len(t, recorded, 1, "the record is in the registry while it is in the zone")
+		e := recorded[0]
+		assert.Equal(t, simulateTool, e.Tool)
+		assert.Equal(t, "acme", e.Namespace)
+		assert.Equal(t, int64(1), e.ZoneID)
+		assert.Equal(t, "_acme-challenge.www.example.com.", e.ResolvedFQDN)
+		assert.WithinRange(t, *e.ExpiresAt, start.Add(time.Minute), time.Now().Add(time.Minute+time.Second))
+		cfg, err := loadConfig(e.challengeRequest().Config, "native")
+		require.NoError(t, err)
+		assert.Equal(t, "sakuracloud-dns-credentials", cfg.AccessTokenRef.Name)
+		assert.Equal(t, "accessTokenSecret", cfg.AccessTokenSecretRef.Key)
+		assert.Equal(t, "example.com", cfg.ZoneName)
+
+		entries, err := registry.list()
+		require.NoError(t, err)
+		assert.Empty(t, entries, "the entry is removed with the record")
+		_, err = run(context.Background(), api, nil, "--domain=www.example.com", "--registry-configmap=registry")
+		assert.ErrorContains(t, err, "--registry-configmap requires --registry-namespace")
+	})
+
+	t.Run("crd registry", func(t *testing.T) {
+		api := newAPI()
+		registry := &ownershipRegistry{store: &crdStore{client: records, namespace: "cert-manager"}}
+		var recorded []*registryEntry
+		checker := &zoneChecker{api: api, err: errors.New("SERVFAIL")}
+		checker.onCheck = func() {
+			recorded, _ = registry.list()
+		}
+		_, err := run(context.Background(), api, checker, "--domain=www.example.com", "--propagation-check-interval=10ms", "--timeout=50ms",
+			"--registry-store=crd", "--registry-namespace=cert-manager")
+		assert.Error(t, err)
+		require.Len(t, recorded, 1, "the record is in the registry while it is in the zone")
+		assert.Equal(t, simulateTool, recorded[0].Tool)
+		entries, err := registry.list()
+		require.NoError(t, err)
+		assert.Empty(t, entries, "the entry is removed with the record")
+
+		_, err = run(context.Background(), api, nil, "--domain=www.example.com", "--registry-store=crd")
+		assert.ErrorContains(t, err, "--registry-store=crd requires --registry-namespace")
+		_, err = run(context.Background(), api, nil, "--domain=www.example.com", "--registry-store=memory")
+		assert.ErrorContains(t, err, "--registry-store=memory keeps the registry in the memory of the webhook")
+	})
+
+	t.Run("wrong zone", func(t *testing.T) {
+		_, err := run(context.Background(), newAPI(), nil, "--domain=example.org", "--zone-id=1")
+		assert.ErrorContains(t, err, "_acme-challenge.example.org. is not in zone 1 (example.com)")
+		_, err = run(context.Background(), newAPI(), nil, "--domain=example.org")
+		assert.ErrorContains(t, err, "no DNS zone holds _acme-challenge.example.org.")
+	})
+}
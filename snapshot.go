@@ -0,0 +1,122 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/sacloud/iaas-api-go"
+	"github.com/sacloud/iaas-api-go/types"
+)
+
+// zoneSnapshot is the record set of a zone at one point in time. The solver
+// saves one before every zone update with --snapshot-dir, and the restore
+// command re-applies it to the zone.
+type zoneSnapshot struct {
+	ZoneID   int64     `json:"zoneID"`
+	ZoneName string    `json:"zoneName"`
+	TakenAt  time.Time `json:"takenAt"`
+	// Hash identifies the record set, see recordsHash.
+	Hash    string           `json:"hash"`
+	Records []snapshotRecord `json:"records"`
+}
+
+// snapshotRecord is a DNS record in a snapshot. It has its own type so the
+// snapshot format does not change with the API client.
+type snapshotRecord struct {
+	Name  string `json:"name"`
+	Type  string `json:"type"`
+	RData string `json:"rdata"`
+	TTL   int    `json:"ttl"`
+}
+
+func newZoneSnapshot(zone *iaas.DNS, takenAt time.Time) *zoneSnapshot {
+	s := &zoneSnapshot{
+		ZoneID:   zone.ID.Int64(),
+		ZoneName: zone.Name,
+		TakenAt:  takenAt.UTC(),
+		Hash:     recordsHash(zone.Records),
+		Records:  make([]snapshotRecord, 0, len(zone.Records)),
+	}
+	for _, r := range zone.Records {
+		s.Records = append(s.Records, snapshotRecord{Name: r.Name, Type: string(r.Type), RData: r.RData, TTL: r.TTL})
+	}
+	return s
+}
+
+// records converts the snapshot back to API records.
+func (s *zoneSnapshot) records() iaas.DNSRecords {
+	records := make(iaas.DNSRecords, 0, len(s.Records))
+	for _, r := range s.Records {
+		records = append(records, &iaas.DNSRecord{Name: r.Name, Type: types.EDNSRecordType(r.Type), RData: r.RData, TTL: r.TTL})
+	}
+	return records
+}
+
+func readZoneSnapshot(r io.Reader) (*zoneSnapshot, error) {
+	s := &zoneSnapshot{}
+	if err := json.NewDecoder(r).Decode(s); err != nil {
+		return nil, fmt.Errorf("decoding snapshot: %w", err)
+	}
+	if hash := recordsHash(s.records()); s.Hash != "" && hash != s.Hash {
+		return nil, fmt.Errorf("snapshot is corrupt: records hash to %s, expected %s", hash, s.Hash)
+	}
+	return s, nil
+}
+
+// recordsHash is a short hash of a record set that does not depend on the
+// order of the records.
+func recordsHash(records iaas.DNSRecords) string {
+	sum := sha256.Sum256([]byte(recordLines(records)))
+	return hex.EncodeToString(sum[:])[:16]
+}
+
+// snapshotStore keeps the last retention snapshots of every zone as files
+// in dir, named <zone ID>-<time>.json. A nil store keeps nothing.
+type snapshotStore struct {
+	dir       string
+	retention int
+}
+
+// save writes s and removes the oldest snapshots of the zone beyond the
+// retention.
+func (st *snapshotStore) save(s *zoneSnapshot) error {
+	if st == nil {
+		return nil
+	}
+	data, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+	name := fmt.Sprintf("%d-%s.json", s.ZoneID, s.TakenAt.Format("20060102T150405.000000000Z"))
+	tmp := filepath.Join(st.dir, "."+name)
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, filepath.Join(st.dir, name)); err != nil {
+		return err
+	}
+
+	if st.retention <= 0 {
+		return nil
+	}
+	// The timestamp format sorts lexically, so the oldest files come first.
+	files, err := filepath.Glob(filepath.Join(st.dir, fmt.Sprintf("%d-*.json", s.ZoneID)))
+	if err != nil {
+		return err
+	}
+	sort.Strings(files)
+	for len(files) > st.retention {
+		if err := os.Remove(files[0]); err != nil {
+			return err
+		}
+		files = files[1:]
+	}
+	return nil
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/sacloud/iaas-api-go"
+	"github.com/sacloud/iaas-api-go/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSnapshotStore(t *testing.T) {
+	dir := t.TempDir()
+	st := &snapshotStore{dir: dir, retention: 2}
+
+	zone := &iaas.DNS{ID: 1, Name: "example.com", Records: iaas.DNSRecords{
+		{Name: "www", Type: types.DNSRecordTypes.A, RData: "192.0.2.1", TTL: 300},
+	}}
+	other := &iaas.DNS{ID: 11, Name: "example.org"}
+
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i := 0; i < 3; i++ {
+		require.NoError(t, st.save(newZoneSnapshot(zone, start.Add(time.Duration(i)*time.Minute))))
+	}
+	require.NoError(t, st.save(newZoneSnapshot(other, start)))
+
+	files, err := filepath.Glob(filepath.Join(dir, "1-*.json"))
+	require.NoError(t, err)
+	require.Len(t, files, 2, "only the last snapshots of the zone are kept")
+
+	f, err := os.Open(files[1])
+	require.NoError(t, err)
+	defer f.Close()
+	s, err := readZoneSnapshot(f)
+	require.NoError(t, err)
+	assert.Equal(t, start.Add(2*time.Minute), s.TakenAt)
+	assert.Equal(t, recordLines(zone.Records), recordLines(s.records()))
+
+	files, err = filepath.Glob(filepath.Join(dir, "11-*.json"))
+	require.NoError(t, err)
+	assert.Len(t, files, 1, "other zones are not pruned")
+}
+
+func TestRecordsHashIgnoresOrder(t *testing.T) {
+	a := &iaas.DNSRecord{Name: "a", Type: types.DNSRecordTypes.A, RData: "192.0.2.1", TTL: 300}
+	b := &iaas.DNSRecord{Name: "b", Type: types.DNSRecordTypes.A, RData: "192.0.2.2", TTL: 300}
+	assert.Equal(t, recordsHash(iaas.DNSRecords{a, b}), recordsHash(iaas.DNSRecords{b, a}))
+	assert.NotEqual(t, recordsHash(iaas.DNSRecords{a}), recordsHash(iaas.DNSRecords{a, b}))
+}
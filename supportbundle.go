@@ -0,0 +1,151 @@
+package main
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+	"time"
+)
+
+// supportBundleFile is a file of the support bundle and where it is
+// fetched from: a path of --debug-url, or --metrics-url if empty.
+type supportBundleFile struct {
+	name      string
+	debugPath string
+}
+
+var supportBundleFiles = []supportBundleFile{
+	{name: "config.json", debugPath: "/debug/config"},
+	{name: "credentials.json", debugPath: "/debug/credentials"},
+	{name: "zone-history.json", debugPath: "/debug/zones/history"},
+	{name: "metrics.txt"},
+}
+
+// runSupportBundle implements the `support-bundle` command. It fetches the
+// effective configuration, API key status and zone history from the debug
+// endpoints of a running webhook and its metrics, and writes them with the
+// version of the command into a tarball to attach to issues. None of them
+// holds credentials: the webhook only reports API keys by credentialHash
+// and logs URLs without user info and query.
+func runSupportBundle(args []string) error {
+	return supportBundle(args, os.Stdout)
+}
+
+func supportBundle(args []string, out io.Writer) error {
+	fs := flag.NewFlagSet("support-bundle", flag.ContinueOnError)
+	debugURL := fs.String("debug-url", "http://127.0.0.1:8082", "URL of the debug endpoints of the webhook (--debug-bind-address), e.g. reached with kubectl port-forward")
+	tokenFile := fs.String("debug-token-file", "", "file holding the bearer token of the debug endpoints (--debug-token-file of the webhook)")
+	metricsURL := fs.String("metrics-url", "http://127.0.0.1:8080/metrics", "URL of the metrics of the webhook; empty leaves them out")
+	output := fs.String("output", "", "tarball to write, support-bundle-<time>.tar.gz if not set")
+	timeout := fs.Duration("timeout", 30*time.Second, "timeout of every request to the webhook")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	var token string
+	if *tokenFile != "" {
+		data, err := os.ReadFile(*tokenFile)
+		if err != nil {
+			return fmt.Errorf("--debug-token-file: %w", err)
+		}
+		token = strings.TrimSpace(string(data))
+	}
+	now := time.Now().UTC()
+	dir := "support-bundle-" + now.Format("20060102T150405Z")
+	if *output == "" {
+		*output = dir + ".tar.gz"
+	}
+
+	f, err := os.Create(*output)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	gz := gzip.NewWriter(f)
+	tw := tar.NewWriter(gz)
+	add := func(name string, data []byte) error {
+		if err := tw.WriteHeader(&tar.Header{Name: path.Join(dir, name), Mode: 0o600, Size: int64(len(data)), ModTime: now}); err != nil {
+			return err
+		}
+		_, err := tw.Write(data)
+		return err
+	}
+
+	// A bundle of a webhook that misbehaves is most useful when it is
+	// incomplete, so the parts that cannot be fetched are listed in
+	// errors.txt instead of failing the command.
+	client := &http.Client{Timeout: *timeout}
+	var failures []string
+	for _, file := range supportBundleFiles {
+		url, bearer := strings.TrimSuffix(*debugURL, "/")+file.debugPath, token
+		if file.debugPath == "" {
+			url, bearer = *metricsURL, ""
+		}
+		if url == "" {
+			continue
+		}
+		data, err := fetchSupportBundleFile(client, url, bearer)
+		if err != nil {
+			failures = append(failures, fmt.Sprintf("%s: %v", file.name, err))
+			fmt.Fprintf(out, "skipping %s: %v\n", file.name, err)
+			continue
+		}
+		if err := add(file.name, data); err != nil {
+			return err
+		}
+	}
+	version, err := json.MarshalIndent(map[string]any{"supportBundle": buildVersion(), "createdAt": now}, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := add("version.json", append(version, '\n')); err != nil {
+		return err
+	}
+	if len(failures) > 0 {
+		if err := add("errors.txt", []byte(strings.Join(failures, "\n")+"\n")); err != nil {
+			return err
+		}
+	}
+
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	if err := gz.Close(); err != nil {
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	fmt.Fprintf(out, "wrote %s\n", *output)
+	return nil
+}
+
+func fetchSupportBundleFile(client *http.Client, url, token string) ([]byte, error) {
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s responded %s", url, resp.Status)
+	}
+	return data, nil
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSupportBundle(t *testing.T) {
+	history := newZoneHistory(10)
+	history.record(1, []*zoneEdit{{op: zoneOp{fqdn: "_acme-challenge.example.com."}, changed: true}})
+	debug := httptest.NewServer(debugHandler("token", history))
+	defer debug.Close()
+	metrics := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		io.WriteString(w, "sakuracloud_webhook_challenges_total 1\n")
+	}))
+	defer metrics.Close()
+	dir := t.TempDir()
+	tokenFile := filepath.Join(dir, "token")
+	require.NoError(t, os.WriteFile(tokenFile, []byte("token\n"), 0o600))
+
+	read := func(output string) map[string][]byte {
+		f, err := os.Open(output)
+		require.NoError(t, err)
+		defer f.Close()
+		gz, err := gzip.NewReader(f)
+		require.NoError(t, err)
+		tr := tar.NewReader(gz)
+		files := map[string][]byte{}
+		for {
+			h, err := tr.Next()
+			if err == io.EOF {
+				return files
+			}
+			require.NoError(t, err)
+			data, err := io.ReadAll(tr)
+			require.NoError(t, err)
+			files[path.Base(h.Name)] = data
+		}
+	}
+
+	output := filepath.Join(dir, "bundle.tar.gz")
+	var out bytes.Buffer
+	require.NoError(t, supportBundle([]string{"--debug-url=" + debug.URL, "--debug-token-file=" + tokenFile,
+		"--metrics-url=" + metrics.URL, "--output=" + output}, &out))
+	assert.Contains(t, out.String(), "wrote "+output)
+	files := read(output)
+	assert.ElementsMatch(t, []string{"config.json", "credentials.json", "zone-history.json", "metrics.txt", "version.json"}, bundleFileNames(files))
+	var zones map[string][]zoneMutation
+	require.NoError(t, json.Unmarshal(files["zone-history.json"], &zones))
+	assert.Equal(t, "_acme-challenge.example.com.", zones["1"][0].FQDN)
+	assert.Equal(t, "sakuracloud_webhook_challenges_total 1\n", string(files["metrics.txt"]))
+	assert.Contains(t, string(files["config.json"]), `"version"`)
+
+	t.Run("unauthorized", func(t *testing.T) {
+		output := filepath.Join(dir, "unauthorized.tar.gz")
+		out.Reset()
+		require.NoError(t, supportBundle([]string{"--debug-url=" + debug.URL, "--metrics-url=", "--output=" + output}, &out))
+		files := read(output)
+		assert.ElementsMatch(t, []string{"version.json", "errors.txt"}, bundleFileNames(files))
+		assert.Contains(t, string(files["errors.txt"]), "config.json: "+debug.URL+"/debug/config responded 401 Unauthorized")
+		assert.Contains(t, out.String(), "skipping zone-history.json")
+	})
+}
+
+func bundleFileNames(m map[string][]byte) []string {
+	var keys []string
+	for k := range m {
+		keys = append(keys, k)
+	}
+	return keys
+}
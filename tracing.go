@@ -0,0 +1,163 @@
+package main
+
+import (
+	"context"
+	"os"
+	"strings"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+	coltracepb "go.opentelemetry.io/proto/otlp/collector/trace/v1"
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+	tracepb "go.opentelemetry.io/proto/otlp/trace/v1"
+	"k8s.io/klog/v2"
+)
+
+// tracer creates the solver's spans. Until setupTracing installs a provider
+// it is a no-op.
+var tracer = otel.Tracer("github.com/ophum/cert-manager-webhook-sakuracloud")
+
+// otelExporterEnabled reports whether the OTEL_*_EXPORTER variable env asks
+// for OTLP. Unlike the OpenTelemetry SDKs, exporting is off unless
+// requested.
+func otelExporterEnabled(env string) bool {
+	for _, e := range strings.Split(os.Getenv(env), ",") {
+		if strings.TrimSpace(e) == "otlp" {
+			return true
+		}
+	}
+	return false
+}
+
+// setupTracing exports spans over OTLP/HTTP when OTEL_TRACES_EXPORTER=otlp,
+// like the metrics of OTEL_METRICS_EXPORTER, see otlpClient.
+func setupTracing(stopCh <-chan struct{}) error {
+	if !otelExporterEnabled("OTEL_TRACES_EXPORTER") {
+		return nil
+	}
+	tp, err := newTracerProvider()
+	if err != nil {
+		return err
+	}
+	otel.SetTracerProvider(tp)
+	klog.Info("exporting traces over OTLP")
+
+	go func() {
+		<-stopCh
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		_ = tp.Shutdown(ctx)
+	}()
+	return nil
+}
+
+// newTracerProvider returns a provider exporting the spans in batches with
+// an otlpSpanExporter.
+func newTracerProvider() (*sdktrace.TracerProvider, error) {
+	client, err := newOTLPClient("traces")
+	if err != nil {
+		return nil, err
+	}
+	return sdktrace.NewTracerProvider(sdktrace.WithBatcher(otlpSpanExporter{client})), nil
+}
+
+// otlpSpanExporter sends spans with an otlpClient, under its resource.
+type otlpSpanExporter struct {
+	*otlpClient
+}
+
+func (e otlpSpanExporter) ExportSpans(ctx context.Context, spans []sdktrace.ReadOnlySpan) error {
+	if len(spans) == 0 {
+		return nil
+	}
+	var scopes []*tracepb.ScopeSpans
+	byScope := map[string]*tracepb.ScopeSpans{}
+	for _, s := range spans {
+		name := s.InstrumentationScope().Name
+		scope, ok := byScope[name]
+		if !ok {
+			scope = &tracepb.ScopeSpans{Scope: &commonpb.InstrumentationScope{Name: name, Version: s.InstrumentationScope().Version}}
+			byScope[name] = scope
+			scopes = append(scopes, scope)
+		}
+		scope.Spans = append(scope.Spans, toOTLPSpan(s))
+	}
+	return e.post(ctx, &coltracepb.ExportTraceServiceRequest{
+		ResourceSpans: []*tracepb.ResourceSpans{{Resource: e.resource, ScopeSpans: scopes}},
+	})
+}
+
+func (otlpSpanExporter) Shutdown(context.Context) error {
+	return nil
+}
+
+// toOTLPSpan converts a finished span to OTLP.
+func toOTLPSpan(s sdktrace.ReadOnlySpan) *tracepb.Span {
+	sc := s.SpanContext()
+	traceID, spanID := sc.TraceID(), sc.SpanID()
+	span := &tracepb.Span{
+		TraceId:           traceID[:],
+		SpanId:            spanID[:],
+		TraceState:        sc.TraceState().String(),
+		Name:              s.Name(),
+		Kind:              tracepb.Span_SpanKind(s.SpanKind()),
+		StartTimeUnixNano: uint64(s.StartTime().UnixNano()),
+		EndTimeUnixNano:   uint64(s.EndTime().UnixNano()),
+		Attributes:        otlpAttributes(s.Attributes()),
+		Status:            &tracepb.Status{Message: s.Status().Description},
+	}
+	if parent := s.Parent(); parent.IsValid() {
+		parentID := parent.SpanID()
+		span.ParentSpanId = parentID[:]
+	}
+	switch s.Status().Code {
+	case codes.Ok:
+		span.Status.Code = tracepb.Status_STATUS_CODE_OK
+	case codes.Error:
+		span.Status.Code = tracepb.Status_STATUS_CODE_ERROR
+	}
+	for _, ev := range s.Events() {
+		span.Events = append(span.Events, &tracepb.Span_Event{
+			TimeUnixNano: uint64(ev.Time.UnixNano()),
+			Name:         ev.Name,
+			Attributes:   otlpAttributes(ev.Attributes),
+		})
+	}
+	return span
+}
+
+func otlpAttributes(attrs []attribute.KeyValue) []*commonpb.KeyValue {
+	kvs := make([]*commonpb.KeyValue, 0, len(attrs))
+	for _, a := range attrs {
+		kvs = append(kvs, &commonpb.KeyValue{Key: string(a.Key), Value: otlpValue(a.Value)})
+	}
+	return kvs
+}
+
+func otlpValue(v attribute.Value) *commonpb.AnyValue {
+	switch v.Type() {
+	case attribute.BOOL:
+		return &commonpb.AnyValue{Value: &commonpb.AnyValue_BoolValue{BoolValue: v.AsBool()}}
+	case attribute.INT64:
+		return &commonpb.AnyValue{Value: &commonpb.AnyValue_IntValue{IntValue: v.AsInt64()}}
+	case attribute.FLOAT64:
+		return &commonpb.AnyValue{Value: &commonpb.AnyValue_DoubleValue{DoubleValue: v.AsFloat64()}}
+	case attribute.STRING:
+		return &commonpb.AnyValue{Value: &commonpb.AnyValue_StringValue{StringValue: v.AsString()}}
+	}
+	// Slices are sent as their JSON encoding; the solver sets none.
+	return &commonpb.AnyValue{Value: &commonpb.AnyValue_StringValue{StringValue: v.Emit()}}
+}
+
+// endSpan records err on span and ends it.
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/attribute"
+	coltracepb "go.opentelemetry.io/proto/otlp/collector/trace/v1"
+	tracepb "go.opentelemetry.io/proto/otlp/trace/v1"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestTracerProvider(t *testing.T) {
+	var got coltracepb.ExportTraceServiceRequest
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/v1/traces", r.URL.Path)
+		assert.Equal(t, "application/x-protobuf", r.Header.Get("Content-Type"))
+		assert.Equal(t, "secret", r.Header.Get("Authorization"))
+		body, err := io.ReadAll(r.Body)
+		require.NoError(t, err)
+		require.NoError(t, proto.Unmarshal(body, &got))
+	}))
+	defer srv.Close()
+
+	// The traces go to the collector of the metrics.
+	t.Setenv("OTEL_EXPORTER_OTLP_ENDPOINT", srv.URL)
+	t.Setenv("OTEL_EXPORTER_OTLP_TRACES_HEADERS", "Authorization=secret")
+	t.Setenv("OTEL_SERVICE_NAME", "webhook")
+	tp, err := newTracerProvider()
+	require.NoError(t, err)
+
+	tracer := tp.Tracer("test")
+	ctx, present := tracer.Start(context.Background(), "Present")
+	present.SetAttributes(attribute.String("fqdn", "_acme-challenge.example.com."), attribute.Int64("zone.id", 1))
+	_, read := tracer.Start(ctx, "ReadZone")
+	endSpan(read, errors.New("zone 1: 401 Unauthorized"))
+	endSpan(present, nil)
+	require.NoError(t, tp.ForceFlush(context.Background()))
+
+	require.Len(t, got.ResourceSpans, 1)
+	rs := got.ResourceSpans[0]
+	assert.Equal(t, "service.name", rs.Resource.Attributes[0].Key)
+	assert.Equal(t, "webhook", rs.Resource.Attributes[0].Value.GetStringValue())
+	require.Len(t, rs.ScopeSpans, 1)
+	assert.Equal(t, "test", rs.ScopeSpans[0].Scope.Name)
+	spans := map[string]*tracepb.Span{}
+	for _, s := range rs.ScopeSpans[0].Spans {
+		spans[s.Name] = s
+	}
+	require.Len(t, spans, 2)
+
+	p, r := spans["Present"], spans["ReadZone"]
+	assert.Equal(t, p.TraceId, r.TraceId)
+	assert.Equal(t, p.SpanId, r.ParentSpanId)
+	assert.Empty(t, p.ParentSpanId)
+	assert.Less(t, p.StartTimeUnixNano, p.EndTimeUnixNano)
+	require.Len(t, p.Attributes, 2)
+	assert.Equal(t, "_acme-challenge.example.com.", p.Attributes[0].Value.GetStringValue())
+	assert.Equal(t, int64(1), p.Attributes[1].Value.GetIntValue())
+	assert.Equal(t, tracepb.Status_STATUS_CODE_UNSET, p.Status.Code)
+	assert.Equal(t, tracepb.Status_STATUS_CODE_ERROR, r.Status.Code)
+	assert.Equal(t, "zone 1: 401 Unauthorized", r.Status.Message)
+	require.Len(t, r.Events, 1, "the error is recorded")
+	assert.Equal(t, "exception", r.Events[0].Name)
+}
+
+func TestSetupTracing(t *testing.T) {
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+
+	t.Setenv("OTEL_EXPORTER_OTLP_TRACES_PROTOCOL", "grpc")
+	assert.NoError(t, setupTracing(stopCh), "tracing is off unless requested")
+
+	t.Setenv("OTEL_TRACES_EXPORTER", "otlp")
+	assert.EqualError(t, setupTracing(stopCh), `OTLP protocol "grpc" is not supported, use http/protobuf`)
+}
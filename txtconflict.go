@@ -0,0 +1,80 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+
+	"github.com/sacloud/iaas-api-go"
+	"github.com/sacloud/iaas-api-go/types"
+)
+
+// txtConflictPolicies are the values of --txt-conflict-policy and of
+// txtConflictPolicy in the Issuer config. They decide what Present does when
+// the challenge name already holds a TXT value that is not an ACME challenge
+// value, e.g. a verification token of another service: fail, append the
+// challenge record next to it, or overwrite it.
+var txtConflictPolicies = []string{"fail", "append", "overwrite"}
+
+// acmeValuePattern matches the values of DNS-01 challenge records: the
+// unpadded base64url encoding of a SHA-256 digest (RFC 8555 section 8.4).
+var acmeValuePattern = regexp.MustCompile(`^[A-Za-z0-9_-]{43}$`)
+
+// isACMEValue reports whether the TXT value is an ACME challenge value. Such
+// values belong to other challenges for the same name, e.g. of the staging
+// and production accounts validating a domain at once, and are left alone.
+func isACMEValue(value string) bool {
+	return acmeValuePattern.MatchString(value)
+}
+
+// txtConflictError is returned by Present when the challenge name holds a
+// TXT value that is not an ACME challenge value and the policy is fail.
+type txtConflictError struct {
+	fqdn  string
+	value string
+}
+
+func (e *txtConflictError) Error() string {
+	return fmt.Sprintf("%s already holds the TXT value %q, which is not an ACME challenge value; "+
+		"set txtConflictPolicy in the Issuer config or --txt-conflict-policy to append or overwrite to write the challenge record anyway", e.fqdn, e.value)
+}
+
+// presentTXT writes the challenge record rdata at entry of zone and reports
+// whether zone was changed. A challenge is identified by its entry and its
+// value: TXT records of other challenges at entry are kept next to it, and
+// other TXT values at entry are handled according to policy.
+func presentTXT(zone *iaas.DNS, fqdn, entry, rdata string, ttl int, policy string) (bool, error) {
+	var other *iaas.DNSRecord
+	for _, r := range zone.Records {
+		if r.Name != entry || r.Type != types.DNSRecordTypes.TXT || isExternalDNSRecord(r) {
+			continue
+		}
+		if r.RData == rdata {
+			return false, nil
+		}
+		if other == nil && !isACMEValue(txtValue(r.RData)) {
+			other = r
+		}
+	}
+	switch {
+	case other != nil && policy == "overwrite":
+		other.RData = rdata
+	case other != nil && policy != "append":
+		return false, &txtConflictError{fqdn: fqdn, value: txtValue(other.RData)}
+	default:
+		zone.Records.Add(&iaas.DNSRecord{Name: entry, Type: types.DNSRecordTypes.TXT, RData: rdata, TTL: ttl})
+	}
+	return true, nil
+}
+
+// cleanUpTXT deletes the TXT record of the challenge with keyDigest digest
+// at entry of zone and reports whether zone was changed. The records of
+// other challenges at entry are kept, whether they were presented before or
+// after it.
+func cleanUpTXT(zone *iaas.DNS, entry, digest string) bool {
+	n := len(zone.Records)
+	zone.Records = slices.DeleteFunc(zone.Records, func(r *iaas.DNSRecord) bool {
+		return r.Name == entry && r.Type == types.DNSRecordTypes.TXT && !isExternalDNSRecord(r) && recordDigest(r) == digest
+	})
+	return len(zone.Records) != n
+}
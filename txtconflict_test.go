@@ -0,0 +1,150 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/sacloud/iaas-api-go"
+	"github.com/sacloud/iaas-api-go/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPresentTXT(t *testing.T) {
+	const (
+		fqdn    = "_acme-challenge.www.example.com."
+		entry   = "_acme-challenge.www"
+		value   = "LoqXcYV8q5ONbJQxbmR7SCTNo3tiAXDfowyjxAjEuX0"
+		stale   = "0123456789abcdefghijklmnopqrstuvwxyzABCDEFG"
+		foreign = "google-site-verification=abc"
+	)
+	txt := func(value string) *iaas.DNSRecord {
+		return &iaas.DNSRecord{Name: entry, Type: types.DNSRecordTypes.TXT, RData: value, TTL: 60}
+	}
+	owner := &iaas.DNSRecord{Name: entry, Type: types.DNSRecordTypes.TXT, RData: externalDNSOwnerValue("default"), TTL: 60}
+
+	for _, tc := range []struct {
+		name    string
+		records iaas.DNSRecords
+		policy  string
+		want    iaas.DNSRecords
+		changed bool
+		err     string
+	}{
+		{name: "empty", policy: "fail", want: iaas.DNSRecords{txt(value)}, changed: true},
+		{name: "presented", records: iaas.DNSRecords{txt(value)}, policy: "fail", want: iaas.DNSRecords{txt(value)}},
+		{name: "other challenge", records: iaas.DNSRecords{txt(stale)}, policy: "fail", want: iaas.DNSRecords{txt(stale), txt(value)}, changed: true},
+		{name: "external-dns", records: iaas.DNSRecords{owner}, policy: "fail", want: iaas.DNSRecords{owner, txt(value)}, changed: true},
+		{name: "fail", records: iaas.DNSRecords{txt(foreign)}, policy: "fail", want: iaas.DNSRecords{txt(foreign)},
+			err: `_acme-challenge.www.example.com. already holds the TXT value "google-site-verification=abc"`},
+		{name: "append", records: iaas.DNSRecords{txt(foreign)}, policy: "append", want: iaas.DNSRecords{txt(foreign), txt(value)}, changed: true},
+		{name: "overwrite", records: iaas.DNSRecords{txt(foreign)}, policy: "overwrite", want: iaas.DNSRecords{txt(value)}, changed: true},
+		{name: "other challenge next to another value", records: iaas.DNSRecords{txt(foreign), txt(stale)}, policy: "fail",
+			want: iaas.DNSRecords{txt(foreign), txt(stale)}, err: `already holds the TXT value "google-site-verification=abc"`},
+		{name: "append next to other challenge", records: iaas.DNSRecords{txt(stale), txt(foreign)}, policy: "append",
+			want: iaas.DNSRecords{txt(stale), txt(foreign), txt(value)}, changed: true},
+		{name: "overwrite next to other challenge", records: iaas.DNSRecords{txt(stale), txt(foreign)}, policy: "overwrite",
+			want: iaas.DNSRecords{txt(stale), txt(value)}, changed: true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			zone := &iaas.DNS{Name: "example.com"}
+			for _, r := range tc.records {
+				c := *r
+				zone.Records = append(zone.Records, &c)
+			}
+			changed, err := presentTXT(zone, fqdn, entry, value, 60, tc.policy)
+			if tc.err != "" {
+				var conflict *txtConflictError
+				require.ErrorAs(t, err, &conflict)
+				assert.ErrorContains(t, err, tc.err)
+			} else {
+				require.NoError(t, err)
+			}
+			assert.Equal(t, tc.changed, changed)
+			assert.Equal(t, tc.want, zone.Records)
+		})
+	}
+}
+
+func TestCleanUpTXT(t *testing.T) {
+	txt := func(value string) *iaas.DNSRecord {
+		return &iaas.DNSRecord{Name: "_acme-challenge", Type: types.DNSRecordTypes.TXT, RData: value, TTL: 60}
+	}
+	const own = "LoqXcYV8q5ONbJQxbmR7SCTNo3tiAXDfowyjxAjEuX0"
+	other := txt("0123456789abcdefghijklmnopqrstuvwxyzABCDEFG")
+	foreign := txt("google-site-verification=abc")
+	elsewhere := &iaas.DNSRecord{Name: "_acme-challenge.www", Type: types.DNSRecordTypes.TXT, RData: own, TTL: 60}
+
+	zone := &iaas.DNS{Records: iaas.DNSRecords{other, txt(own), foreign, elsewhere}}
+	assert.True(t, cleanUpTXT(zone, "_acme-challenge", keyDigest(own)))
+	assert.Equal(t, iaas.DNSRecords{other, foreign, elsewhere}, zone.Records, "only the challenge's own record is deleted")
+	assert.False(t, cleanUpTXT(zone, "_acme-challenge", keyDigest(own)))
+}
+
+// TestConcurrentChallenges interleaves the Presents and CleanUps of two
+// challenges for the same name with different keys, as the staging and
+// production accounts validating a domain at once do. Each challenge only
+// ever sees and removes its own record.
+func TestConcurrentChallenges(t *testing.T) {
+	const (
+		fqdn  = "_acme-challenge.example.com."
+		entry = "_acme-challenge"
+		prod  = "LoqXcYV8q5ONbJQxbmR7SCTNo3tiAXDfowyjxAjEuX0"
+		stage = "0123456789abcdefghijklmnopqrstuvwxyzABCDEFG"
+	)
+	present := func(zone *iaas.DNS, value string) bool {
+		changed, err := presentTXT(zone, fqdn, entry, value, 60, "fail")
+		require.NoError(t, err)
+		return changed
+	}
+	cleanUp := func(zone *iaas.DNS, value string) bool { return cleanUpTXT(zone, entry, keyDigest(value)) }
+	values := func(zone *iaas.DNS) []string {
+		var values []string
+		for _, r := range zone.Records {
+			values = append(values, r.RData)
+		}
+		return values
+	}
+
+	for _, tc := range []struct {
+		name  string
+		steps func(zone *iaas.DNS)
+		want  []string
+	}{
+		{"cleanup in present order", func(zone *iaas.DNS) {
+			assert.True(t, present(zone, prod))
+			assert.True(t, present(zone, stage))
+			assert.True(t, cleanUp(zone, prod))
+			assert.Equal(t, []string{stage}, values(zone))
+			assert.True(t, cleanUp(zone, stage))
+		}, nil},
+		{"cleanup in reverse order", func(zone *iaas.DNS) {
+			assert.True(t, present(zone, prod))
+			assert.True(t, present(zone, stage))
+			assert.True(t, cleanUp(zone, stage))
+			assert.Equal(t, []string{prod}, values(zone))
+			assert.True(t, cleanUp(zone, prod))
+		}, nil},
+		{"retried present", func(zone *iaas.DNS) {
+			assert.True(t, present(zone, prod))
+			assert.True(t, present(zone, stage))
+			assert.False(t, present(zone, prod), "the record of the challenge is still there")
+			assert.False(t, present(zone, stage))
+		}, []string{prod, stage}},
+		{"cleanup before the other present", func(zone *iaas.DNS) {
+			assert.True(t, present(zone, prod))
+			assert.True(t, cleanUp(zone, prod))
+			assert.False(t, cleanUp(zone, prod), "a repeated cleanup finds nothing")
+			assert.True(t, present(zone, stage))
+		}, []string{stage}},
+		{"delayed cleanup of a missing record", func(zone *iaas.DNS) {
+			assert.True(t, present(zone, stage))
+			assert.False(t, cleanUp(zone, prod), "the cleanup of a record that was never presented keeps the other")
+		}, []string{stage}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			zone := &iaas.DNS{Name: "example.com"}
+			tc.steps(zone)
+			assert.Equal(t, tc.want, values(zone))
+		})
+	}
+}
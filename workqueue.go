@@ -0,0 +1,311 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"sync"
+	"time"
+)
+
+// errSupersededByCleanUp is the error of a Present whose CleanUp arrived
+// while the Present still waited in the zone queue.
+var errSupersededByCleanUp = errors.New("the challenge was cleaned up before its record was written")
+
+// zoneOp identifies the challenge a zone edit is for, so that identical
+// edits waiting in the zone queue can be merged.
+type zoneOp struct {
+	// scope is the namespace, zone and credentials of the edit.
+	scope     string
+	fqdn      string
+	keyDigest string
+	cleanup   bool
+}
+
+// recordEngines are the values of --record-engine.
+var recordEngines = []string{"v1", "v2"}
+
+// zoneQueue limits how many zone operations run at once. Operations that
+// have to wait are queued per zone and the free slots go to the zones in
+// turn, so a zone with hundreds of pending challenges delays the challenges
+// of other zones by at most one operation each.
+//
+// Only one operation of a zone runs at a time, whether or not the workers
+// are limited. Operations read the record set of the zone and write it back
+// whole, so two of them running at once would make one fail on the
+// SettingsHash of the other, or drop its records. How often operations
+// queue behind others of their zone, for how long, and how long each keeps
+// the zone are exported per zone, to tell when batching or sharding zones
+// over more replicas would help.
+//
+// While edits wait, a Present for a challenge that is already waiting is
+// answered by the waiting one, and a CleanUp drops the waiting Present of
+// its challenge, so Order churn does not write records only to delete them
+// again.
+type zoneQueue struct {
+	workers int
+	// now times the waits and holds of the zones.
+	now func() time.Time
+
+	mu      sync.Mutex
+	running int
+	// active holds the zones with a running operation.
+	active map[string]bool
+	// turns lists the zones with waiting operations in the order they get
+	// the next free slots.
+	turns   []string
+	waiting map[string][]*zoneJob
+}
+
+// zoneJob is an operation waiting in a zoneQueue. ready is closed when it
+// is handed a slot, or when all of its edits were superseded; finished is
+// closed once its edits have their results.
+type zoneJob struct {
+	edits    []*zoneEdit
+	ready    chan struct{}
+	finished chan struct{}
+	queued   time.Time
+	canceled bool
+	// contended is set if other operations of the zone ran or waited when
+	// the job was queued, so that it waited for the lock of the zone
+	// rather than only for a worker.
+	contended bool
+}
+
+// newZoneQueue returns a queue running up to workers operations at once.
+// With workers at zero or less operations are only limited to one per zone.
+func newZoneQueue(workers int) *zoneQueue {
+	return &zoneQueue{
+		workers: workers,
+		now:     time.Now,
+		active:  map[string]bool{},
+		waiting: map[string][]*zoneJob{},
+	}
+}
+
+// do calls run with edits once a slot is free and zone has its turn. Edits
+// merged into waiting ones get their results, and those superseded by a
+// CleanUp fail with errSupersededByCleanUp; run is only called with the
+// rest. If ctx is done first, the edits fail with its error.
+func (q *zoneQueue) do(ctx context.Context, zone string, edits []*zoneEdit, run func(edits []*zoneEdit)) {
+	if q == nil {
+		run(edits)
+		return
+	}
+	q.enqueue(zone, edits).wait(ctx, run)
+}
+
+// queuedEdits are edits handed to a zoneQueue.
+type queuedEdits struct {
+	q    *zoneQueue
+	zone string
+	// job holds the edits that are run, if queued is set.
+	job    *zoneJob
+	queued bool
+	// follows maps the edits merged into waiting ones to those.
+	follows map[*zoneEdit]queuedEdit
+}
+
+// queuedEdit is an edit waiting in a job.
+type queuedEdit struct {
+	edit *zoneEdit
+	job  *zoneJob
+}
+
+// enqueue queues edits for zone. If a slot is free and zone has no running
+// operation, the returned job is handed the slot right away; slots are only
+// free while every waiting zone has one.
+func (q *zoneQueue) enqueue(zone string, edits []*zoneEdit) *queuedEdits {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	job := &zoneJob{ready: make(chan struct{}), finished: make(chan struct{}), queued: q.now()}
+	w := &queuedEdits{q: q, zone: zone, job: job, queued: true, follows: map[*zoneEdit]queuedEdit{}}
+	if q.free() && !q.active[zone] {
+		q.running++
+		q.active[zone] = true
+		job.edits = edits
+		close(job.ready)
+		zoneLockAcquisitionsTotal.WithLabelValues(zoneLabels.idLabel(zone), "free").Inc()
+		return w
+	}
+
+	for _, e := range edits {
+		if e.op == (zoneOp{}) || e.unmerged {
+			job.edits = append(job.edits, e)
+			continue
+		}
+		if orig := q.find(zone, e.op); orig.edit != nil {
+			w.follows[e] = orig
+			workQueueMergedTotal.WithLabelValues("duplicate").Inc()
+			continue
+		}
+		if e.op.cleanup {
+			q.supersede(zone, e.op)
+		}
+		job.edits = append(job.edits, e)
+	}
+	if len(job.edits) == 0 {
+		w.queued = false
+		return w
+	}
+	_, waiting := q.waiting[zone]
+	if !waiting {
+		q.turns = append(q.turns, zone)
+	}
+	q.waiting[zone] = append(q.waiting[zone], job)
+	workQueueDepth.Inc()
+	job.contended = waiting || q.active[zone]
+	result := "free"
+	if job.contended {
+		result = "contended"
+	}
+	zoneLockAcquisitionsTotal.WithLabelValues(zoneLabels.idLabel(zone), result).Inc()
+	return w
+}
+
+// wait runs the job of w once it is handed a slot, and then waits for the
+// results of the edits merged into other jobs.
+func (w *queuedEdits) wait(ctx context.Context, run func(edits []*zoneEdit)) {
+	if w.queued {
+		w.q.wait(ctx, w.zone, w.job, run)
+	}
+	for e, orig := range w.follows {
+		select {
+		case <-orig.job.finished:
+			e.changed, e.err = orig.edit.changed, orig.edit.err
+		case <-ctx.Done():
+			e.err = ctx.Err()
+		}
+	}
+}
+
+// wait runs job once it is handed a slot.
+func (q *zoneQueue) wait(ctx context.Context, zone string, job *zoneJob, run func(edits []*zoneEdit)) {
+	select {
+	case <-job.ready:
+	case <-ctx.Done():
+		q.mu.Lock()
+		if job.canceled {
+			q.mu.Unlock()
+			return
+		}
+		handed := !q.remove(zone, job)
+		for _, e := range job.edits {
+			e.err = ctx.Err()
+		}
+		close(job.finished)
+		q.mu.Unlock()
+		if handed {
+			// The job was handed a slot meanwhile; pass it on.
+			q.release(zone)
+		}
+		return
+	}
+	if job.canceled {
+		return
+	}
+	defer q.release(zone)
+	defer close(job.finished)
+	defer func(start time.Time) {
+		zoneLockHold.WithLabelValues(zoneLabels.idLabel(zone)).Observe(q.now().Sub(start).Seconds())
+	}(q.now())
+	run(job.edits)
+}
+
+// find returns the waiting edit of zone for op, if any.
+func (q *zoneQueue) find(zone string, op zoneOp) queuedEdit {
+	for _, job := range q.waiting[zone] {
+		for _, e := range job.edits {
+			if e.op == op && !e.unmerged {
+				return queuedEdit{e, job}
+			}
+		}
+	}
+	return queuedEdit{}
+}
+
+// supersede drops the waiting Present edits of zone for the challenge of
+// the CleanUp op. Jobs left without edits are removed from the queue.
+func (q *zoneQueue) supersede(zone string, op zoneOp) {
+	present := op
+	present.cleanup = false
+	for _, job := range slices.Clone(q.waiting[zone]) {
+		job.edits = slices.DeleteFunc(job.edits, func(e *zoneEdit) bool {
+			if e.op != present || e.unmerged {
+				return false
+			}
+			e.err = errSupersededByCleanUp
+			workQueueMergedTotal.WithLabelValues("superseded").Inc()
+			return true
+		})
+		if len(job.edits) == 0 {
+			q.remove(zone, job)
+			job.canceled = true
+			close(job.ready)
+			close(job.finished)
+		}
+	}
+}
+
+// release frees the slot of a finished operation of zone and hands the free
+// slots on.
+func (q *zoneQueue) release(zone string) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.running--
+	delete(q.active, zone)
+	q.dispatch()
+}
+
+// dispatch hands the free slots to the first waiting operation of each zone
+// in turn, skipping zones with a running operation. q.mu must be held.
+func (q *zoneQueue) dispatch() {
+	for i := 0; i < len(q.turns) && q.free(); {
+		zone := q.turns[i]
+		if q.active[zone] {
+			i++
+			continue
+		}
+		q.turns = slices.Delete(q.turns, i, i+1)
+		jobs := q.waiting[zone]
+		job := jobs[0]
+		if len(jobs) == 1 {
+			delete(q.waiting, zone)
+		} else {
+			q.waiting[zone] = jobs[1:]
+			q.turns = append(q.turns, zone)
+		}
+		q.running++
+		q.active[zone] = true
+		workQueueDepth.Dec()
+		workQueueWait.Observe(q.now().Sub(job.queued).Seconds())
+		if job.contended {
+			zoneLockWait.WithLabelValues(zoneLabels.idLabel(zone)).Observe(q.now().Sub(job.queued).Seconds())
+		}
+		close(job.ready)
+	}
+}
+
+// free reports whether a slot is free. q.mu must be held.
+func (q *zoneQueue) free() bool {
+	return q.workers <= 0 || q.running < q.workers
+}
+
+// remove takes job out of the queue and reports whether it was still
+// waiting. q.mu must be held.
+func (q *zoneQueue) remove(zone string, job *zoneJob) bool {
+	jobs := q.waiting[zone]
+	i := slices.Index(jobs, job)
+	if i < 0 {
+		return false
+	}
+	jobs = slices.Delete(jobs, i, i+1)
+	if len(jobs) > 0 {
+		q.waiting[zone] = jobs
+	} else {
+		delete(q.waiting, zone)
+		q.turns = slices.DeleteFunc(q.turns, func(z string) bool { return z == zone })
+	}
+	workQueueDepth.Dec()
+	return true
+}
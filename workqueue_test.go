@@ -0,0 +1,334 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/sacloud/iaas-api-go"
+	"github.com/sacloud/iaas-api-go/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// queueTest runs edits through a zoneQueue with a single worker, which is
+// kept busy until release is called.
+type queueTest struct {
+	q     *zoneQueue
+	block chan struct{}
+	wg    sync.WaitGroup
+
+	mu  sync.Mutex
+	ran []string
+}
+
+func newQueueTest() *queueTest {
+	qt := &queueTest{q: newZoneQueue(1), block: make(chan struct{})}
+	w := qt.q.enqueue("busy", []*zoneEdit{{}})
+	qt.wg.Add(1)
+	go func() {
+		defer qt.wg.Done()
+		w.wait(context.Background(), func([]*zoneEdit) { <-qt.block })
+	}()
+	return qt
+}
+
+// enqueue queues edit for zone and runs it in the background. The op.fqdn
+// of edits is recorded when they are run.
+func (qt *queueTest) enqueue(ctx context.Context, zone string, edit *zoneEdit) {
+	w := qt.q.enqueue(zone, []*zoneEdit{edit})
+	qt.wg.Add(1)
+	go func() {
+		defer qt.wg.Done()
+		w.wait(ctx, func(edits []*zoneEdit) {
+			qt.mu.Lock()
+			defer qt.mu.Unlock()
+			for _, e := range edits {
+				qt.ran = append(qt.ran, e.op.fqdn)
+				e.changed = true
+			}
+		})
+	}()
+}
+
+// release lets the busy worker finish and waits for every edit.
+func (qt *queueTest) release() {
+	close(qt.block)
+	qt.wg.Wait()
+}
+
+func presentOp(fqdn, key string) zoneOp {
+	return zoneOp{scope: "ns/1/token/secret", fqdn: fqdn, keyDigest: keyDigest(key)}
+}
+
+func cleanupOp(fqdn, key string) zoneOp {
+	return zoneOp{scope: "ns/1/token/secret", fqdn: fqdn, keyDigest: keyDigest(key), cleanup: true}
+}
+
+func TestZoneQueueTakesZonesInTurn(t *testing.T) {
+	qt := newQueueTest()
+	ctx := context.Background()
+	for _, name := range []string{"busy-1", "busy-2", "busy-3"} {
+		qt.enqueue(ctx, "busy", &zoneEdit{op: presentOp(name, "k")})
+	}
+	qt.enqueue(ctx, "quiet", &zoneEdit{op: presentOp("quiet-1", "k")})
+	qt.enqueue(ctx, "other", &zoneEdit{op: presentOp("other-1", "k")})
+	qt.release()
+
+	assert.Equal(t, []string{"busy-1", "quiet-1", "other-1", "busy-2", "busy-3"}, qt.ran)
+	assert.Equal(t, 0, qt.q.running)
+	assert.Empty(t, qt.q.turns)
+	assert.Empty(t, qt.q.waiting)
+}
+
+func TestZoneQueueMergesDuplicates(t *testing.T) {
+	qt := newQueueTest()
+	ctx := context.Background()
+	first := &zoneEdit{op: presentOp("a", "k")}
+	duplicate := &zoneEdit{op: presentOp("a", "k")}
+	otherKey := &zoneEdit{op: presentOp("a", "other")}
+	qt.enqueue(ctx, "1", first)
+	qt.enqueue(ctx, "1", duplicate)
+	qt.enqueue(ctx, "1", otherKey)
+	qt.release()
+
+	assert.Equal(t, []string{"a", "a"}, qt.ran, "the duplicate is not run")
+	assert.True(t, duplicate.changed, "the duplicate gets the result of the first edit")
+	assert.NoError(t, duplicate.err)
+	assert.True(t, otherKey.changed)
+}
+
+func TestZoneQueueRunsUnmergedEdits(t *testing.T) {
+	qt := newQueueTest()
+	ctx := context.Background()
+	first := &zoneEdit{op: presentOp("a", "k"), unmerged: true}
+	duplicate := &zoneEdit{op: presentOp("a", "k"), unmerged: true}
+	cleanup := &zoneEdit{op: cleanupOp("a", "k"), unmerged: true}
+	qt.enqueue(ctx, "1", first)
+	qt.enqueue(ctx, "1", duplicate)
+	qt.enqueue(ctx, "1", cleanup)
+	qt.release()
+
+	assert.Equal(t, []string{"a", "a", "a"}, qt.ran, "with --record-engine=v1 every edit is run")
+	assert.NoError(t, first.err)
+	assert.NoError(t, duplicate.err)
+}
+
+func TestZoneQueueCleanUpSupersedesPresent(t *testing.T) {
+	qt := newQueueTest()
+	ctx := context.Background()
+	present := &zoneEdit{op: presentOp("a", "k")}
+	duplicate := &zoneEdit{op: presentOp("a", "k")}
+	otherKey := &zoneEdit{op: presentOp("a", "other")}
+	cleanup := &zoneEdit{op: cleanupOp("a", "k")}
+	qt.enqueue(ctx, "1", present)
+	qt.enqueue(ctx, "1", duplicate)
+	qt.enqueue(ctx, "1", otherKey)
+	qt.enqueue(ctx, "1", cleanup)
+	later := &zoneEdit{op: presentOp("a", "k")}
+	qt.enqueue(ctx, "1", later)
+	qt.release()
+
+	assert.ErrorIs(t, present.err, errSupersededByCleanUp)
+	assert.ErrorIs(t, duplicate.err, errSupersededByCleanUp)
+	assert.False(t, present.changed)
+	assert.NoError(t, otherKey.err)
+	assert.NoError(t, cleanup.err)
+	assert.NoError(t, later.err, "a Present after the CleanUp is kept")
+	assert.Len(t, qt.ran, 3)
+	assert.Empty(t, qt.q.waiting)
+}
+
+func TestZoneQueueCancel(t *testing.T) {
+	qt := newQueueTest()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	edit := &zoneEdit{op: presentOp("a", "k")}
+	w := qt.q.enqueue("1", []*zoneEdit{edit})
+	w.wait(ctx, func([]*zoneEdit) { t.Error("a canceled edit ran") })
+	assert.ErrorIs(t, edit.err, context.DeadlineExceeded)
+	assert.Empty(t, qt.q.turns)
+	assert.Empty(t, qt.q.waiting)
+
+	qt.release()
+	assert.Equal(t, 0, qt.q.running)
+}
+
+func TestZoneQueueUnlimited(t *testing.T) {
+	for _, q := range []*zoneQueue{nil, newZoneQueue(0)} {
+		calls := 0
+		q.do(context.Background(), "a", []*zoneEdit{{}}, func([]*zoneEdit) { calls++ })
+		assert.Equal(t, 1, calls)
+	}
+}
+
+func TestZoneQueueRunsOneOperationPerZone(t *testing.T) {
+	qt := newQueueTest()
+	qt.q.workers = 0
+	ctx := context.Background()
+	qt.enqueue(ctx, "busy", &zoneEdit{op: presentOp("busy-1", "k")})
+	qt.enqueue(ctx, "other", &zoneEdit{op: presentOp("other-1", "k")})
+	assert.Eventually(t, func() bool {
+		qt.mu.Lock()
+		defer qt.mu.Unlock()
+		return slices.Equal(qt.ran, []string{"other-1"})
+	}, time.Second, time.Millisecond, "other zones do not wait without a worker limit")
+	qt.release()
+
+	assert.Equal(t, []string{"other-1", "busy-1"}, qt.ran)
+	assert.Empty(t, qt.q.active)
+}
+
+// TestZoneQueueConcurrentChallenges presents and cleans up hundreds of
+// challenges at once through the batcher and the queue, as the solver does.
+// Like the API with SettingsHash, the zones reject writes based on a stale
+// read. Run it with -race.
+func TestZoneQueueConcurrentChallenges(t *testing.T) {
+	const zones, challenges = 5, 300
+	b := newZoneBatcher(time.Millisecond)
+	q := newZoneQueue(3)
+	presented := newPresentCache(time.Minute)
+	ctx := context.Background()
+
+	var mu sync.Mutex
+	records := map[string][]string{}
+	versions := map[string]int{}
+	running := map[string]int{}
+	run := func(zone string, edits []*zoneEdit) {
+		mu.Lock()
+		running[zone]++
+		assert.Equal(t, 1, running[zone], "operations of zone %s overlap", zone)
+		dns := &iaas.DNS{}
+		for _, v := range records[zone] {
+			dns.Records = append(dns.Records, &iaas.DNSRecord{Name: "_acme-challenge", Type: types.DNSRecordTypes.TXT, RData: v})
+		}
+		version := versions[zone]
+		mu.Unlock()
+
+		for _, e := range edits {
+			e.changed, e.err = e.apply(dns)
+		}
+
+		mu.Lock()
+		defer mu.Unlock()
+		running[zone]--
+		if versions[zone] != version {
+			for _, e := range edits {
+				e.err = fmt.Errorf("zone %s was updated since it was read", zone)
+			}
+			return
+		}
+		versions[zone]++
+		records[zone] = nil
+		for _, r := range dns.Records {
+			records[zone] = append(records[zone], r.RData)
+		}
+	}
+
+	edit := func(i int, cleanup bool) {
+		zone, key := fmt.Sprint(i%zones), fmt.Sprint("key-", i)
+		e := &zoneEdit{op: presentOp("_acme-challenge", key)}
+		e.op.cleanup = cleanup
+		e.apply = func(dns *iaas.DNS) (bool, error) {
+			i := slices.IndexFunc(dns.Records, func(r *iaas.DNSRecord) bool { return r.RData == key })
+			switch {
+			case !cleanup && i < 0:
+				dns.Records = append(dns.Records, &iaas.DNSRecord{Name: "_acme-challenge", Type: types.DNSRecordTypes.TXT, RData: key})
+				return true, nil
+			case cleanup && i >= 0:
+				dns.Records = slices.Delete(dns.Records, i, i+1)
+				return true, nil
+			}
+			return false, nil
+		}
+		b.do(ctx, zone, e, func(ctx context.Context, edits []*zoneEdit) {
+			q.do(ctx, zone, edits, func(edits []*zoneEdit) { run(zone, edits) })
+		})
+		assert.NoError(t, e.err)
+
+		k := presentKey{zoneID: int64(i % zones), fqdn: "_acme-challenge", keyDigest: keyDigest(key)}
+		if cleanup {
+			presented.remove(k)
+		} else {
+			presented.add(k)
+			assert.True(t, presented.hit(k))
+		}
+	}
+	each := func(fn func(i int)) {
+		var wg sync.WaitGroup
+		for i := 0; i < challenges; i++ {
+			// Every challenge is handled twice, as cert-manager retries.
+			for j := 0; j < 2; j++ {
+				wg.Add(1)
+				go func(i int) {
+					defer wg.Done()
+					fn(i)
+				}(i)
+			}
+		}
+		wg.Wait()
+	}
+
+	each(func(i int) { edit(i, false) })
+	for zone := 0; zone < zones; zone++ {
+		assert.Len(t, records[fmt.Sprint(zone)], challenges/zones)
+	}
+	each(func(i int) { edit(i, true) })
+	for zone := 0; zone < zones; zone++ {
+		assert.Empty(t, records[fmt.Sprint(zone)])
+	}
+
+	assert.Equal(t, 0, q.running)
+	assert.Empty(t, q.active)
+	assert.Empty(t, q.waiting)
+	assert.Empty(t, q.turns)
+	assert.Empty(t, b.pending)
+}
+
+// sampleCount returns the number of observations of a histogram.
+func sampleCount(t *testing.T, o prometheus.Observer) uint64 {
+	m := &dto.Metric{}
+	require.NoError(t, o.(prometheus.Metric).Write(m))
+	return m.GetHistogram().GetSampleCount()
+}
+
+func TestZoneQueueLockContention(t *testing.T) {
+	acquired := func(zone, result string) float64 {
+		return testutil.ToFloat64(zoneLockAcquisitionsTotal.WithLabelValues(zone, result))
+	}
+	aFree, aContended, bFree := acquired("lock-a", "free"), acquired("lock-a", "contended"), acquired("lock-b", "free")
+	aWaits, bWaits := sampleCount(t, zoneLockWait.WithLabelValues("lock-a")), sampleCount(t, zoneLockWait.WithLabelValues("lock-b"))
+	aHolds := sampleCount(t, zoneLockHold.WithLabelValues("lock-a"))
+
+	q := newZoneQueue(1)
+	block := make(chan struct{})
+	var wg sync.WaitGroup
+	run := func(zone string, fn func([]*zoneEdit)) {
+		w := q.enqueue(zone, []*zoneEdit{{}})
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			w.wait(context.Background(), fn)
+		}()
+	}
+	run("lock-a", func([]*zoneEdit) { <-block })
+	run("lock-a", func([]*zoneEdit) {})
+	// lock-b only waits for the worker, not for another operation of its
+	// zone.
+	run("lock-b", func([]*zoneEdit) {})
+	close(block)
+	wg.Wait()
+
+	assert.Equal(t, aFree+1, acquired("lock-a", "free"))
+	assert.Equal(t, aContended+1, acquired("lock-a", "contended"))
+	assert.Equal(t, bFree+1, acquired("lock-b", "free"))
+	assert.Equal(t, aWaits+1, sampleCount(t, zoneLockWait.WithLabelValues("lock-a")))
+	assert.Equal(t, bWaits, sampleCount(t, zoneLockWait.WithLabelValues("lock-b")))
+	assert.Equal(t, aHolds+2, sampleCount(t, zoneLockHold.WithLabelValues("lock-a")))
+}
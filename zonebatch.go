@@ -0,0 +1,149 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/sacloud/iaas-api-go"
+)
+
+// zoneEdit is one change to the record set of a zone, e.g. presenting or
+// cleaning up a single challenge record.
+type zoneEdit struct {
+	// apply edits zone.Records in place and reports whether it changed
+	// anything.
+	apply func(zone *iaas.DNS) (bool, error)
+	// op identifies the challenge of the edit, if any.
+	op zoneOp
+	// actor is who the edit is made for, in change events.
+	actor changeActor
+	// unmerged keeps the edit from being merged with or superseded by the
+	// edits waiting in the zone queue, with --record-engine=v1.
+	unmerged bool
+
+	changed bool
+	err     error
+	done    chan struct{}
+
+	// calls counts the API requests of the challenge of the edit, which
+	// gets its share of the requests of the zone update.
+	calls *apiCallCounter
+}
+
+// operation names the kind of edit in the zone history. Edits without a
+// challenge are the pruner's.
+func (e *zoneEdit) operation() string {
+	switch {
+	case e.op.fqdn == "":
+		return "prune"
+	case e.op.cleanup:
+		return "cleanup"
+	}
+	return "present"
+}
+
+// zoneBatcher groups zone edits for the same zone and credentials that
+// arrive within window. An Order for many names in one zone then results in
+// a single read and write of the zone instead of one per challenge.
+type zoneBatcher struct {
+	window time.Duration
+	// after times the window.
+	after func(time.Duration) <-chan time.Time
+
+	mu      sync.Mutex
+	pending map[string]*zoneBatch
+}
+
+// zoneBatch holds the edits collected for a key.
+type zoneBatch struct {
+	edits []*zoneEdit
+	// deadline is the latest deadline of the callers of the edits, if all
+	// of them have one.
+	deadline    time.Time
+	hasDeadline bool
+}
+
+// add adds edit, made with ctx, to the batch.
+func (b *zoneBatch) add(ctx context.Context, edit *zoneEdit) {
+	deadline, ok := ctx.Deadline()
+	if len(b.edits) == 0 {
+		b.deadline, b.hasDeadline = deadline, ok
+	} else if b.hasDeadline {
+		b.deadline, b.hasDeadline = maxTime(b.deadline, deadline), ok
+	}
+	b.edits = append(b.edits, edit)
+}
+
+func maxTime(a, b time.Time) time.Time {
+	if a.After(b) {
+		return a
+	}
+	return b
+}
+
+func newZoneBatcher(window time.Duration) *zoneBatcher {
+	return &zoneBatcher{
+		window:  window,
+		after:   time.After,
+		pending: map[string]*zoneBatch{},
+	}
+}
+
+// len returns the number of edits waiting for their batch.
+func (b *zoneBatcher) len() int {
+	if b == nil {
+		return 0
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	n := 0
+	for _, batch := range b.pending {
+		n += len(batch.edits)
+	}
+	return n
+}
+
+// do runs edit, made with ctx, as part of the batch for key. The first edit
+// for a key waits for the window to pass and then calls run with every edit
+// collected meanwhile; the others block until run has returned. The batch
+// runs with the values of ctx, but is not canceled with the ctx of any one
+// edit: it runs until the latest deadline of their callers, so that a
+// challenge given up on does not fail the others. With a zero window every
+// edit is run on its own, with its ctx.
+func (b *zoneBatcher) do(ctx context.Context, key string, edit *zoneEdit, run func(ctx context.Context, edits []*zoneEdit)) {
+	if b == nil || b.window <= 0 {
+		run(ctx, []*zoneEdit{edit})
+		return
+	}
+
+	edit.done = make(chan struct{})
+	b.mu.Lock()
+	if batch, ok := b.pending[key]; ok {
+		batch.add(ctx, edit)
+		b.mu.Unlock()
+		<-edit.done
+		return
+	}
+	batch := &zoneBatch{}
+	batch.add(ctx, edit)
+	b.pending[key] = batch
+	b.mu.Unlock()
+
+	<-b.after(b.window)
+
+	b.mu.Lock()
+	delete(b.pending, key)
+	b.mu.Unlock()
+
+	ctx = context.WithoutCancel(ctx)
+	if batch.hasDeadline {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithDeadline(ctx, batch.deadline)
+		defer cancel()
+	}
+	run(ctx, batch.edits)
+	for _, e := range batch.edits {
+		close(e.done)
+	}
+}
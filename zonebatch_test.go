@@ -0,0 +1,129 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	cmmeta "github.com/cert-manager/cert-manager/pkg/apis/meta/v1"
+	sctesting "github.com/cert-manager/webhook-example/pkg/testing"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newClockedZoneBatcher returns a batcher whose window passes only when
+// clock is stepped.
+func newClockedZoneBatcher(window time.Duration) (*zoneBatcher, *sctesting.Clock) {
+	clock := sctesting.NewClock(time.Now())
+	b := newZoneBatcher(window)
+	b.after = clock.After
+	return b, clock
+}
+
+func TestZoneBatcherGroupsEditsOfSameKey(t *testing.T) {
+	b, clock := newClockedZoneBatcher(time.Second)
+
+	var mu sync.Mutex
+	var batches [][]*zoneEdit
+	run := func(_ context.Context, edits []*zoneEdit) {
+		mu.Lock()
+		defer mu.Unlock()
+		batches = append(batches, edits)
+	}
+
+	var wg sync.WaitGroup
+	for _, key := range []string{"a", "a", "a", "b"} {
+		wg.Add(1)
+		go func(key string) {
+			defer wg.Done()
+			b.do(context.Background(), key, &zoneEdit{}, run)
+		}(key)
+	}
+	require.Eventually(t, func() bool { return b.len() == 4 }, 5*time.Second, time.Millisecond)
+	clock.Step(time.Second)
+	wg.Wait()
+
+	var sizes []int
+	for _, edits := range batches {
+		sizes = append(sizes, len(edits))
+	}
+	assert.ElementsMatch(t, []int{3, 1}, sizes)
+	assert.Empty(t, b.pending)
+}
+
+func TestZoneBatcherContext(t *testing.T) {
+	b, clock := newClockedZoneBatcher(time.Second)
+	now := time.Now()
+	first, cancel := context.WithDeadline(context.Background(), now.Add(time.Minute))
+	second, cancelSecond := context.WithDeadline(context.Background(), now.Add(time.Hour))
+	defer cancelSecond()
+
+	var batchCtx context.Context
+	var batchErr error
+	var wg sync.WaitGroup
+	for i, ctx := range []context.Context{first, second} {
+		wg.Add(1)
+		go func(ctx context.Context) {
+			defer wg.Done()
+			b.do(ctx, "a", &zoneEdit{}, func(ctx context.Context, edits []*zoneEdit) {
+				assert.Len(t, edits, 2)
+				batchCtx, batchErr = ctx, ctx.Err()
+			})
+		}(ctx)
+		// The edit of first opens the batch.
+		require.Eventually(t, func() bool { return b.len() == i+1 }, 5*time.Second, time.Millisecond)
+	}
+
+	// The caller of the edit that opened the batch gives up.
+	cancel()
+	clock.Step(time.Second)
+	wg.Wait()
+	require.NotNil(t, batchCtx)
+	deadline, ok := batchCtx.Deadline()
+	assert.True(t, ok)
+	assert.Equal(t, now.Add(time.Hour), deadline, "the latest deadline of the callers")
+	assert.NoError(t, batchErr, "not canceled with the first caller")
+
+	// Without a deadline for one of the callers, the batch has none.
+	var batch zoneBatch
+	batch.add(second, &zoneEdit{})
+	batch.add(context.Background(), &zoneEdit{})
+	assert.False(t, batch.hasDeadline)
+}
+
+func TestZoneBatcherWithoutWindow(t *testing.T) {
+	b := newZoneBatcher(0)
+
+	calls := 0
+	for i := 0; i < 3; i++ {
+		b.do(context.Background(), "a", &zoneEdit{}, func(_ context.Context, edits []*zoneEdit) {
+			assert.Len(t, edits, 1)
+			calls++
+		})
+	}
+	assert.Equal(t, 3, calls)
+}
+
+func TestBatchKey(t *testing.T) {
+	ref := func(name, key string) *cmmeta.SecretKeySelector {
+		return &cmmeta.SecretKeySelector{LocalObjectReference: cmmeta.LocalObjectReference{Name: name}, Key: key}
+	}
+	cfg := &sakuraCloudDNSProviderConfig{ZoneID: 1, AccessTokenRef: *ref("creds", "accessToken"), AccessTokenSecretRef: *ref("creds", "accessTokenSecret")}
+	withSecondary := func(name string) *sakuraCloudDNSProviderConfig {
+		c := *cfg
+		c.SecondaryAccessTokenRef, c.SecondaryAccessTokenSecretRef = ref(name, "accessToken"), ref(name, "accessTokenSecret")
+		return &c
+	}
+
+	assert.NotEqual(t, batchKey("acme", cfg), batchKey("other", cfg))
+	assert.NotEqual(t, batchKey("acme", cfg), batchKey("acme", withSecondary("old")))
+	assert.NotEqual(t, batchKey("acme", withSecondary("old")), batchKey("acme", withSecondary("new")),
+		"challenges falling back to different keys are not batched")
+	other := *cfg
+	other.AccessTokenRef.Key = "token"
+	assert.NotEqual(t, batchKey("acme", cfg), batchKey("acme", &other))
+	other = *cfg
+	other.ZoneName = "example.com"
+	assert.NotEqual(t, batchKey("acme", cfg), batchKey("acme", &other), "the zone name is checked on read")
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"slices"
+	"sync"
+	"time"
+)
+
+// zoneMutation is one edit of a zone in the zoneHistory.
+type zoneMutation struct {
+	Time time.Time `json:"time"`
+	// Operation is present, cleanup or prune.
+	Operation string `json:"operation"`
+	FQDN      string `json:"fqdn,omitempty"`
+	// Result is updated if the edit was written to the zone, unchanged if
+	// the zone already was as the edit wanted, or failed with Error.
+	Result string `json:"result"`
+	Error  string `json:"error,omitempty"`
+}
+
+// zoneHistory keeps the last edits of every zone in memory, for
+// /debug/zones/history, so that incidents can be investigated without the
+// logs. A nil history keeps nothing.
+type zoneHistory struct {
+	size int
+	now  func() time.Time
+
+	mu    sync.Mutex
+	zones map[int64]*mutationRing
+}
+
+// mutationRing holds the last len(mutations) mutations of a zone; next is
+// where the next one goes, overwriting the oldest once the ring is full.
+type mutationRing struct {
+	mutations []zoneMutation
+	next      int
+	full      bool
+}
+
+// newZoneHistory returns a history keeping size edits per zone, or nil if
+// size is not positive.
+func newZoneHistory(size int) *zoneHistory {
+	if size <= 0 {
+		return nil
+	}
+	return &zoneHistory{size: size, now: time.Now, zones: map[int64]*mutationRing{}}
+}
+
+// record records the outcome of edits, applied to zoneID together.
+func (h *zoneHistory) record(zoneID int64, edits []*zoneEdit) {
+	if h == nil {
+		return
+	}
+	now := h.now()
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	r, ok := h.zones[zoneID]
+	if !ok {
+		r = &mutationRing{mutations: make([]zoneMutation, h.size)}
+		h.zones[zoneID] = r
+	}
+	for _, e := range edits {
+		m := zoneMutation{Time: now, Operation: e.operation(), FQDN: e.op.fqdn, Result: "unchanged"}
+		switch {
+		case e.err != nil:
+			m.Result, m.Error = "failed", e.err.Error()
+		case e.changed:
+			m.Result = "updated"
+		}
+		r.mutations[r.next] = m
+		r.next = (r.next + 1) % len(r.mutations)
+		r.full = r.full || r.next == 0
+	}
+}
+
+// len returns the number of zones with recorded edits.
+func (h *zoneHistory) len() int {
+	if h == nil {
+		return 0
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return len(h.zones)
+}
+
+// list returns the recorded edits of every zone, oldest first.
+func (h *zoneHistory) list() map[int64][]zoneMutation {
+	zones := map[int64][]zoneMutation{}
+	if h == nil {
+		return zones
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for id, r := range h.zones {
+		if r.full {
+			zones[id] = append(slices.Clone(r.mutations[r.next:]), r.mutations[:r.next]...)
+		} else {
+			zones[id] = slices.Clone(r.mutations[:r.next])
+		}
+	}
+	return zones
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestZoneHistory(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	h := newZoneHistory(3)
+	h.now = func() time.Time { return now }
+
+	present := &zoneEdit{op: zoneOp{fqdn: "_acme-challenge.a.example.com."}, changed: true}
+	cleanup := &zoneEdit{op: zoneOp{fqdn: "_acme-challenge.a.example.com.", cleanup: true}}
+	failed := &zoneEdit{op: zoneOp{fqdn: "_acme-challenge.b.example.com."}, err: errors.New("409 Conflict")}
+	h.record(1, []*zoneEdit{present, cleanup})
+	h.record(2, []*zoneEdit{{changed: true}})
+
+	assert.Equal(t, map[int64][]zoneMutation{
+		1: {
+			{Time: now, Operation: "present", FQDN: "_acme-challenge.a.example.com.", Result: "updated"},
+			{Time: now, Operation: "cleanup", FQDN: "_acme-challenge.a.example.com.", Result: "unchanged"},
+		},
+		2: {{Time: now, Operation: "prune", Result: "updated"}},
+	}, h.list())
+
+	h.record(1, []*zoneEdit{failed, present})
+	history := h.list()[1]
+	require.Len(t, history, 3, "only the last edits are kept")
+	assert.Equal(t, "cleanup", history[0].Operation)
+	assert.Equal(t, zoneMutation{Time: now, Operation: "present", FQDN: "_acme-challenge.b.example.com.", Result: "failed", Error: "409 Conflict"}, history[1])
+	assert.Equal(t, "present", history[2].Operation)
+
+	assert.Nil(t, newZoneHistory(0))
+	var disabled *zoneHistory
+	disabled.record(1, []*zoneEdit{present})
+	assert.Empty(t, disabled.list())
+}
+
+func TestDebugZoneHistory(t *testing.T) {
+	h := newZoneHistory(10)
+	h.record(1, []*zoneEdit{{op: zoneOp{fqdn: "_acme-challenge.example.com."}, changed: true}})
+	h.record(2, []*zoneEdit{{changed: true}})
+	handler := debugHandler("", h)
+
+	get := func(url string) (int, map[string][]zoneMutation) {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, url, nil))
+		var zones map[string][]zoneMutation
+		if rec.Code == http.StatusOK {
+			require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &zones))
+		}
+		return rec.Code, zones
+	}
+
+	code, zones := get("/debug/zones/history")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Len(t, zones, 2)
+	code, zones = get("/debug/zones/history?zone=1")
+	assert.Equal(t, http.StatusOK, code)
+	require.Len(t, zones["1"], 1)
+	assert.Equal(t, "_acme-challenge.example.com.", zones["1"][0].FQDN)
+	code, _ = get("/debug/zones/history?zone=x")
+	assert.Equal(t, http.StatusBadRequest, code)
+}
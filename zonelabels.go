@@ -0,0 +1,81 @@
+package main
+
+import (
+	"path"
+	"sync"
+
+	"github.com/sacloud/iaas-api-go"
+)
+
+// otherZoneLabel is the zone label value of zones that do not get their own.
+const otherZoneLabel = "other"
+
+// zoneLabels limits the values of the zone label of the solver metrics. An
+// account with hundreds of zones would otherwise create a series per zone
+// for every zone metric.
+var zoneLabels = &zoneLabelLimiter{}
+
+// zoneLabelLimiter maps zones to metric label values. Zones not matching
+// allow, or seen after max other zones already got their own label, are
+// folded into "other". Some metrics label a zone with its ID and others
+// with its name, so the zones are counted by ID: a zone takes one of the
+// max labels whichever of the two it is reported with.
+type zoneLabelLimiter struct {
+	// allow holds path.Match patterns matched against the zone ID and the
+	// label value.
+	// Empty allows every zone.
+	allow []string
+	// max caps the number of distinct zone labels. Zero is unlimited.
+	max int
+
+	mu sync.Mutex
+	// seen holds the IDs of the zones that got their own label.
+	seen map[string]struct{}
+}
+
+// label returns value, the ID or the name of zone id, or "other" if the
+// zone does not get its own label.
+func (l *zoneLabelLimiter) label(id, value string) string {
+	if !l.allowed(id) && !l.allowed(value) {
+		return otherZoneLabel
+	}
+	if l.max <= 0 {
+		return value
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if _, ok := l.seen[id]; ok {
+		return value
+	}
+	if len(l.seen) >= l.max {
+		return otherZoneLabel
+	}
+	if l.seen == nil {
+		l.seen = map[string]struct{}{}
+	}
+	l.seen[id] = struct{}{}
+	return value
+}
+
+// idLabel returns the label of zone id reported with its ID.
+func (l *zoneLabelLimiter) idLabel(id string) string {
+	return l.label(id, id)
+}
+
+// nameLabel returns the label of zone reported with its name.
+func (l *zoneLabelLimiter) nameLabel(zone *iaas.DNS) string {
+	return l.label(zone.ID.String(), zone.Name)
+}
+
+func (l *zoneLabelLimiter) allowed(zone string) bool {
+	if len(l.allow) == 0 {
+		return true
+	}
+	for _, pattern := range l.allow {
+		if ok, err := path.Match(pattern, zone); err == nil && ok {
+			return true
+		}
+	}
+	return false
+}
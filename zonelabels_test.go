@@ -0,0 +1,51 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/sacloud/iaas-api-go"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestZoneLabelLimiter(t *testing.T) {
+	t.Run("unlimited", func(t *testing.T) {
+		l := &zoneLabelLimiter{}
+		assert.Equal(t, "example.com", l.label("113000000001", "example.com"))
+		assert.Equal(t, "113000000001", l.idLabel("113000000001"))
+	})
+
+	t.Run("allowlist", func(t *testing.T) {
+		l := &zoneLabelLimiter{allow: []string{"*.example.com", "113000000001"}}
+		assert.Equal(t, "a.example.com", l.label("113000000003", "a.example.com"))
+		assert.Equal(t, "113000000001", l.idLabel("113000000001"))
+		assert.Equal(t, "example.net", l.label("113000000001", "example.net"), "allowed by ID")
+		assert.Equal(t, "other", l.label("113000000002", "example.org"))
+		assert.Equal(t, "other", l.idLabel("113000000002"))
+	})
+
+	t.Run("cap", func(t *testing.T) {
+		l := &zoneLabelLimiter{max: 2}
+		assert.Equal(t, "a", l.label("1", "a"))
+		assert.Equal(t, "b", l.label("2", "b"))
+		assert.Equal(t, "other", l.label("3", "c"))
+		assert.Equal(t, "a", l.label("1", "a"), "zones seen before the cap keep their label")
+	})
+
+	t.Run("cap counts zones by ID", func(t *testing.T) {
+		l := &zoneLabelLimiter{max: 2}
+		a := &iaas.DNS{ID: 1, Name: "a.example"}
+		b := &iaas.DNS{ID: 2, Name: "b.example"}
+		assert.Equal(t, "1", l.idLabel("1"))
+		assert.Equal(t, "a.example", l.nameLabel(a), "the zone already has a label")
+		assert.Equal(t, "b.example", l.nameLabel(b))
+		assert.Equal(t, "2", l.idLabel("2"))
+		assert.Equal(t, "other", l.idLabel("3"))
+	})
+
+	t.Run("cap only counts allowed zones", func(t *testing.T) {
+		l := &zoneLabelLimiter{allow: []string{"a", "b"}, max: 1}
+		assert.Equal(t, "other", l.label("3", "c"))
+		assert.Equal(t, "a", l.label("1", "a"))
+		assert.Equal(t, "other", l.label("2", "b"))
+	})
+}
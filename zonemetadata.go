@@ -0,0 +1,186 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cert-manager/cert-manager/pkg/acme/webhook/apis/acme/v1alpha1"
+	"github.com/sacloud/iaas-api-go"
+	"github.com/sacloud/iaas-api-go/search"
+	"github.com/sacloud/iaas-api-go/types"
+)
+
+// zoneFinder is the part of the DNS API that lists zones. Unlike Read it
+// does not return the records, so it is a cheap way to read the metadata of
+// a large zone.
+type zoneFinder interface {
+	Find(ctx context.Context, conditions *iaas.FindCondition) (*iaas.DNSFindResult, error)
+}
+
+// zoneMetadataKey identifies the metadata of a zone as read with one API
+// key, so that a hit also means the key could read the zone.
+type zoneMetadataKey struct {
+	credential string
+	zoneID     int64
+}
+
+type cachedZoneMetadata struct {
+	zone    *iaas.DNS
+	expires time.Time
+}
+
+// zoneMetadataCache remembers the ID, name and nameservers of zones, which
+// hardly ever change, for --zone-metadata-cache-ttl: much longer than the
+// records could be cached. It is filled by every zone read, so that the
+// Issuer checks and the propagation checkers do not read zones, records and
+// all, to learn what the challenges already read.
+type zoneMetadataCache struct {
+	ttl time.Duration
+	now func() time.Time
+
+	mu      sync.Mutex
+	entries map[zoneMetadataKey]cachedZoneMetadata
+	// names maps zone names, without the trailing dot, to the metadata
+	// read last with any key.
+	names map[string]cachedZoneMetadata
+}
+
+func newZoneMetadataCache(ttl time.Duration) *zoneMetadataCache {
+	return &zoneMetadataCache{
+		ttl:     ttl,
+		now:     time.Now,
+		entries: map[zoneMetadataKey]cachedZoneMetadata{},
+		names:   map[string]cachedZoneMetadata{},
+	}
+}
+
+// len returns the number of zones cached per key, including expired ones
+// that were not looked up again.
+func (m *zoneMetadataCache) len() int {
+	if m == nil {
+		return 0
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return len(m.entries)
+}
+
+// put remembers the metadata of zone as read with the key whose hash is
+// credential, and drops expired entries. The records are not kept.
+func (m *zoneMetadataCache) put(credential string, zone *iaas.DNS) {
+	if m == nil || m.ttl <= 0 {
+		return
+	}
+	meta := &iaas.DNS{
+		ID:             zone.ID,
+		Name:           zone.Name,
+		DNSNameServers: zone.DNSNameServers,
+	}
+	now := m.now()
+	e := cachedZoneMetadata{zone: meta, expires: now.Add(m.ttl)}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for k, old := range m.entries {
+		if !now.Before(old.expires) {
+			delete(m.entries, k)
+		}
+	}
+	for name, old := range m.names {
+		if !now.Before(old.expires) {
+			delete(m.names, name)
+		}
+	}
+	m.entries[zoneMetadataKey{credential, zone.ID.Int64()}] = e
+	m.names[strings.TrimSuffix(zone.Name, ".")] = e
+}
+
+// get returns the metadata of zoneID as read with the key whose hash is
+// credential within the TTL.
+func (m *zoneMetadataCache) get(credential string, zoneID int64) (*iaas.DNS, bool) {
+	if m == nil || m.ttl <= 0 {
+		return nil, false
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	e, ok := m.entries[zoneMetadataKey{credential, zoneID}]
+	if !ok || !m.now().Before(e.expires) {
+		return nil, false
+	}
+	return e.zone, true
+}
+
+// nameservers returns the nameservers Sakura Cloud assigned to the zone
+// called name, with or without the trailing dot, if it was read within the
+// TTL.
+func (m *zoneMetadataCache) nameservers(name string) ([]string, bool) {
+	if m == nil || m.ttl <= 0 {
+		return nil, false
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	e, ok := m.names[strings.TrimSuffix(name, ".")]
+	if !ok || !m.now().Before(e.expires) || len(e.zone.DNSNameServers) == 0 {
+		return nil, false
+	}
+	return e.zone.DNSNameServers, true
+}
+
+// readZoneMetadata returns the metadata of the configured zone, from the
+// cache or else read with the first credential that the API accepts, like
+// readZone. The credential Secrets are read either way. On a miss the zone
+// is looked up with Find, which leaves the records out, if the client has
+// it.
+func (c *sakuraCloudDNSProviderSolver) readZoneMetadata(ctx context.Context, cfg *sakuraCloudDNSProviderConfig, ch *v1alpha1.ChallengeRequest) (*iaas.DNS, error) {
+	var errs []error
+	for _, cred := range cfg.credentials() {
+		start := time.Now()
+		key, err := c.resolveAPIKey(ctx, cred, ch.ResourceNamespace)
+		observePhase("secret_fetch", start)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s credential: %w", cred.name, err))
+			continue
+		}
+		client, status := c.clientForKey(key, cred)
+		if zone, ok := c.metadata.get(status.Credential, cfg.ZoneID); ok {
+			return zone, cfg.checkZone(zone)
+		}
+		zone, err := findZone(ctx, client, cfg.ZoneID)
+		credentialStatuses.record(status, cfg.ZoneID, err)
+		if err != nil {
+			if isAuthError(err) {
+				sampledLog.Warningf("%s credential was rejected for zone %d: %v", cred.name, cfg.ZoneID, err)
+				errs = append(errs, fmt.Errorf("%s credential: %w", cred.name, err))
+				continue
+			}
+			return nil, err
+		}
+		c.metadata.put(status.Credential, zone)
+		return zone, cfg.checkZone(zone)
+	}
+	return nil, errors.Join(errs...)
+}
+
+// findZone reads zone zoneID without its records if client can list zones,
+// else, or if the zone is not listed, with Read.
+func findZone(ctx context.Context, client zoneAPI, zoneID int64) (*iaas.DNS, error) {
+	if b, ok := client.(*budgetedZoneAPI); ok {
+		client = b.zoneAPI
+	}
+	if finder, ok := client.(zoneFinder); ok {
+		res, err := finder.Find(ctx, &iaas.FindCondition{Filter: search.Filter{search.Key("ID"): search.ExactMatch(strconv.FormatInt(zoneID, 10))}})
+		if err != nil {
+			return nil, err
+		}
+		for _, zone := range res.DNS {
+			if zone.ID.Int64() == zoneID {
+				return zone, nil
+			}
+		}
+	}
+	return client.Read(ctx, types.Int64ID(zoneID))
+}
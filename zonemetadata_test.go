@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/sacloud/iaas-api-go"
+	"github.com/sacloud/iaas-api-go/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestZoneMetadataCache(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	m := newZoneMetadataCache(time.Hour)
+	m.now = func() time.Time { return now }
+
+	m.put("a", &iaas.DNS{
+		ID:             types.Int64ID(123),
+		Name:           "example.com",
+		DNSNameServers: []string{"ns1.gslb9.sakura.ne.jp"},
+		Records:        []*iaas.DNSRecord{{Name: "www", Type: types.DNSRecordTypes.A, RData: "192.0.2.1"}},
+	})
+	zone, ok := m.get("a", 123)
+	require.True(t, ok)
+	assert.Equal(t, "example.com", zone.Name)
+	assert.Empty(t, zone.Records, "the records are not kept")
+	_, ok = m.get("b", 123)
+	assert.False(t, ok, "the metadata is cached per API key")
+	servers, ok := m.nameservers("example.com.")
+	assert.True(t, ok)
+	assert.Equal(t, []string{"ns1.gslb9.sakura.ne.jp"}, servers)
+
+	now = now.Add(time.Hour)
+	_, ok = m.get("a", 123)
+	assert.False(t, ok, "expired")
+	_, ok = m.nameservers("example.com")
+	assert.False(t, ok, "expired")
+	m.put("a", &iaas.DNS{ID: types.Int64ID(456), Name: "example.org"})
+	assert.Equal(t, 1, m.len(), "expired entries are dropped")
+	_, ok = m.nameservers("example.org")
+	assert.False(t, ok, "zones without nameservers fall back to the defaults")
+
+	disabled := newZoneMetadataCache(0)
+	disabled.put("a", &iaas.DNS{ID: types.Int64ID(123), Name: "example.com"})
+	_, ok = disabled.get("a", 123)
+	assert.False(t, ok)
+	var none *zoneMetadataCache
+	none.put("a", &iaas.DNS{ID: types.Int64ID(123), Name: "example.com"})
+	assert.Zero(t, none.len())
+}
+
+// readCountingZoneAPI is a zoneAPI counting the calls of Read.
+type readCountingZoneAPI struct {
+	zoneAPI
+	reads int
+}
+
+func (z *readCountingZoneAPI) Read(ctx context.Context, id types.ID) (*iaas.DNS, error) {
+	z.reads++
+	return z.zoneAPI.Read(ctx, id)
+}
+
+func TestFindZone(t *testing.T) {
+	api := &memoryZoneAPI{zone: &iaas.DNS{
+		ID:      types.Int64ID(123),
+		Name:    "example.com",
+		Records: []*iaas.DNSRecord{{Name: "www", Type: types.DNSRecordTypes.A, RData: "192.0.2.1"}},
+	}}
+	zone, err := findZone(context.Background(), &budgetedZoneAPI{zoneAPI: api, credential: "a"}, 123)
+	require.NoError(t, err)
+	assert.Equal(t, "example.com", zone.Name)
+	assert.Empty(t, zone.Records, "listed without the records")
+
+	reader := &readCountingZoneAPI{zoneAPI: api}
+	zone, err = findZone(context.Background(), reader, 123)
+	require.NoError(t, err)
+	assert.Equal(t, "example.com", zone.Name)
+	assert.Equal(t, 1, reader.reads, "clients that cannot list zones read them")
+
+	_, err = findZone(context.Background(), api, 456)
+	require.NoError(t, err, "zones that are not listed are read")
+}
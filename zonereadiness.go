@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/sacloud/iaas-api-go"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/klog/v2"
+)
+
+// installationCredential is the credential of the readiness checks: the
+// key of the installation, from the providers of --credential-providers
+// that do not need a Secret of an Issuer.
+var installationCredential = credential{name: "installation"}
+
+// zoneReadiness holds the result of the last readiness check of the zones
+// of --readiness-zones, and exports it in zoneReady.
+type zoneReadiness struct {
+	mu sync.Mutex
+	// names are the names the zones had when they were last read, for the
+	// name label.
+	names map[int64]string
+	ready map[int64]bool
+}
+
+func newZoneReadiness() *zoneReadiness {
+	return &zoneReadiness{names: map[int64]string{}, ready: map[int64]bool{}}
+}
+
+// record records the check of zoneID, which read zone unless it failed
+// with err. A zone becoming unready is logged with the reason once.
+func (r *zoneReadiness) record(zoneID int64, zone *iaas.DNS, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	id := strconv.FormatInt(zoneID, 10)
+	if zone != nil && zone.Name != r.names[zoneID] {
+		if old, ok := r.names[zoneID]; ok {
+			zoneReady.DeleteLabelValues(id, old)
+		}
+		r.names[zoneID] = zone.Name
+	}
+	name := r.names[zoneID]
+	ready, checked := r.ready[zoneID]
+	r.ready[zoneID] = err == nil
+	if err != nil {
+		zoneReady.WithLabelValues(id, name).Set(0)
+		if ready || !checked {
+			klog.Warningf("zone %d %s is not ready, challenges for it would fail now: %v", zoneID, name, err)
+		}
+		return
+	}
+	zoneReady.WithLabelValues(id, name).Set(1)
+	if checked && !ready {
+		klog.Infof("zone %d %s is ready again", zoneID, name)
+	}
+}
+
+// checkZoneReadiness checks every interval, until stopCh is closed, that
+// the zones of --readiness-zones can be read with the key of the
+// installation and are available, so that dashboards show which zones
+// challenges would fail for before any certificate is requested.
+func (c *sakuraCloudDNSProviderSolver) checkZoneReadiness(interval time.Duration, stopCh <-chan struct{}) {
+	readiness := newZoneReadiness()
+	wait.Until(func() {
+		ctx, cancel := context.WithTimeout(wait.ContextForChannel(stopCh), interval)
+		defer cancel()
+		for _, zoneID := range c.opts.ReadinessZones {
+			zone, err := c.readInstallationZone(ctx, zoneID)
+			readiness.record(zoneID, zone, err)
+		}
+	}, interval, stopCh)
+}
+
+// readInstallationZone reads the metadata of zoneID with the key of the
+// installation and checks that the zone is available.
+func (c *sakuraCloudDNSProviderSolver) readInstallationZone(ctx context.Context, zoneID int64) (*iaas.DNS, error) {
+	key, err := c.findAPIKey(ctx, installationCredential, "", true)
+	if err != nil {
+		return nil, err
+	}
+	client, status := c.clientForKey(key, installationCredential)
+	zone, err := findZone(ctx, client, zoneID)
+	credentialStatuses.record(status, zoneID, err)
+	if err != nil {
+		return nil, err
+	}
+	c.metadata.put(status.Credential, zone)
+	return zone, checkZoneAvailable(zone)
+}
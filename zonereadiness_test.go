@@ -0,0 +1,125 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	sctesting "github.com/cert-manager/webhook-example/pkg/testing"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/sacloud/iaas-api-go"
+	"github.com/sacloud/iaas-api-go/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestZoneReadiness(t *testing.T) {
+	r := newZoneReadiness()
+	r.record(42, &iaas.DNS{ID: types.ID(42), Name: "example.com"}, nil)
+	assert.Equal(t, 1.0, testutil.ToFloat64(zoneReady.WithLabelValues("42", "example.com")))
+
+	r.record(42, nil, errors.New("forbidden"))
+	assert.Equal(t, 0.0, testutil.ToFloat64(zoneReady.WithLabelValues("42", "example.com")), "keeps the last name")
+
+	r.record(42, &iaas.DNS{ID: types.ID(42), Name: "example.org"}, nil)
+	assert.Equal(t, 1.0, testutil.ToFloat64(zoneReady.WithLabelValues("42", "example.org")))
+	assert.False(t, zoneReady.DeleteLabelValues("42", "example.com"), "series of the old name deleted")
+
+	r.record(7, nil, errors.New("not found"))
+	assert.Equal(t, 0.0, testutil.ToFloat64(zoneReady.WithLabelValues("7", "")), "never read")
+}
+
+// rejectedKeyZones is the zone API as seen with an API key that the API
+// rejects.
+type rejectedKeyZones struct{}
+
+func (rejectedKeyZones) Read(_ context.Context, id types.ID) (*iaas.DNS, error) {
+	return nil, sctesting.NewAPIError(http.MethodGet, id, http.StatusUnauthorized, "unauthorized", "the access token is invalid")
+}
+
+func (rejectedKeyZones) UpdateSettings(_ context.Context, id types.ID, _ *iaas.DNSUpdateSettingsRequest) (*iaas.DNS, error) {
+	return nil, sctesting.NewAPIError(http.MethodPut, id, http.StatusUnauthorized, "unauthorized", "the access token is invalid")
+}
+
+// newReadinessSolver returns a solver whose installation key, from the
+// environment, reads zones unless the API rejects it: any token other than
+// "token" is rejected.
+func newReadinessSolver(t *testing.T, zones *sctesting.ZoneClient) *sakuraCloudDNSProviderSolver {
+	t.Setenv("SAKURACLOUD_ACCESS_TOKEN", "token")
+	t.Setenv("SAKURACLOUD_ACCESS_TOKEN_SECRET", "secret")
+	c := newHarnessSolver(zones, sctesting.NewSecretStore())
+	c.opts.CredentialProviders = []string{"env"}
+	c.newZoneAPI = func(token, _ string) zoneAPI {
+		if token != "token" {
+			return rejectedKeyZones{}
+		}
+		return zones
+	}
+	return c
+}
+
+func TestReadInstallationZone(t *testing.T) {
+	zones := sctesting.NewZoneClient(
+		&iaas.DNS{ID: 1, Name: "example.com", Availability: types.Availabilities.Available},
+		&iaas.DNS{ID: 2, Name: "example.net", Availability: types.Availabilities.Failed},
+	)
+	c := newReadinessSolver(t, zones)
+
+	zone, err := c.readInstallationZone(context.Background(), 1)
+	require.NoError(t, err)
+	assert.Equal(t, "example.com", zone.Name)
+
+	zone, err = c.readInstallationZone(context.Background(), 2)
+	var unavailable *zoneUnavailableError
+	assert.ErrorAs(t, err, &unavailable)
+	require.NotNil(t, zone, "the zone is returned for its name")
+	assert.Equal(t, "example.net", zone.Name)
+
+	_, err = c.readInstallationZone(context.Background(), 3)
+	assert.Error(t, err, "unknown zone")
+
+	t.Setenv("SAKURACLOUD_ACCESS_TOKEN", "revoked")
+	_, err = c.readInstallationZone(context.Background(), 1)
+	var apiErr iaas.APIError
+	require.ErrorAs(t, err, &apiErr)
+	assert.Equal(t, http.StatusUnauthorized, apiErr.ResponseCode())
+
+	t.Setenv("SAKURACLOUD_ACCESS_TOKEN", "")
+	_, err = c.readInstallationZone(context.Background(), 1)
+	assert.EqualError(t, err, "none of the credential providers env has an API key for the installation credential")
+}
+
+func TestCheckZoneReadiness(t *testing.T) {
+	zones := sctesting.NewZoneClient(
+		&iaas.DNS{ID: 4771, Name: "example.com", Availability: types.Availabilities.Available},
+		&iaas.DNS{ID: 4772, Name: "example.net", Availability: types.Availabilities.Failed},
+	)
+	c := newReadinessSolver(t, zones)
+	// The unavailable zone is checked first, so it has been checked once
+	// the other one is ready.
+	c.opts.ReadinessZones = []int64{4772, 4771}
+	ready := func(id, name string) float64 { return testutil.ToFloat64(zoneReady.WithLabelValues(id, name)) }
+
+	stopCh, stopped := make(chan struct{}), make(chan struct{})
+	go func() {
+		c.checkZoneReadiness(10*time.Millisecond, stopCh)
+		close(stopped)
+	}()
+	defer func() {
+		close(stopCh)
+		<-stopped
+	}()
+
+	assert.Eventually(t, func() bool { return ready("4771", "example.com") == 1 }, 5*time.Second, 10*time.Millisecond)
+	assert.Equal(t, 0.0, ready("4772", "example.net"), "unavailable zone")
+
+	// The key of the installation is revoked: every zone becomes unready,
+	// under the name it was last read with.
+	t.Setenv("SAKURACLOUD_ACCESS_TOKEN", "revoked")
+	assert.Eventually(t, func() bool { return ready("4771", "example.com") == 0 }, 5*time.Second, 10*time.Millisecond)
+
+	t.Setenv("SAKURACLOUD_ACCESS_TOKEN", "token")
+	assert.Eventually(t, func() bool { return ready("4771", "example.com") == 1 }, 5*time.Second, 10*time.Millisecond)
+}
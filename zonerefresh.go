@@ -0,0 +1,122 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	mdns "github.com/miekg/dns"
+	"k8s.io/klog/v2"
+)
+
+// zoneRefreshInterval is how often the SOA serials are read while waiting
+// for --refresh-after-write.
+const zoneRefreshInterval = time.Second
+
+// zoneRefresh holds the SOA serials the nameservers of a zone served before
+// the zone was updated. The API of Sakura Cloud cannot bump the serial or
+// send a NOTIFY to the secondaries, so with --refresh-after-write Present
+// waits for every nameserver to serve another serial instead: the records
+// are then served everywhere when cert-manager starts its self check, which
+// otherwise fails and waits for its next retry on low TTLs.
+type zoneRefresh struct {
+	zone    string
+	serials map[string]uint32
+	timeout time.Duration
+}
+
+// newZoneRefresh reads the serials of zone, a zone name, from its
+// nameservers, or the ones of --propagation-nameservers. It returns nil
+// without --refresh-after-write, and if no nameserver answers, in which
+// case Present does not wait. Present reads the serials before it knows
+// whether the zone has to be updated, since the zone is held by the
+// update once it is known.
+func (c *sakuraCloudDNSProviderSolver) newZoneRefresh(ctx context.Context, zone string) *zoneRefresh {
+	if c.opts.RefreshAfterWrite <= 0 {
+		return nil
+	}
+	zone = mdns.Fqdn(zone)
+	servers := c.opts.PropagationNameservers
+	if len(servers) == 0 {
+		var err error
+		if servers, err = zoneNameservers.get(ctx, zone); err != nil {
+			servers = c.fallbackNameservers(zone)
+		}
+	}
+	r := &zoneRefresh{zone: zone, serials: map[string]uint32{}, timeout: c.opts.RefreshAfterWrite}
+	for _, server := range servers {
+		serial, err := lookupSerial(ctx, server, zone)
+		if err != nil {
+			klog.V(4).Infof("not waiting for %s to refresh zone %s: %v", server, zone, err)
+			continue
+		}
+		r.serials[server] = serial
+	}
+	if len(r.serials) == 0 {
+		return nil
+	}
+	return r
+}
+
+// wait waits until every nameserver serves a serial other than the one it
+// served before the update, for at most the timeout of
+// --refresh-after-write. It only logs nameservers that did not refresh in
+// time: the propagation check decides whether the record is served.
+func (r *zoneRefresh) wait(ctx context.Context) {
+	if r == nil {
+		return
+	}
+	defer observePhase("zone_refresh", time.Now())
+	ctx, cancel := context.WithTimeout(ctx, r.timeout)
+	defer cancel()
+	pending := make(map[string]uint32, len(r.serials))
+	for server, serial := range r.serials {
+		pending[server] = serial
+	}
+	for {
+		for server, before := range pending {
+			if serial, err := lookupSerial(ctx, server, r.zone); err == nil && serial != before {
+				klog.V(4).Infof("%s serves serial %d of zone %s", server, serial, r.zone)
+				delete(pending, server)
+			}
+		}
+		if len(pending) == 0 {
+			return
+		}
+		select {
+		case <-ctx.Done():
+			servers := make([]string, 0, len(pending))
+			for server := range pending {
+				servers = append(servers, server)
+			}
+			sampledLog.Warningf("%s did not refresh zone %s within %s of the update", strings.Join(servers, ", "), r.zone, r.timeout)
+			return
+		case <-time.After(zoneRefreshInterval):
+		}
+	}
+}
+
+// lookupSerial asks server for the SOA serial of zone.
+func lookupSerial(ctx context.Context, server, zone string) (uint32, error) {
+	if _, _, err := net.SplitHostPort(server); err != nil {
+		server = net.JoinHostPort(server, "53")
+	}
+
+	m := new(mdns.Msg)
+	m.SetQuestion(mdns.Fqdn(zone), mdns.TypeSOA)
+	m.RecursionDesired = false
+
+	client := &mdns.Client{Timeout: 5 * time.Second}
+	in, _, err := client.ExchangeContext(ctx, m, server)
+	if err != nil {
+		return 0, fmt.Errorf("querying %s for the SOA of %s: %w", server, zone, err)
+	}
+	for _, rr := range in.Answer {
+		if soa, ok := rr.(*mdns.SOA); ok {
+			return soa.Serial, nil
+		}
+	}
+	return 0, fmt.Errorf("%s has no SOA for %s: %s", server, zone, mdns.RcodeToString[in.Rcode])
+}
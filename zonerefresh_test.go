@@ -0,0 +1,147 @@
+package main
+
+import (
+	"context"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	sctesting "github.com/cert-manager/webhook-example/pkg/testing"
+	mdns "github.com/miekg/dns"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/sacloud/iaas-api-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// serveSOA serves the SOA of example.com. with the serial returned by serial
+// and returns the address of the server.
+func serveSOA(t *testing.T, serial func() uint32) string {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	server := &mdns.Server{PacketConn: pc, Handler: mdns.HandlerFunc(func(w mdns.ResponseWriter, q *mdns.Msg) {
+		m := new(mdns.Msg)
+		m.SetReply(q)
+		if q.Question[0].Name != "example.com." || q.Question[0].Qtype != mdns.TypeSOA {
+			m.Rcode = mdns.RcodeNameError
+		} else {
+			m.Answer = append(m.Answer, &mdns.SOA{
+				Hdr:    mdns.RR_Header{Name: "example.com.", Rrtype: mdns.TypeSOA, Class: mdns.ClassINET, Ttl: 60},
+				Ns:     "ns1.example.com.",
+				Mbox:   "hostmaster.example.com.",
+				Serial: serial(),
+			})
+		}
+		_ = w.WriteMsg(m)
+	})}
+	go func() { _ = server.ActivateAndServe() }()
+	t.Cleanup(func() { _ = server.Shutdown() })
+	return pc.LocalAddr().String()
+}
+
+func TestLookupSerial(t *testing.T) {
+	addr := serveSOA(t, func() uint32 { return 42 })
+	serial, err := lookupSerial(context.Background(), addr, "example.com")
+	require.NoError(t, err)
+	assert.Equal(t, uint32(42), serial)
+
+	_, err = lookupSerial(context.Background(), addr, "example.org")
+	assert.ErrorContains(t, err, "has no SOA for example.org: NXDOMAIN")
+}
+
+func TestZoneRefresh(t *testing.T) {
+	var serial atomic.Uint32
+	serial.Store(1)
+	addr := serveSOA(t, serial.Load)
+
+	opts := newSolverOptions()
+	opts.PropagationNameservers = []string{addr}
+	c := &sakuraCloudDNSProviderSolver{opts: opts}
+	assert.Nil(t, c.newZoneRefresh(context.Background(), "example.com"), "disabled without --refresh-after-write")
+
+	opts.RefreshAfterWrite = time.Minute
+	r := c.newZoneRefresh(context.Background(), "example.com")
+	require.NotNil(t, r)
+	assert.Equal(t, map[string]uint32{addr: 1}, r.serials)
+
+	serial.Store(2)
+	done := make(chan struct{})
+	go func() {
+		r.wait(context.Background())
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(10 * time.Second):
+		t.Fatal("wait did not return once the serial changed")
+	}
+
+	// A nameserver that does not refresh only delays Present by the
+	// timeout.
+	r = c.newZoneRefresh(context.Background(), "example.com")
+	r.timeout = 10 * time.Millisecond
+	start := time.Now()
+	r.wait(context.Background())
+	assert.Less(t, time.Since(start), 5*time.Second)
+
+	opts.PropagationNameservers = []string{"127.0.0.1:1"}
+	assert.Nil(t, c.newZoneRefresh(context.Background(), "example.com"), "no nameserver answered")
+}
+
+func TestPresentRefreshAfterWrite(t *testing.T) {
+	zones := sctesting.NewZoneClient(&iaas.DNS{ID: 1, Name: "example.com"})
+	c := newHarness(t, zones)
+	c.opts.RefreshAfterWrite = time.Hour
+	var held atomic.Bool
+	c.opts.PropagationNameservers = []string{serveSOA(t, func() uint32 {
+		c.queue.mu.Lock()
+		defer c.queue.mu.Unlock()
+		if c.queue.active["1"] {
+			held.Store(true)
+		}
+		// The nameserver refreshes as soon as the zone is updated.
+		return uint32(zones.Updates(1))
+	})}
+
+	done := make(chan error)
+	go func() { done <- c.Present(harnessChallenge(0)) }()
+	select {
+	case err := <-done:
+		require.NoError(t, err)
+	case <-time.After(10 * time.Second):
+		t.Fatal("Present did not return once the serial changed")
+	}
+	assert.Len(t, zones.Zone(1).Records, 1)
+	assert.False(t, held.Load(), "the serials are not read while the zone is held")
+}
+
+func TestDriftRepairDoesNotWaitForRefresh(t *testing.T) {
+	zones := sctesting.NewZoneClient(&iaas.DNS{ID: 1, Name: "example.com"})
+	c := newHarness(t, zones)
+	serveZoneDoH(t, c, zones)
+	// The nameserver never refreshes.
+	c.opts.RefreshAfterWrite = time.Hour
+	c.opts.PropagationNameservers = []string{serveSOA(t, func() uint32 { return 1 })}
+
+	ch := harnessChallenge(0)
+	cfg, err := c.resolveConfig(context.Background(), ch)
+	require.NoError(t, err)
+	cfg.PropagationCheckTimeout = &metav1.Duration{Duration: 100 * time.Millisecond}
+	rdata, err := txtRData(ch.Key)
+	require.NoError(t, err)
+	repairs := testutil.ToFloat64(driftRepairsTotal)
+
+	// The record is missing from the zone, so the check presents it again.
+	done := make(chan error)
+	go func() { done <- c.checkPropagation(context.Background(), &cfg, ch, rdata, true) }()
+	select {
+	case err := <-done:
+		require.NoError(t, err)
+	case <-time.After(10 * time.Second):
+		t.Fatal("the drift repair waited for --refresh-after-write")
+	}
+	assert.Len(t, zones.Zone(1).Records, 1)
+	assert.Equal(t, repairs+1, testutil.ToFloat64(driftRepairsTotal))
+}